@@ -18,6 +18,9 @@ const (
 	actionLimit
 )
 
+// AllActions are the actions other than NoAction in order.
+var AllActions = []Action{Hold, Left, Right, RotateCW, RotateCCW, SoftDrop, HardDrop}
+
 func (a Action) String() string {
 	switch a {
 	case NoAction:
@@ -55,3 +58,15 @@ func (a Action) Mirror() Action {
 	}
 	return a
 }
+
+// ActionsCost returns the number of key presses needed to perform the
+// actions.
+func ActionsCost(actions []Action) int {
+	var cost int
+	for _, a := range actions {
+		if a != NoAction {
+			cost++
+		}
+	}
+	return cost
+}
@@ -30,3 +30,46 @@ func TestActionString(t *testing.T) {
 		}
 	}
 }
+
+func TestAllActions(t *testing.T) {
+	if got, want := len(AllActions), int(actionLimit)-1; got != want {
+		t.Errorf("AllActions has %d actions, want %d", got, want)
+	}
+	strs := make(map[string]bool)
+	all := make(map[Action]bool)
+	for _, a := range AllActions {
+		if a == NoAction || a >= actionLimit {
+			t.Errorf("AllActions has %v", a)
+		}
+		if strs[a.String()] {
+			t.Errorf("String %q is repeated in AllActions", a.String())
+		}
+		strs[a.String()] = true
+		all[a] = true
+	}
+	for _, a := range AllActions {
+		if !all[a.Mirror()] {
+			t.Errorf("%v.Mirror() = %v is not in AllActions", a, a.Mirror())
+		}
+		if got := a.Mirror().Mirror(); got != a {
+			t.Errorf("%v.Mirror().Mirror() got %v, want %v", a, got, a)
+		}
+	}
+}
+
+func TestActionsCost(t *testing.T) {
+	tests := []struct {
+		desc    string
+		actions []Action
+		want    int
+	}{
+		{desc: "No actions"},
+		{desc: "Hard drop", actions: []Action{HardDrop}, want: 1},
+		{desc: "Ignores NoAction", actions: []Action{Left, NoAction, RotateCW, HardDrop}, want: 3},
+	}
+	for _, test := range tests {
+		if got := ActionsCost(test.actions); got != test.want {
+			t.Errorf("%s: ActionsCost(%v) got %d, want %d", test.desc, test.actions, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"strings"
+	"tetris"
+	"tetris/combo4"
+)
+
+// parseBoardPoints parses the points of the 4x4 residual board from a string
+// of 4 rows separated by semicolons from the top row to the bottom row. Each
+// row is like the clear_row flag.
+func parseBoardPoints(s string) ([4][4]image.Point, error) {
+	var points [4][4]image.Point
+	rows := strings.Split(s, ";")
+	if len(rows) != len(points) {
+		return points, fmt.Errorf("got %d rows in %q, want %d", len(rows), s, len(points))
+	}
+	for i, row := range rows {
+		rowPoints, err := parseRowPoints(row)
+		if err != nil {
+			return points, fmt.Errorf("row %d: %v", i, err)
+		}
+		points[i] = rowPoints
+	}
+	return points, nil
+}
+
+// squareAt returns the piece with the closest color to the square around a
+// point of the current frame. Empty squares are the EmptyPiece.
+func squareAt(src FrameSource, point image.Point) (tetris.Piece, error) {
+	img, err := src.CaptureRect(image.Rectangle{
+		Min: image.Point{X: point.X - readWidth, Y: point.Y - readWidth},
+		Max: image.Point{X: point.X + readWidth, Y: point.Y + readWidth},
+	})
+	if err != nil {
+		return tetris.EmptyPiece, err
+	}
+	return classify(img), nil
+}
+
+// readBoard returns the Field4x4 of the residual board on the current frame.
+// A square is occupied if it is not the color of the EmptyPiece.
+func readBoard(src FrameSource, boardPoints [4][4]image.Point) (combo4.Field4x4, error) {
+	var field [4][4]bool
+	for row, rowPoints := range boardPoints {
+		for col, point := range rowPoints {
+			piece, err := squareAt(src, point)
+			if err != nil {
+				return 0, fmt.Errorf("failed to read the board at %v: %v", point, err)
+			}
+			field[row][col] = piece != tetris.EmptyPiece
+		}
+	}
+	return combo4.NewField4x4(field[:]), nil
+}
+
+// readResumeState returns the State of a game in progress from the residual
+// board and the hold piece on the current frame. The hold piece is assumed
+// to be swappable since that cannot be seen on the screen.
+func readResumeState(src FrameSource, boardPoints [4][4]image.Point, holdPoint image.Point) (combo4.State, error) {
+	field, err := readBoard(src, boardPoints)
+	if err != nil {
+		return combo4.State{}, err
+	}
+	hold, err := squareAt(src, holdPoint)
+	if err != nil {
+		return combo4.State{}, fmt.Errorf("failed to read the hold piece at %v: %v", holdPoint, err)
+	}
+	return combo4.State{Field: field, Hold: hold}, nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+	"tetris"
+	"tetris/combo4"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// testBoardPoints are 20 pixels apart like testRowPoints.
+var testBoardPoints = [4][4]image.Point{
+	{{X: 10, Y: 10}, {X: 30, Y: 10}, {X: 50, Y: 10}, {X: 70, Y: 10}},
+	{{X: 10, Y: 30}, {X: 30, Y: 30}, {X: 50, Y: 30}, {X: 70, Y: 30}},
+	{{X: 10, Y: 50}, {X: 30, Y: 50}, {X: 50, Y: 50}, {X: 70, Y: 50}},
+	{{X: 10, Y: 70}, {X: 30, Y: 70}, {X: 50, Y: 70}, {X: 70, Y: 70}},
+}
+
+var testHoldPoint = image.Point{X: 110, Y: 10}
+
+// boardFrame returns a fake screen showing the field at testBoardPoints in
+// garbage gray and the hold piece at testHoldPoint.
+func boardFrame(field combo4.Field4x4, hold tetris.Piece) *image.RGBA {
+	frame := image.NewRGBA(image.Rect(0, 0, 130, 90))
+	fill := func(point image.Point, c color.RGBA) {
+		rect := image.Rect(point.X-10, point.Y-10, point.X+10, point.Y+10)
+		draw.Draw(frame, rect, &image.Uniform{c}, image.Point{}, draw.Src)
+	}
+	empty := colors[tetris.EmptyPiece]
+	empty.A = 255
+	draw.Draw(frame, frame.Bounds(), &image.Uniform{empty}, image.Point{}, draw.Src)
+	for row, rowPoints := range testBoardPoints {
+		for col, point := range rowPoints {
+			if !field.IsEmpty(row, col) {
+				fill(point, color.RGBA{R: 128, G: 128, B: 128, A: 255})
+			}
+		}
+	}
+	holdColor := colors[hold]
+	holdColor.A = 255
+	fill(testHoldPoint, holdColor)
+	return frame
+}
+
+// frameSource always shows the same frame.
+type frameSource struct {
+	frame *image.RGBA
+}
+
+func (frameSource) Next() error {
+	return nil
+}
+
+func (s frameSource) CaptureRect(rect image.Rectangle) (*image.RGBA, error) {
+	return s.frame.SubImage(rect).(*image.RGBA), nil
+}
+
+func TestReadResumeState(t *testing.T) {
+	tests := []combo4.State{
+		{Field: combo4.LeftI},
+		{Field: combo4.LeftZ, Hold: tetris.T},
+		{Field: combo4.RightI, Hold: tetris.I},
+		{Field: combo4.NewField4x4([][4]bool{{true, false, false, false}, {true, true, true, false}}), Hold: tetris.O},
+	}
+	for _, want := range tests {
+		src := frameSource{boardFrame(want.Field, want.Hold)}
+		got, err := readResumeState(src, testBoardPoints, testHoldPoint)
+		if err != nil {
+			t.Fatalf("readResumeState: %v", err)
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("readResumeState mismatch (-want +got):\n%s", diff)
+		}
+	}
+}
+
+func TestParseBoardPoints(t *testing.T) {
+	got, err := parseBoardPoints("10,10 30,10 50,10 70,10;10,30 30,30 50,30 70,30; 10,50 30,50 50,50 70,50 ;10,70 30,70 50,70 70,70")
+	if err != nil {
+		t.Fatalf("parseBoardPoints: %v", err)
+	}
+	if diff := cmp.Diff(testBoardPoints, got); diff != "" {
+		t.Errorf("parseBoardPoints mismatch (-want +got):\n%s", diff)
+	}
+
+	for _, invalid := range []string{"", "10,10 30,10 50,10 70,10", "10,10 30,10 50,10 70,10;;;"} {
+		if _, err := parseBoardPoints(invalid); err == nil {
+			t.Errorf("parseBoardPoints(%q) got no error", invalid)
+		}
+	}
+}
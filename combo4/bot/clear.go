@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"strings"
+	"tetris"
+	"tetris/combo4"
+	"time"
+)
+
+// pollInterval is the time between reads of the field while waiting for a
+// line to clear.
+const pollInterval = 5 * time.Millisecond
+
+// parseRowPoints parses the points of the 4 columns of a row from a string
+// like "10,20 30,20 50,20 70,20".
+func parseRowPoints(s string) ([4]image.Point, error) {
+	var points [4]image.Point
+	fields := strings.Fields(s)
+	if len(fields) != len(points) {
+		return points, fmt.Errorf("got %d points in %q, want %d", len(fields), s, len(points))
+	}
+	for i, f := range fields {
+		if _, err := fmt.Sscanf(f, "%d,%d", &points[i].X, &points[i].Y); err != nil {
+			return points, fmt.Errorf("parsing point %q: %v", f, err)
+		}
+	}
+	return points, nil
+}
+
+// waitForClear reads frames until the bottom row of the 4 wide changes from
+// before and then matches the bottom row of the field, which happens once the
+// line clear is done. before is the bottom row read before the piece was
+// placed. It often already matches the field so a match without a change
+// first is the row before the line clear. waitForClear returns false if the
+// row does not match before the timeout.
+func waitForClear(src FrameSource, rowPoints [4]image.Point, before [4]bool, field combo4.Field4x4, timeout time.Duration) (bool, error) {
+	deadline := time.Now().Add(timeout)
+	want := fieldBottomRow(field)
+	var changed bool
+	for {
+		if err := src.Next(); err != nil {
+			return false, err
+		}
+		row, err := readBottomRow(src, rowPoints)
+		if err != nil {
+			return false, err
+		}
+		changed = changed || row != before
+		if changed && row == want {
+			return true, nil
+		}
+		if time.Now().After(deadline) {
+			return false, nil
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+// readBottomRow returns whether each square of the bottom row is empty on
+// the current frame.
+func readBottomRow(src FrameSource, rowPoints [4]image.Point) ([4]bool, error) {
+	var row [4]bool
+	for col, point := range rowPoints {
+		piece, err := squareAt(src, point)
+		if err != nil {
+			return row, fmt.Errorf("failed to read the field at %v: %v", point, err)
+		}
+		row[col] = piece == tetris.EmptyPiece
+	}
+	return row, nil
+}
+
+// fieldBottomRow returns whether each square of the bottom row of the field
+// is empty.
+func fieldBottomRow(field combo4.Field4x4) [4]bool {
+	var row [4]bool
+	for col := range row {
+		row[col] = field.IsEmpty(3, col)
+	}
+	return row
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"image"
+	"image/draw"
+	"testing"
+	"tetris"
+	"tetris/combo4"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var testRowPoints = [4]image.Point{{X: 10, Y: 10}, {X: 30, Y: 10}, {X: 50, Y: 10}, {X: 70, Y: 10}}
+
+// clearingSource shows the bottom row of field until placeAfter frames have
+// been read, then a full bottom row until clearAfter frames have been read and
+// then the bottom row of field again.
+type clearingSource struct {
+	field      combo4.Field4x4
+	placeAfter int
+	clearAfter int
+	reads      int
+}
+
+func (s *clearingSource) Next() error {
+	s.reads++
+	return nil
+}
+
+func (s *clearingSource) CaptureRect(rect image.Rectangle) (*image.RGBA, error) {
+	c := colors[tetris.T]
+	if s.reads <= s.placeAfter || s.reads > s.clearAfter {
+		for col, point := range testRowPoints {
+			if point.In(rect) && s.field.IsEmpty(3, col) {
+				c = colors[tetris.EmptyPiece]
+			}
+		}
+	}
+	c.A = 255
+	img := image.NewRGBA(rect)
+	draw.Draw(img, rect, &image.Uniform{c}, image.Point{}, draw.Src)
+	return img, nil
+}
+
+func TestWaitForClear(t *testing.T) {
+	tests := []struct {
+		desc       string
+		placeAfter int
+	}{
+		{desc: "placed before the first frame"},
+		// The row before the piece is placed already matches the field.
+		{desc: "placed after 2 frames", placeAfter: 2},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			src := &clearingSource{field: combo4.LeftI, placeAfter: test.placeAfter, clearAfter: 3}
+			cleared, err := waitForClear(src, testRowPoints, fieldBottomRow(combo4.LeftI), combo4.LeftI, time.Second)
+			if err != nil {
+				t.Fatalf("waitForClear: %v", err)
+			}
+			if !cleared {
+				t.Errorf("waitForClear got cleared=false, want true")
+			}
+			if src.reads != 4 {
+				t.Errorf("waitForClear read %d frames, want 4", src.reads)
+			}
+		})
+	}
+}
+
+func TestWaitForClearTimeout(t *testing.T) {
+	tests := []struct {
+		desc       string
+		placeAfter int
+	}{
+		{desc: "never cleared"},
+		// The row matches the field but never changes from the row before.
+		{desc: "never placed", placeAfter: 1000},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			src := &clearingSource{field: combo4.LeftI, placeAfter: test.placeAfter, clearAfter: 1000}
+			cleared, err := waitForClear(src, testRowPoints, fieldBottomRow(combo4.LeftI), combo4.LeftI, 20*time.Millisecond)
+			if err != nil {
+				t.Fatalf("waitForClear: %v", err)
+			}
+			if cleared {
+				t.Errorf("waitForClear got cleared=true, want false")
+			}
+		})
+	}
+}
+
+func TestParseRowPoints(t *testing.T) {
+	got, err := parseRowPoints("10,10 30,10 50,10 70,10")
+	if err != nil {
+		t.Fatalf("parseRowPoints: %v", err)
+	}
+	if diff := cmp.Diff(testRowPoints, got); diff != "" {
+		t.Errorf("parseRowPoints mismatch (-want +got):\n%s", diff)
+	}
+
+	for _, invalid := range []string{"", "10,10 30,10 50,10", "10,10 30,10 50,10 70"} {
+		if _, err := parseRowPoints(invalid); err == nil {
+			t.Errorf("parseRowPoints(%q) got no error", invalid)
+		}
+	}
+}
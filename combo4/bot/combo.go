@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// comboCounter counts the pieces placed in the current combo and prints the
+// streak to out as it grows and when it ends.
+type comboCounter struct {
+	out io.Writer
+	n   int
+}
+
+// place counts a placed piece and prints the current combo.
+func (c *comboCounter) place() {
+	c.n++
+	fmt.Fprintf(c.out, "Combo: %d\n", c.n)
+}
+
+// end prints the final combo and starts counting a new one. It returns the
+// final combo.
+func (c *comboCounter) end() int {
+	final := c.n
+	fmt.Fprintf(c.out, "Final combo: %d\n", final)
+	c.n = 0
+	return final
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestComboCounter(t *testing.T) {
+	var out bytes.Buffer
+	c := &comboCounter{out: &out}
+	for i := 0; i < 3; i++ {
+		c.place()
+	}
+	if got := c.end(); got != 3 {
+		t.Errorf("end() got %d, want 3", got)
+	}
+	c.place()
+	if got := c.end(); got != 1 {
+		t.Errorf("end() after a new combo got %d, want 1", got)
+	}
+	if got := c.end(); got != 0 {
+		t.Errorf("end() without placing got %d, want 0", got)
+	}
+
+	want := "Combo: 1\nCombo: 2\nCombo: 3\nFinal combo: 3\nCombo: 1\nFinal combo: 1\nFinal combo: 0\n"
+	if got := out.String(); got != want {
+		t.Errorf("got output:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/vova616/screenshot"
+)
+
+// FrameSource provides the frames of the game that pieces are read from.
+type FrameSource interface {
+	// Next advances to the next frame. Next returns io.EOF if there are no
+	// more frames.
+	Next() error
+	// CaptureRect returns the pixels in a rectangle of the current frame.
+	CaptureRect(rect image.Rectangle) (*image.RGBA, error)
+}
+
+// screenSource reads frames from the live screen.
+type screenSource struct{}
+
+// Next does nothing because the screen is always the latest frame.
+func (screenSource) Next() error {
+	return nil
+}
+
+func (screenSource) CaptureRect(rect image.Rectangle) (*image.RGBA, error) {
+	return screenshot.CaptureRect(rect)
+}
+
+// dirSource reads frames from the PNG files in a directory in lexical order.
+type dirSource struct {
+	paths []string
+	frame *image.RGBA
+}
+
+// newDirSource creates a dirSource. Next must be called before reading the
+// first frame.
+func newDirSource(dir string) (*dirSource, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.png"))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no PNG files in %q", dir)
+	}
+	sort.Strings(paths)
+	return &dirSource{paths: paths}, nil
+}
+
+func (s *dirSource) Next() error {
+	if len(s.paths) == 0 {
+		return io.EOF
+	}
+	path := s.paths[0]
+	s.paths = s.paths[1:]
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	img, err := png.Decode(file)
+	if err != nil {
+		return fmt.Errorf("decoding %q: %v", path, err)
+	}
+	frame := image.NewRGBA(img.Bounds())
+	draw.Draw(frame, frame.Bounds(), img, img.Bounds().Min, draw.Src)
+	s.frame = frame
+	return nil
+}
+
+func (s *dirSource) CaptureRect(rect image.Rectangle) (*image.RGBA, error) {
+	if s.frame == nil {
+		return nil, fmt.Errorf("no frame has been read")
+	}
+	if !rect.In(s.frame.Bounds()) {
+		return nil, fmt.Errorf("%v is outside of the frame %v", rect, s.frame.Bounds())
+	}
+	return s.frame.SubImage(rect).(*image.RGBA), nil
+}
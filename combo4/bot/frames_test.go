@@ -0,0 +1,60 @@
+package main
+
+import (
+	"image"
+	"image/draw"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"tetris"
+)
+
+// writeFrame writes a PNG of a single color to the path.
+func writeFrame(t *testing.T, path string, p tetris.Piece) {
+	t.Helper()
+	c := colors[p]
+	c.A = 255 // The colors do not specify the alpha.
+	img := image.NewRGBA(image.Rect(0, 0, 20, 20))
+	draw.Draw(img, img.Bounds(), &image.Uniform{c}, image.Point{}, draw.Src)
+
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("os.Create: %v", err)
+	}
+	defer file.Close()
+	if err := png.Encode(file, img); err != nil {
+		t.Fatalf("png.Encode: %v", err)
+	}
+}
+
+func TestDirSource(t *testing.T) {
+	dir := t.TempDir()
+	writeFrame(t, filepath.Join(dir, "0.png"), tetris.T)
+	writeFrame(t, filepath.Join(dir, "1.png"), tetris.S)
+
+	src, err := newDirSource(dir)
+	if err != nil {
+		t.Fatalf("newDirSource: %v", err)
+	}
+	rect := image.Rect(5, 5, 11, 11)
+	for _, want := range []tetris.Piece{tetris.T, tetris.S} {
+		if err := src.Next(); err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		img, err := src.CaptureRect(rect)
+		if err != nil {
+			t.Fatalf("CaptureRect: %v", err)
+		}
+		if got := classify(img); got != want {
+			t.Errorf("classify got %v, want %v", got, want)
+		}
+	}
+	if err := src.Next(); err != io.EOF {
+		t.Errorf("Next after the last frame got %v, want io.EOF", err)
+	}
+	if _, err := src.CaptureRect(image.Rect(15, 15, 25, 25)); err == nil {
+		t.Errorf("CaptureRect outside of the frame got no error")
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The phases of each step of playGame timed with profile_decisions.
+const (
+	phasePolicy = "policy"
+	phaseRead   = "read"
+	phaseKeys   = "keys"
+)
+
+// phaseOrder is the order of the phases in a latency summary.
+var phaseOrder = []string{phasePolicy, phaseRead, phaseKeys}
+
+// summaryEvery is the number of steps between the latency summaries.
+const summaryEvery = 50
+
+// phaseLatencies accumulates the latencies of each phase of the steps.
+type phaseLatencies struct {
+	samples map[string][]time.Duration
+}
+
+func newPhaseLatencies() *phaseLatencies {
+	return &phaseLatencies{samples: make(map[string][]time.Duration)}
+}
+
+// add records a latency of the phase.
+func (l *phaseLatencies) add(phase string, d time.Duration) {
+	l.samples[phase] = append(l.samples[phase], d)
+}
+
+// time records the time since start for the phase.
+func (l *phaseLatencies) time(phase string, start time.Time) {
+	l.add(phase, time.Since(start))
+}
+
+// percentile returns the latency of the phase that the fraction of the
+// latencies are at most using the nearest rank or 0 if there are none.
+func (l *phaseLatencies) percentile(phase string, fraction float64) time.Duration {
+	samples := append([]time.Duration(nil), l.samples[phase]...)
+	if len(samples) == 0 {
+		return 0
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	rank := int(math.Ceil(fraction*float64(len(samples)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return samples[rank]
+}
+
+// summary returns the p50 and p99 of each phase on one line.
+func (l *phaseLatencies) summary() string {
+	var parts []string
+	for _, phase := range phaseOrder {
+		if len(l.samples[phase]) == 0 {
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("%s p50=%v p99=%v n=%d", phase, l.percentile(phase, 0.50), l.percentile(phase, 0.99), len(l.samples[phase])))
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPhaseLatencies(t *testing.T) {
+	l := newPhaseLatencies()
+	// Added out of order to check that the percentiles sort.
+	for i := 100; i >= 1; i-- {
+		l.add(phasePolicy, time.Duration(i)*time.Millisecond)
+	}
+	l.add(phaseKeys, 3*time.Millisecond)
+
+	tests := []struct {
+		phase    string
+		fraction float64
+		want     time.Duration
+	}{
+		{phasePolicy, 0.50, 50 * time.Millisecond},
+		{phasePolicy, 0.99, 99 * time.Millisecond},
+		{phasePolicy, 1, 100 * time.Millisecond},
+		{phasePolicy, 0, time.Millisecond},
+		{phaseKeys, 0.99, 3 * time.Millisecond},
+		{phaseRead, 0.50, 0},
+	}
+	for _, test := range tests {
+		if got := l.percentile(test.phase, test.fraction); got != test.want {
+			t.Errorf("percentile(%s, %v) got %v, want %v", test.phase, test.fraction, got, test.want)
+		}
+	}
+
+	if got, want := l.summary(), "policy p50=50ms p99=99ms n=100, keys p50=3ms p99=3ms n=1"; got != want {
+		t.Errorf("summary() got %q, want %q", got, want)
+	}
+	if got := newPhaseLatencies().summary(); got != "" {
+		t.Errorf("summary() without latencies got %q, want \"\"", got)
+	}
+}
@@ -2,17 +2,17 @@
 package main
 
 import (
-	"bytes"
-	"compress/gzip"
 	"flag"
 	"fmt"
 	"image"
 	"image/color"
-	"io"
+	"io/ioutil"
 	"log"
 	"math"
+	"net/http"
 	"os"
 	"runtime"
+	"strings"
 	"tetris"
 	"tetris/combo4"
 	"tetris/combo4/policy"
@@ -20,13 +20,21 @@ import (
 
 	"github.com/go-vgo/robotgo"
 	kb "github.com/micmonay/keybd_event"
-	"github.com/vova616/screenshot"
 )
 
 var (
-	pressWait  = flag.Duration("press_delay", 25*time.Millisecond, "Time to wait between key presses.")
-	lineWait   = flag.Duration("clear_delay", 0, "Time to wait for a line to clear.")
-	policyFile = flag.String("policy_file", "policy_6preview.gob.gz", "Path the the gzip policy file. If empty-string, will compute an AI from scratch.")
+	pressWait   = flag.Duration("press_delay", 25*time.Millisecond, "Time to wait between key presses.")
+	lineWait    = flag.Duration("clear_delay", 0, "Time to wait for a line to clear.")
+	clearWait   = flag.Duration("clear_timeout", 0, "If set, waits for a line to clear by reading the bottom row of the field instead of waiting clear_delay. The bot continues after this timeout if the line clear is not seen.")
+	clearRow    = flag.String("clear_row", "", "The points of the 4 columns of the bottom row of the 4 wide like \"x,y x,y x,y x,y\". Required by clear_timeout.")
+	policyFile  = flag.String("policy_file", "policy_6preview.gob.gz", "Path the the gzip policy file. If empty-string, uses the NFAScorer with a permLen of 7 which is embedded in binaries built with -tags embedscorer.")
+	metricsAddr = flag.String("metrics_addr", "", "If set, serves the bot's metrics as JSON over HTTP on this address like \":8080\".")
+	bookFile    = flag.String("opening_book", "", "If set, the path to an opening book file from gen/book. Its choices are used for the first bag of each game.")
+	profile     = flag.Bool("profile_decisions", false, "If set, logs the p50 and p99 of the time spent waiting for the policy, reading pixels and pressing keys every 50 pieces and after each game.")
+	resume      = flag.Bool("resume", false, "If set, each game resumes from the residual board and hold piece on the screen instead of starting from LeftI. Requires board_points.")
+	boardPoints = flag.String("board_points", "", "The points of the 4x4 residual board of the 4 wide as 4 rows like clear_row separated by semicolons from the top row to the bottom row. Required by resume.")
+	record      = flag.String("record", "", "If set, the path of a file to append a JSON line to for each decision of the policy that is not from the opening book. See policy.NewRecorder.")
+	framesDir   = flag.String("frames_dir", "", "Path to a directory of PNG screenshots to read the pieces from instead of the screen. Each read of a new frame uses the next file in lexical order.")
 )
 
 const initialField = combo4.LeftI
@@ -41,10 +49,39 @@ var actionKeys = map[tetris.Action]int{
 	tetris.HardDrop:  kb.VK_SPACE,
 }
 
+// keyNames are the names of the keys in actionKeys to show to a human.
+var keyNames = map[int]string{
+	kb.VK_LEFT:  "Left",
+	kb.VK_RIGHT: "Right",
+	kb.VK_DOWN:  "Down",
+	kb.VK_UP:    "Up",
+	kb.VK_Z:     "Z",
+	kb.VK_C:     "C",
+	kb.VK_SPACE: "Space",
+}
+
+// ActionsToKeyHints returns the names of the keys to press for the actions
+// like "Right → Z → Down → Space". Actions without a key or whose key has no
+// name are shown by the name of the Action.
+func ActionsToKeyHints(actions []tetris.Action, keys map[tetris.Action]int) string {
+	hints := make([]string, 0, len(actions))
+	for _, a := range actions {
+		name := a.String()
+		if k, ok := keys[a]; ok {
+			if keyName, ok := keyNames[k]; ok {
+				name = keyName
+			}
+		}
+		hints = append(hints, name)
+	}
+	return strings.Join(hints, " → ")
+}
+
 // Co-ordinates to read the pixels of the preview pieces.
 // These defaults are how NullpoMino opens on a 4K screen.
 var (
-	// This assumes the initialField is LeftI.
+	// This assumes the initialField is LeftI. With resume the current piece
+	// must also be seen at this point.
 	initialCurrPoint = image.Point{X: 1500, Y: 1400}
 
 	previewPoints = []image.Point{
@@ -58,6 +95,12 @@ var (
 
 	holdPoint = image.Point{X: 1370, Y: 790}
 
+	// Set from the clear_row flag.
+	clearRowPoints [4]image.Point
+
+	// Set from the board_points flag.
+	boardRegion [4][4]image.Point
+
 	// Reads a square starting at the points in the top left
 	// and moving readWith down and right.
 	readWidth = 3
@@ -78,12 +121,55 @@ var colors = map[tetris.Piece]color.RGBA{
 
 var moves, mActions = combo4.AllContinuousMoves()
 
+// nfa has the actions of each move to find the keys to press.
+var nfa = combo4.NewNFAWithActions(moves, mActions)
+
+// frames is where the pieces are read from.
+var frames FrameSource = screenSource{}
+
+// botMetrics is updated while playing and served on metrics_addr.
+var botMetrics = new(metrics)
+
+// openingBook is read from opening_book or nil if it is not set.
+var openingBook *policy.OpeningBook
+
+// recordFile is where the decisions are recorded or nil if record is not set.
+var recordFile *os.File
+
 func main() {
+	flag.Parse()
+
+	if *framesDir != "" {
+		dirFrames, err := newDirSource(*framesDir)
+		if err != nil {
+			log.Fatalf("failed to read frames: %v", err)
+		}
+		frames = dirFrames
+	}
+	if *metricsAddr != "" {
+		go func() {
+			log.Fatalf("serving metrics failed: %v", http.ListenAndServe(*metricsAddr, botMetrics))
+		}()
+	}
+	if *clearWait > 0 {
+		points, err := parseRowPoints(*clearRow)
+		if err != nil {
+			log.Fatalf("invalid clear_row: %v", err)
+		}
+		clearRowPoints = points
+	}
+	if *resume {
+		points, err := parseBoardPoints(*boardPoints)
+		if err != nil {
+			log.Fatalf("invalid board_points: %v", err)
+		}
+		boardRegion = points
+	}
+
 	fmt.Println("Loading AI...")
 	var pol policy.Policy
 	if *policyFile == "" {
-		nfa := combo4.NewNFA(moves)
-		pol = policy.FromScorer(nfa, policy.NewNFAScorer(nfa, 7))
+		pol = policy.FromScorer(nfa, policy.LoadNFAScorer(nfa, 7), policy.PreferFewerKeys(mActions))
 	} else {
 		var err error
 		pol, err = policyFromPath(*policyFile)
@@ -92,6 +178,22 @@ func main() {
 		}
 	}
 
+	if *record != "" {
+		f, err := os.OpenFile(*record, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			log.Fatalf("failed to open the record file: %v", err)
+		}
+		recordFile = f
+	}
+
+	if *bookFile != "" {
+		book, err := bookFromPath(*bookFile)
+		if err != nil {
+			log.Fatalf("failed to read the opening book: %v", err)
+		}
+		openingBook = book
+	}
+
 	keybond, err := newKeyBonding()
 	if err != nil {
 		log.Fatalf("newKeyBonding failed: %v", err)
@@ -108,8 +210,13 @@ func playGame(pol policy.Policy, keybond *kb.KeyBonding) {
 	if !click {
 		log.Fatal("middle mouse button not clicked")
 	}
+	botMetrics.update(func(s *metricsSnapshot) {
+		s.GamesPlayed++
+		s.ComboLength = 0
+	})
 
 	// Read the pieces from the screen.
+	nextFrame()
 	piecePnts := append([]image.Point{initialCurrPoint}, previewPoints...)
 	var initialPieces []tetris.Piece
 	for _, pnt := range piecePnts {
@@ -119,30 +226,128 @@ func playGame(pol policy.Policy, keybond *kb.KeyBonding) {
 		}
 		initialPieces = append(initialPieces, piece)
 	}
-	currPieceCh := make(chan tetris.Piece, len(initialPieces)+1)
-	for _, p := range initialPieces {
-		currPieceCh <- p
-	}
 	fmt.Printf("First piece: %v\n", initialPieces[0])
 	fmt.Printf("Preview: %v\n", initialPieces[1:])
 
+	prevState := combo4.State{Field: initialField}
+	if *resume {
+		state, err := readResumeState(frames, boardRegion, holdPoint)
+		if err != nil {
+			log.Fatalf("failed to read the board: %v", err)
+		}
+		if err := policy.CheckResumeState(nfa, state, initialPieces[0]); err != nil {
+			fmt.Printf("Cannot resume from the board: %v\n", err)
+			return
+		}
+		prevState = state
+		fmt.Printf("Resuming from:\nHold: %s\nField:\n%s\n", state.Hold, state.Field)
+	}
+
 	var (
-		prevState   = combo4.State{Field: initialField}
+		// The known pieces which have not been played yet.
+		queue       = append([]tetris.Piece(nil), initialPieces...)
 		policyInput = make(chan tetris.Piece, 1)
+		// The last piece sent to the policy or EmptyPiece if none was sent.
+		lastInput tetris.Piece
+		// When the policy was last sent a piece.
+		sentAt = time.Now()
+		// The number of keys pressed so far.
+		keyPresses int
+		// The pieces played so far.
+		combo = &comboCounter{out: os.Stdout}
 	)
-	for nextStatePtr := range policy.StartGame(pol, initialField, initialPieces[0], initialPieces[1:], policyInput) {
-		if nextStatePtr == nil {
+	// The Recorder wraps the policy directly so it can record the
+	// candidates. The choices of the opening book are not recorded.
+	gamePol := pol
+	var recorder *policy.Recorder
+	if recordFile != nil {
+		recorder = policy.NewRecorder(gamePol, recordFile)
+		gamePol = recorder
+	}
+	if openingBook != nil && !*resume {
+		gamePol = policy.WithOpeningBook(openingBook, gamePol)
+	}
+	var decisions chan policy.Decision
+	if *resume {
+		// Where the bags start is unknown so the game keeps every bag state
+		// the visible pieces could have been drawn to.
+		decisions = policy.ResumeGame(gamePol, prevState, initialPieces[0], initialPieces[1:], 0, policyInput, policy.UnknownBagPhase())
+	} else {
+		decisions = policy.StartGame(gamePol, initialField, initialPieces[0], initialPieces[1:], policyInput)
+	}
+	latencies := newPhaseLatencies()
+	for decision := range decisions {
+		fmt.Printf("Decision latency: %v\n", time.Since(sentAt))
+		if *profile {
+			latencies.time(phasePolicy, sentAt)
+		}
+		if decision.Err != nil {
+			if lastInput == tetris.EmptyPiece {
+				fmt.Printf("Invalid starting pieces: %v\n", decision.Err)
+				return
+			}
+			// The preview was probably misread. Read it again.
+			botMetrics.update(func(s *metricsSnapshot) { s.Misreads++ })
+			fmt.Printf("Invalid preview piece: %v\nReading the preview again.\n", decision.Err)
+			time.Sleep(*pressWait)
+			readStart := time.Now()
+			nextFrame()
+			lastInput = pieceAt(previewPoints[len(previewPoints)-1])
+			if *profile {
+				latencies.time(phaseRead, readStart)
+			}
+			sentAt = time.Now()
+			policyInput <- lastInput
+			continue
+		}
+		if decision.State == nil {
 			fmt.Println("No more combos!")
+			if *profile {
+				fmt.Printf("Latencies: %s\n", latencies.summary())
+			}
+			if played := combo.end(); played > 0 {
+				fmt.Printf("Average key presses per piece: %.2f\n", float64(keyPresses)/float64(played))
+			}
+			if mdpPol, ok := pol.(*policy.MDPPolicy); ok {
+				stats := mdpPol.Stats()
+				fmt.Printf("Policy hit rate: %.1f%% (hits=%d partial preview=%d unknown state=%d preview too long=%d invalid=%d)\n",
+					stats.HitRate()*100, stats.Hits, stats.PartialPreview, stats.UnknownState, stats.PreviewTooLong, stats.Invalid)
+				mdpPol.Reset()
+			}
+			if recorder != nil {
+				if err := recorder.Err(); err != nil {
+					fmt.Printf("Failed to record the decisions: %v\n", err)
+				}
+			}
 			return
 		}
-		nextState := *nextStatePtr
-
-		currPiece := <-currPieceCh
+		nextState := *decision.State
+		if lastInput != tetris.EmptyPiece {
+			queue = append(queue, lastInput)
+		}
+		currPiece := queue[0]
+		queue = queue[1:]
 
 		fmt.Printf("\nCurrent: %s\nHold: %s\nField:\n%s\n", currPiece, prevState.Hold, prevState.Field)
-
-		toExecute := actions(mActions, prevState, nextState, currPiece)
-		fmt.Println(toExecute)
+		gState := policy.GameState{State: prevState, Current: currPiece, Preview: tetris.MustSeq(queue), BagUsed: decision.BagUsed}
+		fmt.Printf("GameState: %+v\n", gState)
+
+		toExecute := actions(nfa, prevState, nextState, currPiece)
+		fmt.Printf("%v\nKeys: %s\n", toExecute, ActionsToKeyHints(toExecute, actionKeys))
+		keyPresses += tetris.ActionsCost(toExecute)
+		combo.place()
+		botMetrics.update(func(s *metricsSnapshot) { s.ComboLength = combo.n })
+		// The bottom row before the piece is placed so the line clear is
+		// only seen once the row changes.
+		var rowBefore [4]bool
+		if *clearWait > 0 {
+			row, err := readBottomRow(frames, clearRowPoints)
+			if err != nil {
+				log.Fatalf("failed to read the bottom row: %v", err)
+			}
+			rowBefore = row
+		}
+		keysStart := time.Now()
 		for _, a := range toExecute {
 			k, ok := actionKeys[a]
 			if !ok {
@@ -151,55 +356,71 @@ func playGame(pol policy.Policy, keybond *kb.KeyBonding) {
 			keyTap(keybond, k)
 			time.Sleep(*pressWait)
 		}
+		if *profile {
+			latencies.time(phaseKeys, keysStart)
+		}
 
-		time.Sleep(*lineWait)
+		if *clearWait > 0 {
+			cleared, err := waitForClear(frames, clearRowPoints, rowBefore, nextState.Field, *clearWait)
+			if err != nil {
+				log.Fatalf("failed to wait for the line clear: %v", err)
+			}
+			if !cleared {
+				fmt.Println("Timed out waiting for the line clear.")
+			}
+		} else {
+			time.Sleep(*lineWait)
+		}
 
 		// Read the new last preview piece.
-		nextPreview := pieceAt(previewPoints[len(previewPoints)-1])
-		policyInput <- nextPreview
-		currPieceCh <- nextPreview
+		readStart := time.Now()
+		nextFrame()
+		lastInput = pieceAt(previewPoints[len(previewPoints)-1])
+		if *profile {
+			latencies.time(phaseRead, readStart)
+			if combo.n%summaryEvery == 0 {
+				fmt.Printf("Latencies: %s\n", latencies.summary())
+			}
+		}
+		sentAt = time.Now()
+		policyInput <- lastInput
 
 		prevState = nextState
 	}
 }
 
-func actions(mActions map[combo4.Move][]tetris.Action, prevState, nextState combo4.State, piece tetris.Piece) []tetris.Action {
-	var actions []tetris.Action
-
-	movePiece := piece
-	if prevState.Hold != nextState.Hold {
-		movePiece = prevState.Hold
-		actions = append(actions, tetris.Hold)
-
-		// No more actions are need if swapping from EmptyPiece.
-		if prevState.Hold == tetris.EmptyPiece {
-			return actions
-		}
+// actions returns the actions to go from prevState to nextState.
+func actions(nfa *combo4.NFA, prevState, nextState combo4.State, piece tetris.Piece) []tetris.Action {
+	acts, ok := nfa.TransitionActions(prevState, nextState, piece)
+	if !ok {
+		panic(fmt.Sprintf("no actions defined to go from %v to %v with %v", prevState, nextState, piece))
 	}
+	return acts
+}
 
-	move := combo4.Move{
-		Start: prevState.Field,
-		End:   nextState.Field,
-		Piece: movePiece,
-	}
-	moveActions, ok := mActions[move]
-	if !ok {
-		panic(fmt.Sprintf("no actions defined for move %+v", move))
+// nextFrame advances the frames or exits the program.
+func nextFrame() {
+	if err := frames.Next(); err != nil {
+		log.Fatalf("failed to read the next frame: %v", err)
 	}
-	actions = append(actions, moveActions...)
-	return actions
 }
 
 // pieceAt returns the piece at a point or exits the program.
 func pieceAt(point image.Point) tetris.Piece {
-	// Find the average color
-	img, err := screenshot.CaptureRect(image.Rectangle{
+	img, err := frames.CaptureRect(image.Rectangle{
 		Min: image.Point{X: point.X - readWidth, Y: point.Y - readWidth},
 		Max: image.Point{X: point.X + readWidth, Y: point.Y + readWidth},
 	})
 	if err != nil {
 		log.Fatalf("failed to read piece at %v: %v", point, err)
 	}
+	return classify(img)
+}
+
+// classify returns the piece with the closest color to the average color of
+// the image.
+func classify(img *image.RGBA) tetris.Piece {
+	// Find the average color
 	var r, g, b int
 	for x := img.Bounds().Min.X; x < img.Bounds().Max.X; x++ {
 		for y := img.Bounds().Min.Y; y < img.Bounds().Max.Y; y++ {
@@ -251,26 +472,29 @@ func keyTap(keybnd *kb.KeyBonding, key int) {
 }
 
 func policyFromPath(path string) (policy.Policy, error) {
-	file, err := os.Open(path)
+	mdpPol, err := policy.LoadFile(path)
 	if err != nil {
-		return nil, fmt.Errorf("os.Open: %v", err)
+		return nil, err
 	}
-	defer file.Close()
-
-	var buf bytes.Buffer
-	gz, err := gzip.NewReader(file)
-	if err != nil {
-		return nil, fmt.Errorf("gzip.NewReader: %v", err)
+	if err := mdpPol.Validate(nfa); err != nil {
+		return nil, fmt.Errorf("invalid policy: %v", err)
 	}
-	defer gz.Close()
-
-	if _, err := io.Copy(&buf, gz); err != nil {
-		return nil, fmt.Errorf("read file contents failed: %v", err)
+	if got, want := mdpPol.PreviewLen(), len(previewPoints); got != want {
+		return nil, fmt.Errorf("the policy was trained for preview %d but the bot is configured for %d", got, want)
 	}
+	// The bot never changes the policy so it can use the smaller form.
+	mdpPol.Freeze()
+	return mdpPol, nil
+}
 
-	mdpPol := &policy.MDPPolicy{}
-	if err := mdpPol.GobDecode(buf.Bytes()); err != nil {
+func bookFromPath(path string) (*policy.OpeningBook, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("ReadFile: %v", err)
+	}
+	book := new(policy.OpeningBook)
+	if err := book.GobDecode(b); err != nil {
 		return nil, fmt.Errorf("GobDecode failed: %v", err)
 	}
-	return mdpPol, nil
+	return book, nil
 }
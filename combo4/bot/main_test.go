@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+	"tetris"
+	"tetris/combo4"
+
+	"github.com/google/go-cmp/cmp"
+	kb "github.com/micmonay/keybd_event"
+)
+
+// TestActionsWithHold checks that a move reached by swapping with the Hold
+// uses the actions of the held piece. Pieces spawn in the same place whether
+// they come from the Hold or the preview, so the actions of the held piece
+// are the same as if it was played directly.
+func TestActionsWithHold(t *testing.T) {
+	for _, move := range moves {
+		held := move.Piece
+		current := tetris.I
+		if held == tetris.I {
+			current = tetris.O
+		}
+
+		direct := actions(nfa,
+			combo4.State{Field: move.Start, Hold: current},
+			combo4.State{Field: move.End, Hold: current},
+			held)
+		viaHold := actions(nfa,
+			combo4.State{Field: move.Start, Hold: held},
+			combo4.State{Field: move.End, Hold: current},
+			current)
+
+		if diff := cmp.Diff(mActions[move], direct); diff != "" {
+			t.Errorf("actions for %v played directly differ: (-want +got)\n%s", move, diff)
+		}
+		want := append([]tetris.Action{tetris.Hold}, mActions[move]...)
+		if diff := cmp.Diff(want, viaHold); diff != "" {
+			t.Errorf("actions for %v played from the Hold differ: (-want +got)\n%s", move, diff)
+		}
+	}
+}
+
+func TestActionsHoldFromEmpty(t *testing.T) {
+	got := actions(nfa,
+		combo4.State{Field: combo4.LeftI},
+		combo4.State{Field: combo4.LeftI, Hold: tetris.T},
+		tetris.T)
+	if diff := cmp.Diff([]tetris.Action{tetris.Hold}, got); diff != "" {
+		t.Errorf("actions differ: (-want +got)\n%s", diff)
+	}
+}
+
+func TestActionsToKeyHints(t *testing.T) {
+	acts := []tetris.Action{tetris.Hold, tetris.Right, tetris.RotateCCW, tetris.SoftDrop, tetris.HardDrop}
+	if got, want := ActionsToKeyHints(acts, actionKeys), "C → Right → Z → Down → Space"; got != want {
+		t.Errorf("ActionsToKeyHints(%v) got %q, want %q", acts, got, want)
+	}
+
+	// Actions without a configured key use the name of the Action.
+	keys := map[tetris.Action]int{tetris.Left: kb.VK_LEFT}
+	acts = []tetris.Action{tetris.Left, tetris.RotateCW}
+	if got, want := ActionsToKeyHints(acts, keys), "Left → Rotate_CW"; got != want {
+		t.Errorf("ActionsToKeyHints(%v) with only Left configured got %q, want %q", acts, got, want)
+	}
+	if got := ActionsToKeyHints(nil, actionKeys); got != "" {
+		t.Errorf("ActionsToKeyHints(nil) got %q, want \"\"", got)
+	}
+}
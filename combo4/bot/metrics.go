@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+// metricsSnapshot is the JSON rendering of metrics.
+type metricsSnapshot struct {
+	// The number of pieces played in the current game.
+	ComboLength int `json:"combo_length"`
+	// The number of games started.
+	GamesPlayed int `json:"games_played"`
+	// The number of preview pieces that were misread.
+	Misreads int `json:"misreads"`
+}
+
+// metrics counts what happens in the bot's games. metrics is safe for
+// concurrent use.
+type metrics struct {
+	mu   sync.Mutex
+	snap metricsSnapshot
+}
+
+// update calls fn with the counters locked.
+func (m *metrics) update(fn func(*metricsSnapshot)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fn(&m.snap)
+}
+
+func (m *metrics) snapshot() metricsSnapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.snap
+}
+
+// ServeHTTP renders the counters as JSON.
+func (m *metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(m.snapshot()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMetricsServeHTTP(t *testing.T) {
+	m := new(metrics)
+	m.update(func(s *metricsSnapshot) {
+		s.GamesPlayed = 2
+		s.ComboLength = 31
+		s.Misreads++
+	})
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+
+	if got := rec.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("got Content-Type %q, want application/json", got)
+	}
+	var got map[string]int
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("json.Unmarshal(%q): %v", rec.Body.String(), err)
+	}
+	want := map[string]int{"combo_length": 31, "games_played": 2, "misreads": 1}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("metrics mismatch (-want +got):\n%s", diff)
+	}
+}
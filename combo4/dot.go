@@ -0,0 +1,69 @@
+package combo4
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"tetris"
+)
+
+// ToDOT writes the NFA as a Graphviz digraph. Each State is a node labeled by
+// its field and hold piece, with a * if it is swap restricted. Each edge is
+// labeled by the pieces that transition between the two States. If only is
+// not nil, the States that are not in it and their edges are left out.
+//
+// The nodes are written in the order of State.Less so the output is
+// deterministic.
+func (nfa *NFA) ToDOT(w io.Writer, only StateSet) error {
+	include := func(s State) bool {
+		return only == nil || only[s]
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "digraph NFA {")
+	fmt.Fprintln(bw, "  node [shape=box, fontname=monospace];")
+	for _, state := range nfa.states {
+		if include(state) {
+			fmt.Fprintf(bw, "  s%d [label=%q];\n", state.Pack(), dotLabel(state))
+		}
+	}
+	for _, state := range nfa.states {
+		if !include(state) {
+			continue
+		}
+		// Group the pieces by the next State so there is one edge for each
+		// pair of States.
+		var nextStates []State
+		pieces := make(map[State]string)
+		for _, piece := range tetris.NonemptyPieces {
+			for _, next := range nfa.trans[piece][state] {
+				if !include(next) {
+					continue
+				}
+				if _, ok := pieces[next]; !ok {
+					nextStates = append(nextStates, next)
+				}
+				pieces[next] += piece.String()
+			}
+		}
+		for _, next := range nextStates {
+			fmt.Fprintf(bw, "  s%d -> s%d [label=%q];\n", state.Pack(), next.Pack(), pieces[next])
+		}
+	}
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
+
+// dotLabel returns the label of a State in ToDOT.
+func dotLabel(s State) string {
+	label := strings.TrimSuffix(s.Field.String(), "\n")
+	if label == "" {
+		label = "empty"
+	}
+	label += "\nhold=" + s.Hold.String()
+	if s.SwapRestricted {
+		label += "*"
+	}
+	return label
+}
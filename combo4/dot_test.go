@@ -0,0 +1,69 @@
+package combo4
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"tetris"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestToDOT(t *testing.T) {
+	nfa := NewNFANoHold([]Move{
+		{Start: LeftI, End: RightI, Piece: tetris.I},
+		{Start: LeftI, End: RightI, Piece: tetris.O},
+		{Start: RightI, End: LeftI, Piece: tetris.T},
+	})
+	left, right := State{Field: LeftI}, State{Field: RightI}
+	leftNode := fmt.Sprintf("  s%d [label=%q];\n", left.Pack(), dotLabel(left))
+	rightNode := fmt.Sprintf("  s%d [label=%q];\n", right.Pack(), dotLabel(right))
+	// The nodes are in the order of State.Less.
+	nodes := leftNode + rightNode
+	if right.Less(left) {
+		nodes = rightNode + leftNode
+	}
+	// The pieces are in the order of tetris.NonemptyPieces.
+	edges := fmt.Sprintf("  s%d -> s%d [label=\"OI\"];\n", left.Pack(), right.Pack())
+	edges2 := fmt.Sprintf("  s%d -> s%d [label=\"T\"];\n", right.Pack(), left.Pack())
+	if right.Less(left) {
+		edges = edges2 + edges
+	} else {
+		edges += edges2
+	}
+	const header = "digraph NFA {\n  node [shape=box, fontname=monospace];\n"
+
+	tests := []struct {
+		desc string
+		only StateSet
+		want string
+	}{
+		{
+			desc: "All states",
+			want: header + nodes + edges + "}\n",
+		},
+		{
+			desc: "Only one state",
+			only: NewStateSet(left),
+			want: header + leftNode + "}\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := nfa.ToDOT(&buf, test.only); err != nil {
+				t.Fatalf("ToDOT: %v", err)
+			}
+			if diff := cmp.Diff(test.want, buf.String()); diff != "" {
+				t.Errorf("ToDOT mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDOTLabel(t *testing.T) {
+	state := State{Field: LeftI, Hold: tetris.J, SwapRestricted: true}
+	if got, want := dotLabel(state), "□□□_\nhold=J*"; got != want {
+		t.Errorf("dotLabel got %q, want %q", got, want)
+	}
+}
@@ -1,6 +1,10 @@
 package combo4
 
-import "math/bits"
+import (
+	"fmt"
+	"math/bits"
+	"strings"
+)
 
 // Field4x4 represents the state of a 4x4 group of squares.
 type Field4x4 uint16
@@ -56,6 +60,37 @@ func (f Field4x4) String() string {
 	return string(runes)
 }
 
+// ParseField4x4 parses a Field4x4 from the format of String. The rows are
+// placed at the bottom like in NewField4x4. String leaves out the empty rows
+// so a field with an empty row under an occupied one does not parse back to
+// itself.
+func ParseField4x4(s string) (Field4x4, error) {
+	lines := strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+	if s == "" {
+		lines = nil
+	}
+	if len(lines) > 4 {
+		return 0, fmt.Errorf("field %q has %d rows, want at most 4", s, len(lines))
+	}
+	rows := make([][4]bool, len(lines))
+	for r, line := range lines {
+		runes := []rune(line)
+		if len(runes) != 4 {
+			return 0, fmt.Errorf("row %q of field %q has %d squares, want 4", line, s, len(runes))
+		}
+		for c, square := range runes {
+			switch square {
+			case '□':
+				rows[r][c] = true
+			case '_':
+			default:
+				return 0, fmt.Errorf("row %q of field %q has %q which is not □ or _", line, s, square)
+			}
+		}
+	}
+	return NewField4x4(rows), nil
+}
+
 // Array2D returns a 2D boolean array represenation of the field.
 func (f Field4x4) Array2D() [4][4]bool {
 	var s [4][4]bool
@@ -82,6 +117,42 @@ func (f Field4x4) IsEmpty(row, col int) bool {
 	return uint(f)&mask == 0
 }
 
+// Masks of the squares in the leftmost and rightmost columns.
+const (
+	leftColumn  = 0x1111
+	rightColumn = 0x8888
+)
+
+// EmptyRegions returns the number of regions of empty squares where squares
+// in the same region are connected horizontally or vertically.
+func (f Field4x4) EmptyRegions() int {
+	var regions int
+	for empty := ^uint16(f); empty != 0; regions++ {
+		// Grow the region from its lowest square until it stops changing.
+		region := empty & -empty
+		for {
+			grown := region | region<<4 | region>>4 | region<<1&^leftColumn | region>>1&^rightColumn
+			grown &= empty
+			if grown == region {
+				break
+			}
+			region = grown
+		}
+		empty &^= region
+	}
+	return regions
+}
+
+// HasOverhang returns whether any empty square has an occupied square above
+// it in the same column.
+func (f Field4x4) HasOverhang() bool {
+	// Moving the squares down a row sets every square under an occupied one.
+	covered := uint16(f) << 4
+	covered |= covered << 4
+	covered |= covered << 8
+	return covered&^uint16(f) != 0
+}
+
 // Mirror reflects a Field4x4 across the y axis through the middle.
 func (f Field4x4) Mirror() Field4x4 {
 	array := f.Array2D()
@@ -126,3 +126,140 @@ func TestFieldConsants(t *testing.T) {
 		})
 	}
 }
+
+func TestField4x4Regions(t *testing.T) {
+	const X, o = true, false
+
+	tests := []struct {
+		desc         string
+		input        Field4x4
+		wantRegions  int
+		wantOverhang bool
+	}{
+		{
+			desc:        "Empty",
+			input:       0,
+			wantRegions: 1,
+		},
+		{
+			desc:        "Full",
+			input:       0xffff,
+			wantRegions: 0,
+		},
+		{
+			desc: "Clean well",
+			input: NewField4x4([][4]bool{
+				{X, o, o, o},
+				{X, X, o, X},
+			}),
+			wantRegions: 1,
+		},
+		{
+			desc: "Overhang",
+			input: NewField4x4([][4]bool{
+				{X, X, o, o},
+				{X, o, o, o},
+			}),
+			wantRegions:  1,
+			wantOverhang: true,
+		},
+		{
+			desc: "Covered hole",
+			input: NewField4x4([][4]bool{
+				{X, X, X, X},
+				{X, o, o, X},
+			}),
+			wantRegions:  2,
+			wantOverhang: true,
+		},
+		{
+			desc: "Split by a column",
+			input: NewField4x4([][4]bool{
+				{o, X, o, o},
+				{o, X, o, o},
+				{o, X, o, o},
+				{o, X, o, o},
+			}),
+			wantRegions: 2,
+		},
+		{
+			desc: "Not connected across rows",
+			input: NewField4x4([][4]bool{
+				{X, X, X, o},
+				{o, X, X, X},
+				{X, X, X, X},
+				{X, X, X, X},
+			}),
+			wantRegions:  2,
+			wantOverhang: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			if got := test.input.EmptyRegions(); got != test.wantRegions {
+				t.Errorf("EmptyRegions got %d, want %d", got, test.wantRegions)
+			}
+			if got := test.input.HasOverhang(); got != test.wantOverhang {
+				t.Errorf("HasOverhang got %t, want %t", got, test.wantOverhang)
+			}
+		})
+	}
+}
+
+func TestParseField4x4(t *testing.T) {
+	tests := []struct {
+		desc    string
+		s       string
+		want    Field4x4
+		wantErr bool
+	}{
+		{desc: "empty", s: "", want: 0},
+		{desc: "LeftZ", s: "□___\n□□__\n", want: LeftZ},
+		{desc: "no trailing newline", s: "□□□_", want: LeftI},
+		{desc: "too many rows", s: "____\n____\n____\n____\n□□□_\n", wantErr: true},
+		{desc: "short row", s: "□□□\n", wantErr: true},
+		{desc: "unknown square", s: "XXX_\n", wantErr: true},
+		{desc: "empty line", s: "□___\n\n□□__\n", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			got, err := ParseField4x4(test.s)
+			if gotErr := err != nil; gotErr != test.wantErr {
+				t.Fatalf("ParseField4x4(%q) got error %v, want error=%t", test.s, err, test.wantErr)
+			}
+			if got != test.want {
+				t.Errorf("ParseField4x4(%q) got %v, want %v", test.s, got, test.want)
+			}
+		})
+	}
+}
+
+func FuzzField4x4(f *testing.F) {
+	for _, field := range []Field4x4{0, LeftI, RightI, LeftZ, Field4x4(LeftZ).Mirror()} {
+		f.Add(uint16(field))
+	}
+	f.Fuzz(func(t *testing.T, v uint16) {
+		field := Field4x4(v)
+		if got := field.Mirror().Mirror(); got != field {
+			t.Errorf("Mirror().Mirror() of\n%vgot\n%v", field, got)
+		}
+
+		// String leaves out every empty row so only fields without an
+		// empty row under an occupied one round trip.
+		var seenOccupied bool
+		for r := 0; r < 4; r++ {
+			if !field.isRowEmpty(uint(r)) {
+				seenOccupied = true
+			} else if seenOccupied {
+				return
+			}
+		}
+		got, err := ParseField4x4(field.String())
+		if err != nil {
+			t.Fatalf("ParseField4x4(%q): %v", field.String(), err)
+		}
+		if got != field {
+			t.Errorf("ParseField4x4(%q) got %d, want %d", field.String(), got, field)
+		}
+	})
+}
@@ -1,6 +1,7 @@
 package combo4
 
 import (
+	"errors"
 	"fmt"
 	"tetris"
 )
@@ -17,6 +18,24 @@ func (m Move) String() string {
 	return fmt.Sprintf("{\nStart:\n%v\nEnd:\n%v\nPiece: %v\n}\n", m.Start, m.End, m.Piece)
 }
 
+// ErrDuplicateMove is returned by ValidateNoDuplicateMoves when a Move is in
+// a list more than once.
+var ErrDuplicateMove = errors.New("duplicate move")
+
+// ValidateNoDuplicateMoves returns an ErrDuplicateMove for the first Move
+// that is repeated in the list. Moves with the same Start and Piece but a
+// different End are different choices and not duplicates.
+func ValidateNoDuplicateMoves(moves []Move) error {
+	firstIdx := make(map[Move]int, len(moves))
+	for idx, m := range moves {
+		if first, ok := firstIdx[m]; ok {
+			return fmt.Errorf("%w: %v from %#04x to %#04x at indexes %d and %d", ErrDuplicateMove, m.Piece, uint16(m.Start), uint16(m.End), first, idx)
+		}
+		firstIdx[m] = idx
+	}
+	return nil
+}
+
 type moveActions struct {
 	Start Field4x4
 	End   Field4x4
@@ -674,3 +693,33 @@ func mirrorActions(acts []tetris.Action) []tetris.Action {
 	}
 	return mirror
 }
+
+// TransitionActions returns the actions that go from prev to next when the
+// current piece is played. A Hold swap is included in the actions and the
+// held piece is played instead. The piece spawns in the same place whether it
+// comes from the Hold or not so the actions of a Move are used for both.
+// TransitionActions returns false if the actions are not known.
+func TransitionActions(mActions map[Move][]tetris.Action, prev, next State, current tetris.Piece) ([]tetris.Action, bool) {
+	var actions []tetris.Action
+
+	movePiece := current
+	if prev.Hold != next.Hold {
+		movePiece = prev.Hold
+		actions = append(actions, tetris.Hold)
+
+		// No more actions are need if swapping from EmptyPiece.
+		if prev.Hold == tetris.EmptyPiece {
+			return actions, true
+		}
+	}
+
+	moveActions, ok := mActions[Move{
+		Start: prev.Field,
+		End:   next.Field,
+		Piece: movePiece,
+	}]
+	if !ok {
+		return nil, false
+	}
+	return append(actions, moveActions...), true
+}
@@ -1,7 +1,9 @@
 package combo4
 
 import (
+	"errors"
 	"fmt"
+	"strings"
 	"testing"
 	"tetris"
 
@@ -37,14 +39,8 @@ func TestAllContinuousMoves(t *testing.T) {
 	}
 
 	// Verify that nothing is repeated.
-	moveCount := make(map[Move]int)
-	for _, move := range all {
-		moveCount[move]++
-	}
-	for move, count := range moveCount {
-		if count != 1 {
-			t.Errorf("move %+v occurs %d times, want 1", move, count)
-		}
+	if err := ValidateNoDuplicateMoves(all); err != nil {
+		t.Errorf("ValidateNoDuplicateMoves: %v", err)
 	}
 
 	// Verify that actions have NoAction.
@@ -224,3 +220,73 @@ var canonicalPieceMap = map[Field4x4]tetris.Piece{
 	NewField4x4([][4]bool{{true, true, true, true}}):       tetris.I,
 	NewField4x4([][4]bool{{true}, {true}, {true}, {true}}): tetris.I,
 }
+
+func TestValidateNoDuplicateMoves(t *testing.T) {
+	all, _ := AllContinuousMoves()
+	// A different End for the same Start and Piece is not a duplicate.
+	choice := Move{Start: all[0].Start, End: all[0].End ^ 1, Piece: all[0].Piece}
+	if err := ValidateNoDuplicateMoves(append([]Move{choice}, all...)); err != nil {
+		t.Errorf("ValidateNoDuplicateMoves with another End got %v, want nil", err)
+	}
+
+	withDup := append(append([]Move(nil), all...), all[3])
+	err := ValidateNoDuplicateMoves(withDup)
+	if !errors.Is(err, ErrDuplicateMove) {
+		t.Fatalf("ValidateNoDuplicateMoves got %v, want an ErrDuplicateMove", err)
+	}
+	if want := fmt.Sprintf("indexes 3 and %d", len(all)); !strings.Contains(err.Error(), want) {
+		t.Errorf("got error %q, want it to contain %q", err, want)
+	}
+}
+
+func TestTransitionActions(t *testing.T) {
+	all, mActions := AllContinuousMoves()
+	move := all[0]
+
+	tests := []struct {
+		desc        string
+		prev, next  State
+		current     tetris.Piece
+		want        []tetris.Action
+		wantUnknown bool
+	}{
+		{
+			desc:    "Play the current piece",
+			prev:    State{Field: move.Start, Hold: tetris.O},
+			next:    State{Field: move.End, Hold: tetris.O},
+			current: move.Piece,
+			want:    mActions[move],
+		},
+		{
+			desc:    "Play the held piece",
+			prev:    State{Field: move.Start, Hold: move.Piece},
+			next:    State{Field: move.End, Hold: tetris.O},
+			current: tetris.O,
+			want:    append([]tetris.Action{tetris.Hold}, mActions[move]...),
+		},
+		{
+			desc:    "Hold into an empty Hold",
+			prev:    State{Field: move.Start},
+			next:    State{Field: move.Start, Hold: tetris.O, SwapRestricted: true},
+			current: tetris.O,
+			want:    []tetris.Action{tetris.Hold},
+		},
+		{
+			desc:        "Unknown move",
+			prev:        State{Field: NewField4x4([][4]bool{{true, true, true, true}})},
+			next:        State{Field: move.End},
+			current:     move.Piece,
+			wantUnknown: true,
+		},
+	}
+	for _, test := range tests {
+		got, ok := TransitionActions(mActions, test.prev, test.next, test.current)
+		if ok == test.wantUnknown {
+			t.Errorf("%s: TransitionActions got ok=%t, want %t", test.desc, ok, !test.wantUnknown)
+			continue
+		}
+		if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("%s: TransitionActions mismatch (-want +got):\n%s", test.desc, diff)
+		}
+	}
+}
@@ -2,18 +2,18 @@
 package main
 
 import (
-	"bytes"
-	"compress/gzip"
 	"flag"
 	"fmt"
-	"io"
+	"io/ioutil"
 	"math/rand"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 	"tetris"
 	"tetris/combo4"
 	"tetris/combo4/policy"
-	"text/tabwriter"
 	"time"
 )
 
@@ -21,188 +21,352 @@ var (
 	numTrials     = flag.Int("num_trials", 200, "the number of trials to test each scorer with")
 	previewSize   = flag.Int("preview_size", 6, "the number of pieces you can see in the preview")
 	deterministic = flag.Bool("deterministic", true, "whether the output is the same with each run")
+	policyNames   = flag.String("policies", "seq3,seq6,seq6_nohold,mcts100", "the comma separated names of the registered policies to compare. See registry")
+	mdpFiles      = flag.String("mdp_files", "policy_6preview.gob.gz", "the comma separated paths of gzipped MDPPolicy gob encodings to compare")
+	memoryless    = flag.Bool("memoryless", false, "whether the queues are from a memoryless randomizer instead of a 7 bag randomizer. The MDPPolicy must be trained with policy.Memoryless")
+	tableFile     = flag.String("continuation_table", "continuation_6preview.gob", "the path of the ContinuationTable from gen/continuation used by the ext6 policy")
+	recordDir     = flag.String("record_dir", "", "if set, the decisions of the trials that do not consume every piece are written as JSON lines to a file per policy and trial in this directory. See policy.NewRecorder")
 )
 
 // Which points to keep track of.
 var checkpoints = [...]int{100, 500, 1000, 2000, 5000, 10000, 20000, 30000}
 
-var nfa = func() *combo4.NFA {
-	moves, _ := combo4.AllContinuousMoves()
-	return combo4.NewNFA(moves)
-}()
+var moves, mActions = combo4.AllContinuousMoves()
 
-// The Policies to test.
-var policiesWithNames = [...]struct {
+var nfa = combo4.NewNFA(moves)
+
+var nfaNoHold = combo4.NewNFANoHold(moves)
+
+type namedPolicy struct {
 	name string
 	pol  policy.Policy
-}{
-	{"Seq 3", policy.FromScorer(nfa, policy.NewNFAScorer(nfa, 3))},
-	{"Seq 6", policy.FromScorer(nfa, policy.NewNFAScorer(nfa, 6))},
-	{"MDP 6", newMDPPolicy("policy_6preview.gob.gz")},
+	opts []policy.GameOption
+}
+
+// registry has the Policies that can be compared by the name used in the
+// policies flag. Each Policy is only created if it is selected and an error
+// is returned if it cannot be created.
+var registry = map[string]func() (namedPolicy, error){
+	"seq3": func() (namedPolicy, error) {
+		return namedPolicy{"Seq 3", policy.FromScorer(nfa, policy.NewNFAScorer(nfa, 3)), nil}, nil
+	},
+	"seq6": func() (namedPolicy, error) {
+		return namedPolicy{"Seq 6", policy.FromScorer(nfa, policy.NewNFAScorer(nfa, 6)), nil}, nil
+	},
+	// seq9 is not in the default policies because it is much slower than
+	// seq6 and must be selected explicitly.
+	"seq9": func() (namedPolicy, error) {
+		return namedPolicy{"Seq 9", policy.FromScorer(nfa, policy.NewNFAScorer(nfa, 9)), nil}, nil
+	},
+	"ext6": func() (namedPolicy, error) {
+		table, err := readContinuationTable(*tableFile)
+		if err != nil {
+			return namedPolicy{}, err
+		}
+		if table.PreviewSize() != *previewSize {
+			return namedPolicy{}, fmt.Errorf("%s is for a preview of %d pieces, not %d", *tableFile, table.PreviewSize(), *previewSize)
+		}
+		return namedPolicy{"Ext 6", policy.FromScorer(nfa, policy.NewExtendedScorer(policy.NewNFAScorer(nfa, 6), table)), nil}, nil
+	},
+	"blend6": func() (namedPolicy, error) {
+		scorer := policy.CompositeScorer([]policy.WeightedScorer{
+			{Scorer: policy.NewNFAScorer(nfa, 6), Weight: 0.9},
+			{Scorer: policy.FieldScorer{}, Weight: 0.1},
+		})
+		return namedPolicy{"Blend 6", policy.FromScorer(nfa, scorer), nil}, nil
+	},
+	"embedded": func() (namedPolicy, error) {
+		// The same Policy as the bot without a policy_file. The NFAScorer
+		// is computed without the embedscorer build tag.
+		return namedPolicy{"Embedded", policy.FromScorer(nfa, policy.LoadNFAScorer(nfa, 7), policy.PreferFewerKeys(mActions)), nil}, nil
+	},
+	"seq6_nohold": func() (namedPolicy, error) {
+		return namedPolicy{"Seq 6 (no hold)", policy.FromScorer(nfaNoHold, policy.NewNFAScorer(nfaNoHold, 6)), []policy.GameOption{policy.NoHold()}}, nil
+	},
+	"mcts100": func() (namedPolicy, error) {
+		return namedPolicy{"MCTS 100", policy.NewMCTSPolicy(nfa, policy.MCTSOptions{Simulations: 100}), nil}, nil
+	},
+}
+
+// resolvePolicies returns the registered Policies with the names followed by
+// the MDPPolicies read from the files. It returns an error for a name that
+// is not registered, a registered Policy that cannot be created or a file
+// that cannot be read.
+func resolvePolicies(names, mdpPaths []string) ([]namedPolicy, error) {
+	var policies []namedPolicy
+	for _, name := range names {
+		newPolicy, ok := registry[name]
+		if !ok {
+			known := make([]string, 0, len(registry))
+			for name := range registry {
+				known = append(known, name)
+			}
+			sort.Strings(known)
+			return nil, fmt.Errorf("unknown policy %q, want one of %v", name, known)
+		}
+		d, err := newPolicy()
+		if err != nil {
+			return nil, fmt.Errorf("creating policy %q: %v", name, err)
+		}
+		policies = append(policies, d)
+	}
+	for _, path := range mdpPaths {
+		pol, err := newMDPPolicy(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %v", path, err)
+		}
+		name := "MDP " + strings.TrimSuffix(filepath.Base(path), ".gob.gz")
+		policies = append(policies, namedPolicy{name, pol, nil})
+	}
+	return policies, nil
+}
+
+// splitList returns the comma separated values of a flag.
+func splitList(list string) []string {
+	var values []string
+	for _, v := range strings.Split(list, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// pieceModel returns the NextPieceModel of the queues.
+func pieceModel() policy.NextPieceModel {
+	if *memoryless {
+		return policy.Memoryless{}
+	}
+	return policy.SevenBag{}
 }
 
-func newMDPPolicy(path string) policy.Policy {
-	file, err := os.Open(path)
+func newMDPPolicy(path string) (*policy.MDPPolicy, error) {
+	mdpPol, err := policy.LoadFile(path)
 	if err != nil {
-		fmt.Printf("os.Open: %v\n", err)
-		os.Exit(1)
+		return nil, err
+	}
+	if got, want := mdpPol.Model().Name(), pieceModel().Name(); got != want {
+		return nil, fmt.Errorf("trained with the %q model but the queues are from the %q model", got, want)
 	}
-	defer file.Close()
+	return mdpPol, nil
+}
 
-	var buf bytes.Buffer
-	gz, err := gzip.NewReader(file)
+// readContinuationTable reads a ContinuationTable written by
+// gen/continuation.
+func readContinuationTable(path string) (*policy.ContinuationTable, error) {
+	b, err := ioutil.ReadFile(path)
 	if err != nil {
-		fmt.Printf("gzip.NewReader: %v\n", err)
-		os.Exit(1)
+		return nil, err
+	}
+	table := new(policy.ContinuationTable)
+	if err := table.GobDecode(b); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %v", path, err)
 	}
-	defer gz.Close()
+	return table, nil
+}
 
-	if _, err := io.Copy(&buf, gz); err != nil {
-		fmt.Printf("read file contents failed: %v\n", err)
-		os.Exit(1)
+// failureRecorder records the decisions of each trial with a
+// policy.Recorder to a file in dir and removes the files of the trials that
+// consume every piece of their queue.
+type failureRecorder struct {
+	dir  string
+	name string
+	// The most pieces a trial can consume.
+	maxConsumed int
+
+	mu  sync.Mutex
+	err error
+}
+
+// trialPolicy is the TrialPolicy of the EvalOptions.
+func (r *failureRecorder) trialPolicy(trial int, pol policy.Policy) (policy.Policy, func(int)) {
+	path := filepath.Join(r.dir, fmt.Sprintf("%s_trial%d.jsonl", recordName(r.name), trial))
+	f, err := os.Create(path)
+	if err != nil {
+		r.setErr(fmt.Errorf("failed to create the record of trial %d: %v", trial, err))
+		return pol, nil
+	}
+	rec := policy.NewRecorder(pol, f)
+	return rec, func(consumed int) {
+		err := rec.Err()
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil && consumed >= r.maxConsumed {
+			err = os.Remove(path)
+		}
+		if err != nil {
+			r.setErr(fmt.Errorf("failed to write %s: %v", path, err))
+		}
 	}
+}
 
-	mdpPol := &policy.MDPPolicy{}
-	if err := mdpPol.GobDecode(buf.Bytes()); err != nil {
-		fmt.Printf("GobDecode failed: %v\n", err)
-		os.Exit(1)
+func (r *failureRecorder) setErr(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err == nil {
+		r.err = err
 	}
-	return mdpPol
 }
 
-/* Sample Output
+// Err returns the first error recording the trials or nil.
+func (r *failureRecorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
 
-Preview Size = 6 pieces
-Trials = 200
-Max sequence per trial = 30000
-              Avg       Reach 100   Reach 500   Reach 1000   Reach 2000   Reach 5000   Reach 10000   Reach 20000   Reach 30000
-Seq 3         587.2     67.0%       43.0%       21.5%        5.5%         0.0%         0.0%          0.0%          0.0%
-Seq 6         1102.3    70.5%       56.5%       41.0%        18.0%        2.0%         0.0%          0.0%          0.0%
-MDP 6         2420.9    73.5%       68.0%       57.0%        37.0%        15.0%        3.5%          0.5%          0.0%
-Upper-bound   22717.4   77.0%       77.0%       77.0%        77.0%        77.0%        76.0%         75.0%         75.0%
+// recordName returns the name of a policy for a file name e.g. "seq_3" for
+// "Seq 3".
+func recordName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-', r == '.':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		}
+		return '_'
+	}, name)
+}
 
-*/
+// Sample output:
+//
+//	Preview Size = 6 pieces
+//	Trials = 200
+//	Max sequence per trial = 30000
+//	Randomizer = 7bag
+//	              Avg       Reach 100   Reach 500   Reach 1000   Reach 2000   Reach 5000   Reach 10000   Reach 20000   Reach 30000   p50     p95     Max     Hit rate
+//	Seq 3         587.2     67.0%       43.0%       21.5%        5.5%         0.0%         0.0%          0.0%          0.0%          21µs    58µs    1.9ms   -
+//	Seq 6         1102.3    70.5%       56.5%       41.0%        18.0%        2.0%         0.0%          0.0%          0.0%          143µs   412µs   6.8ms   -
+//	MDP 6         2420.9    73.5%       68.0%       57.0%        37.0%        15.0%        3.5%          0.5%          0.0%          2µs     9µs     3.1ms   96.2%
+//	Upper-bound   22717.4   77.0%       77.0%       77.0%        77.0%        77.0%        76.0%         75.0%         75.0%
 func main() {
 	flag.Parse()
 
+	seed := int64(1)
 	if !*deterministic {
-		rand.Seed(time.Now().UnixNano())
+		seed = time.Now().UnixNano()
 	}
 
-	var (
-		totals [len(policiesWithNames)]int
-		counts [len(policiesWithNames)][len(checkpoints)]int
-
-		nfaTotal  int
-		nfaCounts [len(checkpoints)]int
-	)
-
 	piecesPerTrial := checkpoints[len(checkpoints)-1]
+	evalOpts := policy.EvalOptions{
+		Trials:         *numTrials,
+		PiecesPerTrial: piecesPerTrial,
+		PreviewSize:    *previewSize,
+		Concurrency:    32,
+		Checkpoints:    checkpoints[:],
+	}
+	if *memoryless {
+		evalOpts.Model = pieceModel()
+	}
 
-	// Add the totals and counts for each decider.
-	type queueItem struct {
-		dIdx     int
-		consumed int
-	}
-	policiesCh := make(chan queueItem, 30)
-	var wg sync.WaitGroup
-	wg.Add(1)
-	go func() {
-		for i := 0; i < *numTrials*len(policiesWithNames); i++ {
-			qItem := <-policiesCh
-			for cIdx, c := range checkpoints {
-				if qItem.consumed >= c {
-					counts[qItem.dIdx][cIdx]++
-				}
-			}
-			totals[qItem.dIdx] += qItem.consumed
-		}
-		wg.Done()
-	}()
-
-	// Add the totals and counts for the NFA
-	nfaCh := make(chan int, 10)
-	wg.Add(1)
-	go func() {
-		for i := 0; i < *numTrials; i++ {
-			count := <-nfaCh
-			nfaTotal += count
-			for cIdx, c := range checkpoints {
-				if count > c {
-					nfaCounts[cIdx]++
-				}
-			}
+	// Each policy uses a Rand with the same seed so they play the same
+	// queues.
+	policies, err := resolvePolicies(splitList(*policyNames), splitList(*mdpFiles))
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	// The queues of the trials for the upper-bound. They are the same as
+	// the ones Evaluate generates from the seed.
+	queues := make([][]tetris.Piece, *numTrials)
+	r := rand.New(rand.NewSource(seed))
+	for t := range queues {
+		queues[t] = policy.RandQueue(evalOpts.Model, r, piecesPerTrial+*previewSize+1)
+	}
+	if *recordDir != "" {
+		if err := os.MkdirAll(*recordDir, 0755); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
 		}
-		wg.Done()
-	}()
+	}
 
-	maxConcurrency := make(chan bool, 32)
-	for t := 0; t < *numTrials; t++ {
-		if (t+1)%10 == 0 {
-			fmt.Printf("Trial %d of %d\n", t+1, *numTrials)
+	var (
+		results   = make([]policy.EvalResult, len(policies))
+		latencies = make([]policy.LatencyStats, len(policies))
+		// The hit rate of the MDP policies or "-" for other policies.
+		hitRates = make([]string, len(policies))
+	)
+	for idx, d := range policies {
+		fmt.Printf("Evaluating %s\n", d.name)
+		opts := evalOpts
+		opts.Rand = rand.New(rand.NewSource(seed))
+		opts.GameOptions = d.opts
+		instrumented := policy.Instrumented(d.pol)
+		var recorder *failureRecorder
+		if *recordDir != "" {
+			recorder = &failureRecorder{dir: *recordDir, name: d.name, maxConsumed: piecesPerTrial + 1}
+			opts.TrialPolicy = recorder.trialPolicy
 		}
-		queue := tetris.RandPieces(piecesPerTrial + *previewSize + 1)
-
-		for dIdx, d := range policiesWithNames {
-			dIdx, d := dIdx, d // Capture range variable.
-			maxConcurrency <- true
-			go func() {
-				defer func() { <-maxConcurrency }()
-
-				input := make(chan tetris.Piece, 1)
-
-				output := policy.StartGame(d.pol, combo4.LeftI, queue[0], queue[1:*previewSize+1], input)
-				var consumed int
-				if <-output != nil {
-					consumed++
-					for _, p := range queue[*previewSize+1:] {
-						input <- p
-						if <-output == nil {
-							break
-						}
-						consumed++
-					}
-				}
-				policiesCh <- queueItem{dIdx: dIdx, consumed: consumed}
-			}()
+		results[idx] = policy.Evaluate(instrumented, opts)
+		if recorder != nil {
+			if err := recorder.Err(); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		}
+		latencies[idx] = instrumented.Stats()
+		if mdpPol, ok := d.pol.(*policy.MDPPolicy); ok {
+			hitRates[idx] = fmt.Sprintf("%.1f%%", mdpPol.Stats().HitRate()*100)
+		} else {
+			hitRates[idx] = "-"
 		}
-
-		go func() {
-			_, count := nfa.EndStates(combo4.NewStateSet(combo4.State{Field: combo4.LeftI}), queue)
-			nfaCh <- count
-		}()
 	}
 
-	// Wait for all trials to be computed.
-	wg.Wait()
-
-	fmt.Printf("\n\nPreview Size = %d pieces\nTrials = %d\nMax sequence per trial = %d\n", *previewSize, *numTrials, piecesPerTrial)
-
-	const padding = 3
-	w := tabwriter.NewWriter(os.Stdout, 0, 0, padding, ' ', 0)
-
-	title := "\tAvg"
-	for _, c := range checkpoints {
-		title += fmt.Sprintf("\tReach %d", c)
+	// The upper-bound is computed from the NFA with the same queues.
+	var (
+		nfaTotal  int
+		nfaCounts [len(checkpoints)]int
+	)
+	for _, queue := range queues {
+		_, count := nfa.EndStates(combo4.NewStateSet(combo4.State{Field: combo4.LeftI}), queue)
+		nfaTotal += count
+		for cIdx, c := range checkpoints {
+			if count > c {
+				nfaCounts[cIdx]++
+			}
+		}
 	}
-	fmt.Fprintln(w, title)
 
-	const fmtString = "\t%.1f%%"
-	for idx, d := range policiesWithNames {
-		row := d.name
-		row += fmt.Sprintf("\t%.1f", float64(totals[idx])/float64(*numTrials))
-		for _, count := range counts[idx] {
-			row += fmt.Sprintf(fmtString, float64(count*100)/float64(*numTrials))
+	fmt.Printf("\n\nPreview Size = %d pieces\nTrials = %d\nMax sequence per trial = %d\nRandomizer = %s\n", *previewSize, *numTrials, piecesPerTrial, pieceModel().Name())
+
+	// Every policy played the same queues so they can be compared on each.
+	// The rows are in the order of the policies flag.
+	var (
+		paired  = make(map[string]policy.EvalResult, len(policies))
+		names   = make([]string, len(policies))
+		columns = []policy.TableColumn{
+			{Title: "p50", Cells: map[string]string{}},
+			{Title: "p95", Cells: map[string]string{}},
+			{Title: "Max", Cells: map[string]string{}},
+			{Title: "Hit rate", Cells: map[string]string{}},
 		}
-		fmt.Fprintln(w, row)
+	)
+	for idx, d := range policies {
+		paired[d.name] = results[idx]
+		names[idx] = d.name
+		columns[0].Cells[d.name] = fmt.Sprint(latencies[idx].P50)
+		columns[1].Cells[d.name] = fmt.Sprint(latencies[idx].P95)
+		columns[2].Cells[d.name] = fmt.Sprint(latencies[idx].Max)
+		columns[3].Cells[d.name] = hitRates[idx]
 	}
-
-	nfaRow := "Upper-bound"
-	nfaRow += fmt.Sprintf("\t%.1f", float64(nfaTotal)/float64(*numTrials))
+	tournament := policy.NewTournamentResult(paired, checkpoints[:])
+	tournament.Names = names
+	tournament.ExtraColumns = columns
+	upperBound := policy.EvalResult{Mean: float64(nfaTotal) / float64(*numTrials)}
 	for _, count := range nfaCounts {
-		nfaRow += fmt.Sprintf(fmtString, float64(count*100)/float64(*numTrials))
+		upperBound.Reach = append(upperBound.Reach, float64(count)/float64(*numTrials))
+	}
+	tournament.ExtraRows = []policy.TableRow{{Name: "Upper-bound", Result: upperBound}}
+	if err := tournament.WriteTable(os.Stdout); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
 	}
-	fmt.Fprintln(w, nfaRow)
 
-	w.Flush()
+	fmt.Printf("\nWins-losses-ties of each row against each column on the same queues and the mean difference in pieces\n")
+	if err := tournament.WritePairs(os.Stdout); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"tetris"
+	"tetris/combo4"
+	"tetris/combo4/policy"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestResolvePolicies(t *testing.T) {
+	policies, err := resolvePolicies([]string{"seq3"}, nil)
+	if err != nil {
+		t.Fatalf("resolvePolicies: %v", err)
+	}
+	if len(policies) != 1 || policies[0].name != "Seq 3" || policies[0].pol == nil {
+		t.Errorf("resolvePolicies(seq3) got %+v, want Seq 3", policies)
+	}
+
+	blend, err := resolvePolicies([]string{"blend6"}, nil)
+	if err != nil || len(blend) != 1 || blend[0].name != "Blend 6" || blend[0].pol == nil {
+		t.Errorf("resolvePolicies(blend6) got %+v, %v, want Blend 6", blend, err)
+	}
+
+	embedded, err := resolvePolicies([]string{"embedded"}, nil)
+	if err != nil || len(embedded) != 1 || embedded[0].pol == nil {
+		t.Errorf("resolvePolicies(embedded) got %+v, %v, want the embedded Policy", embedded, err)
+	}
+
+	if _, err := resolvePolicies([]string{"seq3", "unknown"}, nil); err == nil || !strings.Contains(err.Error(), `"unknown"`) {
+		t.Errorf("resolvePolicies with an unknown name got error %v, want one naming it", err)
+	}
+
+	missing := filepath.Join(t.TempDir(), "missing.gob.gz")
+	if _, err := resolvePolicies(nil, []string{missing}); err == nil {
+		t.Errorf("resolvePolicies with a missing MDP file got no error")
+	}
+
+	defer func(old string) { *tableFile = old }(*tableFile)
+	*tableFile = filepath.Join(t.TempDir(), "missing.gob")
+	if _, err := resolvePolicies([]string{"ext6"}, nil); err == nil || !strings.Contains(err.Error(), `"ext6"`) {
+		t.Errorf("resolvePolicies(ext6) with a missing table got error %v, want one naming ext6", err)
+	}
+}
+
+// registered returns the registered Policy with the name.
+func registered(t *testing.T, name string) namedPolicy {
+	t.Helper()
+	d, err := registry[name]()
+	if err != nil {
+		t.Fatalf("creating policy %q: %v", name, err)
+	}
+	return d
+}
+
+func TestSeq9OptIn(t *testing.T) {
+	if _, ok := registry["seq9"]; !ok {
+		t.Fatalf("seq9 is not registered")
+	}
+	for _, name := range splitList(*policyNames) {
+		if name == "seq9" {
+			t.Errorf("seq9 is in the default policies %q, want it opt-in", *policyNames)
+		}
+	}
+}
+
+func TestSplitList(t *testing.T) {
+	got := splitList(" seq3, ,mcts100,")
+	if len(got) != 2 || got[0] != "seq3" || got[1] != "mcts100" {
+		t.Errorf("splitList got %q, want [seq3 mcts100]", got)
+	}
+	if got := splitList(""); got != nil {
+		t.Errorf("splitList of an empty flag got %q, want none", got)
+	}
+}
+
+// evalQuick evaluates a Policy on a few short queues that are the same for
+// every call.
+func evalQuick(pol policy.Policy) policy.EvalResult {
+	return policy.Evaluate(pol, policy.EvalOptions{
+		Trials:         10,
+		PiecesPerTrial: 100,
+		PreviewSize:    3,
+		Rand:           rand.New(rand.NewSource(1)),
+	})
+}
+
+func TestPolicyFuncNeverChooses(t *testing.T) {
+	// A PolicyFunc that never chooses plays exactly like its fallback.
+	never := policy.PolicyFunc(func(combo4.State, tetris.Piece, []tetris.Piece, tetris.PieceSet) *combo4.State {
+		return nil
+	})
+	seq3 := registered(t, "seq3").pol
+	if diff := cmp.Diff(evalQuick(seq3), evalQuick(policy.WrapWithFallback(never, seq3))); diff != "" {
+		t.Errorf("WrapWithFallback(never, seq3) result mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestPolicyFuncHoldAsLastResort(t *testing.T) {
+	// Plays without the hold and lets Seq 3 use it when there is no other
+	// move. Each game plays the same as without the hold until that game
+	// would end so it consumes at least as many pieces.
+	noHold := policy.FromScorer(nfaNoHold, policy.NewNFAScorer(nfaNoHold, 3))
+	withoutHold := policy.PolicyFunc(func(initial combo4.State, current tetris.Piece, preview []tetris.Piece, endBagUsed tetris.PieceSet) *combo4.State {
+		if initial.Hold != tetris.EmptyPiece {
+			return nil
+		}
+		return noHold.NextState(initial, current, preview, endBagUsed)
+	})
+	seq3 := registered(t, "seq3").pol
+	var fallbacks int64
+	fallback := policy.PolicyFunc(func(initial combo4.State, current tetris.Piece, preview []tetris.Piece, endBagUsed tetris.PieceSet) *combo4.State {
+		atomic.AddInt64(&fallbacks, 1)
+		return seq3.NextState(initial, current, preview, endBagUsed)
+	})
+	got := evalQuick(policy.WrapWithFallback(withoutHold, fallback))
+	want := evalQuick(noHold)
+	for i := range want.Consumed {
+		if got.Consumed[i] < want.Consumed[i] {
+			t.Errorf("trial %d consumed %d pieces with the hold as a last resort, want at least the %d without it", i, got.Consumed[i], want.Consumed[i])
+		}
+	}
+	if want.WinRate < 1 && fallbacks == 0 {
+		t.Errorf("Seq 3 was never used although a game without the hold ended early")
+	}
+}
+
+func TestFailureRecorder(t *testing.T) {
+	dir := t.TempDir()
+	d := registered(t, "seq3")
+	const piecesPerTrial = 100
+	recorder := &failureRecorder{dir: dir, name: d.name, maxConsumed: piecesPerTrial + 1}
+	opts := policy.EvalOptions{
+		Trials:         6,
+		PiecesPerTrial: piecesPerTrial,
+		PreviewSize:    *previewSize,
+		Rand:           rand.New(rand.NewSource(1)),
+		TrialPolicy:    recorder.trialPolicy,
+	}
+	result := policy.Evaluate(policy.Instrumented(d.pol), opts)
+	if err := recorder.Err(); err != nil {
+		t.Fatalf("Err: %v", err)
+	}
+
+	// Only the trials that did not consume every piece are recorded.
+	var want []string
+	var failed int
+	for trial, c := range result.Consumed {
+		if c < piecesPerTrial+1 {
+			want = append(want, filepath.Join(dir, fmt.Sprintf("seq_3_trial%d.jsonl", trial)))
+			failed = trial
+		}
+	}
+	if len(want) == 0 || len(want) == opts.Trials {
+		t.Fatalf("got %d failed trials of %d, want some to fail and some to win", len(want), opts.Trials)
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		t.Fatalf("Glob: %v", err)
+	}
+	if diff := cmp.Diff(want, files); diff != "" {
+		t.Fatalf("recorded files mismatch (-want +got):\n%s", diff)
+	}
+
+	f, err := os.Open(want[len(want)-1])
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer f.Close()
+	records, err := policy.ReadRecords(f)
+	if err != nil {
+		t.Fatalf("ReadRecords: %v", err)
+	}
+	if got := len(records); got < result.Consumed[failed] {
+		t.Fatalf("got %d records, want one for each of the %d pieces consumed", got, result.Consumed[failed])
+	}
+	var scored bool
+	for _, rec := range records {
+		scored = scored || len(rec.Candidates) > 0
+	}
+	if !scored {
+		t.Errorf("got no candidates in the records of trial %d", failed)
+	}
+}
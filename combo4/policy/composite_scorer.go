@@ -0,0 +1,105 @@
+package policy
+
+import (
+	"tetris"
+	"tetris/combo4"
+)
+
+// NormalizedScorer is a Scorer that can also score a situation in [0, 1] so
+// its scores can be blended with other Scorers by a CompositeScorer.
+type NormalizedScorer interface {
+	Scorer
+	// NormalizedScore returns the score in [0, 1]. More is better and it
+	// must not order two situations the opposite of Score.
+	NormalizedScore(state combo4.State, next []tetris.Piece, bagUsed tetris.PieceSet) float64
+}
+
+// WeightedScorer is a component of a CompositeScorer.
+type WeightedScorer struct {
+	Scorer NormalizedScorer
+	// The weight of the normalized scores of the Scorer. It must not be
+	// negative.
+	Weight float64
+}
+
+type compositeScorer struct {
+	components  []WeightedScorer
+	totalWeight float64
+}
+
+// CompositeScorer returns a Scorer of the weighted mean of the normalized
+// scores of the components e.g. 0.9 of an NFAScorer and 0.1 of a FieldScorer.
+// The mean is in ScoreBreakdown.Normalized and the components are summed in
+// order so the scores are deterministic. The Scorer is also a
+// NormalizedScorer so composites can be nested.
+func CompositeScorer(components []WeightedScorer) NormalizedScorer {
+	c := &compositeScorer{components: append([]WeightedScorer(nil), components...)}
+	for _, component := range components {
+		c.totalWeight += component.Weight
+	}
+	return c
+}
+
+func (c *compositeScorer) Score(state combo4.State, next []tetris.Piece, bagUsed tetris.PieceSet) ScoreBreakdown {
+	return ScoreBreakdown{Normalized: c.NormalizedScore(state, next, bagUsed)}
+}
+
+func (c *compositeScorer) NormalizedScore(state combo4.State, next []tetris.Piece, bagUsed tetris.PieceSet) float64 {
+	if c.totalWeight == 0 {
+		return 0
+	}
+	var sum float64
+	for _, component := range c.components {
+		if component.Weight == 0 {
+			continue
+		}
+		sum += component.Weight * component.Scorer.NormalizedScore(state, next, bagUsed)
+	}
+	return sum / c.totalWeight
+}
+
+// FieldScorer scores a situation by the field of the State alone. A field is
+// better with fewer holes, which are empty squares under an occupied square
+// in the same column, and then with a lower highest column. The next pieces
+// are not used.
+type FieldScorer struct{}
+
+// The most holes and the highest column of a Field4x4.
+const (
+	maxHoles  = 12
+	maxHeight = 4
+)
+
+// Score returns the NormalizedScore in ScoreBreakdown.Normalized.
+func (FieldScorer) Score(state combo4.State, next []tetris.Piece, bagUsed tetris.PieceSet) ScoreBreakdown {
+	return ScoreBreakdown{Normalized: FieldScorer{}.NormalizedScore(state, next, bagUsed)}
+}
+
+// NormalizedScore returns 1 for an empty field and 0 for a field with the
+// most holes.
+func (FieldScorer) NormalizedScore(state combo4.State, _ []tetris.Piece, _ tetris.PieceSet) float64 {
+	holes, height := fieldHolesAndHeight(state.Field)
+	// Each hole costs more than the highest column.
+	penalty := holes*(maxHeight+1) + height
+	return 1 - float64(penalty)/float64(maxHoles*(maxHeight+1)+maxHeight)
+}
+
+// fieldHolesAndHeight returns the number of holes of the field and the
+// height of its highest column.
+func fieldHolesAndHeight(f combo4.Field4x4) (holes, height int) {
+	for col := 0; col < 4; col++ {
+		covered := false
+		for row := 0; row < 4; row++ {
+			switch {
+			case !f.IsEmpty(row, col):
+				if !covered && 4-row > height {
+					height = 4 - row
+				}
+				covered = true
+			case covered:
+				holes++
+			}
+		}
+	}
+	return holes, height
+}
@@ -0,0 +1,153 @@
+package policy
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"tetris"
+	"tetris/combo4"
+)
+
+func TestFieldScorer(t *testing.T) {
+	tests := []struct {
+		desc  string
+		field [][4]bool
+		want  float64
+	}{
+		{
+			desc: "empty",
+			want: 1,
+		},
+		{
+			desc:  "LeftI",
+			field: [][4]bool{{true, true, true, false}},
+			want:  1 - 1.0/64,
+		},
+		{
+			desc: "hole under an overhang",
+			field: [][4]bool{
+				{true, false, false, false},
+				{false, true, true, true},
+			},
+			want: 1 - (5.0+2)/64,
+		},
+		{
+			desc:  "top row only",
+			field: [][4]bool{{true, true, true, true}, {}, {}, {}},
+			want:  0,
+		},
+	}
+	for _, test := range tests {
+		state := combo4.State{Field: combo4.NewField4x4(test.field)}
+		if got := (FieldScorer{}).NormalizedScore(state, nil, 0); math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("%s: NormalizedScore got %v, want %v", test.desc, got, test.want)
+		}
+		if got := (FieldScorer{}).Score(state, nil, 0).Normalized; math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("%s: Score got Normalized=%v, want %v", test.desc, got, test.want)
+		}
+	}
+}
+
+// constNormalizedScorer gives every situation the same normalized score.
+type constNormalizedScorer float64
+
+func (c constNormalizedScorer) Score(combo4.State, []tetris.Piece, tetris.PieceSet) ScoreBreakdown {
+	return ScoreBreakdown{Normalized: float64(c)}
+}
+
+func (c constNormalizedScorer) NormalizedScore(combo4.State, []tetris.Piece, tetris.PieceSet) float64 {
+	return float64(c)
+}
+
+func TestCompositeScorer(t *testing.T) {
+	tests := []struct {
+		desc       string
+		components []WeightedScorer
+		want       float64
+	}{
+		{
+			desc: "weighted mean",
+			components: []WeightedScorer{
+				{constNormalizedScorer(1), 0.9},
+				{constNormalizedScorer(0), 0.1},
+			},
+			want: 0.9,
+		},
+		{
+			desc: "weights are relative",
+			components: []WeightedScorer{
+				{constNormalizedScorer(0.5), 3},
+				{constNormalizedScorer(1), 1},
+			},
+			want: 0.625,
+		},
+		{
+			desc: "nested",
+			components: []WeightedScorer{
+				{CompositeScorer([]WeightedScorer{{constNormalizedScorer(1), 1}, {constNormalizedScorer(0), 1}}), 1},
+				{constNormalizedScorer(0.5), 1},
+			},
+			want: 0.5,
+		},
+		{
+			desc: "no weight",
+			components: []WeightedScorer{
+				{constNormalizedScorer(1), 0},
+			},
+			want: 0,
+		},
+	}
+	for _, test := range tests {
+		scorer := CompositeScorer(test.components)
+		if got := scorer.Score(combo4.State{}, nil, 0).Normalized; math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("%s: Score got Normalized=%v, want %v", test.desc, got, test.want)
+		}
+	}
+}
+
+func TestNFAScorerNormalizedScore(t *testing.T) {
+	moves, _ := combo4.AllContinuousMoves()
+	nfa := combo4.NewNFA(moves)
+	scorer := NewNFAScorer(nfa, 3)
+	states := nfa.States().Slice()
+	r := rand.New(rand.NewSource(1))
+
+	// The normalized scores never order two situations the opposite way.
+	for i := 0; i < 500; i++ {
+		next := tetris.RandPiecesFrom(r, 1+r.Intn(4))
+		bag := tetris.NewPieceSet(next...)
+		a, b := states[r.Intn(len(states))], states[r.Intn(len(states))]
+		scoreA, scoreB := scorer.Score(a, next, bag), scorer.Score(b, next, bag)
+		normA, normB := scorer.NormalizedScore(a, next, bag), scorer.NormalizedScore(b, next, bag)
+		if normA < 0 || normA > 1 {
+			t.Fatalf("NormalizedScore(%v, %v) got %v, want it in [0, 1]", a, next, normA)
+		}
+		if cmp := scoreA.Compare(scoreB); cmp > 0 && normA < normB || cmp < 0 && normA > normB {
+			t.Fatalf("NormalizedScore of %v and %v with %v got %v and %v, want the order of %+v and %+v", a, b, next, normA, normB, scoreA, scoreB)
+		}
+	}
+}
+
+func TestCompositeScorerPolicy(t *testing.T) {
+	moves, _ := combo4.AllContinuousMoves()
+	nfa := combo4.NewNFA(moves)
+	nfaScorer := NewNFAScorer(nfa, 3)
+	blend := FromScorer(nfa, CompositeScorer([]WeightedScorer{
+		{nfaScorer, 0.9},
+		{FieldScorer{}, 0.1},
+	}))
+	opts := EvalOptions{
+		Trials:         10,
+		PiecesPerTrial: 50,
+		PreviewSize:    3,
+		Rand:           rand.New(rand.NewSource(1)),
+	}
+	first := Evaluate(blend, opts)
+	if first.Mean < 1 {
+		t.Errorf("the blended policy got a mean of %v pieces, want at least 1", first.Mean)
+	}
+	opts.Rand = rand.New(rand.NewSource(1))
+	if again := Evaluate(blend, opts); again.Mean != first.Mean {
+		t.Errorf("the blended policy got a mean of %v then %v, want it deterministic", first.Mean, again.Mean)
+	}
+}
@@ -0,0 +1,118 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// SweepStats is how much the values of an MDP changed in a sweep.
+type SweepStats struct {
+	// The largest change of a value.
+	Residual float64
+	// The number of values that changed by at least the epsilon that
+	// training stops at.
+	Unconverged int
+}
+
+// observe counts the change of a value.
+func (s *SweepStats) observe(change float64) {
+	change = math.Abs(change)
+	s.Residual = math.Max(s.Residual, change)
+	if change >= epsilon {
+		s.Unconverged++
+	}
+}
+
+// merge adds the changes counted by other.
+func (s *SweepStats) merge(other SweepStats) {
+	s.Residual = math.Max(s.Residual, other.Residual)
+	s.Unconverged += other.Unconverged
+}
+
+// ConvergenceInfo is how close the values of an MDP are to converging. Update
+// stops once no value changes by epsilon in a sweep but it can be stopped
+// early by MaxSweeps or by interrupting it, in which case the values are
+// underestimates.
+//
+// The Residual is not a strict bound on the error of the values. Each value
+// can still grow by about the Residual times the number of sweeps left.
+type ConvergenceInfo struct {
+	// The SweepStats of the last sweep. The Residual is +Inf if the values
+	// were never swept or it is Unrecorded.
+	SweepStats
+	// Unrecorded is set if the MDP was decoded from an encoding from before
+	// the residual was recorded so whether its values converged is unknown
+	// until it is updated.
+	Unrecorded bool
+	// The SweepStats of each sweep of the values in the last Update in
+	// order. They are not encoded.
+	History []SweepStats
+}
+
+// unsweptConvergence returns the ConvergenceInfo of values that were never
+// swept.
+func unsweptConvergence() ConvergenceInfo {
+	return ConvergenceInfo{SweepStats: SweepStats{Residual: math.Inf(1)}}
+}
+
+// add records the sweeps after the ones already recorded.
+func (c *ConvergenceInfo) add(stats []SweepStats) {
+	if len(stats) == 0 {
+		return
+	}
+	c.SweepStats = stats[len(stats)-1]
+	c.Unrecorded = false
+	c.History = append(c.History, stats...)
+}
+
+// ConvergenceInfo returns how close the values of the MDP are to converging.
+func (m *MDP) ConvergenceInfo() ConvergenceInfo {
+	info := m.convergence
+	info.History = append([]SweepStats(nil), info.History...)
+	return info
+}
+
+// Converged returns whether no value changed by epsilon in the last sweep.
+func (m *MDP) Converged() bool {
+	return m.convergence.Residual < epsilon
+}
+
+// ErrNotConverged is returned when a policy is taken from an MDP whose values
+// have not converged so they may be underestimates.
+var ErrNotConverged = errors.New("values have not converged")
+
+// CheckConverged returns an ErrNotConverged if the MDP has not Converged
+// which says whether the residual is unknown or how large it is.
+func (m *MDP) CheckConverged() error {
+	switch info := m.convergence; {
+	case m.Converged():
+		return nil
+	case info.Unrecorded:
+		return fmt.Errorf("%w: the MDP is from before the residual was recorded so it is unknown", ErrNotConverged)
+	case math.IsInf(info.Residual, 1):
+		return fmt.Errorf("%w: the MDP was never updated", ErrNotConverged)
+	default:
+		return fmt.Errorf("%w: the MDP has a residual of %v with %d values still changing", ErrNotConverged, info.Residual, info.Unconverged)
+	}
+}
+
+// SetMaxSweeps changes the MaxSweeps of the MDP e.g. after it is decoded. See
+// MDPOptions.
+func (m *MDP) SetMaxSweeps(maxSweeps int) {
+	m.maxSweeps = maxSweeps
+}
+
+// ExpectedValueWithResidual is like ExpectedValue but also returns the
+// Residual of the values. The value of a GameState that is not in the MDP is
+// only a lower bound from its preview so its residual is +Inf.
+func (m *MDP) ExpectedValueWithResidual(gState GameState) (value, residual float64) {
+	value = m.ExpectedValue(gState)
+	if isSevenBag(m.model) {
+		gState.BagUsed = fullIfEmpty(gState.BagUsed)
+	}
+	if _, ok := m.value[gState]; !ok {
+		return value, math.Inf(1)
+	}
+	return value, m.convergence.Residual
+}
@@ -0,0 +1,77 @@
+package policy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"tetris"
+)
+
+// CorpusResult is the result of EvaluateCorpus. WinRate is the fraction of
+// the queues that were survived since the queues can have different
+// lengths.
+type CorpusResult struct {
+	EvalResult
+	// Whether every piece of each queue was consumed.
+	Survived []bool
+}
+
+// EvaluateCorpus plays a game of each queue like Evaluate and summarizes how
+// many pieces were consumed. The result only depends on the queues for a
+// deterministic Policy so it can be compared between runs. The PreviewSize,
+// Concurrency, Checkpoints, GameOptions and Model of opts are used.
+func EvaluateCorpus(pol Policy, queues [][]tetris.Piece, opts EvalOptions) CorpusResult {
+	consumed := simulateQueues(pol, queues, opts)
+	res := CorpusResult{
+		// The WinRate is replaced by the fraction survived.
+		EvalResult: summarize(consumed, opts.Checkpoints, 0),
+		Survived:   make([]bool, len(queues)),
+	}
+	var survived int
+	for t, queue := range queues {
+		if len(queue) > opts.PreviewSize && consumed[t] == len(queue)-opts.PreviewSize {
+			res.Survived[t] = true
+			survived++
+		}
+	}
+	if len(queues) > 0 {
+		res.WinRate = float64(survived) / float64(len(queues))
+	}
+	return res
+}
+
+// ReadCorpus parses a corpus of queues for EvaluateCorpus. Each queue is a
+// line from tetris.CompressSeq. Empty lines and lines starting with "#" are
+// ignored.
+func ReadCorpus(r io.Reader) ([][]tetris.Piece, error) {
+	var queues [][]tetris.Piece
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		queue, err := tetris.DecompressSeq(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNum, err)
+		}
+		queues = append(queues, queue)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return queues, nil
+}
+
+// WriteCorpus writes the queues in the format of ReadCorpus.
+func WriteCorpus(w io.Writer, queues [][]tetris.Piece) error {
+	bw := bufio.NewWriter(w)
+	for _, queue := range queues {
+		if _, err := fmt.Fprintln(bw, tetris.CompressSeq(queue)); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,75 @@
+package policy
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"tetris"
+	"tetris/combo4"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestEvaluateCorpus(t *testing.T) {
+	moves, _ := combo4.AllContinuousMoves()
+	nfa := combo4.NewNFA(moves)
+	seq3 := FromScorer(nfa, NewNFAScorer(nfa, 3))
+	// Plays like seq3 until the first O piece.
+	noO := PolicyFunc(func(initial combo4.State, current tetris.Piece, preview []tetris.Piece, endBagUsed tetris.PieceSet) *combo4.State {
+		if current == tetris.O {
+			return nil
+		}
+		return seq3.NextState(initial, current, preview, endBagUsed)
+	})
+	queues := [][]tetris.Piece{
+		// The O is the 5th piece.
+		tetris.SeqFromStr("TLJSOZI"),
+		// No O in the 4 pieces after the preview.
+		tetris.SeqFromStr("ILJT"),
+		// Shorter than the preview.
+		tetris.SeqFromStr("T"),
+		// The I after the preview does not follow the 7 bag.
+		tetris.SeqFromStr("ITIL"),
+	}
+	opts := EvalOptions{PreviewSize: 1, Checkpoints: []int{3}}
+
+	got := EvaluateCorpus(noO, queues, opts)
+	want := CorpusResult{
+		EvalResult: EvalResult{
+			Consumed: []int{4, 3, 0, 1},
+			Mean:     2,
+			Median:   2,
+			Reach:    []float64{0.5},
+			WinRate:  0.25,
+		},
+		Survived: []bool{false, true, false, false},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("EvaluateCorpus mismatch (-want +got):\n%s", diff)
+	}
+	if again := EvaluateCorpus(noO, queues, opts); !cmp.Equal(got, again) {
+		t.Errorf("EvaluateCorpus got %+v then %+v, want the same result", got, again)
+	}
+}
+
+func TestCorpusRoundTrip(t *testing.T) {
+	queues := [][]tetris.Piece{
+		tetris.SeqFromStr("TLJSOZI"),
+		tetris.SeqFromStr("ILJ"),
+	}
+	var buf bytes.Buffer
+	if err := WriteCorpus(&buf, queues); err != nil {
+		t.Fatalf("WriteCorpus: %v", err)
+	}
+	got, err := ReadCorpus(strings.NewReader("# A comment.\n\n" + buf.String()))
+	if err != nil {
+		t.Fatalf("ReadCorpus: %v", err)
+	}
+	if diff := cmp.Diff(queues, got); diff != "" {
+		t.Errorf("ReadCorpus(WriteCorpus) mismatch (-want +got):\n%s", diff)
+	}
+
+	if _, err := ReadCorpus(strings.NewReader("B3\n!\n")); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("ReadCorpus with an invalid line got error %v, want one naming line 2", err)
+	}
+}
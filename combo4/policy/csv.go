@@ -0,0 +1,91 @@
+package policy
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math/rand"
+	"strconv"
+	"strings"
+	"tetris"
+	"tetris/combo4"
+)
+
+// CSVOptions configures MDP.WriteCSV.
+type CSVOptions struct {
+	// SampleRate is the fraction of GameStates to write. If 0, every
+	// GameState is written.
+	SampleRate float64
+	// Rand is used to sample the GameStates. If nil, the math/rand
+	// functions are used.
+	Rand *rand.Rand
+}
+
+// csvHeader is the first row written by WriteCSV.
+var csvHeader = []string{"state", "field", "hold", "current", "preview", "bag", "value", "next_field", "next_hold"}
+
+// WriteCSV writes one row for each GameState in the MDP with its expected
+// value and chosen next state. The rows are written as the GameStates are
+// visited so the MDP is never copied. The fields are written as 4 rows from
+// top to bottom separated by slashes with X for an occupied square and _ for
+// an empty square. Pieces are written as letters and EmptyPiece is empty.
+func (m *MDP) WriteCSV(w io.Writer, opts CSVOptions) error {
+	sample := rand.Float64
+	if opts.Rand != nil {
+		sample = opts.Rand.Float64
+	}
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return fmt.Errorf("writing the header: %v", err)
+	}
+	for gState := range m.value {
+		if opts.SampleRate != 0 && sample() >= opts.SampleRate {
+			continue
+		}
+		choice := m.policy[gState]
+		row := []string{
+			strconv.FormatUint(uint64(gState.State.Pack()), 10),
+			fieldCSVString(gState.State.Field),
+			piecesCSVString(gState.State.Hold),
+			piecesCSVString(gState.Current),
+			piecesCSVString(gState.Preview.Slice()...),
+			piecesCSVString(gState.BagUsed.Slice()...),
+			strconv.FormatFloat(m.ExpectedValue(gState), 'f', -1, 64),
+			fieldCSVString(choice.Field),
+			piecesCSVString(choice.Hold),
+		}
+		if err := cw.Write(row); err != nil {
+			return fmt.Errorf("writing the row for %v: %v", gState, err)
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func fieldCSVString(f combo4.Field4x4) string {
+	var sb strings.Builder
+	for r, row := range f.Array2D() {
+		if r > 0 {
+			sb.WriteByte('/')
+		}
+		for _, occupied := range row {
+			if occupied {
+				sb.WriteByte('X')
+			} else {
+				sb.WriteByte('_')
+			}
+		}
+	}
+	return sb.String()
+}
+
+func piecesCSVString(pieces ...tetris.Piece) string {
+	var sb strings.Builder
+	for _, p := range pieces {
+		if p != tetris.EmptyPiece {
+			sb.WriteString(p.String())
+		}
+	}
+	return sb.String()
+}
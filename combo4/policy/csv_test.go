@@ -0,0 +1,93 @@
+package policy
+
+import (
+	"bytes"
+	"encoding/csv"
+	"math/rand"
+	"strconv"
+	"testing"
+	"tetris"
+	"tetris/combo4"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMDPWriteCSV(t *testing.T) {
+	t.Parallel()
+
+	mdp, err := NewMDP(1)
+	if err != nil {
+		t.Fatalf("NewMDP: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := mdp.WriteCSV(&buf, CSVOptions{}); err != nil {
+		t.Fatalf("WriteCSV: %v", err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("reading the CSV: %v", err)
+	}
+	if diff := cmp.Diff(csvHeader, rows[0]); diff != "" {
+		t.Errorf("header mismatch (-want +got):\n%s", diff)
+	}
+	if got, want := len(rows)-1, len(mdp.value); got != want {
+		t.Errorf("got %d rows, want %d", got, want)
+	}
+
+	gState := GameState{
+		State: combo4.State{
+			Hold: tetris.J,
+			Field: combo4.NewField4x4([][4]bool{
+				{true, false, false, false},
+				{true, true, false, false},
+			}),
+		},
+		Current: tetris.S,
+		Preview: tetris.MustSeq([]tetris.Piece{tetris.O}),
+		BagUsed: tetris.NewPieceSet(tetris.O, tetris.S),
+	}
+	choice := mdp.policy[gState]
+	want := []string{
+		strconv.FormatUint(uint64(gState.State.Pack()), 10),
+		"____/____/X___/XX__",
+		"J",
+		"S",
+		"O",
+		"SO",
+		"2",
+		fieldCSVString(choice.Field),
+		piecesCSVString(choice.Hold),
+	}
+	var found bool
+	for _, row := range rows[1:] {
+		if row[1] != want[1] || row[2] != want[2] || row[3] != want[3] || row[4] != want[4] || row[5] != want[5] {
+			continue
+		}
+		found = true
+		if diff := cmp.Diff(want, row); diff != "" {
+			t.Errorf("row mismatch (-want +got):\n%s", diff)
+		}
+	}
+	if !found {
+		t.Errorf("no row for %v", gState)
+	}
+
+	buf.Reset()
+	if err := mdp.WriteCSV(&buf, CSVOptions{SampleRate: 0.1, Rand: rand.New(rand.NewSource(1))}); err != nil {
+		t.Fatalf("WriteCSV: %v", err)
+	}
+	sampled, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("reading the CSV: %v", err)
+	}
+	if got, want := float64(len(sampled)-1)/float64(len(mdp.value)), 0.1; got < want*0.9 || got > want*1.1 {
+		t.Errorf("got %.3f of the rows when sampling, want about %.1f", got, want)
+	}
+}
+
+func TestFieldCSVString(t *testing.T) {
+	if got, want := fieldCSVString(combo4.LeftI), "____/____/____/XXX_"; got != want {
+		t.Errorf("fieldCSVString(LeftI) got %q, want %q", got, want)
+	}
+}
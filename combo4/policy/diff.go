@@ -0,0 +1,65 @@
+package policy
+
+import (
+	"sort"
+	"tetris/combo4"
+)
+
+// PolicyDiff is a GameState where two MDPPolicies choose differently.
+type PolicyDiff struct {
+	GameState GameState
+	// The choices of each MDPPolicy or nil if it has no possible move.
+	ChoiceA, ChoiceB *combo4.State
+}
+
+// DiffPolicies returns every GameState in either MDPPolicy where their
+// choices differ. A GameState that is not in an MDPPolicy uses the choice of
+// its fallback like NextState so compressed policies are compared by what
+// they play. GameStates with only one next State in the NFA are skipped
+// since both must choose it.
+//
+// The diffs are sorted by GameState so the output of two runs can be
+// compared. Unlike NextState, DiffPolicies does not change the Stats of the
+// MDPPolicies.
+func DiffPolicies(a, b *MDPPolicy, nfa *combo4.NFA) []PolicyDiff {
+	gStates := make(map[GameState]bool, a.Len())
+	add := func(gState GameState, _ combo4.State) { gStates[gState] = true }
+	a.forEach(add)
+	b.forEach(add)
+
+	var diffs []PolicyDiff
+	for gState := range gStates {
+		if len(nfa.NextStates(gState.State, gState.Current)) < 2 {
+			continue
+		}
+		choiceA, choiceB := a.choice(gState), b.choice(gState)
+		if choiceA == nil && choiceB == nil || choiceA != nil && choiceB != nil && *choiceA == *choiceB {
+			continue
+		}
+		diffs = append(diffs, PolicyDiff{GameState: gState, ChoiceA: choiceA, ChoiceB: choiceB})
+	}
+	sort.Slice(diffs, func(i, j int) bool { return gameStateLess(diffs[i].GameState, diffs[j].GameState) })
+	return diffs
+}
+
+// choice returns the choice of the MDPPolicy for the GameState without
+// counting it in Stats.
+func (m *MDPPolicy) choice(gState GameState) *combo4.State {
+	if next, ok := m.lookup(gState); ok {
+		return &next
+	}
+	return m.fallback().NextState(gState.State, gState.Current, gState.Preview.Slice(), gState.BagUsed)
+}
+
+// gameStateLess orders GameStates by State.Less and then by the pieces.
+func gameStateLess(a, b GameState) bool {
+	switch {
+	case a.State != b.State:
+		return a.State.Less(b.State)
+	case a.Current != b.Current:
+		return a.Current < b.Current
+	case a.Preview != b.Preview:
+		return a.Preview < b.Preview
+	}
+	return a.BagUsed < b.BagUsed
+}
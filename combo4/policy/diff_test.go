@@ -0,0 +1,59 @@
+package policy
+
+import (
+	"testing"
+	"tetris/combo4"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDiffPolicies(t *testing.T) {
+	t.Parallel()
+
+	mdp, err := NewMDP(0)
+	if err != nil {
+		t.Fatalf("NewMDP: %v", err)
+	}
+	pol := mdp.Policy().(*MDPPolicy)
+
+	if diffs := DiffPolicies(pol, pol, mdp.nfa); len(diffs) != 0 {
+		t.Errorf("DiffPolicies of a policy with itself got %d diffs, want none: %+v", len(diffs), diffs[0])
+	}
+
+	// Change the choice of one GameState with more than one choice.
+	modified := &MDPPolicy{
+		policy:     make(map[GameState]combo4.State, len(pol.policy)),
+		previewLen: pol.previewLen,
+		defaultPol: pol.defaultPol,
+	}
+	var want []PolicyDiff
+	for gState, choice := range pol.policy {
+		modified.policy[gState] = choice
+		if want != nil {
+			continue
+		}
+		for _, other := range mdp.nfa.NextStates(gState.State, gState.Current) {
+			if other != choice {
+				choice, other := choice, other
+				modified.policy[gState] = other
+				want = []PolicyDiff{{GameState: gState, ChoiceA: &choice, ChoiceB: &other}}
+				break
+			}
+		}
+	}
+	if diff := cmp.Diff(want, DiffPolicies(pol, modified, mdp.nfa)); diff != "" {
+		t.Errorf("DiffPolicies with a modified copy mismatch (-want +got):\n%s", diff)
+	}
+
+	// A GameState left out of a policy uses the fallback's choice.
+	delete(modified.policy, want[0].GameState)
+	fallbackChoice := pol.defaultPol.NextState(want[0].GameState.State, want[0].GameState.Current, nil, want[0].GameState.BagUsed)
+	for _, diff := range DiffPolicies(pol, modified, mdp.nfa) {
+		if diff.GameState == want[0].GameState && !cmp.Equal(diff.ChoiceB, fallbackChoice) {
+			t.Errorf("got ChoiceB %v for a GameState not in the policy, want the fallback's %v", diff.ChoiceB, fallbackChoice)
+		}
+	}
+	if got := pol.Stats(); got != (MDPPolicyStats{}) {
+		t.Errorf("got Stats %+v after DiffPolicies, want none", got)
+	}
+}
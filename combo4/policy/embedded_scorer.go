@@ -0,0 +1,76 @@
+package policy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+	"tetris/combo4"
+)
+
+//go:generate go run ./gen/scorer --perm_len=7 --out=nfa_scorer7.gob.gz
+
+// The NFAScorer is only embedded with the embedscorer build tag since it
+// takes about 4MB and computing it takes less than a second. See
+// embedded_scorer_data.go.
+
+const embeddedPermLen = 7
+
+// ErrNoEmbeddedScorer is returned by EmbeddedNFAScorer in binaries built
+// without the embedscorer build tag.
+var ErrNoEmbeddedScorer = errors.New("the NFAScorer is only embedded with the embedscorer build tag")
+
+// LoadNFAScorer is like NewNFAScorer but decodes the embedded NFAScorer
+// instead of computing it when the NFA and permLen match and the binary is
+// built with the embedscorer build tag.
+func LoadNFAScorer(nfa *combo4.NFA, permLen int) *NFAScorer {
+	if permLen != embeddedPermLen || embeddedScorer == nil {
+		return NewNFAScorer(nfa, permLen)
+	}
+	s, err := EmbeddedNFAScorer(nfa)
+	if err != nil {
+		log.Printf("Computing the NFAScorer because the embedded NFAScorer could not be used: %v", err)
+		return NewNFAScorer(nfa, permLen)
+	}
+	return s
+}
+
+// EmbeddedNFAScorer decodes the NFAScorer with a permLen of 7 that is
+// embedded in the binary. It returns an error instead of computing the
+// NFAScorer if the embedded encoding does not match its checksum or is not
+// for the NFA and an ErrNoEmbeddedScorer without the embedscorer build tag.
+func EmbeddedNFAScorer(nfa *combo4.NFA) (*NFAScorer, error) {
+	if embeddedScorer == nil {
+		return nil, ErrNoEmbeddedScorer
+	}
+	return decodeEmbeddedScorer(nfa, embeddedScorer, embeddedScorerSum)
+}
+
+func decodeEmbeddedScorer(nfa *combo4.NFA, encoded []byte, wantSum string) (*NFAScorer, error) {
+	sum := sha256.Sum256(encoded)
+	if got, want := hex.EncodeToString(sum[:]), strings.TrimSpace(wantSum); got != want {
+		return nil, fmt.Errorf("the embedded NFAScorer has SHA-256 %s, want %s", got, want)
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("gzip.NewReader: %v", err)
+	}
+	defer gz.Close()
+	b, err := ioutil.ReadAll(gz)
+	if err != nil {
+		return nil, fmt.Errorf("reading the embedded NFAScorer: %v", err)
+	}
+	s, err := NewNFAScorerFromGob(nfa, b)
+	if err != nil {
+		return nil, err
+	}
+	if s.permLen != embeddedPermLen {
+		return nil, fmt.Errorf("the embedded NFAScorer has permLen %d, want %d", s.permLen, embeddedPermLen)
+	}
+	return s, nil
+}
@@ -0,0 +1,17 @@
+//go:build embedscorer
+
+package policy
+
+import _ "embed" // Used to embed the NFAScorer.
+
+// embeddedScorer is the gzipped gob encoding of the NFAScorer for
+// combo4.NewNFA with a permLen of embeddedPermLen.
+//
+//go:embed nfa_scorer7.gob.gz
+var embeddedScorer []byte
+
+// embeddedScorerSum is the hex SHA-256 of embeddedScorer written by
+// gen/scorer.
+//
+//go:embed nfa_scorer7.gob.gz.sha256
+var embeddedScorerSum string
@@ -0,0 +1,10 @@
+//go:build !embedscorer
+
+package policy
+
+// Without the embedscorer build tag, nothing is embedded and LoadNFAScorer
+// computes the NFAScorer.
+var (
+	embeddedScorer    []byte
+	embeddedScorerSum string
+)
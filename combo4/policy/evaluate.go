@@ -0,0 +1,197 @@
+package policy
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"tetris"
+	"tetris/combo4"
+)
+
+// EvalOptions configures Evaluate.
+type EvalOptions struct {
+	// The number of games to play.
+	Trials int
+	// The number of pieces in each queue after the current piece and the
+	// preview. At most PiecesPerTrial+1 pieces can be consumed in a trial.
+	PiecesPerTrial int
+	// The number of pieces in the preview.
+	PreviewSize int
+	// Rand is used to generate the queues with a 7 bag randomizer or the
+	// Model. The result is deterministic for a seeded Rand and a
+	// deterministic Policy. If nil, tetris.RandPieces or rand.Float64 is
+	// used.
+	Rand *rand.Rand
+	// The number of trials played at the same time. Defaults to 8.
+	Concurrency int
+	// The Reach of each checkpoint is computed.
+	Checkpoints []int
+	// The options for each game.
+	GameOptions []GameOption
+	// Model is the randomizer of the queues instead of the 7 bag randomizer
+	// if set. The games are played with the WithPieceModel option.
+	Model NextPieceModel
+	// TrialPolicy returns the Policy that plays a trial instead of the
+	// Policy if set e.g. to record the decisions of the trial. done is
+	// called with the pieces consumed at the end of the trial if it is not
+	// nil.
+	TrialPolicy func(trial int, pol Policy) (trialPol Policy, done func(consumed int))
+}
+
+// EvalResult is the result of Evaluate.
+type EvalResult struct {
+	// The number of pieces consumed for each trial.
+	Consumed []int
+	Mean     float64
+	Median   float64
+	// The fraction of trials that consumed at least the number of pieces of
+	// the checkpoint at the same index.
+	Reach []float64
+	// The fraction of trials that consumed every piece in the queue.
+	WinRate float64
+}
+
+// Evaluate plays games with random queues starting from combo4.LeftI and
+// summarizes how many pieces were consumed.
+func Evaluate(pol Policy, opts EvalOptions) EvalResult {
+	return evaluateQueues(pol, evalQueues(opts), opts)
+}
+
+// evalQueues generates the queues of the trials of Evaluate. They are all
+// generated first so the result does not depend on the order the trials
+// complete.
+func evalQueues(opts EvalOptions) [][]tetris.Piece {
+	queueLen := opts.PiecesPerTrial + opts.PreviewSize + 1
+	queues := make([][]tetris.Piece, opts.Trials)
+	for t := range queues {
+		queues[t] = RandQueue(opts.Model, opts.Rand, queueLen)
+	}
+	return queues
+}
+
+// evaluateQueues is Evaluate with a trial for each of the queues.
+func evaluateQueues(pol Policy, queues [][]tetris.Piece, opts EvalOptions) EvalResult {
+	return summarize(simulateQueues(pol, queues, opts), opts.Checkpoints, opts.PiecesPerTrial+1)
+}
+
+// simulateQueues returns the pieces consumed in a trial of each queue with
+// the preview, concurrency and game options of opts.
+func simulateQueues(pol Policy, queues [][]tetris.Piece, opts EvalOptions) []int {
+	gameOpts := opts.GameOptions
+	if opts.Model != nil {
+		gameOpts = append(gameOpts[:len(gameOpts):len(gameOpts)], WithPieceModel(opts.Model))
+	}
+
+	numWorkers := opts.Concurrency
+	if numWorkers <= 0 {
+		numWorkers = concurrency
+	}
+	consumed := make([]int, len(queues))
+	trialCh := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(numWorkers)
+	for i := 0; i < numWorkers; i++ {
+		go func() {
+			defer wg.Done()
+			for t := range trialCh {
+				trialPol, done := pol, func(int) {}
+				if opts.TrialPolicy != nil {
+					trialPol, done = opts.TrialPolicy(t, pol)
+					if done == nil {
+						done = func(int) {}
+					}
+				}
+				consumed[t] = Simulate(trialPol, combo4.LeftI, queues[t], opts.PreviewSize, gameOpts...)
+				done(consumed[t])
+			}
+		}()
+	}
+	for t := range queues {
+		trialCh <- t
+	}
+	close(trialCh)
+	wg.Wait()
+	return consumed
+}
+
+// summarize computes an EvalResult from the pieces consumed in each trial.
+func summarize(consumed []int, checkpoints []int, maxConsumed int) EvalResult {
+	res := EvalResult{
+		Consumed: consumed,
+		Reach:    make([]float64, len(checkpoints)),
+	}
+	if len(consumed) == 0 {
+		return res
+	}
+
+	var total, wins int
+	for _, c := range consumed {
+		total += c
+		if c >= maxConsumed {
+			wins++
+		}
+		for idx, checkpoint := range checkpoints {
+			if c >= checkpoint {
+				res.Reach[idx]++
+			}
+		}
+	}
+	n := float64(len(consumed))
+	res.Mean = float64(total) / n
+	res.WinRate = float64(wins) / n
+	for idx := range res.Reach {
+		res.Reach[idx] /= n
+	}
+
+	sorted := append([]int(nil), consumed...)
+	sort.Ints(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 1 {
+		res.Median = float64(sorted[mid])
+	} else {
+		res.Median = float64(sorted[mid-1]+sorted[mid]) / 2
+	}
+	return res
+}
+
+// minPreviewPieces is the number of pieces in each trial of
+// MinPreviewForWinRate.
+const minPreviewPieces = 100
+
+// MinPreviewForWinRate returns the smallest preview size from 0 to 7 for which
+// a policy from the embedded NFAScorer wins at least target of the trials. A
+// trial is won if all minPreviewPieces pieces are consumed. It returns -1 if
+// no preview size reaches the target.
+func MinPreviewForWinRate(target float64, trials int, r *rand.Rand) int {
+	moves, _ := combo4.AllContinuousMoves()
+	nfa := combo4.NewNFA(moves)
+	pol := FromScorer(nfa, LoadNFAScorer(nfa, 7))
+	for previewLen := 0; previewLen <= 7; previewLen++ {
+		res := Evaluate(pol, EvalOptions{
+			Trials:         trials,
+			PiecesPerTrial: minPreviewPieces,
+			PreviewSize:    previewLen,
+			Rand:           r,
+		})
+		if res.WinRate >= target {
+			return previewLen
+		}
+	}
+	return -1
+}
+
+// RandQueue returns n random pieces from the NextPieceModel or the 7 bag
+// randomizer if it is nil like the queues of Evaluate. r is the source of
+// randomness if it is not nil.
+func RandQueue(model NextPieceModel, r *rand.Rand, n int) []tetris.Piece {
+	if isSevenBag(model) {
+		if r == nil {
+			return tetris.RandPieces(n)
+		}
+		return tetris.RandPiecesFrom(r, n)
+	}
+	if r == nil {
+		return randPiecesFrom(model, rand.Float64, n)
+	}
+	return randPiecesFrom(model, r.Float64, n)
+}
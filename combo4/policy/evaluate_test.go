@@ -0,0 +1,106 @@
+package policy
+
+import (
+	"math/rand"
+	"sync"
+	"testing"
+	"tetris/combo4"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestEvaluateDeterministic(t *testing.T) {
+	moves, _ := combo4.AllContinuousMoves()
+	nfa := combo4.NewNFA(moves)
+	pol := FromScorer(nfa, NewNFAScorer(nfa, 3))
+
+	evaluate := func(concurrency int) EvalResult {
+		return Evaluate(pol, EvalOptions{
+			Trials:         20,
+			PiecesPerTrial: 50,
+			PreviewSize:    2,
+			Rand:           rand.New(rand.NewSource(7)),
+			Concurrency:    concurrency,
+			Checkpoints:    []int{10, 51},
+		})
+	}
+	want := evaluate(1)
+	if len(want.Consumed) != 20 {
+		t.Fatalf("got %d trials, want 20", len(want.Consumed))
+	}
+	if got := evaluate(4); !cmp.Equal(want, got) {
+		t.Errorf("Evaluate with the same seed differs (-want +got):\n%s", cmp.Diff(want, got))
+	}
+	if want.Reach[1] != want.WinRate {
+		t.Errorf("got Reach=%.2f for the full queue, want the WinRate %.2f", want.Reach[1], want.WinRate)
+	}
+}
+
+func TestEvaluateTrialPolicy(t *testing.T) {
+	moves, _ := combo4.AllContinuousMoves()
+	nfa := combo4.NewNFA(moves)
+	pol := FromScorer(nfa, NewNFAScorer(nfa, 3))
+	opts := EvalOptions{
+		Trials:         10,
+		PiecesPerTrial: 30,
+		PreviewSize:    2,
+		Rand:           rand.New(rand.NewSource(7)),
+	}
+	want := Evaluate(pol, opts)
+
+	var mu sync.Mutex
+	calls := make([]*recordingPolicy, opts.Trials)
+	done := make([]int, opts.Trials)
+	opts.Rand = rand.New(rand.NewSource(7))
+	opts.TrialPolicy = func(trial int, pol Policy) (Policy, func(int)) {
+		rec := &recordingPolicy{pol: pol}
+		mu.Lock()
+		defer mu.Unlock()
+		calls[trial] = rec
+		return rec, func(consumed int) {
+			mu.Lock()
+			defer mu.Unlock()
+			done[trial] = consumed
+		}
+	}
+	got := Evaluate(pol, opts)
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Evaluate with a TrialPolicy mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(got.Consumed, done); diff != "" {
+		t.Errorf("done got different pieces consumed (-want +got):\n%s", diff)
+	}
+	for trial, rec := range calls {
+		if rec == nil || len(rec.calls) == 0 {
+			t.Errorf("trial %d was not played with its TrialPolicy", trial)
+		}
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	got := summarize([]int{1, 5, 10, 3}, []int{3, 10}, 10)
+	want := EvalResult{
+		Consumed: []int{1, 5, 10, 3},
+		Mean:     4.75,
+		Median:   4,
+		Reach:    []float64{0.75, 0.25},
+		WinRate:  0.25,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("summarize mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestMinPreviewForWinRate(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+	if got := MinPreviewForWinRate(0, 5, r); got != 0 {
+		t.Errorf("MinPreviewForWinRate(0) got %d, want 0", got)
+	}
+	low := MinPreviewForWinRate(0.1, 10, r)
+	if low < 0 || low > 3 {
+		t.Errorf("MinPreviewForWinRate(0.1) got %d, want between 0 and 3", low)
+	}
+	if got := MinPreviewForWinRate(1.1, 1, r); got != -1 {
+		t.Errorf("MinPreviewForWinRate(1.1) got %d, want -1", got)
+	}
+}
@@ -0,0 +1,70 @@
+package policy
+
+import (
+	"tetris"
+	"tetris/combo4"
+)
+
+// ExactExpectedValue returns the expected number of pieces that will be
+// consumed for a GameState with the best possible play. Every piece that
+// could come after the preview is enumerated for depth pieces. After that
+// only the known pieces are counted like in MDP.ExpectedValue, so the result
+// never overestimates and approaches the true value as depth grows.
+//
+// The number of GameStates visited grows quickly with depth so this is only
+// practical for small previews and depths.
+func ExactExpectedValue(nfa *combo4.NFA, gs GameState, depth int) float64 {
+	e := &exactEvaluator{
+		nfa:  nfa,
+		memo: make(map[exactKey]float64),
+	}
+	return e.value(gs, depth)
+}
+
+type exactKey struct {
+	gState GameState
+	depth  int
+}
+
+type exactEvaluator struct {
+	nfa  *combo4.NFA
+	memo map[exactKey]float64
+}
+
+func (e *exactEvaluator) value(gs GameState, depth int) float64 {
+	choices := e.nfa.NextStates(gs.State, gs.Current)
+	if len(choices) == 0 {
+		return 0
+	}
+	preview := gs.Preview.Slice()
+	if depth == 0 {
+		_, consumed := e.nfa.EndStates(combo4.NewStateSet(choices...), preview)
+		return float64(consumed) + 1
+	}
+
+	key := exactKey{gs, depth}
+	if v, ok := e.memo[key]; ok {
+		return v
+	}
+
+	nextPieces := gs.NextBagPieces()
+
+	var best float64
+	for _, choice := range choices {
+		var total float64
+		for _, p := range nextPieces {
+			queue := append(append(make([]tetris.Piece, 0, len(preview)+1), preview...), p)
+			total += e.value(GameState{
+				State:   choice,
+				Current: queue[0],
+				Preview: tetris.MustSeq(queue[1:]),
+				BagUsed: SevenBag{}.Draw(gs.BagUsed, p),
+			}, depth-1)
+		}
+		if v := 1 + total/float64(len(nextPieces)); v > best {
+			best = v
+		}
+	}
+	e.memo[key] = best
+	return best
+}
@@ -0,0 +1,103 @@
+package policy
+
+import (
+	"math"
+	"sync"
+	"tetris"
+	"tetris/combo4"
+)
+
+// expectimaxPolicy picks the next state by looking past the preview and
+// averaging a Scorer's scores over the pieces that could come next.
+type expectimaxPolicy struct {
+	nfa    *combo4.NFA
+	scorer Scorer
+	depth  int
+}
+
+// NewExpectimaxPolicy creates a Policy that searches depth plies ahead. Each
+// ply after the first reveals one more piece after the preview. Since every
+// piece remaining in the bag is equally likely, the scores for each of those
+// pieces are averaged. A depth of 1 is equivalent to FromScorer. The scores
+// are averaged by ScoreBreakdown.Value which can lose the Continuation and
+// Normalized of large scores.
+func NewExpectimaxPolicy(nfa *combo4.NFA, scorer Scorer, depth int) Policy {
+	return &expectimaxPolicy{
+		nfa:    nfa,
+		scorer: scorer,
+		depth:  depth,
+	}
+}
+
+// NextState returns the best possible next state or nil if there are no
+// possible moves.
+func (p *expectimaxPolicy) NextState(initial combo4.State, current tetris.Piece, preview []tetris.Piece, endBagUsed tetris.PieceSet) *combo4.State {
+	choices := p.nfa.NextStates(initial, current)
+	switch len(choices) {
+	case 0:
+		return nil
+	case 1:
+		return &choices[0]
+	}
+
+	values := make([]float64, len(choices))
+	var wg sync.WaitGroup
+	wg.Add(len(choices))
+	for idx, choice := range choices {
+		idx, choice := idx, choice // Capture range variables.
+		go func() {
+			values[idx] = p.value(choice, preview, endBagUsed, p.depth-1)
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+
+	var (
+		bestState combo4.State
+		bestValue = math.Inf(-1)
+	)
+	for idx, value := range values {
+		if value > bestValue {
+			bestValue = value
+			bestState = choices[idx]
+		}
+	}
+	return &bestState
+}
+
+// value returns the expected score of a state after searching the specified
+// number of plies past the queue.
+func (p *expectimaxPolicy) value(state combo4.State, queue []tetris.Piece, bagUsed tetris.PieceSet, plies int) float64 {
+	if plies <= 0 {
+		return p.scorer.Score(state, queue, bagUsed).Value()
+	}
+
+	if bagUsed.IsFullBag() {
+		bagUsed = 0
+	}
+	possible := bagUsed.Inverted().Slice()
+
+	extended := make([]tetris.Piece, len(queue)+1)
+	copy(extended, queue)
+
+	var total float64
+	for _, piece := range possible {
+		extended[len(queue)] = piece
+		newBag := bagUsed.Add(piece)
+
+		choices := p.nfa.NextStates(state, extended[0])
+		if len(choices) == 0 {
+			// There is nothing left to search so just score the state.
+			total += p.scorer.Score(state, extended, newBag).Value()
+			continue
+		}
+		best := math.Inf(-1)
+		for _, choice := range choices {
+			if v := p.value(choice, extended[1:], newBag, plies-1); v > best {
+				best = v
+			}
+		}
+		total += best
+	}
+	return total / float64(len(possible))
+}
@@ -0,0 +1,78 @@
+package policy
+
+import (
+	"math/rand"
+	"testing"
+	"tetris"
+	"tetris/combo4"
+)
+
+func TestExpectimaxDepth1(t *testing.T) {
+	moves, _ := combo4.AllContinuousMoves()
+	nfa := combo4.NewNFA(moves)
+	states := nfa.States().Slice()
+
+	scorer := NewNFAScorer(nfa, 3)
+	want := FromScorer(nfa, scorer)
+	got := NewExpectimaxPolicy(nfa, scorer, 1)
+
+	r := rand.New(rand.NewSource(20))
+	for i := 0; i < 500; i++ {
+		state := states[r.Intn(len(states))]
+		queue := tetris.RandPiecesFrom(r, 4)
+		bag := tetris.NewPieceSet(queue...)
+
+		wantState := want.NextState(state, queue[0], queue[1:], bag)
+		gotState := got.NextState(state, queue[0], queue[1:], bag)
+		if (wantState == nil) != (gotState == nil) || (wantState != nil && *wantState != *gotState) {
+			t.Fatalf("NextState(%v, %v, %v) got %v, want %v", state, queue[0], queue[1:], gotState, wantState)
+		}
+	}
+}
+
+func TestExpectimaxAvoidsTrap(t *testing.T) {
+	moves, _ := combo4.AllContinuousMoves()
+	nfa := combo4.NewNFA(moves)
+	scorer := &basicScorer{nfa}
+
+	const X, o = true, false
+	initial := combo4.State{
+		Field: combo4.NewField4x4([][4]bool{
+			{X, o, o, o},
+			{X, X, o, o},
+		}),
+		Hold: tetris.T,
+	}
+	current := tetris.L
+	preview := []tetris.Piece{tetris.T}
+	bag := tetris.NewPieceSet(tetris.T, tetris.L)
+
+	// deaths returns the number of possible pieces after the preview that
+	// cannot be played from the state.
+	deaths := func(state combo4.State) int {
+		var count int
+		for _, p := range bag.Inverted().Slice() {
+			queue := append(append([]tetris.Piece{}, preview...), p)
+			if _, consumed := nfa.EndStates(combo4.NewStateSet(state), queue); consumed != len(queue) {
+				count++
+			}
+		}
+		return count
+	}
+
+	shallow := NewExpectimaxPolicy(nfa, scorer, 1).NextState(initial, current, preview, bag)
+	if shallow == nil {
+		t.Fatalf("depth 1 got no next state")
+	}
+	if deaths(*shallow) == 0 {
+		t.Fatalf("depth 1 chose %v which is not a trap; the test needs a new setup", shallow)
+	}
+
+	deep := NewExpectimaxPolicy(nfa, scorer, 2).NextState(initial, current, preview, bag)
+	if deep == nil {
+		t.Fatalf("depth 2 got no next state")
+	}
+	if got := deaths(*deep); got != 0 {
+		t.Errorf("depth 2 chose %v which fails for %d next pieces, want 0", deep, got)
+	}
+}
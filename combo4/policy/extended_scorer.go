@@ -0,0 +1,256 @@
+package policy
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"sort"
+	"sync"
+	"tetris"
+	"tetris/combo4"
+)
+
+// continuationHorizon is the number of pieces after a choice that the combo
+// must continue for to count as continued in a ContinuationTable.
+const continuationHorizon = 20
+
+// maxContinuationStates is the largest number of end States that a
+// ContinuationTable tells apart. Scores with more end States share the
+// estimate of this many.
+const maxContinuationStates = 8
+
+// continuationPrior is how many choices the estimate of a continuationKey
+// without its State counts as when estimating a continuationKey with few
+// choices recorded.
+const continuationPrior = 5
+
+// continuationKey summarizes a choice scored by an NFAScorer by the chosen
+// State, its number of end States and the pieces of the bag used after the
+// next pieces.
+type continuationKey struct {
+	state     combo4.State
+	numStates int
+	bag       tetris.PieceSet
+}
+
+func newContinuationKey(state combo4.State, score ScoreBreakdown, bagUsed tetris.PieceSet) continuationKey {
+	numStates := score.NumStates
+	if numStates > maxContinuationStates {
+		numStates = maxContinuationStates
+	}
+	// A full bag draws the same pieces next as an empty one.
+	if bagUsed.IsFullBag() {
+		bagUsed = 0
+	}
+	return continuationKey{state: state, numStates: numStates, bag: bagUsed}
+}
+
+// coarse returns the key without its State.
+func (k continuationKey) coarse() continuationKey {
+	k.state = combo4.State{}
+	return k
+}
+
+// ContinuationTable estimates the probability that a combo continues for
+// another 20 pieces after choosing a State from the number of States that
+// the NFAScorer can reach after the next pieces and the bag at that horizon.
+// It is computed offline from games played by a Policy with
+// NewContinuationTable, usually by gen/continuation, and saved with
+// GobEncode.
+type ContinuationTable struct {
+	// The preview size of the games the table was made from.
+	previewSize int
+	// The number of choices and how many of them continued for each key and
+	// for each coarse key.
+	counts map[continuationKey][2]int
+}
+
+// NewContinuationTable plays games like Evaluate and records for each choice
+// of the Policy the key of the scorer's score of the choice and whether the
+// combo continued for another 20 pieces. The choices within 20 pieces of the
+// end of the queue are not recorded since it is unknown whether they would
+// have continued.
+func NewContinuationTable(pol Policy, scorer *NFAScorer, opts EvalOptions) *ContinuationTable {
+	queueLen := opts.PiecesPerTrial + opts.PreviewSize + 1
+	queues := make([][]tetris.Piece, opts.Trials)
+	for t := range queues {
+		queues[t] = RandQueue(nil, opts.Rand, queueLen)
+	}
+
+	numWorkers := opts.Concurrency
+	if numWorkers <= 0 {
+		numWorkers = concurrency
+	}
+	table := &ContinuationTable{previewSize: opts.PreviewSize, counts: make(map[continuationKey][2]int)}
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		trialCh = make(chan int)
+	)
+	wg.Add(numWorkers)
+	for i := 0; i < numWorkers; i++ {
+		go func() {
+			defer wg.Done()
+			for t := range trialCh {
+				rec := &continuationRecorder{pol: pol, scorer: scorer}
+				consumed := Simulate(rec, combo4.LeftI, queues[t], opts.PreviewSize, opts.GameOptions...)
+
+				mu.Lock()
+				for step, key := range rec.keys {
+					// The piece of the step is the step+1th piece consumed.
+					if step+1+continuationHorizon > opts.PiecesPerTrial+1 {
+						break
+					}
+					var continued int
+					if consumed-(step+1) >= continuationHorizon {
+						continued = 1
+					}
+					table.add(key, 1, continued)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for t := range queues {
+		trialCh <- t
+	}
+	close(trialCh)
+	wg.Wait()
+	return table
+}
+
+// add counts choices of the key and how many of them continued in the key
+// and its coarse key.
+func (t *ContinuationTable) add(key continuationKey, choices, continued int) {
+	for _, k := range [...]continuationKey{key, key.coarse()} {
+		c := t.counts[k]
+		c[0] += choices
+		c[1] += continued
+		t.counts[k] = c
+	}
+}
+
+// PreviewSize returns the preview size of the games the table was made from.
+// The estimates are for choices made with the same preview size.
+func (t *ContinuationTable) PreviewSize() int {
+	return t.previewSize
+}
+
+// Estimate returns the estimated probability that the combo continues for
+// another 20 pieces after choosing the State with the score from the
+// NFAScorer and the bag after the next pieces. Choices with few recorded
+// choices of the same State are estimated mostly from the other States with
+// the same number of end States and bag.
+func (t *ContinuationTable) Estimate(state combo4.State, score ScoreBreakdown, bagUsed tetris.PieceSet) float64 {
+	key := newContinuationKey(state, score, bagUsed)
+	coarse := t.counts[key.coarse()]
+	// Add one of each outcome so rare coarse keys stay near 0.5.
+	prior := float64(coarse[1]+1) / float64(coarse[0]+2)
+	c := t.counts[key]
+	return (float64(c[1]) + continuationPrior*prior) / (float64(c[0]) + continuationPrior)
+}
+
+// gobContinuationTable is the gob encoding of a ContinuationTable. Only the
+// counts of the keys with a State are encoded since the coarse keys are
+// their sums.
+type gobContinuationTable struct {
+	PreviewSize int
+	Counts      []gobContinuationCount
+}
+
+type gobContinuationCount struct {
+	State     combo4.State
+	NumStates int
+	Bag       tetris.PieceSet
+	Choices   int
+	Continued int
+}
+
+// GobEncode returns the gob encoding of the ContinuationTable. The encoding
+// is the same for equal tables.
+func (t *ContinuationTable) GobEncode() ([]byte, error) {
+	enc := gobContinuationTable{PreviewSize: t.previewSize}
+	for key, c := range t.counts {
+		if key == key.coarse() {
+			continue
+		}
+		enc.Counts = append(enc.Counts, gobContinuationCount{key.state, key.numStates, key.bag, c[0], c[1]})
+	}
+	sort.Slice(enc.Counts, func(i, j int) bool {
+		a, b := enc.Counts[i], enc.Counts[j]
+		switch {
+		case a.State != b.State:
+			return a.State.Less(b.State)
+		case a.NumStates != b.NumStates:
+			return a.NumStates < b.NumStates
+		}
+		return a.Bag < b.Bag
+	})
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(&enc); err != nil {
+		return nil, fmt.Errorf("encoder.Encode(table): %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// GobDecode decodes a gob encoding into a ContinuationTable.
+func (t *ContinuationTable) GobDecode(data []byte) error {
+	var enc gobContinuationTable
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&enc); err != nil {
+		return fmt.Errorf("decoder.Decode(table): %v", err)
+	}
+	t.previewSize = enc.PreviewSize
+	t.counts = make(map[continuationKey][2]int)
+	for _, c := range enc.Counts {
+		t.add(continuationKey{state: c.State, numStates: c.NumStates, bag: c.Bag}, c.Choices, c.Continued)
+	}
+	return nil
+}
+
+// continuationRecorder is a Policy that records the continuationKey of each
+// choice of another Policy.
+type continuationRecorder struct {
+	pol    Policy
+	scorer *NFAScorer
+	keys   []continuationKey
+}
+
+func (r *continuationRecorder) NextState(initial combo4.State, current tetris.Piece, preview []tetris.Piece, endBagUsed tetris.PieceSet) *combo4.State {
+	next := r.pol.NextState(initial, current, preview, endBagUsed)
+	if next != nil {
+		r.keys = append(r.keys, newContinuationKey(*next, r.scorer.Score(*next, preview, endBagUsed), endBagUsed))
+	}
+	return next
+}
+
+// ExtendedScorer is an NFAScorer whose scores also have the Continuation
+// estimated by a ContinuationTable. It breaks ties between choices with the
+// same number of inviable permutations and end States by how likely the
+// combo is to continue from the chosen State and the bag at the horizon.
+// ScoreBreakdown.Value only keeps the Continuation while the Int64 of the
+// score is small so it rarely makes a difference where scores are averaged
+// e.g. by an ExpectimaxPolicy.
+//
+// With a preview of 6 and 500 trials of 3000 pieces, the ext6 policy of
+// compare with the table from gen/continuation beat seq6 in 59 trials and
+// lost in 37 with a mean of 919 to 880 pieces.
+type ExtendedScorer struct {
+	base  *NFAScorer
+	table *ContinuationTable
+}
+
+// NewExtendedScorer creates an ExtendedScorer. The table is usually created
+// with the same NFAScorer.
+func NewExtendedScorer(base *NFAScorer, table *ContinuationTable) *ExtendedScorer {
+	return &ExtendedScorer{base: base, table: table}
+}
+
+// Score returns the score of the NFAScorer with the Continuation set if all
+// of the next pieces can be consumed.
+func (s *ExtendedScorer) Score(state combo4.State, next []tetris.Piece, bagUsed tetris.PieceSet) ScoreBreakdown {
+	score := s.base.Score(state, next, bagUsed)
+	if score.Consumed == len(next) {
+		score.Continuation = s.table.Estimate(state, score, bagUsed)
+	}
+	return score
+}
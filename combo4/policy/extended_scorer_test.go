@@ -0,0 +1,106 @@
+package policy
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+	"tetris"
+	"tetris/combo4"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestExtendedScorer(t *testing.T) {
+	t.Parallel()
+
+	moves, _ := combo4.AllContinuousMoves()
+	nfa := combo4.NewNFA(moves)
+	base := NewNFAScorer(nfa, 3)
+	table := NewContinuationTable(FromScorer(nfa, base), base, EvalOptions{
+		Trials:         20,
+		PiecesPerTrial: 300,
+		PreviewSize:    3,
+		Rand:           rand.New(rand.NewSource(1)),
+	})
+	var total int
+	for key, c := range table.counts {
+		if c[1] > c[0] {
+			t.Errorf("%+v continued %d times out of %d choices", key, c[1], c[0])
+		}
+		total += c[0]
+	}
+	if total == 0 {
+		t.Fatalf("NewContinuationTable recorded no choices")
+	}
+
+	if table.PreviewSize() != 3 {
+		t.Errorf("got PreviewSize()=%d, want 3", table.PreviewSize())
+	}
+	b, err := table.GobEncode()
+	if err != nil {
+		t.Fatalf("GobEncode: %v", err)
+	}
+	decoded := new(ContinuationTable)
+	if err := decoded.GobDecode(b); err != nil {
+		t.Fatalf("GobDecode: %v", err)
+	}
+	if diff := cmp.Diff(table, decoded, cmp.AllowUnexported(ContinuationTable{}, continuationKey{})); diff != "" {
+		t.Errorf("GobDecode mismatch (-want +got):\n%s", diff)
+	}
+	if again, err := decoded.GobEncode(); err != nil || !bytes.Equal(b, again) {
+		t.Errorf("GobEncode of the decoded table got a different encoding, err=%v", err)
+	}
+
+	// A full bag is keyed like an empty one since the same pieces come next.
+	key := continuationKey{state: combo4.State{Field: combo4.LeftI}, numStates: 1}
+	if got := newContinuationKey(key.state, ScoreBreakdown{NumStates: 1}, tetris.NewPieceSet(tetris.NonemptyPieces[:]...)); got != key {
+		t.Errorf("newContinuationKey with a full bag got %+v, want %+v", got, key)
+	}
+
+	scorer := NewExtendedScorer(base, table)
+	r := rand.New(rand.NewSource(1))
+	states := nfa.States().Slice()
+	for i := 0; i < 300; i++ {
+		state := states[r.Intn(len(states))]
+		queue := tetris.RandPiecesFrom(r, 4)
+		bag, _ := BagUsedAfter(queue)
+		want := base.Score(state, queue, bag)
+		got := scorer.Score(state, queue, bag)
+		if diff := cmp.Diff(want, got, cmpopts.IgnoreFields(ScoreBreakdown{}, "Continuation")); diff != "" {
+			t.Fatalf("Score(%v, %v) mismatch with the NFAScorer (-want +got):\n%s", state, queue, diff)
+		}
+		switch {
+		case want.Consumed < len(queue) && got.Continuation != 0:
+			t.Errorf("Score(%v, %v) consumed %d pieces but got Continuation=%v, want 0", state, queue, got.Consumed, got.Continuation)
+		case want.Consumed == len(queue) && (got.Continuation <= 0 || got.Continuation >= 1):
+			t.Errorf("Score(%v, %v) got Continuation=%v, want within (0, 1)", state, queue, got.Continuation)
+		}
+	}
+
+	tests := []struct {
+		desc          string
+		worse, better ScoreBreakdown
+	}{
+		{
+			desc:   "continuation breaks ties",
+			worse:  ScoreBreakdown{Consumed: 3, NumStates: 2, Continuation: 0.2},
+			better: ScoreBreakdown{Consumed: 3, NumStates: 2, Continuation: 0.4},
+		},
+		{
+			desc:   "more states",
+			worse:  ScoreBreakdown{Consumed: 3, NumStates: 1, Continuation: 0.9},
+			better: ScoreBreakdown{Consumed: 3, NumStates: 2, Continuation: 0.1},
+		},
+		{
+			desc:   "fewer inviable",
+			worse:  ScoreBreakdown{Consumed: 3, Inviable: 1, Continuation: 1},
+			better: ScoreBreakdown{Consumed: 3},
+		},
+	}
+	for _, test := range tests {
+		if got := test.worse.Compare(test.better); got != -1 {
+			t.Errorf("%s: %+v.Compare(%+v) got %d, want -1", test.desc, test.worse, test.better, got)
+		}
+	}
+}
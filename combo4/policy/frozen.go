@@ -0,0 +1,111 @@
+package policy
+
+import (
+	"sort"
+	"tetris"
+	"tetris/combo4"
+)
+
+// frozenPolicy is the read-only storage of an MDPPolicy after Freeze. The
+// GameStates are packed into sorted keys with the choice for each key at the
+// same index so lookups are a binary search. Large Go maps leave a lot of
+// the memory of their buckets unused and fragment the heap of long running
+// processes which the two slices avoid.
+type frozenPolicy struct {
+	keys    []uint64
+	choices []combo4.State
+}
+
+// packGameState returns the GameState as a key for a frozenPolicy. The
+// packed State only uses the lowest 4 and highest 16 of its 32 bits so
+// Current and BagUsed fit in between and the Preview is the upper 32 bits.
+func packGameState(gState GameState) uint64 {
+	return uint64(gState.Preview)<<32 |
+		uint64(gState.State.Pack()) |
+		uint64(gState.BagUsed)<<8 |
+		uint64(gState.Current)<<4
+}
+
+// unpackGameState is the inverse of packGameState.
+func unpackGameState(key uint64) GameState {
+	return GameState{
+		State: combo4.State{
+			Field:          combo4.Field4x4(key >> 16),
+			Hold:           tetris.Piece(key>>1) & 7,
+			SwapRestricted: key&1 == 1,
+		},
+		Current: tetris.Piece(key>>4) & 15,
+		Preview: tetris.Seq(key >> 32),
+		BagUsed: tetris.PieceSet(key >> 8),
+	}
+}
+
+func newFrozenPolicy(policy map[GameState]combo4.State) *frozenPolicy {
+	keys := make([]uint64, 0, len(policy))
+	for gState := range policy {
+		keys = append(keys, packGameState(gState))
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	choices := make([]combo4.State, len(keys))
+	for i, key := range keys {
+		choices[i] = policy[unpackGameState(key)]
+	}
+	return &frozenPolicy{keys: keys, choices: choices}
+}
+
+func (f *frozenPolicy) lookup(gState GameState) (combo4.State, bool) {
+	key := packGameState(gState)
+	idx := sort.Search(len(f.keys), func(i int) bool { return f.keys[i] >= key })
+	if idx == len(f.keys) || f.keys[idx] != key {
+		return combo4.State{}, false
+	}
+	return f.choices[idx], true
+}
+
+// Freeze converts the MDPPolicy into a read-only form that uses much less
+// memory than a map and looks up GameStates with a binary search. It is
+// meant for a long running process like the bot that only calls NextState.
+// Freeze is not safe to call concurrently with NextState and calling it
+// again does nothing.
+func (m *MDPPolicy) Freeze() {
+	if m.frozen != nil {
+		return
+	}
+	m.frozen = newFrozenPolicy(m.policy)
+	m.policy = nil
+}
+
+// Frozen returns whether Freeze was called.
+func (m *MDPPolicy) Frozen() bool {
+	return m.frozen != nil
+}
+
+// lookup returns the choice for the GameState in either form of the policy.
+func (m *MDPPolicy) lookup(gState GameState) (combo4.State, bool) {
+	if m.frozen != nil {
+		return m.frozen.lookup(gState)
+	}
+	choice, ok := m.policy[gState]
+	return choice, ok
+}
+
+// forEach calls do for every GameState in either form of the policy.
+func (m *MDPPolicy) forEach(do func(GameState, combo4.State)) {
+	if m.frozen != nil {
+		for i, key := range m.frozen.keys {
+			do(unpackGameState(key), m.frozen.choices[i])
+		}
+		return
+	}
+	for gState, choice := range m.policy {
+		do(gState, choice)
+	}
+}
+
+// Len returns the number of GameStates in the policy.
+func (m *MDPPolicy) Len() int {
+	if m.frozen != nil {
+		return len(m.frozen.keys)
+	}
+	return len(m.policy)
+}
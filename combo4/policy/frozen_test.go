@@ -0,0 +1,158 @@
+package policy
+
+import (
+	"runtime"
+	"sync"
+	"testing"
+	"tetris"
+	"tetris/combo4"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var (
+	mdp2PolicyOnce sync.Once
+	mdp2Policy     map[GameState]combo4.State
+)
+
+// loadMDP2Policy returns the untrained policy of an MDP with a preview of 2
+// pieces.
+func loadMDP2Policy(b *testing.B) map[GameState]combo4.State {
+	mdp2PolicyOnce.Do(func() {
+		mdp, err := NewMDP(2)
+		if err != nil {
+			b.Fatalf("NewMDP: %v", err)
+		}
+		mdp2Policy = mdp.policy
+	})
+	return mdp2Policy
+}
+
+func BenchmarkMDPPolicyLookupMap(b *testing.B) {
+	benchmarkMDPPolicyLookup(b, false)
+}
+
+func BenchmarkMDPPolicyLookupFrozen(b *testing.B) {
+	benchmarkMDPPolicyLookup(b, true)
+}
+
+// benchmarkMDPPolicyLookup reports the latency of NextState for the
+// GameStates in the policy and the bytes of heap used by the policy.
+func benchmarkMDPPolicyLookup(b *testing.B, freeze bool) {
+	policy := loadMDP2Policy(b)
+	gStates := make([]GameState, 0, len(policy))
+	for gState := range policy {
+		gStates = append(gStates, gState)
+	}
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	pol := &MDPPolicy{policy: make(map[GameState]combo4.State, len(policy)), previewLen: 2}
+	for gState, choice := range policy {
+		pol.policy[gState] = choice
+	}
+	if freeze {
+		pol.Freeze()
+	}
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		gState := gStates[n%len(gStates)]
+		pol.NextState(gState.State, gState.Current, gState.Preview.Slice(), gState.BagUsed)
+	}
+	b.StopTimer()
+	// ReportMetric is after the loop since ResetTimer clears the metrics.
+	b.ReportMetric(float64(after.HeapAlloc)-float64(before.HeapAlloc), "policy-bytes")
+	runtime.KeepAlive(pol)
+}
+
+func TestPackGameState(t *testing.T) {
+	t.Parallel()
+
+	tests := []GameState{
+		{},
+		{
+			State:   combo4.State{Field: combo4.LeftI, Hold: tetris.T, SwapRestricted: true},
+			Current: tetris.S,
+			Preview: tetris.MustSeq([]tetris.Piece{tetris.O, tetris.I, tetris.L, tetris.J, tetris.Z, tetris.T, tetris.S, tetris.O}),
+			BagUsed: tetris.NewPieceSet(tetris.S, tetris.O, tetris.I),
+		},
+		{
+			State:   combo4.State{Field: combo4.Field4x4(0xffff), Hold: tetris.J},
+			Current: tetris.Z,
+			Preview: tetris.MustSeq([]tetris.Piece{tetris.L}),
+			BagUsed: tetris.NewPieceSet(tetris.I, tetris.O, tetris.L, tetris.J, tetris.S, tetris.Z, tetris.T),
+		},
+	}
+	for _, gState := range tests {
+		if got := unpackGameState(packGameState(gState)); got != gState {
+			t.Errorf("unpackGameState(packGameState(%v)) got %v", gState, got)
+		}
+	}
+}
+
+func TestMDPPolicyFreeze(t *testing.T) {
+	t.Parallel()
+
+	mdp, err := NewMDP(1)
+	if err != nil {
+		t.Fatalf("NewMDP: %v", err)
+	}
+	encoding, err := mdp.Policy().(*MDPPolicy).GobEncode()
+	if err != nil {
+		t.Fatalf("GobEncode: %v", err)
+	}
+	pol, err := NewMDPPolicyFromGob(encoding)
+	if err != nil {
+		t.Fatalf("NewMDPPolicyFromGob: %v", err)
+	}
+	frozen, err := NewMDPPolicyFromGob(encoding)
+	if err != nil {
+		t.Fatalf("NewMDPPolicyFromGob: %v", err)
+	}
+	frozen.Freeze()
+	if !frozen.Frozen() || pol.Frozen() {
+		t.Fatalf("Frozen() got %t for the frozen policy and %t for the map policy", frozen.Frozen(), pol.Frozen())
+	}
+	if frozen.Len() != pol.Len() {
+		t.Errorf("Len() got %d after Freeze, want %d", frozen.Len(), pol.Len())
+	}
+
+	unknown := GameState{
+		State:   combo4.State{Field: combo4.LeftI},
+		Current: tetris.S,
+		Preview: tetris.MustSeq([]tetris.Piece{tetris.O}),
+		BagUsed: tetris.NewPieceSet(tetris.S, tetris.O),
+	}
+	gStates := []GameState{unknown}
+	for gState := range mdp.policy {
+		gStates = append(gStates, gState)
+	}
+	for _, gState := range gStates {
+		preview := gState.Preview.Slice()
+		want := pol.NextState(gState.State, gState.Current, preview, gState.BagUsed)
+		got := frozen.NextState(gState.State, gState.Current, preview, gState.BagUsed)
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Fatalf("NextState(%v) mismatch after Freeze (-want +got):\n%s", gState, diff)
+		}
+	}
+	if diff := cmp.Diff(pol.Stats(), frozen.Stats()); diff != "" {
+		t.Errorf("Stats mismatch after Freeze (-want +got):\n%s", diff)
+	}
+
+	// A frozen policy encodes the same choices.
+	reencoding, err := frozen.GobEncode()
+	if err != nil {
+		t.Fatalf("GobEncode of the frozen policy: %v", err)
+	}
+	decoding, err := NewMDPPolicyFromGob(reencoding)
+	if err != nil {
+		t.Fatalf("NewMDPPolicyFromGob: %v", err)
+	}
+	if diff := cmp.Diff(pol.policy, decoding.policy); diff != "" {
+		t.Errorf("decoding of the frozen policy mismatch (-want +got):\n%s", diff)
+	}
+}
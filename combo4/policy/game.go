@@ -0,0 +1,632 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"tetris"
+	"tetris/combo4"
+)
+
+// Decision is output by a game for the first move and then for each input.
+type Decision struct {
+	// State is the chosen next state or nil if there are no more possible
+	// moves.
+	State *combo4.State
+	// Err is set if the input could not be used. The game continues from
+	// the previous State as if the input was never sent and State is nil.
+	// The exception is ErrHoldUsed which ends the game.
+	Err error
+	// BagUsed is the bag state of the game after the last piece in the
+	// preview. With the UnknownBagPhase option it is the first bag state
+	// that is still possible. It is unset if the game could not start.
+	BagUsed tetris.PieceSet
+}
+
+// ErrImpossiblePiece is the Err of a Decision when an input piece does not
+// follow the 7 bag randomizer or the NextPieceModel of the WithPieceModel
+// option.
+type ErrImpossiblePiece struct {
+	Piece   tetris.Piece
+	BagUsed tetris.PieceSet
+}
+
+func (e *ErrImpossiblePiece) Error() string {
+	return `impossible piece "` + e.Piece.String() + `" for bag state ` + e.BagUsed.String()
+}
+
+// ErrInvalidReport is the Err of a Decision when a StateReport has a State
+// without any transitions.
+var ErrInvalidReport = errors.New("reported state has no transitions")
+
+// ErrMalformedState is returned by CheckResumeState and is the Err of a
+// Decision when a game is resumed with the CheckState option from a State
+// without any transitions in the NFA. Such a State could never be reached e.g.
+// a swap restricted State without a piece held.
+var ErrMalformedState = errors.New("state has no transitions")
+
+// ErrDeadEnd is returned by CheckResumeState when a State has transitions
+// in the NFA but none for the current piece. The State is valid but the game
+// cannot continue from it.
+var ErrDeadEnd = errors.New("state has no transitions for the current piece")
+
+// ErrHoldUsed is the Err of a Decision when the Policy changes the Hold in a
+// game played with the NoHold option.
+var ErrHoldUsed = errors.New("policy used the hold in a game without hold")
+
+// StateReport reports the actual State of a game and the piece that is
+// about to be played from it.
+type StateReport struct {
+	State   combo4.State
+	Current tetris.Piece
+}
+
+// Game plays a Policy one piece at a time. Game is not safe for concurrent
+// use.
+type Game struct {
+	pol      Policy
+	state    *combo4.State
+	current  tetris.Piece
+	preview  []tetris.Piece
+	bagUsed  tetris.PieceSet
+	consumed int
+	noHold   bool
+	model    NextPieceModel
+	err      error
+	// The bag states the pieces could have been drawn to with the
+	// UnknownBagPhase option in the order of possibleBags or nil if the bag
+	// state is known.
+	bagCandidates []tetris.PieceSet
+	// The States of the plan from Plan that are not followed yet and the
+	// State before the first of them.
+	plan     []plannedMove
+	planFrom combo4.State
+}
+
+// NewGame creates a Game and decides the first move. The initial State may
+// have a piece held or be swap restricted and bagUsed is the bag state after
+// the last piece in preview.
+//
+// With the UnknownBagPhase option, if no bag state can draw current and
+// preview, the Game has no possible moves and Err returns an
+// ErrImpossiblePiece. With the PanicOnBagViolation option, NewGame panics
+// instead.
+func NewGame(pol Policy, initialState combo4.State, current tetris.Piece, preview []tetris.Piece, bagUsed tetris.PieceSet, opts ...GameOption) *Game {
+	return newGame(pol, initialState, current, preview, bagUsed, newGameOptions(opts))
+}
+
+// newGame implements NewGame with the options already applied.
+func newGame(pol Policy, initialState combo4.State, current tetris.Piece, preview []tetris.Piece, bagUsed tetris.PieceSet, o *gameOptions) *Game {
+	g := &Game{
+		pol:     pol,
+		current: current,
+		// Make a copy of preview because it will be modified.
+		preview: append([]tetris.Piece(nil), preview...),
+		bagUsed: bagUsed,
+		noHold:  o.noHold,
+		model:   o.model,
+	}
+	if o.unknownBagPhase && isSevenBag(g.model) {
+		bags, err := possibleBags(append([]tetris.Piece{current}, preview...))
+		if err != nil {
+			if o.panicOnBagViolation {
+				panic(err.Error())
+			}
+			g.err = err
+			return g
+		}
+		g.setBags(bags)
+	}
+	g.err = g.decide(initialState)
+	g.countDecision()
+	return g
+}
+
+// NewGameFromField is like NewGame but assumes there is no piece held and
+// the game is starting with no pieces played yet (starting with an empty
+// bag). It returns an ErrImpossiblePiece if current and preview do not follow
+// the 7 bag randomizer or the NextPieceModel of the WithPieceModel option.
+func NewGameFromField(pol Policy, initial combo4.Field4x4, current tetris.Piece, preview []tetris.Piece, opts ...GameOption) (*Game, error) {
+	bag, err := startingBag(newGameOptions(opts).model, current, preview)
+	if err != nil {
+		return nil, err
+	}
+	return NewGame(pol, combo4.State{Field: initial}, current, preview, bag, opts...), nil
+}
+
+// FirstMoveAdvice returns whether the Policy holds the current piece as the
+// first move of a game started by StartGame and the resulting State. It
+// returns an error if the pieces do not follow the 7 bag randomizer or there
+// is no possible first move.
+func FirstMoveAdvice(pol Policy, field combo4.Field4x4, current tetris.Piece, next []tetris.Piece) (hold bool, resulting combo4.State, err error) {
+	game, err := NewGameFromField(pol, field, current, next)
+	if err != nil {
+		return false, combo4.State{}, err
+	}
+	state := game.State()
+	if state == nil {
+		return false, combo4.State{}, fmt.Errorf("no possible first move with %v", current)
+	}
+	return state.Hold != tetris.EmptyPiece, *state, nil
+}
+
+// startingBag returns the bag state after drawing the pieces from an empty
+// bag of the model. A nil model is the 7 bag randomizer.
+func startingBag(model NextPieceModel, current tetris.Piece, preview []tetris.Piece) (tetris.PieceSet, error) {
+	var bag tetris.PieceSet
+	for _, p := range append([]tetris.Piece{current}, preview...) {
+		newBag, ok := canDraw(model, bag, p)
+		if !ok {
+			return bag, &ErrImpossiblePiece{Piece: p, BagUsed: bag}
+		}
+		bag = newBag
+	}
+	return bag, nil
+}
+
+// Step adds a piece to the end of the preview and decides the next move.
+// Step returns nil if there are no more possible moves.
+//
+// If the piece does not follow the 7 bag randomizer or the NextPieceModel of
+// the WithPieceModel option, Step returns an ErrImpossiblePiece and the Game
+// is unchanged. In a game played with the NoHold option, Step returns an
+// ErrHoldUsed and ends the Game if the Policy changes the Hold.
+func (g *Game) Step(p tetris.Piece) (*combo4.State, error) {
+	if g.state == nil {
+		return nil, nil
+	}
+	if g.bagCandidates != nil {
+		bags := drawEach(g.bagCandidates, p)
+		if len(bags) == 0 {
+			return nil, &ErrImpossiblePiece{Piece: p, BagUsed: g.bagUsed}
+		}
+		g.setBags(bags)
+	} else {
+		newBag, ok := canDraw(g.model, g.bagUsed, p)
+		if !ok {
+			return nil, &ErrImpossiblePiece{Piece: p, BagUsed: g.bagUsed}
+		}
+		g.bagUsed = newBag
+	}
+	g.current = shiftQueue(g.current, g.preview, p)
+	g.err = g.decide(*g.state)
+	g.countDecision()
+	return g.state, g.err
+}
+
+// Report replaces the State and current piece of the Game and decides the
+// next move from them. The preview, bag and Consumed are unaffected since
+// the reported piece was already counted. A Game with no more possible moves
+// can be continued with a report.
+//
+// Report returns an ErrInvalidReport if the reported State has no
+// transitions in the NFA and the Game is unchanged.
+func (g *Game) Report(nfa *combo4.NFA, report StateReport) (*combo4.State, error) {
+	if !nfa.HasState(report.State) {
+		return nil, fmt.Errorf("%w: %+v", ErrInvalidReport, report.State)
+	}
+	g.current = report.Current
+	g.err = g.decide(report.State)
+	return g.state, g.err
+}
+
+// decide sets the next State using the Policy and follows or drops the
+// plan.
+func (g *Game) decide(from combo4.State) error {
+	g.state = g.pol.NextState(from, g.current, g.preview, g.bagUsed)
+	if g.state != nil && g.noHold && g.state.Hold != from.Hold {
+		g.state = nil
+		g.plan = nil
+		return ErrHoldUsed
+	}
+	g.followPlan(from)
+	return nil
+}
+
+// setBags sets the bag state to the first of the possible bag states and
+// keeps the others until the pieces rule them out.
+func (g *Game) setBags(bags []tetris.PieceSet) {
+	g.bagUsed = bags[0]
+	g.bagCandidates = nil
+	if len(bags) > 1 {
+		g.bagCandidates = bags
+	}
+}
+
+// countDecision counts the piece played by the last decision if it had a
+// possible move.
+func (g *Game) countDecision() {
+	if g.state != nil {
+		g.consumed++
+	}
+}
+
+// Err returns the error that ended the Game or nil.
+func (g *Game) Err() error {
+	return g.err
+}
+
+// State returns the last chosen State or nil if there are no more possible
+// moves.
+func (g *Game) State() *combo4.State {
+	return g.state
+}
+
+// Current returns the piece played to reach State.
+func (g *Game) Current() tetris.Piece {
+	return g.current
+}
+
+// Preview returns a copy of the pieces in the preview.
+func (g *Game) Preview() []tetris.Piece {
+	return append([]tetris.Piece(nil), g.preview...)
+}
+
+// BagUsed returns the bag state after the last piece in the preview.
+func (g *Game) BagUsed() tetris.PieceSet {
+	return g.bagUsed
+}
+
+// Consumed returns the number of pieces played. This is the first move plus
+// each Step that had a possible move. Reports do not change it.
+func (g *Game) Consumed() int {
+	return g.consumed
+}
+
+// GameOption configures how a game is played.
+type GameOption func(*gameOptions)
+
+type gameOptions struct {
+	panicOnBagViolation bool
+	noHold              bool
+	checkStateNFA       *combo4.NFA
+	model               NextPieceModel
+	outputBuffer        int
+	unknownBagPhase     bool
+}
+
+// defaultOutputBuffer is the capacity of the output channel of a game
+// without the OutputBuffer option.
+const defaultOutputBuffer = 4
+
+// PanicOnBagViolation makes the game panic instead of outputting an
+// ErrImpossiblePiece.
+func PanicOnBagViolation() GameOption {
+	return func(o *gameOptions) {
+		o.panicOnBagViolation = true
+	}
+}
+
+// NoHold makes the game end with an ErrHoldUsed instead of outputting a State
+// with a different Hold than the previous State. The Policy should be created
+// from an NFA without hold e.g. combo4.NewNFANoHold.
+func NoHold() GameOption {
+	return func(o *gameOptions) {
+		o.noHold = true
+	}
+}
+
+// CheckState makes ResumeGame output only a Decision with an
+// ErrMalformedState if the initial State has no transitions in the NFA. A
+// game resumed from a valid State with no transitions for the current piece
+// still outputs a Decision with a nil State and no error.
+func CheckState(nfa *combo4.NFA) GameOption {
+	return func(o *gameOptions) {
+		o.checkStateNFA = nfa
+	}
+}
+
+// UnknownBagPhase makes the game not assume where the 7 bags start e.g. when
+// a game is resumed in the middle of a bag. The game keeps every bag state
+// that the pieces could have been drawn to and only rejects a piece that
+// none of them can draw. Until the pieces rule out the others, the Policy is
+// given the bag state of the bag that started the latest. ResumeGame ignores
+// the bag state it is given. It has no effect with the WithPieceModel option.
+func UnknownBagPhase() GameOption {
+	return func(o *gameOptions) {
+		o.unknownBagPhase = true
+	}
+}
+
+// WithPieceModel makes the game check the pieces against the NextPieceModel
+// instead of the 7 bag randomizer. The bag states of the game are the ones
+// defined by the model which is how an MDPPolicy trained with the model
+// expects them. ResumeGame does not check the pieces against the bag state
+// it is given.
+func WithPieceModel(model NextPieceModel) GameOption {
+	return func(o *gameOptions) {
+		o.model = model
+	}
+}
+
+// OutputBuffer sets the capacity of the output channel of StartGame,
+// ResumeGame and ResumeGameWithReports. The game decides the moves for up to
+// n inputs that were not read yet before it waits for the Decisions to be
+// read. An n of 0 makes the game wait for each Decision to be read before
+// reading the next input and a negative n is the same as 0. Defaults to 4.
+func OutputBuffer(n int) GameOption {
+	if n < 0 {
+		n = 0
+	}
+	return func(o *gameOptions) {
+		o.outputBuffer = n
+	}
+}
+
+func newGameOptions(opts []GameOption) *gameOptions {
+	o := &gameOptions{outputBuffer: defaultOutputBuffer}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// StartGame returns a channel that outputs a Decision for the first move and
+// then an additional Decision for each input. The Decision's State is nil if
+// there are no more possible moves.
+//
+// The output channel is buffered (see OutputBuffer). Once the buffer is full,
+// the game stops reading the input until a Decision is read so a slow
+// consumer holds back the game instead of losing Decisions.
+//
+// StartGame assumes there is no piece held and the game is starting with no
+// pieces played yet (starting with an empty bag).
+//
+// If current and next do not follow the 7 bag randomizer or the
+// NextPieceModel of the WithPieceModel option, the only output is a Decision
+// with an ErrImpossiblePiece.
+func StartGame(pol Policy, initial combo4.Field4x4, current tetris.Piece, next []tetris.Piece, input chan tetris.Piece, opts ...GameOption) chan Decision {
+	o := newGameOptions(opts)
+	bag, err := startingBag(o.model, current, next)
+	if err != nil {
+		if o.panicOnBagViolation {
+			panic(err.Error())
+		}
+		return errorDecision(err)
+	}
+	return resumeGame(pol, combo4.State{Field: initial}, current, next, bag, input, o)
+}
+
+// ResumeGame is like StartGame but does not assume the game is played from
+// the beginning. The initial State may have a piece held or be swap
+// restricted and endBagUsed is the bag state after the last piece in next.
+//
+// If the pieces do not pass GameState.Validate, the only output is a
+// Decision with the error or the game panics with the PanicOnBagViolation
+// option if they do not follow the bag. With the NextPieceModel of the
+// WithPieceModel option, the pieces cannot be undrawn from endBagUsed so only
+// the pieces themselves are checked. The initial State itself is not checked
+// unless the CheckState option is used. Otherwise a State the NFA does not
+// have e.g. a swap restricted State without a piece held is
+// indistinguishable from a State with no possible moves. See
+// CheckResumeState.
+func ResumeGame(pol Policy, initialState combo4.State, current tetris.Piece, next []tetris.Piece, endBagUsed tetris.PieceSet, input chan tetris.Piece, opts ...GameOption) chan Decision {
+	return resumeGame(pol, initialState, current, next, endBagUsed, input, newGameOptions(opts))
+}
+
+// resumeGame implements ResumeGame with the options already applied.
+func resumeGame(pol Policy, initialState combo4.State, current tetris.Piece, next []tetris.Piece, endBagUsed tetris.PieceSet, input chan tetris.Piece, o *gameOptions) chan Decision {
+	err := checkResumePieces(initialState, current, next, endBagUsed, o)
+	if nfa := o.checkStateNFA; err == nil && nfa != nil {
+		if err = CheckResumeState(nfa, initialState, current); errors.Is(err, ErrDeadEnd) {
+			err = nil
+		}
+	}
+	if err != nil {
+		return errorDecision(err)
+	}
+	return playGame(pol, nil, initialState, current, next, endBagUsed, input, nil, o)
+}
+
+// errorDecision returns a closed output channel with only a Decision with
+// the error.
+func errorDecision(err error) chan Decision {
+	output := make(chan Decision, 1)
+	output <- Decision{Err: err}
+	close(output)
+	return output
+}
+
+// checkResumePieces returns an error if a game cannot be resumed with the
+// pieces. It panics with the PanicOnBagViolation option if the pieces do not
+// follow the bag like StartGame.
+func checkResumePieces(initialState combo4.State, current tetris.Piece, next []tetris.Piece, endBagUsed tetris.PieceSet, o *gameOptions) error {
+	if err := validateResumePieces(current, next); err != nil {
+		return err
+	}
+	var err error
+	switch {
+	case !isSevenBag(o.model):
+		return nil
+	case o.unknownBagPhase:
+		_, err = possibleBags(append([]tetris.Piece{current}, next...))
+	default:
+		// The pieces themselves are valid so the error is from the bag.
+		_, err = newValidGameState(initialState, current, next, endBagUsed)
+	}
+	if err != nil && o.panicOnBagViolation {
+		panic(err.Error())
+	}
+	return err
+}
+
+// validateResumePieces returns an error if there is no current piece or next
+// is not a valid preview.
+func validateResumePieces(current tetris.Piece, next []tetris.Piece) error {
+	seq, err := tetris.NewSeq(next)
+	if err != nil {
+		return fmt.Errorf("invalid preview %v: %v", next, err)
+	}
+	return validatePieces(GameState{Current: current, Preview: seq}, len(next))
+}
+
+// CheckResumeState returns an ErrMalformedState if the State has no
+// transitions in the NFA or an ErrDeadEnd if it has no transitions for the
+// current piece. It returns nil if a game resumed from the State has at
+// least one possible first move.
+func CheckResumeState(nfa *combo4.NFA, state combo4.State, current tetris.Piece) error {
+	if !nfa.HasState(state) {
+		if unrestricted := (combo4.State{Field: state.Field, Hold: state.Hold}); state.SwapRestricted && nfa.HasState(unrestricted) {
+			return fmt.Errorf("%w: %+v is swap restricted but only %+v is in the NFA", ErrMalformedState, state, unrestricted)
+		}
+		return fmt.Errorf("%w: %+v", ErrMalformedState, state)
+	}
+	if len(nfa.NextStates(state, current)) == 0 {
+		return fmt.Errorf("%w: %+v with %v", ErrDeadEnd, state, current)
+	}
+	return nil
+}
+
+// ResumeGameWithReports is like ResumeGame but the State of the game can be
+// corrected by sending a StateReport. This is useful when the game being
+// played diverged from the states that were output e.g. a key press was
+// dropped.
+//
+// Each report replaces the internal state and outputs one additional Decision
+// for the reported State and Current piece. The preview and bag are
+// unaffected by reports. A game that had no more possible moves can be
+// continued with a report. Reports of States without any transitions in the
+// NFA output an ErrInvalidReport.
+//
+// The pieces are checked like in ResumeGame but the CheckState option is
+// ignored since a report can replace the initial State.
+func ResumeGameWithReports(pol Policy, nfa *combo4.NFA, initialState combo4.State, current tetris.Piece, next []tetris.Piece, endBagUsed tetris.PieceSet, input chan tetris.Piece, reports chan StateReport, opts ...GameOption) chan Decision {
+	o := newGameOptions(opts)
+	if err := checkResumePieces(initialState, current, next, endBagUsed, o); err != nil {
+		return errorDecision(err)
+	}
+	return playGame(pol, nfa, initialState, current, next, endBagUsed, input, reports, o)
+}
+
+// playGame implements ResumeGame and ResumeGameWithReports using a Game.
+func playGame(pol Policy, nfa *combo4.NFA, initialState combo4.State, current tetris.Piece, next []tetris.Piece, endBagUsed tetris.PieceSet, input chan tetris.Piece, reports chan StateReport, o *gameOptions) chan Decision {
+	panicOnBagViolation := o.panicOnBagViolation
+
+	output := make(chan Decision, o.outputBuffer)
+	go func() {
+		defer close(output)
+
+		// Output the first move.
+		game := newGame(pol, initialState, current, next, endBagUsed, o)
+		output <- Decision{State: game.State(), Err: game.Err(), BagUsed: game.BagUsed()}
+
+		for {
+			select {
+			case p, ok := <-input:
+				if !ok {
+					return
+				}
+				state, err := game.Step(p)
+				var impossible *ErrImpossiblePiece
+				if panicOnBagViolation && errors.As(err, &impossible) {
+					panic(err.Error())
+				}
+				output <- Decision{State: state, Err: err, BagUsed: game.BagUsed()}
+
+			case report, ok := <-reports:
+				if !ok {
+					// Stop listening for reports.
+					reports = nil
+					continue
+				}
+				state, err := game.Report(nfa, report)
+				output <- Decision{State: state, Err: err, BagUsed: game.BagUsed()}
+			}
+		}
+	}()
+
+	return output
+}
+
+// NewGameState creates a GameState from a reading of a live game at its
+// start. BagUsed is derived from the current and preview pieces using
+// BagUsedAfter, which assumes they start a bag, so it is only valid before
+// any piece was played. Later in a game, use the BagUsed of the Decision or
+// the Game instead.
+func NewGameState(state combo4.State, current tetris.Piece, preview []tetris.Piece) GameState {
+	bagUsed, _ := BagUsedAfter(append([]tetris.Piece{current}, preview...))
+	return GameState{
+		State:   state,
+		Current: current,
+		Preview: tetris.MustSeq(preview),
+		BagUsed: bagUsed,
+	}
+}
+
+// BagUsedAfter returns the bag state after drawing the pieces starting from
+// an empty bag. It returns false if a piece could not be drawn from the
+// current bag, in which case the piece is assumed to start a new bag.
+func BagUsedAfter(pieces []tetris.Piece) (tetris.PieceSet, bool) {
+	var bagUsed tetris.PieceSet
+	valid := true
+	for _, p := range pieces {
+		newBag, ok := draw(bagUsed, p)
+		if !ok {
+			newBag = p.PieceSet()
+			valid = false
+		}
+		bagUsed = newBag
+	}
+	return bagUsed, valid
+}
+
+// possibleBags returns every bag state of the 7 bag randomizer after the
+// pieces if they may start in the middle of a bag. The bag states are in the
+// order of the bag states before the pieces from the fewest pieces drawn so
+// the first one assumes the pieces start a bag if they can. It returns an
+// ErrImpossiblePiece if no bag state can draw the pieces.
+func possibleBags(pieces []tetris.Piece) ([]tetris.PieceSet, error) {
+	starts := tetris.AllPieceSets()
+	// The full bag is the same as the empty one.
+	starts = starts[:len(starts)-1]
+	sort.SliceStable(starts, func(i, j int) bool { return starts[i].Len() < starts[j].Len() })
+	bags := starts
+	for _, p := range pieces {
+		next := drawEach(bags, p)
+		if len(next) == 0 {
+			return nil, &ErrImpossiblePiece{Piece: p, BagUsed: bags[0]}
+		}
+		bags = next
+	}
+	return bags, nil
+}
+
+// drawEach returns the bag states after drawing the piece from each of the
+// bags that can draw it in the same order without duplicates.
+func drawEach(bags []tetris.PieceSet, p tetris.Piece) []tetris.PieceSet {
+	var next []tetris.PieceSet
+	seen := make(map[tetris.PieceSet]bool, len(bags))
+	for _, bag := range bags {
+		newBag, ok := draw(bag, p)
+		if !ok || seen[newBag] {
+			continue
+		}
+		seen[newBag] = true
+		next = append(next, newBag)
+	}
+	return next
+}
+
+// shiftQueue adds the piece to the end of the queue and returns the new
+// current piece. The next slice is modified in place.
+func shiftQueue(current tetris.Piece, next []tetris.Piece, p tetris.Piece) tetris.Piece {
+	if len(next) == 0 {
+		return p
+	}
+	current = next[0]
+	copy(next, next[1:])
+	next[len(next)-1] = p
+	return current
+}
+
+// draw returns the bag state after drawing a piece. draw returns false if
+// the piece could not have been drawn using a 7 bag randomizer.
+func draw(bagUsed tetris.PieceSet, p tetris.Piece) (tetris.PieceSet, bool) {
+	if bagUsed.IsFullBag() {
+		bagUsed = 0
+	}
+	if bagUsed.Contains(p) {
+		return bagUsed, false
+	}
+	return bagUsed.Add(p), true
+}
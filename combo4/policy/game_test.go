@@ -0,0 +1,332 @@
+package policy
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+	"tetris"
+	"tetris/combo4"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestGame(t *testing.T) {
+	moves, _ := combo4.AllContinuousMoves()
+	nfa := combo4.NewNFA(moves)
+	rec := &recordingPolicy{pol: FromScorer(nfa, NewNFAScorer(nfa, 3))}
+
+	game, err := NewGameFromField(rec, combo4.LeftI, tetris.T, []tetris.Piece{tetris.L, tetris.J})
+	if err != nil {
+		t.Fatalf("NewGameFromField: %v", err)
+	}
+	if game.State() == nil || game.Consumed() != 1 {
+		t.Fatalf("got State=%v Consumed=%d after the first move, want a State and 1", game.State(), game.Consumed())
+	}
+
+	// An impossible piece leaves the Game unchanged.
+	before := *game.State()
+	var impossible *ErrImpossiblePiece
+	if _, err := game.Step(tetris.L); !errors.As(err, &impossible) {
+		t.Fatalf("Step(L) got err=%v, want an ErrImpossiblePiece", err)
+	}
+	if *game.State() != before || game.Consumed() != 1 || len(rec.calls) != 1 {
+		t.Fatalf("Step(L) changed the Game")
+	}
+
+	state, err := game.Step(tetris.S)
+	if err != nil {
+		t.Fatalf("Step(S): %v", err)
+	}
+	if state == nil || state != game.State() {
+		t.Fatalf("Step(S) got %v, want the Game's State %v", state, game.State())
+	}
+	if game.Consumed() != 2 {
+		t.Errorf("got Consumed()=%d, want 2", game.Consumed())
+	}
+	if game.Current() != tetris.L {
+		t.Errorf("got Current()=%v, want L", game.Current())
+	}
+	if diff := cmp.Diff([]tetris.Piece{tetris.J, tetris.S}, game.Preview()); diff != "" {
+		t.Errorf("Preview() mismatch (-want +got):\n%s", diff)
+	}
+	if want := tetris.NewPieceSet(tetris.T, tetris.L, tetris.J, tetris.S); game.BagUsed() != want {
+		t.Errorf("got BagUsed()=%v, want %v", game.BagUsed(), want)
+	}
+	if got := rec.calls[1]; got.initial != before || got.current != tetris.L {
+		t.Errorf("got NextState(%v, %v, ...), want NextState(%v, L, ...)", got.initial, got.current, before)
+	}
+
+	// Reports replace the State and current piece.
+	report := StateReport{State: combo4.State{Field: combo4.RightI, Hold: tetris.T}, Current: tetris.O}
+	if _, err := game.Report(nfa, report); err != nil {
+		t.Fatalf("Report: %v", err)
+	}
+	if got := rec.calls[2]; got.initial != report.State || got.current != tetris.O {
+		t.Errorf("got NextState(%v, %v, ...) after a report, want NextState(%v, O, ...)", got.initial, got.current, report.State)
+	}
+	if game.Consumed() != 2 {
+		t.Errorf("got Consumed()=%d after a report, want it unchanged at 2", game.Consumed())
+	}
+	if _, err := game.Report(nfa, StateReport{State: combo4.State{Field: combo4.LeftI, SwapRestricted: true}}); !errors.Is(err, ErrInvalidReport) {
+		t.Errorf("Report got err=%v, want ErrInvalidReport", err)
+	}
+}
+
+func TestBagUsedAfter(t *testing.T) {
+	tests := []struct {
+		desc   string
+		pieces string
+		want   tetris.PieceSet
+		wantOK bool
+	}{
+		{desc: "empty", wantOK: true},
+		{desc: "one bag", pieces: "TLJ", want: tetris.NewPieceSet(tetris.T, tetris.L, tetris.J), wantOK: true},
+		{desc: "next bag", pieces: "TLJSZOIS", want: tetris.S.PieceSet(), wantOK: true},
+		{desc: "repeated piece", pieces: "TLT", want: tetris.T.PieceSet()},
+	}
+	for _, test := range tests {
+		got, ok := BagUsedAfter(tetris.SeqFromStr(test.pieces))
+		if got != test.want || ok != test.wantOK {
+			t.Errorf("%s: BagUsedAfter(%s) got %v, %t, want %v, %t", test.desc, test.pieces, got, ok, test.want, test.wantOK)
+		}
+	}
+}
+
+func TestGameUnknownBagPhase(t *testing.T) {
+	moves, _ := combo4.AllContinuousMoves()
+	nfa := combo4.NewNFA(moves)
+	pol := FromScorer(nfa, NewNFAScorer(nfa, 3))
+	// The first bag ends with TL so the next T is in the third bag. The
+	// visible pieces could also start a bag which only the last T rules out.
+	visible := tetris.SeqFromStr("TLJSZO")
+	next := tetris.SeqFromStr("ITLT")
+
+	started, _ := BagUsedAfter(visible)
+	assumed := NewGame(pol, combo4.State{Field: combo4.LeftI, Hold: tetris.I}, visible[0], visible[1:], started)
+	unknown := NewGame(pol, combo4.State{Field: combo4.LeftI, Hold: tetris.I}, visible[0], visible[1:], 0, UnknownBagPhase())
+	if unknown.BagUsed() != started {
+		t.Errorf("got BagUsed()=%v, want %v of a bag started by the pieces", unknown.BagUsed(), started)
+	}
+	for i, p := range next {
+		_, assumedErr := assumed.Step(p)
+		if _, err := unknown.Step(p); err != nil {
+			t.Fatalf("Step(%v) with an unknown bag phase got err=%v", p, err)
+		}
+		var impossible *ErrImpossiblePiece
+		if last := i == len(next)-1; last != errors.As(assumedErr, &impossible) {
+			t.Errorf("Step(%v) assuming the pieces started a bag got err=%v, want an ErrImpossiblePiece only for the last piece", p, assumedErr)
+		}
+	}
+	if want := tetris.T.PieceSet(); unknown.BagUsed() != want {
+		t.Errorf("got BagUsed()=%v, want %v", unknown.BagUsed(), want)
+	}
+
+	// No bag state can draw three Ts in a row.
+	decisions := ResumeGame(pol, combo4.State{Field: combo4.LeftI, Hold: tetris.I}, tetris.T, tetris.SeqFromStr("TT"), 0, nil, UnknownBagPhase())
+	var impossible *ErrImpossiblePiece
+	if d := <-decisions; !errors.As(d.Err, &impossible) {
+		t.Errorf("ResumeGame(TTT) got err=%v, want an ErrImpossiblePiece", d.Err)
+	}
+	game := NewGame(pol, combo4.State{Field: combo4.LeftI, Hold: tetris.I}, tetris.T, tetris.SeqFromStr("TT"), 0, UnknownBagPhase())
+	if !errors.As(game.Err(), &impossible) || game.State() != nil {
+		t.Errorf("NewGame(TTT) got State()=%v, Err()=%v, want no State and an ErrImpossiblePiece", game.State(), game.Err())
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("NewGame(TTT) with PanicOnBagViolation did not panic")
+			}
+		}()
+		NewGame(pol, combo4.State{Field: combo4.LeftI, Hold: tetris.I}, tetris.T, tetris.SeqFromStr("TT"), 0, UnknownBagPhase(), PanicOnBagViolation())
+	}()
+}
+
+func TestResumeGameMemoryless(t *testing.T) {
+	moves, _ := combo4.AllContinuousMoves()
+	nfa := combo4.NewNFA(moves)
+	pol := FromScorer(nfa, NewNFAScorer(nfa, 1))
+	initial := combo4.State{Field: combo4.LeftI, Hold: tetris.I}
+
+	// Three Ts in a row are possible without a bag.
+	decisions := ResumeGame(pol, initial, tetris.T, tetris.SeqFromStr("TT"), 0, nil, WithPieceModel(Memoryless{}))
+	if d := <-decisions; d.Err != nil {
+		t.Errorf("ResumeGame(TTT) got err=%v, want nil", d.Err)
+	}
+	for _, test := range []struct {
+		current tetris.Piece
+		next    []tetris.Piece
+	}{
+		{current: tetris.EmptyPiece, next: tetris.SeqFromStr("TT")},
+		{current: tetris.T, next: []tetris.Piece{tetris.T, tetris.EmptyPiece}},
+	} {
+		decisions := ResumeGame(pol, initial, test.current, test.next, 0, nil, WithPieceModel(Memoryless{}))
+		if d := <-decisions; d.Err == nil {
+			t.Errorf("ResumeGame(%v, %v) got no error, want an invalid piece error", test.current, test.next)
+		}
+	}
+}
+
+func TestNewGameState(t *testing.T) {
+	moves, _ := combo4.AllContinuousMoves()
+	nfa := combo4.NewNFA(moves)
+
+	tests := []struct {
+		desc   string
+		pieces []tetris.Piece
+	}{
+		{
+			desc:   "Partial bag",
+			pieces: tetris.SeqFromStr("TLJ"),
+		},
+		{
+			desc:   "Full bag",
+			pieces: tetris.SeqFromStr("TLJSZOI"),
+		},
+		{
+			desc:   "Into the next bag",
+			pieces: tetris.SeqFromStr("TLJSZOIIT"),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			rec := &recordingPolicy{pol: FromScorer(nfa, NewNFAScorer(nfa, 3))}
+			if _, err := NewGameFromField(rec, combo4.LeftI, test.pieces[0], test.pieces[1:]); err != nil {
+				t.Fatalf("NewGameFromField: %v", err)
+			}
+
+			gState := NewGameState(combo4.State{Field: combo4.LeftI}, test.pieces[0], test.pieces[1:])
+			if want := rec.calls[0].bag; gState.BagUsed != want {
+				t.Errorf("got BagUsed=%v, want %v", gState.BagUsed, want)
+			}
+		})
+	}
+}
+
+// holdingPolicy always holds the current piece.
+type holdingPolicy struct{}
+
+func (holdingPolicy) NextState(initial combo4.State, current tetris.Piece, preview []tetris.Piece, endBagUsed tetris.PieceSet) *combo4.State {
+	return &combo4.State{Field: initial.Field, Hold: current, SwapRestricted: true}
+}
+
+func TestGameNoHold(t *testing.T) {
+	game, err := NewGameFromField(holdingPolicy{}, combo4.LeftI, tetris.T, []tetris.Piece{tetris.L}, NoHold())
+	if err != nil {
+		t.Fatalf("NewGameFromField: %v", err)
+	}
+	if game.State() != nil || !errors.Is(game.Err(), ErrHoldUsed) {
+		t.Errorf("got State=%v Err=%v, want no State and ErrHoldUsed", game.State(), game.Err())
+	}
+
+	output := StartGame(holdingPolicy{}, combo4.LeftI, tetris.T, []tetris.Piece{tetris.L}, make(chan tetris.Piece), NoHold())
+	if decision := <-output; decision.State != nil || !errors.Is(decision.Err, ErrHoldUsed) {
+		t.Errorf("got %+v from StartGame, want an ErrHoldUsed", decision)
+	}
+}
+
+func TestDecisionBagUsed(t *testing.T) {
+	input := make(chan tetris.Piece, 2)
+	input <- tetris.T
+	input <- tetris.L
+	close(input)
+	output := StartGame(holdingPolicy{}, combo4.LeftI, tetris.T, tetris.SeqFromStr("LJSZOI"), input)
+
+	// The second bag starts with the first input.
+	want := []tetris.PieceSet{
+		tetris.NewPieceSet(tetris.NonemptyPieces[:]...),
+		tetris.NewPieceSet(tetris.T),
+		tetris.NewPieceSet(tetris.T, tetris.L),
+	}
+	var got []tetris.PieceSet
+	for decision := range output {
+		if decision.Err != nil {
+			t.Fatalf("got %+v, want no error", decision)
+		}
+		got = append(got, decision.BagUsed)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("BagUsed of the Decisions mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestStartGameOutputBuffer(t *testing.T) {
+	const numInputs = 10
+	queue := tetris.RandPiecesFrom(rand.New(rand.NewSource(1)), numInputs+2)
+	input := make(chan tetris.Piece, numInputs)
+	for _, p := range queue[2:] {
+		input <- p
+	}
+	close(input)
+	output := StartGame(holdingPolicy{}, combo4.LeftI, queue[0], queue[1:2], input)
+	if got := cap(output); got != defaultOutputBuffer {
+		t.Errorf("got an output capacity of %d, want %d", got, defaultOutputBuffer)
+	}
+
+	// The game decides ahead of the consumer until the buffer is full.
+	deadline := time.Now().Add(10 * time.Second)
+	for len(output) < defaultOutputBuffer {
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d buffered Decisions, want %d", len(output), defaultOutputBuffer)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// A slow consumer still gets every Decision.
+	var got int
+	timeout := time.After(10 * time.Second)
+	for done := false; !done; {
+		select {
+		case decision, ok := <-output:
+			if !ok {
+				done = true
+				break
+			}
+			if decision.State == nil || decision.Err != nil {
+				t.Fatalf("got %+v, want a State", decision)
+			}
+			got++
+			time.Sleep(time.Millisecond)
+		case <-timeout:
+			t.Fatalf("timed out after %d Decisions", got)
+		}
+	}
+	if want := numInputs + 1; got != want {
+		t.Errorf("got %d Decisions, want %d", got, want)
+	}
+
+	for _, n := range []int{0, 8} {
+		noInput := make(chan tetris.Piece)
+		close(noInput)
+		output := StartGame(holdingPolicy{}, combo4.LeftI, queue[0], queue[1:2], noInput, OutputBuffer(n))
+		if got := cap(output); got != n {
+			t.Errorf("got an output capacity of %d with OutputBuffer(%d)", got, n)
+		}
+		for range output {
+		}
+	}
+}
+
+func TestFirstMoveAdvice(t *testing.T) {
+	moves, _ := combo4.AllContinuousMoves()
+	nfa := combo4.NewNFA(moves)
+	pol := FromScorer(nfa, LoadNFAScorer(nfa, 7))
+
+	// Playing the S right away can only consume 2 more pieces while holding
+	// it can consume all of them.
+	hold, resulting, err := FirstMoveAdvice(pol, combo4.LeftI, tetris.S, tetris.SeqFromStr("ITJOLZ"))
+	if err != nil {
+		t.Fatalf("FirstMoveAdvice: %v", err)
+	}
+	if !hold {
+		t.Errorf("FirstMoveAdvice got hold=false, want true")
+	}
+	want := combo4.State{Field: combo4.LeftI, Hold: tetris.S, SwapRestricted: true}
+	if resulting != want {
+		t.Errorf("FirstMoveAdvice got resulting State %+v, want %+v", resulting, want)
+	}
+
+	if _, _, err := FirstMoveAdvice(pol, combo4.LeftI, tetris.S, tetris.SeqFromStr("S")); err == nil {
+		t.Errorf("FirstMoveAdvice got no error for a repeated piece")
+	}
+}
@@ -0,0 +1,46 @@
+// This packages generates a policy.OpeningBook object and saves it to a file.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"tetris/combo4"
+	"tetris/combo4/policy"
+	"time"
+)
+
+var (
+	bookFile = flag.String("book_file", "opening_book.gob", "The path to write the binary file of the OpeningBook")
+	noHold   = flag.Bool("no_hold", false, "If set to true, creates a book that never uses the hold")
+)
+
+func main() {
+	flag.Parse()
+	if err := run(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+// run creates the OpeningBook and writes it to the file set by the flags.
+func run() error {
+	start := time.Now()
+	moves, _ := combo4.AllContinuousMoves()
+	nfa := combo4.NewNFA(moves)
+	if *noHold {
+		nfa = combo4.NewNFANoHold(moves)
+	}
+	book := policy.NewOpeningBook(nfa, policy.LoadNFAScorer(nfa, 7), combo4.LeftI, combo4.RightI, combo4.LeftZ)
+	fmt.Printf("Created the book in %v\n", time.Since(start))
+
+	bytes, err := book.GobEncode()
+	if err != nil {
+		return fmt.Errorf("encode failed: %v", err)
+	}
+	if err := ioutil.WriteFile(*bookFile, bytes, 0644); err != nil {
+		return fmt.Errorf("WriteFile failed: %v", err)
+	}
+	return nil
+}
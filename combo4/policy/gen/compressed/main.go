@@ -14,41 +14,71 @@ import (
 var (
 	mdpFile    = flag.String("mdp_file", "mdp5.gob", "The path to a binary file of the MDP gob encoding")
 	policyFile = flag.String("policy_file", "mdp_policy5.gob", "The path to write the binary file of the MDPPolicy")
+	maxLoss    = flag.Float64("max_loss", 0, "Drop choices that lose at most this much expected value when using the default policy instead. 0 keeps every choice")
+	force      = flag.Bool("force", false, "If set to true, compresses the MDP even if its values have not converged")
+	tSpinBonus = flag.Float64("tspin_bonus", 0, "If set, checks that the MDP was trained with this bonus for each T-spin. Defaults to the bonus the MDP was trained with")
 )
 
 func main() {
 	flag.Parse()
+	if err := run(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
 
+// run reads the MDP and writes its compressed MDPPolicy to the files set by
+// the flags.
+func run() error {
 	start := time.Now()
-	bytes, err := ioutil.ReadFile(*mdpFile)
+	bytes, err := policy.ReadGobFile(*mdpFile)
 	if err != nil {
-		fmt.Printf("failed to read file at %q: %v\n", *mdpFile, err)
-		os.Exit(1)
+		// The error already has the path.
+		return err
 	}
-	mdp := &policy.MDP{}
-	if err := mdp.GobDecode(bytes); err != nil {
-		fmt.Printf("GobDecode failed: %v\n", err)
-		os.Exit(1)
+	mdp, err := policy.NewMDPFromGob(bytes, policy.MDPDecodeOptions{Reward: reward()})
+	if err != nil {
+		return fmt.Errorf("NewMDPFromGob failed: %v", err)
 	}
 	fmt.Printf("Got initial MDP in %v\n", time.Since(start))
+	if err := mdp.CheckConverged(); err != nil {
+		if !*force {
+			return fmt.Errorf("%v (update it with gen/mdp or use --force)", err)
+		}
+		fmt.Printf("Compressing anyway: %v\n", err)
+	}
 
 	// Release resouces
 	bytes = nil
 
-	policy := mdp.CompressedPolicy()
+	var pol *policy.MDPPolicy
+	if *maxLoss > 0 {
+		var report policy.CompressionReport
+		pol, report = mdp.CompressedPolicyWithBudget(*maxLoss)
+		fmt.Printf("Dropped %d choices with a max loss of %.4f\n", report.Dropped, report.MaxLoss)
+	} else {
+		pol = mdp.CompressedPolicy()
+	}
 
 	// Release resources.
 	mdp = nil
 
 	start = time.Now()
-	bytes, err = policy.GobEncode()
+	bytes, err = pol.GobEncode()
 	if err != nil {
-		fmt.Printf("encode failed: %v", err)
-		os.Exit(1)
+		return fmt.Errorf("encode failed: %v", err)
 	}
 	if err := ioutil.WriteFile(*policyFile, []byte(bytes), 0644); err != nil {
-		fmt.Printf("WriteFile failed: %v", err)
-		os.Exit(1)
+		return fmt.Errorf("WriteFile failed: %v", err)
 	}
 	log.Printf("Updated file in %v\n", time.Since(start))
+	return nil
+}
+
+// reward returns the Reward set by the flags.
+func reward() policy.Reward {
+	if *tSpinBonus == 0 {
+		return policy.Reward{}
+	}
+	return policy.TSpinReward(*tSpinBonus)
 }
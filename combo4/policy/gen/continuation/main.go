@@ -0,0 +1,55 @@
+// This packages generates a policy.ContinuationTable object and saves it to a
+// file for the ext6 policy of compare.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"tetris/combo4"
+	"tetris/combo4/policy"
+	"time"
+)
+
+var (
+	tableFile      = flag.String("table_file", "continuation_6preview.gob", "The path to write the binary file of the ContinuationTable")
+	previewSize    = flag.Int("preview_size", 6, "The number of pieces in the preview of the games. The NFAScorer considers permutations of this length")
+	numTrials      = flag.Int("num_trials", 200, "The number of games to play")
+	piecesPerTrial = flag.Int("pieces_per_trial", 2000, "The number of pieces in the queue of each game")
+	seed           = flag.Int64("seed", 2, "The seed of the queues. It should differ from the seed of the queues that are compared")
+)
+
+func main() {
+	flag.Parse()
+	if err := run(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+// run creates the ContinuationTable and writes it to the file set by the
+// flags.
+func run() error {
+	start := time.Now()
+	moves, _ := combo4.AllContinuousMoves()
+	nfa := combo4.NewNFA(moves)
+	scorer := policy.NewNFAScorer(nfa, *previewSize)
+	table := policy.NewContinuationTable(policy.FromScorer(nfa, scorer), scorer, policy.EvalOptions{
+		Trials:         *numTrials,
+		PiecesPerTrial: *piecesPerTrial,
+		PreviewSize:    *previewSize,
+		Rand:           rand.New(rand.NewSource(*seed)),
+	})
+	fmt.Printf("Created the table in %v\n", time.Since(start))
+
+	bytes, err := table.GobEncode()
+	if err != nil {
+		return fmt.Errorf("encode failed: %v", err)
+	}
+	if err := ioutil.WriteFile(*tableFile, bytes, 0644); err != nil {
+		return fmt.Errorf("WriteFile failed: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+// This packages writes the values and policy of a policy.MDP to a CSV file
+// and optionally a summary of the values for each State.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"tetris/combo4/policy"
+	"time"
+)
+
+var (
+	mdpFile    = flag.String("mdp_file", "mdp5.gob", "The path to a binary file of the MDP gob encoding")
+	csvFile    = flag.String("csv_file", "mdp5.csv", "The path to write the CSV file to")
+	sampleRate = flag.Float64("sample_rate", 0, "The fraction of GameStates to write. 0 writes every GameState")
+	seed       = flag.Int64("seed", 1, "The seed used to sample the GameStates")
+	summary    = flag.String("summary_file", "", "If set, the path to write a CSV file of the values of the GameStates summarized for each State to")
+	tSpinBonus = flag.Float64("tspin_bonus", 0, "If set, checks that the MDP was trained with this bonus for each T-spin. Defaults to the bonus the MDP was trained with")
+)
+
+func main() {
+	flag.Parse()
+	if err := run(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+// run reads the MDP and writes the CSV file set by the flags.
+func run() error {
+	start := time.Now()
+	bytes, err := policy.ReadGobFile(*mdpFile)
+	if err != nil {
+		// The error already has the path.
+		return err
+	}
+	mdp, err := policy.NewMDPFromGob(bytes, policy.MDPDecodeOptions{Reward: reward()})
+	if err != nil {
+		return fmt.Errorf("NewMDPFromGob failed: %v", err)
+	}
+	fmt.Printf("Got MDP in %v\n", time.Since(start))
+
+	// Release resources.
+	bytes = nil
+
+	file, err := os.Create(*csvFile)
+	if err != nil {
+		return fmt.Errorf("os.Create: %v", err)
+	}
+	opts := policy.CSVOptions{
+		SampleRate: *sampleRate,
+		Rand:       rand.New(rand.NewSource(*seed)),
+	}
+	if err := mdp.WriteCSV(file, opts); err != nil {
+		file.Close()
+		return fmt.Errorf("WriteCSV failed: %v", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("Close failed: %v", err)
+	}
+	fmt.Printf("Wrote %q in %v\n", *csvFile, time.Since(start))
+
+	if *summary == "" {
+		return nil
+	}
+	summaryFile, err := os.Create(*summary)
+	if err != nil {
+		return fmt.Errorf("os.Create: %v", err)
+	}
+	if err := policy.WriteStateSummaryCSV(summaryFile, mdp.StateSummary()); err != nil {
+		summaryFile.Close()
+		return fmt.Errorf("WriteStateSummaryCSV failed: %v", err)
+	}
+	if err := summaryFile.Close(); err != nil {
+		return fmt.Errorf("Close failed: %v", err)
+	}
+	fmt.Printf("Wrote %q in %v\n", *summary, time.Since(start))
+	return nil
+}
+
+// reward returns the Reward set by the flags.
+func reward() policy.Reward {
+	if *tSpinBonus == 0 {
+		return policy.Reward{}
+	}
+	return policy.TSpinReward(*tSpinBonus)
+}
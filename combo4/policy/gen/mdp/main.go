@@ -4,7 +4,8 @@ package main
 import (
 	"flag"
 	"fmt"
-	"io/ioutil"
+	"log"
+	"math/rand"
 	"os"
 	"tetris/combo4/policy"
 	"time"
@@ -15,44 +16,133 @@ var (
 	previewLen  = flag.Int("preview_len", 5, "The number of pieces in preview")
 	maxCombo    = flag.Int("max_combo", -1, "The maximum combo")
 	fromScratch = flag.Bool("from_scratch", false, "If set to true, does not read the MDP from file but creates a new one")
+	noHold      = flag.Bool("no_hold", false, "If set to true with --from_scratch, creates an MDP that never uses the hold")
+	openings    = flag.Bool("openings", false, "If set to true with --from_scratch, includes the states without a piece held and the swap restricted states")
+	concurrency = flag.Int("concurrency", 0, "The number of goroutines used to find the initial states with --from_scratch. Defaults to 8")
+	risk        = flag.Float64("risk_aversion", 0, "With --from_scratch, the weight of the standard deviation subtracted from the expected value of each choice")
+	tSpinBonus  = flag.Float64("tspin_bonus", 0, "If set, the bonus added to the value of each T-spin. When continuing to train an MDP from file, it must be the same and defaults to the bonus the MDP was trained with")
+	memoryless  = flag.Bool("memoryless", false, "If set to true with --from_scratch, trains for a memoryless randomizer instead of a 7 bag randomizer")
+	mode        = flag.String("training_mode", "", "How the MDP is trained: policy for policy iteration or value for value iteration. Defaults to policy with --from_scratch and otherwise to the mode the MDP was trained with")
+	verify      = flag.Bool("verify", false, "If set to true, checks the MDP read from file and after updating is consistent")
+	evalEvery   = flag.Duration("eval_every", 0, "If set, evaluates the policy being trained this often and logs the result")
+	maxSweeps   = flag.Int("max_sweeps", 0, "If set, stops training after this many sweeps of the values of a policy even if they have not converged")
 )
 
 func main() {
 	flag.Parse()
+	if err := run(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
 
+// run creates or reads the MDP set by the flags, updates it and saves it.
+func run() error {
 	start := time.Now()
-	mdp := getMDP()
+	mdp, err := getMDP()
+	if err != nil {
+		return err
+	}
 	fmt.Printf("Got initial MDP in %v\n", time.Since(start))
+	if *mode != "" {
+		trainingMode, err := policy.ParseTrainingMode(*mode)
+		if err != nil {
+			return err
+		}
+		if err := mdp.SetTrainingMode(trainingMode); err != nil {
+			return err
+		}
+	}
 
+	mdp.SetMaxSweeps(*maxSweeps)
+
+	if *evalEvery > 0 {
+		mdp.SetPublishSnapshots(true)
+		done := make(chan struct{})
+		defer close(done)
+		go evalPeriodically(mdp, *evalEvery, done)
+	}
 	if err := mdp.Update(*gobFile); err != nil {
-		fmt.Printf("Update failed: %v\n", err)
-		return
+		return fmt.Errorf("Update failed: %v", err)
+	}
+	if *verify {
+		if errs := mdp.Verify(); len(errs) > 0 {
+			for _, err := range errs {
+				fmt.Println(err)
+			}
+			return fmt.Errorf("the updated MDP has %d problems", len(errs))
+		}
+	}
+	if err := mdp.CheckConverged(); err != nil {
+		fmt.Println(err)
 	}
 	fmt.Printf("Completed in %v", time.Since(start))
+	return nil
 }
 
-func getMDP() *policy.MDP {
+func getMDP() (*policy.MDP, error) {
 	// Create a new MDP.
 	if *fromScratch {
-		mdp, err := policy.NewMDP(*previewLen)
+		mdp, err := policy.NewMDPWithOptions(*previewLen, policy.MDPOptions{NoHold: *noHold, Openings: *openings, Concurrency: *concurrency, RiskAversion: *risk, Reward: reward(), Model: model()})
 		if err != nil {
-			fmt.Printf("NewMDP failed: %v\n", err)
-			os.Exit(1)
+			return nil, fmt.Errorf("NewMDPWithOptions failed: %v", err)
 		}
-		return mdp
+		return mdp, nil
 	}
 
 	// Fetch the MDP from file.
-	bytes, err := ioutil.ReadFile(*gobFile)
+	bytes, err := policy.ReadGobFile(*gobFile)
 	if err != nil {
-		fmt.Printf("failed to read file at %q: %v\n", *gobFile, err)
-		fmt.Println("Maybe try using --from_scratch")
-		os.Exit(1)
+		// The error already has the path.
+		return nil, fmt.Errorf("%v (maybe try using --from_scratch)", err)
 	}
-	mdp := &policy.MDP{}
-	if err := mdp.GobDecode(bytes); err != nil {
-		fmt.Printf("GobDecode failed: %v\n", err)
-		os.Exit(1)
+	mdp, err := policy.NewMDPFromGob(bytes, policy.MDPDecodeOptions{Verify: *verify, Reward: reward()})
+	if err != nil {
+		return nil, fmt.Errorf("NewMDPFromGob failed: %v", err)
+	}
+	return mdp, nil
+}
+
+// evalPeriodically evaluates the last snapshot of the MDP every interval
+// until done is closed. Each evaluation plays the same queues so the results
+// can be compared.
+func evalPeriodically(mdp *policy.MDP, interval time.Duration, done chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		pol := mdp.SnapshotPolicy()
+		if pol == nil {
+			continue
+		}
+		start := time.Now()
+		result := policy.Evaluate(pol, policy.EvalOptions{
+			Trials:         100,
+			PiecesPerTrial: 1000,
+			PreviewSize:    pol.PreviewLen(),
+			Rand:           rand.New(rand.NewSource(1)),
+			Model:          pol.Model(),
+		})
+		log.Printf("Evaluated the snapshot policy with a mean of %.1f and median of %.1f pieces in %v", result.Mean, result.Median, time.Since(start))
+	}
+}
+
+// reward returns the Reward set by the flags.
+func reward() policy.Reward {
+	if *tSpinBonus == 0 {
+		return policy.Reward{}
+	}
+	return policy.TSpinReward(*tSpinBonus)
+}
+
+// model returns the NextPieceModel set by the flags.
+func model() policy.NextPieceModel {
+	if *memoryless {
+		return policy.Memoryless{}
 	}
-	return mdp
+	return policy.SevenBag{}
 }
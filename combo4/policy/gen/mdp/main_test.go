@@ -0,0 +1,20 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunMissingFile(t *testing.T) {
+	*gobFile = filepath.Join(t.TempDir(), "missing.gob")
+	*fromScratch = false
+
+	err := run()
+	if err == nil {
+		t.Fatalf("run() with a missing --mdp_file got no error")
+	}
+	if !strings.Contains(err.Error(), *gobFile) {
+		t.Errorf("run() got error %q, want it to mention %q", err, *gobFile)
+	}
+}
@@ -0,0 +1,65 @@
+// This packages generates a gzipped policy.NFAScorer gob encoding and saves
+// it to a file. The hex SHA-256 of the file is saved next to it with a
+// .sha256 suffix so the embedded NFAScorer can be verified.
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"tetris/combo4"
+	"tetris/combo4/policy"
+	"time"
+)
+
+var (
+	permLen = flag.Int("perm_len", 7, "The length of permutations considered by the NFAScorer")
+	outFile = flag.String("out", "nfa_scorer7.gob.gz", "The path to write the gzipped gob encoding to")
+)
+
+func main() {
+	flag.Parse()
+	if err := run(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+// run creates the NFAScorer and writes it to the file set by the flags.
+func run() error {
+	start := time.Now()
+	moves, _ := combo4.AllContinuousMoves()
+	scorer := policy.NewNFAScorer(combo4.NewNFA(moves), *permLen)
+	fmt.Printf("Created NFAScorer in %v\n", time.Since(start))
+
+	encoded, err := scorer.GobEncode()
+	if err != nil {
+		return fmt.Errorf("GobEncode failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	gz, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return fmt.Errorf("gzip.NewWriterLevel: %v", err)
+	}
+	if _, err := gz.Write(encoded); err != nil {
+		return fmt.Errorf("write failed: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("gzip Close failed: %v", err)
+	}
+
+	if err := ioutil.WriteFile(*outFile, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("writing %s: %v", *outFile, err)
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	if err := ioutil.WriteFile(*outFile+".sha256", []byte(hex.EncodeToString(sum[:])), 0644); err != nil {
+		return fmt.Errorf("writing the checksum: %v", err)
+	}
+	return nil
+}
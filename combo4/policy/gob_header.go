@@ -0,0 +1,196 @@
+package policy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"sort"
+	"sync"
+	"tetris/combo4"
+)
+
+// gobMagic starts the encodings of MDPs and MDPPolicies. A gob stream never
+// starts with a 0 byte so encodings without it are from before the header
+// was added.
+var gobMagic = []byte("\x00tetris4w")
+
+// gobVersion is the format version written in the gobHeader. It is
+// increased when the encoding after the header changes in a way that older
+// code cannot decode and GobDecode decodes the fields of each version.
+//
+// Version 2 encodes the rest with a new gob stream whose length and checksum
+// are in the header. Version 1 was never released and is not decoded.
+const gobVersion = 2
+
+// The kinds of encodings with a gobHeader.
+const (
+	kindMDP       = "MDP"
+	kindMDPPolicy = "MDPPolicy"
+)
+
+// ErrGobHeader is returned when decoding an encoding whose header does not
+// match what is being decoded.
+var ErrGobHeader = errors.New("mismatched gob header")
+
+// ErrWrongKind is an ErrGobHeader returned when decoding a different type
+// than was encoded e.g. an MDP as an MDPPolicy.
+var ErrWrongKind = fmt.Errorf("%w: wrong kind", ErrGobHeader)
+
+// ErrTruncated is returned when an encoding ends before all of it is
+// decoded e.g. from a partial download.
+var ErrTruncated = errors.New("truncated encoding")
+
+// ErrChecksum is returned when an encoding does not match its checksum.
+var ErrChecksum = errors.New("checksum mismatch")
+
+// gobHeader is encoded after gobMagic and before the rest of an encoding.
+type gobHeader struct {
+	Version int
+	// The type that was encoded e.g. kindMDP.
+	Kind       string
+	PreviewLen int
+	// The movesHash of the Moves that the encoding was trained with.
+	MovesHash uint64
+	// The number of bytes and the SHA-256 of the rest of the encoding from
+	// version 2.
+	Len      int
+	Checksum []byte
+	// The last sweep of the values of an MDP. It is nil for MDPPolicies and
+	// encodings from before it was added.
+	Convergence *SweepStats
+}
+
+// newGobHeader returns the gobHeader for an encoding of the kind with this
+// version.
+func newGobHeader(kind string, previewLen int) gobHeader {
+	return gobHeader{
+		Version:    gobVersion,
+		Kind:       kind,
+		PreviewLen: previewLen,
+		MovesHash:  movesHash(),
+	}
+}
+
+// gobWriter encodes the rest of an encoding with a gobHeader. The header is
+// written in front of it by Bytes.
+type gobWriter struct {
+	*gob.Encoder
+	header gobHeader
+	rest   bytes.Buffer
+}
+
+// newGobWriter returns a gobWriter for an encoding of the kind.
+func newGobWriter(kind string, previewLen int) *gobWriter {
+	w := &gobWriter{header: newGobHeader(kind, previewLen)}
+	w.Encoder = gob.NewEncoder(&w.rest)
+	return w
+}
+
+// Bytes returns gobMagic followed by the header and the rest of the
+// encoding.
+func (w *gobWriter) Bytes() ([]byte, error) {
+	sum := sha256.Sum256(w.rest.Bytes())
+	w.header.Len = w.rest.Len()
+	w.header.Checksum = sum[:]
+
+	buf := bytes.NewBuffer(append([]byte(nil), gobMagic...))
+	if err := gob.NewEncoder(buf).Encode(&w.header); err != nil {
+		return nil, fmt.Errorf("encoder.Encode(header): %v", err)
+	}
+	buf.Write(w.rest.Bytes()) // Always returns nil.
+	return buf.Bytes(), nil
+}
+
+// decodeGobHeader returns a decoder for the encoding after its header and the
+// header or nil if the encoding is from before the header was added. It
+// returns an ErrGobHeader if the header is for a different kind, a newer
+// version or different Moves, an ErrTruncated if the encoding is shorter
+// than in its header and an ErrChecksum if the rest does not match the
+// checksum in its header.
+func decodeGobHeader(b []byte, kind string) (*gob.Decoder, *gobHeader, error) {
+	if !bytes.HasPrefix(b, gobMagic) {
+		return gob.NewDecoder(bytes.NewReader(b)), nil, nil
+	}
+	// A bytes.Reader is not buffered by the decoder so it is left at the end
+	// of the header.
+	r := bytes.NewReader(b[len(gobMagic):])
+	decoder := gob.NewDecoder(r)
+	header := new(gobHeader)
+	if err := decoder.Decode(header); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, nil, fmt.Errorf("%w: the header ends early", ErrTruncated)
+		}
+		return nil, nil, fmt.Errorf("decoder.Decode(header): %v", err)
+	}
+	switch {
+	case header.Kind != kind:
+		return nil, nil, fmt.Errorf("%w: the file is an %s, not an %s", ErrWrongKind, header.Kind, kind)
+	case header.Version > gobVersion:
+		return nil, nil, fmt.Errorf("%w: the %s has format version %d but only versions up to %d can be decoded", ErrGobHeader, kind, header.Version, gobVersion)
+	case header.Version < 2:
+		return nil, nil, fmt.Errorf("%w: the %s has format version %d which was never released", ErrGobHeader, kind, header.Version)
+	case header.MovesHash != movesHash():
+		return nil, nil, fmt.Errorf("%w: the %s was trained with different Moves than combo4.AllContinuousMoves", ErrGobHeader, kind)
+	}
+
+	rest := b[len(b)-r.Len():]
+	if len(rest) < header.Len {
+		return nil, nil, fmt.Errorf("%w: the %s has %d of its %d bytes", ErrTruncated, kind, len(rest), header.Len)
+	}
+	rest = rest[:header.Len]
+	if sum := sha256.Sum256(rest); !bytes.Equal(sum[:], header.Checksum) {
+		return nil, nil, fmt.Errorf("%w: the %s is corrupted", ErrChecksum, kind)
+	}
+	return gob.NewDecoder(bytes.NewReader(rest)), header, nil
+}
+
+// version returns the format version of the encoding or 0 if it is from
+// before the header was added.
+func (h *gobHeader) version() int {
+	if h == nil {
+		return 0
+	}
+	return h.Version
+}
+
+// checkPreviewLen returns an ErrGobHeader if the header has a different
+// preview length than the decoded one. It returns nil without a header.
+func (h *gobHeader) checkPreviewLen(previewLen int) error {
+	if h == nil || h.PreviewLen == previewLen {
+		return nil
+	}
+	return fmt.Errorf("%w: the %s has previewLen=%d in its header but %d in its contents", ErrGobHeader, h.Kind, h.PreviewLen, previewLen)
+}
+
+var (
+	movesHashOnce sync.Once
+	movesHashVal  uint64
+)
+
+// movesHash returns a hash of combo4.AllContinuousMoves which the NFAs of
+// MDPs are made from. It does not depend on the order of the Moves.
+func movesHash() uint64 {
+	movesHashOnce.Do(func() {
+		moves, _ := combo4.AllContinuousMoves()
+		sort.Slice(moves, func(i, j int) bool {
+			a, b := moves[i], moves[j]
+			if a.Start != b.Start {
+				return a.Start < b.Start
+			}
+			if a.End != b.End {
+				return a.End < b.End
+			}
+			return a.Piece < b.Piece
+		})
+		h := fnv.New64a()
+		for _, m := range moves {
+			h.Write([]byte{byte(m.Start >> 8), byte(m.Start), byte(m.End >> 8), byte(m.End), byte(m.Piece)})
+		}
+		movesHashVal = h.Sum64()
+	})
+	return movesHashVal
+}
@@ -0,0 +1,164 @@
+package policy
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"strings"
+	"testing"
+	"tetris"
+	"tetris/combo4"
+)
+
+func TestGobHeader(t *testing.T) {
+	t.Parallel()
+
+	mdp, err := NewMDP(0)
+	if err != nil {
+		t.Fatalf("NewMDP: %v", err)
+	}
+	mdpBytes, err := mdp.GobEncode()
+	if err != nil {
+		t.Fatalf("MDP.GobEncode: %v", err)
+	}
+	polBytes, err := mdp.Policy().(*MDPPolicy).GobEncode()
+	if err != nil {
+		t.Fatalf("MDPPolicy.GobEncode: %v", err)
+	}
+	// withHeader returns an encoding of an MDP with the header changed by
+	// modify and only its previewLen of 0 after it.
+	withHeader := func(modify func(h *gobHeader)) []byte {
+		w := newGobWriter(kindMDP, 0)
+		modify(&w.header)
+		var previewLen int
+		if err := w.Encode(&previewLen); err != nil {
+			t.Fatalf("Encode(previewLen): %v", err)
+		}
+		b, err := w.Bytes()
+		if err != nil {
+			t.Fatalf("Bytes: %v", err)
+		}
+		return b
+	}
+
+	tests := []struct {
+		desc    string
+		decode  func([]byte) error
+		b       []byte
+		wantErr string
+	}{
+		{
+			desc:    "MDP as MDPPolicy",
+			decode:  new(MDPPolicy).GobDecode,
+			b:       mdpBytes,
+			wantErr: "the file is an MDP, not an MDPPolicy",
+		},
+		{
+			desc:    "MDPPolicy as MDP",
+			decode:  new(MDP).GobDecode,
+			b:       polBytes,
+			wantErr: "the file is an MDPPolicy, not an MDP",
+		},
+		{
+			desc:    "newer version",
+			decode:  new(MDP).GobDecode,
+			b:       withHeader(func(h *gobHeader) { h.Version = gobVersion + 1 }),
+			wantErr: "only versions up to",
+		},
+		{
+			desc:    "older version",
+			decode:  new(MDP).GobDecode,
+			b:       withHeader(func(h *gobHeader) { h.Version = 1 }),
+			wantErr: "format version 1",
+		},
+		{
+			desc:    "different Moves",
+			decode:  new(MDP).GobDecode,
+			b:       withHeader(func(h *gobHeader) { h.MovesHash++ }),
+			wantErr: "trained with different Moves",
+		},
+		{
+			desc:    "previewLen",
+			decode:  new(MDP).GobDecode,
+			b:       withHeader(func(h *gobHeader) { h.PreviewLen = 5 }),
+			wantErr: "previewLen=5 in its header but 0 in its contents",
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+			err := test.decode(test.b)
+			if !errors.Is(err, ErrGobHeader) {
+				t.Fatalf("GobDecode got %v, want an ErrGobHeader", err)
+			}
+			if !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("GobDecode got %q, want it to contain %q", err, test.wantErr)
+			}
+		})
+	}
+
+	t.Run("current", func(t *testing.T) {
+		t.Parallel()
+		if err := new(MDP).GobDecode(mdpBytes); err != nil {
+			t.Errorf("MDP.GobDecode: %v", err)
+		}
+		pol := new(MDPPolicy)
+		if err := pol.GobDecode(polBytes); err != nil {
+			t.Errorf("MDPPolicy.GobDecode: %v", err)
+		}
+		if got := pol.PreviewLen(); got != 0 {
+			t.Errorf("PreviewLen() got %d, want 0", got)
+		}
+	})
+}
+
+func TestGobHeaderless(t *testing.T) {
+	t.Parallel()
+
+	gState := GameState{
+		State:   combo4.State{Field: combo4.LeftI, Hold: tetris.I},
+		Current: tetris.T,
+		Preview: tetris.MustSeq([]tetris.Piece{tetris.O}),
+		BagUsed: tetris.NewPieceSet(tetris.T, tetris.O),
+	}
+
+	// The first encodings of an MDP only had the previewLen and the values.
+	buf := new(bytes.Buffer)
+	encoder := gob.NewEncoder(buf)
+	previewLen := 1
+	values := map[GameState]float64{gState: 2}
+	if err := encoder.Encode(&previewLen); err != nil {
+		t.Fatalf("Encode(previewLen): %v", err)
+	}
+	if err := encoder.Encode(&values); err != nil {
+		t.Fatalf("Encode(values): %v", err)
+	}
+	mdp := new(MDP)
+	if err := mdp.GobDecode(buf.Bytes()); err != nil {
+		t.Fatalf("MDP.GobDecode without a header: %v", err)
+	}
+	if mdp.previewLen != 1 || mdp.value[gState] != 2 {
+		t.Errorf("got previewLen=%d and value %v, want 1 and 2", mdp.previewLen, mdp.value[gState])
+	}
+
+	// The first encodings of an MDPPolicy only had the policy and whether
+	// it is compressed.
+	buf.Reset()
+	encoder = gob.NewEncoder(buf)
+	policy := map[GameState]combo4.State{gState: {Field: combo4.LeftI, Hold: tetris.I}}
+	compressed := false
+	if err := encoder.Encode(&policy); err != nil {
+		t.Fatalf("Encode(policy): %v", err)
+	}
+	if err := encoder.Encode(&compressed); err != nil {
+		t.Fatalf("Encode(compressed): %v", err)
+	}
+	pol := new(MDPPolicy)
+	if err := pol.GobDecode(buf.Bytes()); err != nil {
+		t.Fatalf("MDPPolicy.GobDecode without a header: %v", err)
+	}
+	if got := pol.PreviewLen(); got != 1 {
+		t.Errorf("PreviewLen() got %d, want 1", got)
+	}
+}
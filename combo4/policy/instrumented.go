@@ -0,0 +1,131 @@
+package policy
+
+import (
+	"math"
+	"sync"
+	"tetris"
+	"tetris/combo4"
+	"time"
+)
+
+// LatencyStats summarizes the latency of NextState calls. The percentiles are
+// approximate and within 10% of the true values.
+type LatencyStats struct {
+	Count int
+	P50   time.Duration
+	P95   time.Duration
+	Max   time.Duration
+}
+
+// InstrumentedPolicy records the latency of each NextState call of a Policy.
+//
+// InstrumentedPolicy is safe for concurrent use if the wrapped Policy is.
+type InstrumentedPolicy struct {
+	pol Policy
+
+	mu      sync.Mutex
+	buckets map[int]int
+	count   int
+	max     time.Duration
+}
+
+// Instrumented wraps a Policy to record its latencies.
+func Instrumented(pol Policy) *InstrumentedPolicy {
+	return &InstrumentedPolicy{
+		pol:     pol,
+		buckets: make(map[int]int),
+	}
+}
+
+// NextState calls NextState of the wrapped Policy and records the latency.
+func (p *InstrumentedPolicy) NextState(initial combo4.State, current tetris.Piece, preview []tetris.Piece, endBagUsed tetris.PieceSet) *combo4.State {
+	start := time.Now()
+	next := p.pol.NextState(initial, current, preview, endBagUsed)
+	p.add(time.Since(start))
+	return next
+}
+
+// nextStateWithCandidates lets a Recorder wrapping the InstrumentedPolicy
+// record the candidates of the wrapped Policy.
+func (p *InstrumentedPolicy) nextStateWithCandidates(initial combo4.State, current tetris.Piece, preview []tetris.Piece, endBagUsed tetris.PieceSet) (*combo4.State, []CandidateScore) {
+	scorer, ok := p.pol.(candidateScorer)
+	if !ok {
+		return p.NextState(initial, current, preview, endBagUsed), nil
+	}
+	start := time.Now()
+	next, candidates := scorer.nextStateWithCandidates(initial, current, preview, endBagUsed)
+	p.add(time.Since(start))
+	return next, candidates
+}
+
+// add records a latency.
+func (p *InstrumentedPolicy) add(elapsed time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.buckets[latencyBucket(elapsed)]++
+	p.count++
+	if elapsed > p.max {
+		p.max = elapsed
+	}
+}
+
+// Stats returns the statistics of the latencies recorded since the last
+// Reset.
+func (p *InstrumentedPolicy) Stats() LatencyStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return LatencyStats{
+		Count: p.count,
+		P50:   p.percentile(0.50),
+		P95:   p.percentile(0.95),
+		Max:   p.max,
+	}
+}
+
+// Reset removes all the recorded latencies.
+func (p *InstrumentedPolicy) Reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.buckets = make(map[int]int)
+	p.count = 0
+	p.max = 0
+}
+
+// percentile returns the upper bound of the bucket containing the
+// percentile. percentile assumes the lock is held.
+func (p *InstrumentedPolicy) percentile(fraction float64) time.Duration {
+	if p.count == 0 {
+		return 0
+	}
+	// Visit the buckets in order.
+	var (
+		target = int(math.Ceil(fraction * float64(p.count)))
+		seen   int
+	)
+	for bucket := 0; ; bucket++ {
+		seen += p.buckets[bucket]
+		if seen >= target {
+			if upper := bucketUpperBound(bucket); upper < p.max {
+				return upper
+			}
+			return p.max
+		}
+	}
+}
+
+// Each doubling of the latency is split into this many buckets so each
+// bucket spans less than 10% of its values.
+const bucketsPerDoubling = 8
+
+// latencyBucket returns the histogram bucket of a latency.
+func latencyBucket(d time.Duration) int {
+	if d <= 1 {
+		return 0
+	}
+	return int(math.Log2(float64(d)) * bucketsPerDoubling)
+}
+
+// bucketUpperBound returns the largest latency in a bucket.
+func bucketUpperBound(bucket int) time.Duration {
+	return time.Duration(math.Exp2(float64(bucket+1) / bucketsPerDoubling))
+}
@@ -0,0 +1,60 @@
+package policy
+
+import (
+	"testing"
+	"tetris"
+	"tetris/combo4"
+	"time"
+)
+
+// sleepPolicy sleeps for each of its delays in turn and never has a next
+// state.
+type sleepPolicy struct {
+	delays []time.Duration
+}
+
+func (p *sleepPolicy) NextState(initial combo4.State, current tetris.Piece, preview []tetris.Piece, endBagUsed tetris.PieceSet) *combo4.State {
+	time.Sleep(p.delays[0])
+	p.delays = p.delays[1:]
+	return nil
+}
+
+func TestInstrumented(t *testing.T) {
+	delays := make([]time.Duration, 20)
+	for i := range delays {
+		delays[i] = time.Millisecond
+	}
+	delays[7] = 30 * time.Millisecond
+	pol := Instrumented(&sleepPolicy{delays: delays})
+
+	for range delays {
+		pol.NextState(combo4.State{Field: combo4.LeftI}, tetris.T, nil, 0)
+	}
+	stats := pol.Stats()
+	if stats.Count != 20 {
+		t.Errorf("got Count=%d, want 20", stats.Count)
+	}
+	if stats.P50 < time.Millisecond || stats.P50 >= 10*time.Millisecond {
+		t.Errorf("got P50=%v, want about 1ms", stats.P50)
+	}
+	if stats.P95 < stats.P50 || stats.P95 >= 10*time.Millisecond {
+		t.Errorf("got P95=%v, want about 1ms", stats.P95)
+	}
+	if stats.Max < 30*time.Millisecond {
+		t.Errorf("got Max=%v, want at least 30ms", stats.Max)
+	}
+
+	pol.Reset()
+	if stats := pol.Stats(); stats != (LatencyStats{}) {
+		t.Errorf("got %+v after Reset, want no stats", stats)
+	}
+}
+
+func TestLatencyBucket(t *testing.T) {
+	for _, d := range []time.Duration{1, 2, 999, time.Microsecond, 1234567, time.Second} {
+		upper := bucketUpperBound(latencyBucket(d))
+		if upper < d || float64(upper) > 1.1*float64(d)+1 {
+			t.Errorf("got bucket upper bound %v for %v, want within 10%%", upper, d)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package policy
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// gzipMagic starts every gzip file.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// ReadGobFile returns the contents of a file of an MDP or MDPPolicy gob
+// encoding which may be gzipped. It returns an ErrTruncated or ErrChecksum
+// if the gzip stream is incomplete or corrupted.
+func ReadGobFile(path string) ([]byte, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(b, gzipMagic) {
+		return b, nil
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, withHint(path, fileError(err))
+	}
+	defer gz.Close()
+	b, err = ioutil.ReadAll(gz)
+	if err != nil {
+		return nil, withHint(path, fileError(err))
+	}
+	return b, nil
+}
+
+// LoadFile reads an MDPPolicy from a file written by gen/mdp or
+// gen/compressed. The file may be gzipped and either an MDP or an MDPPolicy.
+// The MDPPolicy is configured by the options.
+//
+// It returns an ErrTruncated if the file is incomplete, an ErrChecksum if it
+// is corrupted, an ErrWrongKind if it is not an MDP or MDPPolicy and an
+// ErrRewardMismatch if it does not have the Reward of WithReward. An MDP
+// whose values have not converged returns an ErrNotConverged. The errors say
+// how to fix them.
+func LoadFile(path string, opts ...MDPPolicyOption) (*MDPPolicy, error) {
+	b, err := ReadGobFile(path)
+	if err != nil {
+		return nil, err
+	}
+	switch kind := gobKind(b); kind {
+	case kindMDP:
+		m, err := NewMDPFromGob(b, MDPDecodeOptions{})
+		if err != nil {
+			return nil, withHint(path, fileError(err))
+		}
+		if err := m.CheckConverged(); err != nil {
+			return nil, withHint(path, err)
+		}
+		pol := m.policyFrom(m.policy)
+		if err := pol.applyOptions(opts); err != nil {
+			return nil, withHint(path, err)
+		}
+		return pol, nil
+	case kindMDPPolicy, "":
+		pol, err := NewMDPPolicyFromGob(b, opts...)
+		if err == nil {
+			return pol, nil
+		}
+		if !bytes.HasPrefix(b, gobMagic) && !errors.Is(err, ErrRewardMismatch) {
+			// Encodings from before the header cannot be told apart from
+			// other files or checked for truncation.
+			err = fmt.Errorf("%w: not an MDPPolicy encoding or a truncated one from before the header: %v", ErrWrongKind, err)
+		}
+		return nil, withHint(path, fileError(err))
+	default:
+		return nil, withHint(path, fmt.Errorf("%w: the file is an %s, not a policy", ErrWrongKind, kind))
+	}
+}
+
+// gobKind returns the kind in the gobHeader of an encoding or "" if it does
+// not have one or it cannot be decoded.
+func gobKind(b []byte) string {
+	if !bytes.HasPrefix(b, gobMagic) {
+		return ""
+	}
+	var header gobHeader
+	if err := gob.NewDecoder(bytes.NewReader(b[len(gobMagic):])).Decode(&header); err != nil {
+		return ""
+	}
+	return header.Kind
+}
+
+// fileError returns the error as an ErrTruncated or ErrChecksum if it is
+// from reading an incomplete or corrupted encoding.
+func fileError(err error) error {
+	switch {
+	case errors.Is(err, ErrTruncated), errors.Is(err, ErrChecksum):
+		return err
+	case errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, io.EOF):
+		return fmt.Errorf("%w: %v", ErrTruncated, err)
+	case errors.Is(err, gzip.ErrChecksum), errors.Is(err, gzip.ErrHeader), errors.As(err, new(flate.CorruptInputError)):
+		return fmt.Errorf("%w: %v", ErrChecksum, err)
+	}
+	return err
+}
+
+// withHint adds the path and how to fix the error to it.
+func withHint(path string, err error) error {
+	var hint string
+	switch {
+	case errors.Is(err, ErrTruncated):
+		hint = "the file is incomplete, download or generate it again"
+	case errors.Is(err, ErrChecksum):
+		hint = "the file is corrupted, download or generate it again"
+	case errors.Is(err, ErrWrongKind):
+		hint = "use a file written by gen/mdp or gen/compressed"
+	case errors.Is(err, ErrGobHeader):
+		hint = "generate the file again with this version"
+	case errors.Is(err, ErrRewardMismatch):
+		hint = "use a file trained with the same Reward"
+	case errors.Is(err, ErrNotConverged):
+		hint = "train it more with gen/mdp or compress it with gen/compressed --force"
+	default:
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return fmt.Errorf("%s: %w (%s)", path, err, hint)
+}
@@ -0,0 +1,95 @@
+package policy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadFile(t *testing.T) {
+	t.Parallel()
+
+	mdp, err := NewMDP(1)
+	if err != nil {
+		t.Fatalf("NewMDP: %v", err)
+	}
+	unconvergedBytes, err := mdp.GobEncode()
+	if err != nil {
+		t.Fatalf("MDP.GobEncode: %v", err)
+	}
+	// Training takes too long so the values are only marked as converged.
+	mdp.convergence = ConvergenceInfo{}
+	mdpBytes, err := mdp.GobEncode()
+	if err != nil {
+		t.Fatalf("MDP.GobEncode: %v", err)
+	}
+	polBytes, err := mdp.Policy().(*MDPPolicy).GobEncode()
+	if err != nil {
+		t.Fatalf("MDPPolicy.GobEncode: %v", err)
+	}
+	scorerBytes, err := NewNFAScorer(mdp.nfa, 1).GobEncode()
+	if err != nil {
+		t.Fatalf("NFAScorer.GobEncode: %v", err)
+	}
+	gzipped := func(b []byte) []byte {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		gz.Write(b)
+		if err := gz.Close(); err != nil {
+			t.Fatalf("gzip Close: %v", err)
+		}
+		return buf.Bytes()
+	}
+	corrupted := func(b []byte, idx int) []byte {
+		b = append([]byte(nil), b...)
+		b[idx] ^= 0xff
+		return b
+	}
+	tests := []struct {
+		desc    string
+		b       []byte
+		wantErr error
+	}{
+		{desc: "MDPPolicy", b: polBytes},
+		{desc: "gzipped MDPPolicy", b: gzipped(polBytes)},
+		{desc: "gzipped MDP", b: gzipped(mdpBytes)},
+		{desc: "unconverged MDP", b: unconvergedBytes, wantErr: ErrNotConverged},
+		{desc: "truncated", b: polBytes[:len(polBytes)-10], wantErr: ErrTruncated},
+		{desc: "truncated header", b: polBytes[:len(gobMagic)+10], wantErr: ErrTruncated},
+		{desc: "truncated gzip", b: gzipped(polBytes)[:100], wantErr: ErrTruncated},
+		{desc: "corrupted", b: corrupted(polBytes, len(polBytes)-10), wantErr: ErrChecksum},
+		{desc: "corrupted gzip", b: corrupted(gzipped(polBytes), 50), wantErr: ErrChecksum},
+		{desc: "NFAScorer", b: gzipped(scorerBytes), wantErr: ErrWrongKind},
+		{desc: "text", b: []byte("not a policy"), wantErr: ErrWrongKind},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+			path := filepath.Join(t.TempDir(), "policy.gob")
+			if err := ioutil.WriteFile(path, test.b, 0644); err != nil {
+				t.Fatalf("WriteFile: %v", err)
+			}
+			pol, err := LoadFile(path)
+			if test.wantErr == nil {
+				if err != nil {
+					t.Fatalf("LoadFile: %v", err)
+				}
+				if pol == nil {
+					t.Errorf("LoadFile got a nil *MDPPolicy")
+				}
+				return
+			}
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("LoadFile got %v, want %v", err, test.wantErr)
+			}
+			if !strings.Contains(err.Error(), path) || !strings.Contains(err.Error(), "(") {
+				t.Errorf("LoadFile got %q, want the path and a hint", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package policy
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"tetris"
+	"tetris/combo4"
+	"time"
+)
+
+// MCTSOptions configures NewMCTSPolicy.
+type MCTSOptions struct {
+	// The number of simulations for each decision. Defaults to 100.
+	Simulations int
+	// If non-zero, stops simulating once the time limit for a decision is
+	// reached.
+	TimeLimit time.Duration
+	// The number of sampled pieces after the preview in each simulation.
+	// Defaults to 14.
+	Horizon int
+	// The source of randomness. The decisions are deterministic for a seeded
+	// Rand as long as NextState is not called concurrently. If nil, a Rand
+	// seeded with the current time is used.
+	Rand *rand.Rand
+}
+
+// mctsPolicy picks the next state with a Monte Carlo tree search. The search
+// tree only branches at the root. Each simulation samples pieces after the
+// preview using the 7 bag randomizer and scores a choice by how many of the
+// sampled pieces the NFA can consume from it.
+type mctsPolicy struct {
+	nfa  *combo4.NFA
+	opts MCTSOptions
+
+	mu  sync.Mutex // Guards rng.
+	rng *rand.Rand
+}
+
+// NewMCTSPolicy creates a new Policy based on a Monte Carlo tree search.
+// Unlike an MDP it can be used with any preview length.
+func NewMCTSPolicy(nfa *combo4.NFA, opts MCTSOptions) Policy {
+	if opts.Simulations <= 0 {
+		opts.Simulations = 100
+	}
+	if opts.Horizon <= 0 {
+		opts.Horizon = 14
+	}
+	rng := opts.Rand
+	if rng == nil {
+		rng = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	return &mctsPolicy{nfa: nfa, opts: opts, rng: rng}
+}
+
+// explorationConst is the exploration constant of UCB1.
+const explorationConst = math.Sqrt2
+
+// NextState returns the choice with the best average survival over the
+// simulations or nil if there are no possible moves.
+func (p *mctsPolicy) NextState(initial combo4.State, current tetris.Piece, preview []tetris.Piece, endBagUsed tetris.PieceSet) *combo4.State {
+	choices := p.nfa.NextStates(initial, current)
+	switch len(choices) {
+	case 0:
+		return nil
+	case 1:
+		return &choices[0]
+	}
+
+	var (
+		totals = make([]float64, len(choices))
+		visits = make([]int, len(choices))
+		start  = time.Now()
+		queue  = make([]tetris.Piece, len(preview)+p.opts.Horizon)
+	)
+	for sim := 0; sim < p.opts.Simulations; sim++ {
+		if p.opts.TimeLimit > 0 && time.Since(start) > p.opts.TimeLimit {
+			break
+		}
+
+		// Pick the choice to simulate with UCB1. Unvisited choices are
+		// simulated first.
+		idx := sim
+		if sim >= len(choices) {
+			bestUCB := math.Inf(-1)
+			for i := range choices {
+				ucb := totals[i]/float64(visits[i]) + explorationConst*math.Sqrt(math.Log(float64(sim))/float64(visits[i]))
+				if ucb > bestUCB {
+					bestUCB = ucb
+					idx = i
+				}
+			}
+		}
+
+		p.sampleQueue(queue, preview, endBagUsed)
+		_, consumed := p.nfa.EndStates(combo4.NewStateSet(choices[idx]), queue)
+		totals[idx] += float64(consumed) / float64(len(queue))
+		visits[idx]++
+	}
+
+	best := 0
+	bestMean := math.Inf(-1)
+	for i := range choices {
+		if visits[i] == 0 {
+			continue
+		}
+		if mean := totals[i] / float64(visits[i]); mean > bestMean {
+			bestMean = mean
+			best = i
+		}
+	}
+	return &choices[best]
+}
+
+// sampleQueue fills the queue with the preview followed by random pieces
+// that follow the 7 bag randomizer.
+func (p *mctsPolicy) sampleQueue(queue []tetris.Piece, preview []tetris.Piece, bagUsed tetris.PieceSet) {
+	copy(queue, preview)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i := len(preview); i < len(queue); i++ {
+		if bagUsed.IsFullBag() {
+			bagUsed = 0
+		}
+		remaining := bagUsed.Inverted().Slice()
+		piece := remaining[p.rng.Intn(len(remaining))]
+		bagUsed = bagUsed.Add(piece)
+		queue[i] = piece
+	}
+}
@@ -0,0 +1,59 @@
+package policy
+
+import (
+	"math/rand"
+	"testing"
+	"tetris"
+	"tetris/combo4"
+)
+
+func TestMCTSSucessRate(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping slow MCTS evaluation in short mode")
+	}
+	moves, _ := combo4.AllContinuousMoves()
+	nfa := combo4.NewNFA(moves)
+
+	evalOpts := func() EvalOptions {
+		return EvalOptions{
+			Trials:         100,
+			PiecesPerTrial: 93,
+			PreviewSize:    6,
+			Rand:           rand.New(rand.NewSource(110)),
+			// Keep the MCTS deterministic.
+			Concurrency: 1,
+		}
+	}
+	want := Evaluate(FromScorer(nfa, NewNFAScorer(nfa, 3)), evalOpts()).WinRate
+
+	mcts := NewMCTSPolicy(nfa, MCTSOptions{
+		Simulations: 50,
+		Rand:        rand.New(rand.NewSource(1)),
+	})
+	if got := Evaluate(mcts, evalOpts()).WinRate; got < want {
+		t.Errorf("MCTS has win rate=%.2f, want at least the Seq 3 win rate %.2f", got, want)
+	}
+}
+
+func TestMCTSDeterministic(t *testing.T) {
+	moves, _ := combo4.AllContinuousMoves()
+	nfa := combo4.NewNFA(moves)
+
+	newPolicy := func() Policy {
+		return NewMCTSPolicy(nfa, MCTSOptions{Simulations: 20, Rand: rand.New(rand.NewSource(3))})
+	}
+	pol1, pol2 := newPolicy(), newPolicy()
+	r := rand.New(rand.NewSource(5))
+	states := nfa.States().Slice()
+	for i := 0; i < 50; i++ {
+		state := states[r.Intn(len(states))]
+		queue := tetris.RandPiecesFrom(r, 4)
+		bag, _ := BagUsedAfter(queue)
+
+		got1 := pol1.NextState(state, queue[0], queue[1:], bag)
+		got2 := pol2.NextState(state, queue[0], queue[1:], bag)
+		if (got1 == nil) != (got2 == nil) || (got1 != nil && *got1 != *got2) {
+			t.Fatalf("NextState(%v, %v, %v) got %v and %v with the same seed", state, queue[0], queue[1:], got1, got2)
+		}
+	}
+}
@@ -1,14 +1,16 @@
 package policy
 
 import (
-	"bytes"
-	"encoding/gob"
+	"container/heap"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"math"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"tetris"
 	"tetris/combo4"
 	"time"
@@ -21,10 +23,23 @@ const concurrency = 8
 // that are considered "stable". That is, states with a piece held and are not
 // swap restricted.
 //
-// MDP is *NOT* safe for concurrent use.
+// MDP is *NOT* safe for concurrent use except for SnapshotPolicy and
+// SnapshotValues.
 type MDP struct {
 	nfa        *combo4.NFA
 	previewLen int
+	noHold     bool
+	// Whether updateValues uses prioritizedSweep instead of fullSweep.
+	prioritizedSweep bool
+	// How Update trains the MDP.
+	trainingMode TrainingMode
+	// The most sweeps of each sweepValues or 0 for no limit.
+	maxSweeps int
+	// How close the values are to converging. See ConvergenceInfo.
+	convergence ConvergenceInfo
+
+	// The actions of each Move used to break ties between equal choices.
+	mActions map[combo4.Move][]tetris.Action
 
 	// A map from GameState to the next chosen state.
 	policy map[GameState]combo4.State
@@ -35,10 +50,35 @@ type MDP struct {
 	// not in the map can only consume len(preview) pieces. This is
 	// conveniently the 0 value.
 	value map[GameState]float64
+
+	// The weight of the standard deviation subtracted from the expected
+	// value of each choice in updatePolicy.
+	riskAversion float64
+	// The expected square of the value in the same units as value. It is
+	// only kept if riskAversion is not 0.
+	secondMoment map[GameState]float64
+
+	// The bonus of each choice added to the piece it consumes or nil if
+	// there is none. rewardName is the Name of its Reward.
+	rewardFunc RewardFunc
+	rewardName string
+
+	// How the next piece is drawn. It defines the BagUsed of the
+	// GameStates.
+	model NextPieceModel
+
+	// Whether Update publishes snapshots. The *mdpSnapshot last published
+	// by Update for other goroutines.
+	publishSnapshots bool
+	snapshot         atomic.Value
 }
 
 // GameState encapsulates all information about the current game state while
 // doing 4 wide combos. GameState can be used as map key.
+//
+// With the 7 bag randomizer, BagUsed is never 0 in an MDP since the current
+// piece has always been drawn. A BagUsed of 0 allows the same pieces next as
+// a full bag so lookups use the full bag instead.
 type GameState struct {
 	State   combo4.State
 	Current tetris.Piece
@@ -46,78 +86,273 @@ type GameState struct {
 	BagUsed tetris.PieceSet
 }
 
+// String returns the GameState on one line with the field rows from top to
+// bottom separated by slashes.
+func (gs GameState) String() string {
+	field := strings.ReplaceAll(strings.TrimSuffix(gs.State.Field.String(), "\n"), "\n", "/")
+	return fmt.Sprintf("field=%s hold=%v cur=%v preview=%s bag=%v", field, gs.State.Hold, gs.Current, previewString(gs.Preview), gs.BagUsed)
+}
+
+// Verbose returns the GameState on multiple lines with the field drawn.
+func (gs GameState) Verbose() string {
+	return fmt.Sprintf("Hold: %v\nSwapRestricted: %t\nCurrent: %v\nPreview: %s\nBag: %v\nField:\n%s",
+		gs.State.Hold, gs.State.SwapRestricted, gs.Current, previewString(gs.Preview), gs.BagUsed, gs.State.Field)
+}
+
+// BagPhase returns the number of pieces drawn from the current 7 bag. A
+// full bag is phase 0 like an empty one since the next piece starts a new
+// bag.
+func (gs GameState) BagPhase() int {
+	return gs.BagUsed.Len() % 7
+}
+
+// NextBagPieces returns the pieces that the 7 bag randomizer can draw after
+// the BagUsed.
+func (gs GameState) NextBagPieces() []tetris.Piece {
+	return SevenBag{}.Possible(gs.BagUsed)
+}
+
+func previewString(preview tetris.Seq) string {
+	var sb strings.Builder
+	preview.ForEach(func(_ int, p tetris.Piece) {
+		sb.WriteString(p.String())
+	})
+	return sb.String()
+}
+
+// Validate returns an error if the GameState could not happen with the 7 bag
+// randomizer or does not have previewLen pieces in the preview. A BagUsed of
+// 0 is the same as a full bag.
+func (gs GameState) Validate(previewLen int) error {
+	if err := validatePieces(gs, previewLen); err != nil {
+		return err
+	}
+	preview := gs.Preview.Slice()
+	bag := gs.BagUsed
+	if bag == 0 {
+		bag = tetris.NewPieceSet(tetris.NonemptyPieces[:]...)
+	}
+	// Undraw the pieces from the end so each piece is checked against the
+	// bag it was drawn from.
+	pieces := append([]tetris.Piece{gs.Current}, preview...)
+	for i := len(pieces) - 1; i >= 0; i-- {
+		if !bag.Contains(pieces[i]) {
+			return fmt.Errorf("GameState %v has %v which is not in the bag", gs, pieces[i])
+		}
+		bag = undraw(bag, pieces[i])
+	}
+	return nil
+}
+
+// newValidGameState creates a GameState and validates it. Unlike MustSeq it
+// returns an error if the preview is too long or contains EmptyPiece.
+func newValidGameState(state combo4.State, current tetris.Piece, preview []tetris.Piece, bagUsed tetris.PieceSet) (GameState, error) {
+	seq, err := tetris.NewSeq(preview)
+	if err != nil {
+		return GameState{}, fmt.Errorf("invalid preview %v: %v", preview, err)
+	}
+	gs := GameState{
+		State:   state,
+		Current: current,
+		Preview: seq,
+		BagUsed: fullIfEmpty(bagUsed),
+	}
+	return gs, gs.Validate(len(preview))
+}
+
+// fullIfEmpty returns the full bag for an empty bag since they allow the
+// same pieces to be drawn next.
+func fullIfEmpty(bagUsed tetris.PieceSet) tetris.PieceSet {
+	if bagUsed == 0 {
+		return tetris.NewPieceSet(tetris.NonemptyPieces[:]...)
+	}
+	return bagUsed
+}
+
+// MDPOptions configures an MDP.
+type MDPOptions struct {
+	// NoHold makes the MDP play without ever using the hold. The stable
+	// states are then the states without a piece held.
+	NoHold bool
+	// PrioritizedSweep updates the value whose dependencies changed the
+	// most first instead of updating every value in order in each sweep.
+	// This does fewer updates but each one also maintains a priority queue
+	// so it is slower when most of the values are still changing, which is
+	// the case when training from the initial values.
+	PrioritizedSweep bool
+	// Openings includes the GameStates without a piece held and the swap
+	// restricted GameStates. These usually only happen in the first pieces
+	// of a game so without them the policy falls back to its Scorer for
+	// those pieces. Openings has no effect with NoHold since every
+	// GameState is already included.
+	//
+	// This almost doubles the number of GameStates.
+	Openings bool
+	// The number of goroutines used to find the initial GameStates.
+	// Defaults to 8.
+	Concurrency int
+	// RiskAversion makes the policy choose the next State with the highest
+	// mean - RiskAversion*stddev of the number of pieces consumed instead of
+	// the highest mean. This prefers choices that are less likely to end
+	// the combo early at some cost to the expected value. Each choice only
+	// looks at the distribution after it so the policy is not the best
+	// mean-variance trade-off for the whole game.
+	//
+	// The values from ExpectedValue are still the expected values of the
+	// resulting policy. Training takes about twice as long since the second
+	// moments are also updated.
+	RiskAversion float64
+	// Reward adds a bonus to the value of some choices. With a Reward, the
+	// values are the expected pieces consumed plus the expected bonuses.
+	// Defaults to no bonus.
+	Reward Reward
+	// Model is how the randomizer draws the next piece. The BagUsed of the
+	// GameStates is the model's bag state. Defaults to SevenBag.
+	Model NextPieceModel
+	// TrainingMode is how Update trains the MDP. Defaults to
+	// PolicyIteration.
+	TrainingMode TrainingMode
+	// MaxSweeps is the most sweeps of the values of each policy, or of all
+	// of ValueIteration, before Update stops and saves the values even if
+	// they have not converged. See ConvergenceInfo. 0 means no limit.
+	MaxSweeps int
+}
+
 // NewMDP constructs a new MDP for the given preview length.
 func NewMDP(previewLen int) (*MDP, error) {
+	return NewMDPWithOptions(previewLen, MDPOptions{})
+}
+
+// NewMDPWithOptions is like NewMDP but configured by MDPOptions.
+func NewMDPWithOptions(previewLen int, opts MDPOptions) (*MDP, error) {
 	if previewLen > 7 || previewLen < 0 {
 		return nil, errors.New("previewLen must be between 0 and 7")
 	}
+	if opts.Reward.Func != nil && opts.Reward.Name == "" {
+		return nil, errors.New("a Reward with a Func must have a Name")
+	}
+	if err := validateTrainingMode(opts.TrainingMode, opts.RiskAversion); err != nil {
+		return nil, err
+	}
+	if opts.MaxSweeps < 0 {
+		return nil, errors.New("MaxSweeps must not be negative")
+	}
 
-	continuousMoves, _ := combo4.AllContinuousMoves()
+	nfa, mActions := newMDPNFA(opts.NoHold)
 	m := &MDP{
-		nfa:        combo4.NewNFA(continuousMoves),
-		previewLen: previewLen,
-		value:      make(map[GameState]float64, int(128*28*7*7*math.Pow(2.6, float64(previewLen)))),
+		nfa:              nfa,
+		mActions:         mActions,
+		previewLen:       previewLen,
+		noHold:           opts.NoHold,
+		prioritizedSweep: opts.PrioritizedSweep,
+		trainingMode:     opts.TrainingMode,
+		maxSweeps:        opts.MaxSweeps,
+		convergence:      unsweptConvergence(),
+		riskAversion:     opts.RiskAversion,
+		rewardFunc:       opts.Reward.Func,
+		rewardName:       opts.Reward.Name,
+		model:            opts.Model,
+		value:            make(map[GameState]float64, int(128*28*7*7*math.Pow(2.6, float64(previewLen)))),
 	}
 
 	var filteredStates []combo4.State
 	for state := range m.nfa.States() {
-		// Don't include states that usually only show up in the beginning.
-		if state.SwapRestricted || state.Hold == tetris.EmptyPiece {
+		// Don't include states that usually only show up in the beginning
+		// unless asked to.
+		if !m.noHold && !opts.Openings && (state.SwapRestricted || state.Hold == tetris.EmptyPiece) {
 			continue
 		}
 		filteredStates = append(filteredStates, state)
 	}
 
-	stableCh := make(chan GameState, 5000)
+	if m.model == nil {
+		m.model = SevenBag{}
+	}
+
+	numWorkers := opts.Concurrency
+	if numWorkers <= 0 {
+		numWorkers = concurrency
+	}
+	// The 7 bag enumerates the sequences ending in each bag state. Other
+	// models enumerate the sequences drawn from each reachable bag state.
+	var queues [][]pieceQueue
+	if isSevenBag(m.model) {
+		for _, bagUsed := range tetris.AllPieceSets() {
+			// Drawing a piece never empties the bag so every sequence
+			// ending in the empty bag is the same as one ending in the
+			// full bag. See GameState.
+			if bagUsed == 0 {
+				continue
+			}
+			queues = append(queues, []pieceQueue{{bagUsed: bagUsed}})
+		}
+	} else {
+		queues = m.modelQueues()
+	}
+	queueCh := make(chan []pieceQueue)
+	stableCh := make(chan []GameState, numWorkers)
+	var wg sync.WaitGroup
+	wg.Add(numWorkers)
+	for i := 0; i < numWorkers; i++ {
+		go func() {
+			defer wg.Done()
+			enum := m.newStableEnumerator(filteredStates)
+			for batch := range queueCh {
+				if batch[0].pieces == nil {
+					stableCh <- enum.stableGameStates(batch[0].bagUsed)
+					continue
+				}
+				var stable []GameState
+				for _, q := range batch {
+					stable = enum.appendStable(stable, q.pieces[0], q.pieces[1:], q.bagUsed)
+				}
+				stableCh <- stable
+			}
+		}()
+	}
 	go func() {
-		allBags := tetris.AllPieceSets()
-		var wg sync.WaitGroup
-		wg.Add(len(allBags))
-		maxConcurrency := make(chan bool, concurrency)
-		for _, bagUsed := range allBags {
-			bagUsed := bagUsed // Capture range variable.
-
-			maxConcurrency <- true
-			go func() {
-				defer func() { <-maxConcurrency }()
-				defer wg.Done()
-
-				reversed := make([]tetris.Piece, previewLen+1)
-				forEachSeq(bagUsed.Inverted(), previewLen+1, func(seq []tetris.Piece) {
-					for i, p := range seq {
-						reversed[len(reversed)-1-i] = p
-					}
-					current := reversed[0]
-					preview := tetris.MustSeq(reversed[1:])
-					for _, state := range filteredStates {
-						gState := GameState{
-							State:   state,
-							Current: current,
-							Preview: preview,
-							BagUsed: bagUsed,
-						}
-						if m.isStable(gState) {
-							stableCh <- gState
-						}
-					}
-				})
-			}()
+		for _, batch := range queues {
+			queueCh <- batch
 		}
+		close(queueCh)
 		wg.Wait()
 		close(stableCh)
 	}()
 
-	for gState := range stableCh {
-		m.value[gState] = 1
+	for batch := range stableCh {
+		for _, gState := range batch {
+			m.value[gState] = 1
+		}
 	}
+	m.initSecondMoments()
 
 	m.initPolicy()
 	return m, nil
 }
 
+// newMDPNFA returns the NFA used by an MDP and the actions of its Moves.
+func newMDPNFA(noHold bool) (*combo4.NFA, map[combo4.Move][]tetris.Action) {
+	continuousMoves, mActions := combo4.AllContinuousMoves()
+	if noHold {
+		return combo4.NewNFANoHold(continuousMoves), mActions
+	}
+	return combo4.NewNFA(continuousMoves), mActions
+}
+
 // ExpectedValue returns the expected number of pieces that will be consumed
-// for a GameState. This is only accurate if Update() has completed.
+// for a GameState. This is only accurate if Update() has completed. With a
+// Reward, the expected bonuses are included for the GameStates in the MDP.
+//
+// Both the current piece and the pieces of the preview are counted. The
+// values of the MDP count the current piece and the pieces drawn after the
+// preview so the previewLen pieces of the preview are added to them. For a
+// GameState that is not in the MDP, the current piece is counted if it can
+// be placed along with the pieces of the preview that can be consumed after
+// it. This is the same as the value of a stable GameState before Update.
 func (m *MDP) ExpectedValue(gState GameState) float64 {
+	if isSevenBag(m.model) {
+		gState.BagUsed = fullIfEmpty(gState.BagUsed)
+	}
 	if val, ok := m.value[gState]; ok {
 		return val + float64(m.previewLen)
 	}
@@ -132,16 +367,47 @@ func (m *MDP) ExpectedValue(gState GameState) float64 {
 // initPolicy creates an initial policy. initPolicy assumes the scores have
 // been initialized.
 func (m *MDP) initPolicy() {
+	m.InitPolicyFrom(FromScorer(m.nfa, NewNFAScorer(m.nfa, m.previewLen), PreferFewerKeys(m.mActions)))
+}
+
+// InitPolicyFrom replaces the policy of the MDP with the choices of p. Update
+// then starts from this policy instead of the one chosen by an NFAScorer,
+// e.g. to seed it from a previously trained MDPPolicy. The GameStates where
+// p returns nil or a State that is not a next state fall back to the
+// NFAScorer. It must not be called during Update.
+func (m *MDP) InitPolicyFrom(p Policy) {
+	var scorerPol Policy
+	fallback := PolicyFunc(func(initial combo4.State, current tetris.Piece, preview []tetris.Piece, endBagUsed tetris.PieceSet) *combo4.State {
+		if scorerPol == nil {
+			scorerPol = FromScorer(m.nfa, NewNFAScorer(m.nfa, m.previewLen), PreferFewerKeys(m.mActions))
+		}
+		return scorerPol.NextState(initial, current, preview, endBagUsed)
+	})
+	valid := PolicyFunc(func(initial combo4.State, current tetris.Piece, preview []tetris.Piece, endBagUsed tetris.PieceSet) *combo4.State {
+		choice := p.NextState(initial, current, preview, endBagUsed)
+		if choice == nil {
+			return nil
+		}
+		for _, next := range m.nfa.NextStates(initial, current) {
+			if next == *choice {
+				return choice
+			}
+		}
+		return nil
+	})
+	pol := WrapWithFallback(valid, fallback)
+
 	m.policy = make(map[GameState]combo4.State, len(m.value))
-	p := FromScorer(m.nfa, NewNFAScorer(m.nfa, m.previewLen))
 	for gState := range m.value {
-		choice := p.NextState(gState.State, gState.Current, gState.Preview.Slice(), gState.BagUsed)
+		choice := pol.NextState(gState.State, gState.Current, gState.Preview.Slice(), gState.BagUsed)
 		m.policy[gState] = *choice
 	}
 }
 
 // isStable is used to compute the initial values.
 // A GameState is considered stable if the current + preview can be consumed.
+// Without a piece held, the current piece can always be consumed by holding
+// it so only the preview decides whether the GameState is stable.
 func (m *MDP) isStable(gState GameState) bool {
 	start := m.nfa.NextStates(gState.State, gState.Current)
 	if len(start) == 0 {
@@ -151,13 +417,134 @@ func (m *MDP) isStable(gState GameState) bool {
 	return consumed == m.previewLen
 }
 
+// stableEnumerator finds the stable GameStates for NewMDP. It is the same as
+// calling isStable on each GameState but looks up the first choices of each
+// State once instead of for every sequence and reuses its scratch sets. A
+// stableEnumerator must not be used by multiple goroutines at the same time.
+type stableEnumerator struct {
+	m      *MDP
+	states []combo4.State
+	// The first choices of each State for each piece or nil if there are
+	// none.
+	starts  [][8]combo4.StateSet
+	scratch combo4.EndStatesScratch
+}
+
+func (m *MDP) newStableEnumerator(states []combo4.State) *stableEnumerator {
+	e := &stableEnumerator{
+		m:      m,
+		states: states,
+		starts: make([][8]combo4.StateSet, len(states)),
+	}
+	for idx, state := range states {
+		for _, p := range tetris.NonemptyPieces {
+			if next := m.nfa.NextStates(state, p); len(next) > 0 {
+				e.starts[idx][p] = combo4.NewStateSet(next...)
+			}
+		}
+	}
+	return e
+}
+
+// stableGameStates returns the stable GameStates with the bag of the 7 bag
+// randomizer.
+func (e *stableEnumerator) stableGameStates(bagUsed tetris.PieceSet) []GameState {
+	var stable []GameState
+	reversed := make([]tetris.Piece, e.m.previewLen+1)
+	forEachSeq(bagUsed.Inverted(), e.m.previewLen+1, func(seq []tetris.Piece) {
+		for i, p := range seq {
+			reversed[len(reversed)-1-i] = p
+		}
+		stable = e.appendStable(stable, reversed[0], reversed[1:], bagUsed)
+	})
+	return stable
+}
+
+// appendStable appends the stable GameStates with the pieces and bag.
+func (e *stableEnumerator) appendStable(stable []GameState, current tetris.Piece, preview []tetris.Piece, bagUsed tetris.PieceSet) []GameState {
+	previewSeq := tetris.MustSeq(preview)
+	for idx, state := range e.states {
+		start := e.starts[idx][current]
+		if start == nil || e.m.nfa.NumConsumedInto(&e.scratch, start, preview) != e.m.previewLen {
+			continue
+		}
+		stable = append(stable, GameState{
+			State:   state,
+			Current: current,
+			Preview: previewSeq,
+			BagUsed: bagUsed,
+		})
+	}
+	return stable
+}
+
+// pieceQueue is a current piece and preview with the bag state after them.
+// A pieceQueue without pieces stands for every sequence that ends with the
+// bag of the 7 bag randomizer.
+type pieceQueue struct {
+	pieces  []tetris.Piece
+	bagUsed tetris.PieceSet
+}
+
+// modelQueues returns every current piece and preview that the model can
+// draw from a bag state reachable from the empty bag, batched by the
+// current piece.
+func (m *MDP) modelQueues() [][]pieceQueue {
+	reachable := map[tetris.PieceSet]bool{0: true}
+	for frontier := []tetris.PieceSet{0}; len(frontier) > 0; {
+		bagUsed := frontier[len(frontier)-1]
+		frontier = frontier[:len(frontier)-1]
+		for _, p := range m.model.Possible(bagUsed) {
+			if next := m.model.Draw(bagUsed, p); !reachable[next] {
+				reachable[next] = true
+				frontier = append(frontier, next)
+			}
+		}
+	}
+
+	type queueKey struct {
+		current tetris.Piece
+		preview tetris.Seq
+		bagUsed tetris.PieceSet
+	}
+	seen := make(map[queueKey]bool)
+	batches := make(map[tetris.Piece][]pieceQueue)
+	seq := make([]tetris.Piece, m.previewLen+1)
+	var forEach func(idx int, bagUsed tetris.PieceSet)
+	forEach = func(idx int, bagUsed tetris.PieceSet) {
+		if idx == len(seq) {
+			key := queueKey{current: seq[0], preview: tetris.MustSeq(seq[1:]), bagUsed: bagUsed}
+			if !seen[key] {
+				seen[key] = true
+				batches[seq[0]] = append(batches[seq[0]], pieceQueue{pieces: append([]tetris.Piece(nil), seq...), bagUsed: bagUsed})
+			}
+			return
+		}
+		for _, p := range m.model.Possible(bagUsed) {
+			seq[idx] = p
+			forEach(idx+1, m.model.Draw(bagUsed, p))
+		}
+	}
+	for bagUsed := range reachable {
+		forEach(0, bagUsed)
+	}
+
+	queues := make([][]pieceQueue, 0, len(batches))
+	for _, p := range tetris.NonemptyPieces {
+		if batch := batches[p]; len(batch) > 0 {
+			queues = append(queues, batch)
+		}
+	}
+	return queues
+}
+
 func forEachSeq(bagUsed tetris.PieceSet, seqLen int, do func([]tetris.Piece)) {
 	seq := make([]tetris.Piece, seqLen)
 	forEachSeqHelper(seq, bagUsed, 0, do)
 }
 
 func forEachSeqHelper(seq []tetris.Piece, bagUsed tetris.PieceSet, seqIdx int, do func([]tetris.Piece)) {
-	if bagUsed.Len() == 7 {
+	if bagUsed.IsFullBag() {
 		bagUsed = 0
 	}
 	for _, p := range bagUsed.Inverted().Slice() {
@@ -173,24 +560,12 @@ func forEachSeqHelper(seq []tetris.Piece, bagUsed tetris.PieceSet, seqIdx int, d
 // updatePolicy updates the policy based on values and returns how many
 // policy changes there were.
 func (m *MDP) updatePolicy() int {
-	var changed int
+	var (
+		changed int
+		buf     possibilityBuf
+	)
 	for gState, currentChoice := range m.policy {
-		choices := m.nfa.NextStates(gState.State, gState.Current)
-		if len(choices) == 1 {
-			continue
-		}
-
-		var (
-			bestChoice combo4.State
-			bestVal    = math.Inf(-1)
-		)
-		for _, choice := range choices {
-			if v := m.calcValue(gState, choice); v > bestVal {
-				bestVal = v
-				bestChoice = choice
-			}
-		}
-		if currentChoice != bestChoice {
+		if bestChoice := m.bestChoice(gState, 0, &buf); currentChoice != bestChoice {
 			changed++
 			m.policy[gState] = bestChoice
 		}
@@ -199,160 +574,599 @@ func (m *MDP) updatePolicy() int {
 	return changed
 }
 
+// bestChoice returns the choice of the GameState with the highest
+// choiceValue. Values within tolerance of the highest value are ties which
+// are broken by the number of key presses and then by State.Less so the
+// choice does not depend on the order of the choices.
+func (m *MDP) bestChoice(gState GameState, tolerance float64, buf *possibilityBuf) combo4.State {
+	choices := m.nfa.NextStates(gState.State, gState.Current)
+	if len(choices) == 1 {
+		return choices[0]
+	}
+
+	values := make([]float64, len(choices))
+	maxVal := math.Inf(-1)
+	for idx, choice := range choices {
+		values[idx] = m.choiceValue(gState, choice, buf)
+		maxVal = math.Max(maxVal, values[idx])
+	}
+
+	var (
+		bestChoice combo4.State
+		bestCost   = -1
+	)
+	for idx, choice := range choices {
+		if values[idx] < maxVal-tolerance {
+			continue
+		}
+		cost := actionsCost(m.mActions, gState.State, choice, gState.Current)
+		if bestCost < 0 || cost < bestCost || (cost == bestCost && choice.Less(bestChoice)) {
+			bestChoice = choice
+			bestCost = cost
+		}
+	}
+	return bestChoice
+}
+
 // valueChange is used for efficient updating of values given a particular
-// policy.
+// policy. The values themselves are kept in a slice indexed by idx so a
+// sweep can read the values of the previous sweep while writing the next.
 type valueChange struct {
+	// The next value is the highest value of the choices. There is only the
+	// policy choice unless training with ValueIteration.
+	choices []valueChoice
+	// The indexes of the valueChanges that depend on this value.
+	dependents []int32
+	idx        int32
+}
+
+// valueChoice is the part of a valueChange for one choice.
+type valueChoice struct {
 	// Used to calculate the next value.
-	// The next value is 1 + sum(dependencies) / possibilities
+	// The next value is base + sum(dependencies) / possibilities if the
+	// possibilities are equally likely and otherwise
+	// base + sum(weights * dependencies).
+	base          float64
 	possibilities float64
-	dependencies  []*float64
-
-	// It is important that this is updated atomically. So that valCurrent
-	// never decreases. valCurrent may be concurrently modified and read.
-	// But will still reach equilibrium as long as it does not decrease or
-	// overly increase.
-	value float64
+	// The indexes of the values that this value depends on.
+	dependencies []int32
+	// The probabilities of the dependencies or nil if the possibilities are
+	// equally likely.
+	weights []float64
 }
 
 const epsilon = 0.0001 // The smallest value that we care about updating.
 
+// nextValue returns the value computed from the values of the dependencies.
+// The dependencies in the same chunk but a different part than the given
+// one are read from prev instead. If parts is nil every dependency is read
+// from values.
+func (c *valueChange) nextValue(values, prev []float64, parts []int32, part int32) float64 {
+	best := math.Inf(-1)
+	for _, choice := range c.choices {
+		var totalVal float64
+		for i, d := range choice.dependencies {
+			var v float64
+			if parts != nil && parts[d] != part && parts[d]/concurrency == part/concurrency {
+				v = prev[d]
+			} else {
+				v = values[d]
+			}
+			if choice.weights != nil {
+				v *= choice.weights[i]
+			}
+			totalVal += v
+		}
+		if choice.weights == nil {
+			totalVal /= choice.possibilities
+		}
+		best = math.Max(best, choice.base+totalVal)
+	}
+	return best
+}
+
+// hasDependencies returns whether any of the choices depends on a value.
+func (c *valueChange) hasDependencies() bool {
+	for _, choice := range c.choices {
+		if len(choice.dependencies) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
 // updateValues updates the expected values based on the current
 // expected values and policy. updateValues returns the number of values
-// that changed. cap can be used to specify a maximum value.
+// that changed. The second moments are also updated if they are kept.
 func (m *MDP) updateValues() int {
+	totalChanges, stats := m.sweepValues(m.value, m.policyChoice, m.choiceBase)
+	m.convergence.add(stats)
+	if m.secondMoment != nil {
+		// E[(c+X)^2] = c^2 + 2cE[X] + E[X^2] where c is the piece consumed
+		// plus the reward and X is the value after the choice. The values
+		// have converged so only E[X^2] changes.
+		changes, _ := m.sweepValues(m.secondMoment, m.policyChoice, func(gState GameState, choice combo4.State, possibilities []GameState, probs []float64) float64 {
+			c := m.choiceBase(gState, choice, possibilities, probs)
+			return c*c + 2*c*m.meanValue(possibilities, probs)
+		})
+		totalChanges += changes
+	}
+	return totalChanges
+}
+
+// policyChoice returns the choice of the policy for the GameState.
+func (m *MDP) policyChoice(gState GameState) []combo4.State {
+	return []combo4.State{m.policy[gState]}
+}
+
+// choiceBase returns the piece consumed by the choice plus its reward.
+func (m *MDP) choiceBase(gState GameState, choice combo4.State, _ []GameState, _ []float64) float64 {
+	return 1 + m.reward(gState, choice)
+}
+
+// sweepValues updates the values until they reach equilibrium or maxSweeps
+// sweeps. The next value of each GameState is the highest of its choices
+// where the value of a choice is its base plus the mean of the values of its
+// possibilities weighted by their probabilities. sweepValues returns the
+// number of values that changed and the SweepStats of each sweep.
+func (m *MDP) sweepValues(values map[GameState]float64, choices func(GameState) []combo4.State, base func(gState GameState, choice combo4.State, possibilities []GameState, probs []float64) float64) (int, []SweepStats) {
+	vals, gStates, newValues := m.valueChanges(values, choices, base)
+	var stats []SweepStats
+	if m.prioritizedSweep {
+		stats = prioritizedSweep(vals, newValues, m.maxSweeps)
+	} else {
+		vals = dependencyOrder(vals)
+		renumber(vals, gStates, newValues)
+		stats = fullSweep(vals, newValues, m.maxSweeps)
+	}
+
+	// Update the values map.
+	var totalChanges int
+	for idx, gState := range gStates {
+		if old := values[gState]; old != newValues[idx] {
+			values[gState] = newValues[idx]
+			totalChanges++
+		}
+	}
+
+	return totalChanges, stats
+}
+
+// valueChanges returns a valueChange for each of the values with its
+// dependencies on the others, the GameState at the same index and a copy of
+// the values at the same index. The GameStates are sorted by gameStateLess
+// so the sweeps are the same every time for the same values.
+func (m *MDP) valueChanges(values map[GameState]float64, choices func(GameState) []combo4.State, base func(gState GameState, choice combo4.State, possibilities []GameState, probs []float64) float64) ([]*valueChange, []GameState, []float64) {
+	gStates := make([]GameState, 0, len(values)) // Used for valueChange -> GameState
+	for gState := range values {
+		gStates = append(gStates, gState)
+	}
+	sort.Slice(gStates, func(i, j int) bool { return gameStateLess(gStates[i], gStates[j]) })
+
 	var (
-		vals    = make([]*valueChange, 0, len(m.value))
-		gStates = make([]GameState, 0, len(m.value))             // Used for valueChange -> GameState
-		cMap    = make(map[GameState]*valueChange, len(m.value)) // Used for GameState -> valueChange
+		vals      = make([]*valueChange, len(gStates))
+		newValues = make([]float64, len(gStates))
+		idxMap    = make(map[GameState]int32, len(gStates)) // Used for GameState -> valueChange
 	)
-	for gState, v := range m.value {
-		c := &valueChange{value: v}
-		cMap[gState] = c
-		vals = append(vals, c)
-		gStates = append(gStates, gState)
+	for idx, gState := range gStates {
+		vals[idx] = &valueChange{idx: int32(idx)}
+		newValues[idx] = values[gState]
+		idxMap[gState] = int32(idx)
 	}
-	for gState, c := range cMap {
-		possibilities := m.possibilities(gState, m.policy[gState])
-		for _, poss := range possibilities {
-			if dep, ok := cMap[poss]; ok {
-				c.dependencies = append(c.dependencies, &dep.value)
+	var buf possibilityBuf
+	for idx, gState := range gStates {
+		c := vals[idx]
+		for _, choice := range choices(gState) {
+			possibilities, probs := m.possibilities(gState, choice, &buf)
+			uniform := isUniform(probs)
+			var vc valueChoice
+			for i, poss := range possibilities {
+				if dep, ok := idxMap[poss]; ok {
+					vc.dependencies = append(vc.dependencies, dep)
+					vals[dep].dependents = append(vals[dep].dependents, c.idx)
+					if !uniform {
+						vc.weights = append(vc.weights, probs[i])
+					}
+				}
 			}
+			vc.base = base(gState, choice, possibilities, probs)
+			vc.possibilities = float64(len(possibilities))
+			c.choices = append(c.choices, vc)
 		}
-		c.possibilities = float64(len(possibilities))
 	}
-	cMap = nil // No longer needed.
+	return vals, gStates, newValues
+}
 
-	for iter := 0; ; iter++ {
-		changesCh := make(chan int, 1)
-		for i := 0; i < concurrency; i++ {
-			start := i * len(vals) / concurrency
-			end := (i + 1) * len(vals) / concurrency
-			go func() {
-				var changes int
-				for _, c := range vals[start:end] {
-					// Update val based on depdendencies.
-					// Even though dependencies may change from different
-					// go-routines, this is fine because it is okay to read
-					// either version of the value.
-					var totalVal float64
-					for _, d := range c.dependencies {
-						totalVal += *d
-					}
-					newVal := 1 + totalVal/c.possibilities
+// dependencyOrder returns the valueChanges ordered so each one tends to come
+// after its dependencies. A sweep in this order uses more of the values
+// updated in the same sweep so the values propagate in fewer sweeps. The
+// valueChanges without dependencies come first since their values are
+// final and the rest follow in the breadth-first order of their
+// dependents. The valueChanges that do not depend on any of them come last
+// in their original order.
+func dependencyOrder(vals []*valueChange) []*valueChange {
+	ordered := make([]*valueChange, 0, len(vals))
+	visited := make([]bool, len(vals))
+	for _, c := range vals {
+		if !c.hasDependencies() {
+			visited[c.idx] = true
+			ordered = append(ordered, c)
+		}
+	}
+	for i := 0; i < len(ordered); i++ {
+		for _, dep := range ordered[i].dependents {
+			if !visited[dep] {
+				visited[dep] = true
+				ordered = append(ordered, vals[dep])
+			}
+		}
+	}
+	for _, c := range vals {
+		if !visited[c.idx] {
+			ordered = append(ordered, c)
+		}
+	}
+	return ordered
+}
 
-					if math.Abs(newVal-c.value) >= epsilon {
-						changes++
-						c.value = newVal
-					}
-				}
-				changesCh <- changes
-			}()
+// renumber sets the idx of each valueChange to its index in vals and moves
+// the GameStates and values to match. The values of the dependencies of the
+// valueChanges next to each other in a sweep tend to be next to each other
+// after renumbering.
+func renumber(vals []*valueChange, gStates []GameState, values []float64) {
+	newIdx := make([]int32, len(vals))
+	for idx, c := range vals {
+		newIdx[c.idx] = int32(idx)
+	}
+	oldStates := append([]GameState(nil), gStates...)
+	oldValues := append([]float64(nil), values...)
+	for idx, c := range vals {
+		gStates[idx] = oldStates[c.idx]
+		values[idx] = oldValues[c.idx]
+		c.idx = int32(idx)
+		for _, choice := range c.choices {
+			for i, d := range choice.dependencies {
+				choice.dependencies[i] = newIdx[d]
+			}
+		}
+		for i, d := range c.dependents {
+			c.dependents[i] = newIdx[d]
 		}
-		var changes int
+	}
+}
+
+// sweepChunk is the number of valueChanges that fullSweep updates
+// concurrently between waiting for all of them.
+const sweepChunk = 1 << 13
+
+// fullSweep updates every value in order until none of them change or there
+// were maxSweeps sweeps if it is positive. The valueChanges are split into
+// chunks which are split into a part for each goroutine. The parts of a chunk
+// are updated concurrently and use the values updated earlier in the sweep
+// except for the other parts of the same chunk which are read from a copy
+// made before the sweep. So no value is read while it is written and the
+// result only depends on the order of the valueChanges. fullSweep returns
+// the SweepStats of each sweep.
+func fullSweep(vals []*valueChange, values []float64, maxSweeps int) []SweepStats {
+	// The part of each value by idx is chunk*concurrency + the goroutine.
+	parts := make([]int32, len(values))
+	for chunk := 0; chunk*sweepChunk < len(vals); chunk++ {
 		for i := 0; i < concurrency; i++ {
-			changes += <-changesCh
+			start, end := sweepPart(len(vals), chunk, i)
+			for _, c := range vals[start:end] {
+				parts[c.idx] = int32(chunk*concurrency + i)
+			}
+		}
+	}
+	prev := make([]float64, len(values))
+	var allStats []SweepStats
+	for iter := 0; ; iter++ {
+		copy(prev, values)
+		var stats SweepStats
+		for chunk := 0; chunk*sweepChunk < len(vals); chunk++ {
+			statsCh := make(chan SweepStats, concurrency)
+			for i := 0; i < concurrency; i++ {
+				start, end := sweepPart(len(vals), chunk, i)
+				part := int32(chunk*concurrency + i)
+				go func() {
+					var stats SweepStats
+					for _, c := range vals[start:end] {
+						newVal := c.nextValue(values, prev, parts, part)
+						stats.observe(newVal - values[c.idx])
+
+						if math.Abs(newVal-values[c.idx]) >= epsilon {
+							values[c.idx] = newVal
+						}
+					}
+					statsCh <- stats
+				}()
+			}
+			for i := 0; i < concurrency; i++ {
+				stats.merge(<-statsCh)
+			}
 		}
-		log.Printf("Updated %d values (#%d)", changes, iter)
-		if changes == 0 {
-			break
+		allStats = append(allStats, stats)
+		log.Printf("Updated %d values with a residual of %.6f (#%d)", stats.Unconverged, stats.Residual, iter)
+		if stats.Unconverged == 0 || len(allStats) == maxSweeps {
+			return allStats
 		}
 	}
+}
 
-	// Update the values map.
-	var totalChanges int
-	for idx, c := range vals {
-		gState := gStates[idx]
+// sweepPart returns the range of the valueChanges that goroutine i updates
+// in the chunk.
+func sweepPart(numVals, chunk, i int) (start, end int) {
+	chunkStart := chunk * sweepChunk
+	chunkLen := numVals - chunkStart
+	if chunkLen > sweepChunk {
+		chunkLen = sweepChunk
+	}
+	return chunkStart + i*chunkLen/concurrency, chunkStart + (i+1)*chunkLen/concurrency
+}
 
-		old := m.value[gState]
-		if old != c.value {
-			m.value[gState] = c.value
-			totalChanges++
+// prioritizedSweep updates the value with the largest pending change first
+// until no pending change is at least epsilon or there were maxSweeps sweeps
+// if it is positive. The pending change of a value is the sum of the changes
+// of its dependencies since it was last updated, which bounds how much it can
+// change since the probabilities of the possibilities of a choice sum to 1.
+// Every value starts with an infinite pending change so it is updated at
+// least once. Each len(vals) updates count as a sweep and the last
+// SweepStats has the largest pending change left as its Residual.
+func prioritizedSweep(vals []*valueChange, values []float64, maxSweeps int) []SweepStats {
+	queue := newSweepQueue(len(vals))
+	var (
+		allStats []SweepStats
+		stats    SweepStats
+		// The number of updates in the current sweep.
+		updates int
+	)
+	for queue.Len() > 0 && queue.top() >= epsilon {
+		c := vals[heap.Pop(queue).(int32)]
+		newVal := c.nextValue(values, nil, nil, 0)
+		change := newVal - values[c.idx]
+		stats.observe(change)
+		if math.Abs(change) >= epsilon {
+			values[c.idx] = newVal
+			for _, dep := range c.dependents {
+				queue.add(dep, math.Abs(change))
+			}
+		}
+
+		if updates++; updates == len(vals) {
+			allStats = append(allStats, stats)
+			log.Printf("Updated %d values with a residual of %.6f and %d still queued (#%d)", stats.Unconverged, stats.Residual, queue.Len(), len(allStats)-1)
+			if len(allStats) == maxSweeps {
+				return allStats
+			}
+			stats, updates = SweepStats{}, 0
 		}
 	}
+	if updates > 0 {
+		allStats = append(allStats, stats)
+	}
+	var pending float64
+	if queue.Len() > 0 {
+		pending = queue.top()
+	}
+	return append(allStats, SweepStats{Residual: pending})
+}
 
-	return totalChanges
+// sweepQueue is a max heap of the indexes of valueChanges by their pending
+// change for prioritizedSweep.
+type sweepQueue struct {
+	idxs []int32
+	// The position of each index in idxs or -1 if it is not queued.
+	pos     []int32
+	pending []float64
+}
+
+// newSweepQueue returns a sweepQueue with every index queued with an
+// infinite pending change.
+func newSweepQueue(n int) *sweepQueue {
+	q := &sweepQueue{
+		idxs:    make([]int32, n),
+		pos:     make([]int32, n),
+		pending: make([]float64, n),
+	}
+	for i := range q.idxs {
+		q.idxs[i] = int32(i)
+		q.pos[i] = int32(i)
+		q.pending[i] = math.Inf(1)
+	}
+	return q
+}
+
+// top returns the largest pending change. The queue must not be empty.
+func (q *sweepQueue) top() float64 {
+	return q.pending[q.idxs[0]]
+}
+
+// add adds change to the pending change of idx and queues it if needed.
+func (q *sweepQueue) add(idx int32, change float64) {
+	q.pending[idx] += change
+	if q.pos[idx] < 0 {
+		heap.Push(q, idx)
+	} else {
+		heap.Fix(q, int(q.pos[idx]))
+	}
+}
+
+func (q *sweepQueue) Len() int { return len(q.idxs) }
+
+func (q *sweepQueue) Less(i, j int) bool {
+	return q.pending[q.idxs[i]] > q.pending[q.idxs[j]]
+}
+
+func (q *sweepQueue) Swap(i, j int) {
+	q.idxs[i], q.idxs[j] = q.idxs[j], q.idxs[i]
+	q.pos[q.idxs[i]] = int32(i)
+	q.pos[q.idxs[j]] = int32(j)
 }
 
-func (m *MDP) possibilities(cur GameState, choice combo4.State) []GameState {
+func (q *sweepQueue) Push(x interface{}) {
+	idx := x.(int32)
+	q.pos[idx] = int32(len(q.idxs))
+	q.idxs = append(q.idxs, idx)
+}
+
+// Pop removes the last index and resets its pending change since it is
+// about to be updated.
+func (q *sweepQueue) Pop() interface{} {
+	idx := q.idxs[len(q.idxs)-1]
+	q.idxs = q.idxs[:len(q.idxs)-1]
+	q.pos[idx] = -1
+	q.pending[idx] = 0
+	return idx
+}
+
+// possibilityBuf holds the slices returned by possibilities so the next call
+// can reuse them. It must only be used by one goroutine.
+type possibilityBuf struct {
+	gStates []GameState
+	probs   []float64
+}
+
+// possibilities returns the GameStates after the choice for each piece that
+// can be drawn next and their probabilities. Without a preview, the piece
+// drawn next is the current piece of the GameState. The slices are only
+// valid until the next call with the same buf. A nil buf allocates them.
+func (m *MDP) possibilities(cur GameState, choice combo4.State, buf *possibilityBuf) ([]GameState, []float64) {
 	var (
 		current        = cur.Preview.AtIndex(0)
 		previewShifted = cur.Preview.RemoveFirst()
 	)
-
-	bag := cur.BagUsed
-	if bag.Len() == 7 {
-		bag = 0
+	if buf == nil {
+		buf = &possibilityBuf{}
 	}
-	possibleNextPiece := bag.Inverted().Slice()
-	possibilities := make([]GameState, 0, len(possibleNextPiece))
-	for _, p := range possibleNextPiece {
-		var newBag tetris.PieceSet
-		if cur.BagUsed.Len() == 7 {
-			newBag = p.PieceSet()
-		} else {
-			newBag = bag.Add(p)
-		}
 
+	possibilities, probs := buf.gStates[:0], buf.probs[:0]
+	for _, p := range m.model.Possible(cur.BagUsed) {
 		var preview tetris.Seq
+		next := current
 		if m.previewLen > 0 {
 			preview = previewShifted.SetIndex(m.previewLen-1, p)
+		} else {
+			next = p
 		}
 
 		possibilities = append(possibilities, GameState{
 			State:   choice,
-			Current: current,
+			Current: next,
 			Preview: preview,
-			BagUsed: newBag,
+			BagUsed: m.model.Draw(cur.BagUsed, p),
 		})
+		probs = append(probs, m.model.Prob(cur.BagUsed, p))
+	}
+	buf.gStates, buf.probs = possibilities, probs
+	return possibilities, probs
+}
+
+// isUniform returns whether all the probabilities are the same.
+func isUniform(probs []float64) bool {
+	for _, prob := range probs {
+		if prob != probs[0] {
+			return false
+		}
 	}
-	return possibilities
+	return true
 }
 
 // calcValue calculates the expected value given the current estimates and
 // policy. This needs to be kept in sync with the formula in updateValues().
-func (m *MDP) calcValue(cur GameState, choice combo4.State) float64 {
-	var totalVal float64
-	poss := m.possibilities(cur, choice)
-	for _, next := range poss {
-		totalVal += m.value[next]
+// The buf is passed to possibilities.
+func (m *MDP) calcValue(cur GameState, choice combo4.State, buf *possibilityBuf) float64 {
+	return 1 + m.reward(cur, choice) + m.meanValue(m.possibilities(cur, choice, buf))
+}
+
+// meanValue returns the mean of the values of the GameStates weighted by
+// their probabilities.
+func (m *MDP) meanValue(gStates []GameState, probs []float64) float64 {
+	return weightedMean(m.value, gStates, probs)
+}
+
+// weightedMean returns the mean of the values of the GameStates weighted by
+// their probabilities. The mean of equally likely GameStates is computed
+// without the probabilities so it is the same as the unweighted mean.
+func weightedMean(values map[GameState]float64, gStates []GameState, probs []float64) float64 {
+	var total float64
+	if isUniform(probs) {
+		for _, gState := range gStates {
+			total += values[gState]
+		}
+		return total / float64(len(gStates))
+	}
+	for i, gState := range gStates {
+		total += probs[i] * values[gState]
+	}
+	return total
+}
+
+// choiceValue is the value of a choice that updatePolicy maximizes. It is
+// calcValue minus riskAversion times the standard deviation.
+func (m *MDP) choiceValue(cur GameState, choice combo4.State, buf *possibilityBuf) float64 {
+	if m.riskAversion == 0 {
+		return m.calcValue(cur, choice, buf)
+	}
+	poss, probs := m.possibilities(cur, choice, buf)
+	meanNext := m.meanValue(poss, probs)
+	c := 1 + m.reward(cur, choice)
+	mean := c + meanNext
+	second := c*c + 2*c*meanNext + weightedMean(m.secondMoment, poss, probs)
+	// The variance can be slightly negative before the values converge.
+	return mean - m.riskAversion*math.Sqrt(math.Max(second-mean*mean, 0))
+}
+
+// initSecondMoments sets the second moments to the initial values if they
+// are kept.
+func (m *MDP) initSecondMoments() {
+	if m.riskAversion == 0 {
+		return
+	}
+	m.secondMoment = make(map[GameState]float64, len(m.value))
+	for gState, v := range m.value {
+		m.secondMoment[gState] = v * v
 	}
-	return 1 + totalVal/float64(len(poss))
 }
 
 // Update updates the MDP until it is at an optimal policy while periodically
-// saving progress to the given filePath.
+// saving progress to the given filePath. The TrainingMode decides how.
+//
+// Update returns an ErrRewardMismatch if the MDP was decoded without its
+// RewardFunc. It stops early with values that have not converged if the
+// MaxSweeps of the MDP are reached. See ConvergenceInfo.
+//
+// The MDP cannot be used by other goroutines during Update but Update
+// publishes snapshots of it for SnapshotPolicy and SnapshotValues after
+// each sweep if SetPublishSnapshots is enabled.
 func (m *MDP) Update(filePath string) error {
+	if m.rewardName != "" && m.rewardFunc == nil {
+		return fmt.Errorf("%w: the MDP was trained with the unknown Reward %q which must be given to NewMDPFromGob to update it", ErrRewardMismatch, m.rewardName)
+	}
+	m.convergence.History = nil
+	if m.trainingMode == ValueIteration {
+		return m.valueIterate(filePath)
+	}
+	m.publishSnapshot()
 	for i := 0; ; i++ {
 		start := time.Now()
 		valueChanges := m.updateValues()
 		log.Printf("updatedValues (iteration=#%d) with %d total changes in %v", i, valueChanges, time.Since(start))
 		if valueChanges == 0 {
+			// Save the residual of the values even if they did not change.
+			if err := m.Save(filePath); err != nil {
+				return fmt.Errorf("Save() failed: %v", err)
+			}
 			return nil
 		}
+		m.publishSnapshot()
 
 		if err := m.Save(filePath); err != nil {
 			return fmt.Errorf("Save() failed: %v", err)
 		}
+		if !m.Converged() {
+			log.Printf("Stopped after %d sweeps with a residual of %.6f", m.maxSweeps, m.convergence.Residual)
+			return nil
+		}
 
 		start = time.Now()
 		policyChanges := m.updatePolicy()
@@ -360,7 +1174,146 @@ func (m *MDP) Update(filePath string) error {
 		if policyChanges == 0 {
 			return nil
 		}
+		m.publishSnapshot()
+	}
+}
+
+// maxVerifyErrors is the most errors Verify returns.
+const maxVerifyErrors = 100
+
+// Verify checks that the MDP is consistent and returns up to 100 of the
+// problems found. It checks that every GameState has a preview of previewLen
+// pieces and a BagUsed that could have drawn its pieces, a finite
+// non-negative value and a policy choice that is one of its next states.
+func (m *MDP) Verify() []error {
+	gStates := make([]GameState, 0, len(m.value))
+	for gState := range m.value {
+		gStates = append(gStates, gState)
+	}
+
+	errsCh := make(chan []error, concurrency)
+	for i := 0; i < concurrency; i++ {
+		start := i * len(gStates) / concurrency
+		end := (i + 1) * len(gStates) / concurrency
+		go func() {
+			var errs []error
+			for _, gState := range gStates[start:end] {
+				if err := m.verifyGameState(gState); err != nil && len(errs) < maxVerifyErrors {
+					errs = append(errs, err)
+				}
+			}
+			errsCh <- errs
+		}()
+	}
+	var errs []error
+	for i := 0; i < concurrency; i++ {
+		errs = append(errs, <-errsCh...)
+	}
+	for gState := range m.policy {
+		if _, ok := m.value[gState]; !ok {
+			errs = append(errs, fmt.Errorf("GameState %v has a policy choice but no value", gState))
+		}
+	}
+	if len(errs) > maxVerifyErrors {
+		errs = errs[:maxVerifyErrors]
+	}
+	return errs
+}
+
+func (m *MDP) verifyGameState(gState GameState) error {
+	if err := m.validate(gState); err != nil {
+		return err
+	}
+	if v := m.value[gState]; math.IsNaN(v) || math.IsInf(v, 0) || v < 0 {
+		return fmt.Errorf("GameState %v has an invalid value %v", gState, v)
+	}
+	choice, ok := m.policy[gState]
+	if !ok {
+		return fmt.Errorf("GameState %v has no policy choice", gState)
+	}
+	for _, next := range m.nfa.NextStates(gState.State, gState.Current) {
+		if next == choice {
+			return nil
+		}
+	}
+	return fmt.Errorf("choice %+v is not a next state of %v", choice, gState)
+}
+
+// validate is GameState.Validate for the NextPieceModel of the MDP. Only the
+// 7 bag can be checked against the pieces so other models only check the
+// number of pieces.
+func (m *MDP) validate(gState GameState) error {
+	if isSevenBag(m.model) {
+		return gState.Validate(m.previewLen)
 	}
+	return validatePieces(gState, m.previewLen)
+}
+
+// validatePieces returns an error if the GameState has no current piece or
+// does not have previewLen pieces in the preview.
+func validatePieces(gState GameState, previewLen int) error {
+	if gState.Current == tetris.EmptyPiece {
+		return fmt.Errorf("GameState %v has no current piece", gState)
+	}
+	var n int
+	gState.Preview.ForEach(func(int, tetris.Piece) { n++ })
+	if n != previewLen {
+		return fmt.Errorf("GameState %v has a preview of %d pieces, want %d", gState, n, previewLen)
+	}
+	return nil
+}
+
+// MDPDecodeOptions configures NewMDPFromGob.
+type MDPDecodeOptions struct {
+	// Verify runs MDP.Verify after decoding and returns an error if there
+	// are any problems.
+	Verify bool
+	// Reward must be the MDPOptions.Reward the MDP was created with if it
+	// is set e.g. to continue training. By default, the Reward is the one
+	// the MDP was created with if it is known by name like TSpinReward.
+	// Otherwise only its Name is known and the MDP cannot be updated.
+	Reward Reward
+	// Model is needed if the MDP was created with a NextPieceModel other
+	// than SevenBag or Memoryless. It must have the same Name.
+	Model NextPieceModel
+}
+
+// ErrRewardMismatch is returned when decoding an MDP with a different Reward
+// than it was trained with.
+var ErrRewardMismatch = errors.New("mismatched Reward")
+
+// NewMDPFromGob decodes an MDP from a Gob encoding. It returns an
+// ErrRewardMismatch if the MDP was trained with a different Reward than the
+// MDPDecodeOptions.Reward that is set.
+func NewMDPFromGob(b []byte, opts MDPDecodeOptions) (*MDP, error) {
+	m := &MDP{rewardFunc: opts.Reward.Func, rewardName: opts.Reward.Name, model: opts.Model}
+	if err := m.GobDecode(b); err != nil {
+		return nil, err
+	}
+	if !opts.Verify {
+		return m, nil
+	}
+	if errs := m.Verify(); len(errs) > 0 {
+		return nil, fmt.Errorf("the decoded MDP has %d problems, the first is: %v", len(errs), errs[0])
+	}
+	return m, nil
+}
+
+// dropOrphans removes the GameStates where the current piece cannot be placed
+// and returns how many were removed. A trained MDP has none but an encoding
+// from before the moves of the NFA changed can. They would have no choice in
+// the policy.
+func (m *MDP) dropOrphans() int {
+	var dropped int
+	for gState := range m.value {
+		if len(m.nfa.NextStates(gState.State, gState.Current)) > 0 {
+			continue
+		}
+		delete(m.value, gState)
+		delete(m.secondMoment, gState)
+		dropped++
+	}
+	return dropped
 }
 
 // Save the MDP to the filePath or returns nil if the path is empty.
@@ -381,32 +1334,112 @@ func (m *MDP) Save(filePath string) error {
 	return nil
 }
 
-// GobEncode returns a Gob encoding of a MDP.
+// GobEncode returns a Gob encoding of a MDP. It starts with a header that
+// GobDecode checks before decoding the rest.
 func (m *MDP) GobEncode() ([]byte, error) {
-	buf := new(bytes.Buffer)
-	encoder := gob.NewEncoder(buf)
+	encoder := newGobWriter(kindMDP, m.previewLen)
+	stats := m.convergence.SweepStats
+	encoder.header.Convergence = &stats
 	if err := encoder.Encode(&m.previewLen); err != nil {
 		return nil, fmt.Errorf("encoder.Encode(previewLen): %v", err)
 	}
 	if err := encoder.Encode(&m.value); err != nil {
 		return nil, fmt.Errorf("encoder.Encode(value): %v", err)
 	}
-	return buf.Bytes(), nil
+	if err := encoder.Encode(&m.noHold); err != nil {
+		return nil, fmt.Errorf("encoder.Encode(noHold): %v", err)
+	}
+	if err := encoder.Encode(&m.riskAversion); err != nil {
+		return nil, fmt.Errorf("encoder.Encode(riskAversion): %v", err)
+	}
+	if m.riskAversion != 0 {
+		if err := encoder.Encode(&m.secondMoment); err != nil {
+			return nil, fmt.Errorf("encoder.Encode(secondMoment): %v", err)
+		}
+	}
+	if err := encoder.Encode(&m.rewardName); err != nil {
+		return nil, fmt.Errorf("encoder.Encode(rewardName): %v", err)
+	}
+	modelName := m.model.Name()
+	if err := encoder.Encode(&modelName); err != nil {
+		return nil, fmt.Errorf("encoder.Encode(modelName): %v", err)
+	}
+	if err := encoder.Encode(&m.trainingMode); err != nil {
+		return nil, fmt.Errorf("encoder.Encode(trainingMode): %v", err)
+	}
+	return encoder.Bytes()
 }
 
-// GobDecode decodes a Gob encoding into an MDP.
+// GobDecode decodes a Gob encoding into an MDP. It returns an ErrGobHeader
+// if the encoding is not of an MDP or cannot be used with this version.
+// Encodings from before the header was added are still decoded.
 func (m *MDP) GobDecode(b []byte) error {
-	buf := new(bytes.Buffer)
-	buf.Write(b) // Always returns nil.
-	decoder := gob.NewDecoder(buf)
+	decoder, header, err := decodeGobHeader(b, kindMDP)
+	if err != nil {
+		return err
+	}
 	if err := decoder.Decode(&m.previewLen); err != nil {
 		return fmt.Errorf("decoder.Decode(previewLen): %v", err)
 	}
+	if err := header.checkPreviewLen(m.previewLen); err != nil {
+		return err
+	}
+	m.convergence = unsweptConvergence()
+	if header != nil && header.Convergence != nil {
+		m.convergence.SweepStats = *header.Convergence
+	} else {
+		m.convergence.Unrecorded = true
+	}
 	if err := decoder.Decode(&m.value); err != nil {
 		return fmt.Errorf("decoder.Decode(value): %v", err)
 	}
-	continuousMoves, _ := combo4.AllContinuousMoves()
-	m.nfa = combo4.NewNFA(continuousMoves)
+	// Encodings from before the header end after the values and are of an
+	// MDP with the hold trained with PolicyIteration for SevenBag.
+	var rewardName, modelName string
+	m.noHold, m.riskAversion, m.secondMoment, m.trainingMode = false, 0, nil, PolicyIteration
+	if header.version() >= 2 {
+		if err := decoder.Decode(&m.noHold); err != nil {
+			return fmt.Errorf("decoder.Decode(noHold): %v", err)
+		}
+		if err := decoder.Decode(&m.riskAversion); err != nil {
+			return fmt.Errorf("decoder.Decode(riskAversion): %v", err)
+		}
+		if m.riskAversion != 0 {
+			if err := decoder.Decode(&m.secondMoment); err != nil {
+				return fmt.Errorf("decoder.Decode(secondMoment): %v", err)
+			}
+		}
+		if err := decoder.Decode(&rewardName); err != nil {
+			return fmt.Errorf("decoder.Decode(rewardName): %v", err)
+		}
+		if err := decoder.Decode(&modelName); err != nil {
+			return fmt.Errorf("decoder.Decode(modelName): %v", err)
+		}
+		if err := decoder.Decode(&m.trainingMode); err != nil {
+			return fmt.Errorf("decoder.Decode(trainingMode): %v", err)
+		}
+	}
+	// The RewardFunc cannot be encoded so it is set before decoding by
+	// NewMDPFromGob or found by its name.
+	if m.rewardName == "" && m.rewardFunc == nil {
+		reward, _ := rewardByName(rewardName)
+		m.rewardName, m.rewardFunc = reward.Name, reward.Func
+	} else if rewardName != m.rewardName {
+		return fmt.Errorf("%w: the MDP was trained with %q but decoded with %q", ErrRewardMismatch, rewardName, m.rewardName)
+	}
+	if m.model == nil {
+		model, err := modelByName(modelName)
+		if err != nil {
+			return err
+		}
+		m.model = model
+	} else if modelName != "" && modelName != m.model.Name() {
+		return fmt.Errorf("the MDP was trained with the NextPieceModel %q but decoded with %q", modelName, m.model.Name())
+	}
+	m.nfa, m.mActions = newMDPNFA(m.noHold)
+	if n := m.dropOrphans(); n > 0 {
+		log.Printf("dropped %d GameStates without a next state in the NFA", n)
+	}
 
 	hasInitialVals := true
 	for _, v := range m.value {
@@ -435,50 +1468,383 @@ func (m *MDP) GobDecode(b []byte) error {
 //
 // MDPPolicy is safe for concurrent use.
 type MDPPolicy struct {
+	// The counts of NextState calls since the last Reset. They are first so
+	// they are 64-bit aligned for the atomic functions.
+	hits, partialPreview, unknownState, previewTooLong, invalid int64
+
+	// The policy is in policy until Freeze moves it to frozen.
 	policy map[GameState]combo4.State
+	frozen *frozenPolicy
+	// The length of the preview in the GameStates of the policy or -1 if
+	// the policy is empty.
+	previewLen int
 
 	compressed bool
-	defaultPol Policy // defaultPol is used if the policy does not contain the game state.
+	noHold     bool
+	// Whether defaultPol breaks ties by the number of key presses. Policies
+	// compressed before this was added do not.
+	fewerKeys bool
+	// The Name of the Reward the MDP was trained with or empty for none.
+	rewardName string
+	// The Name of the Reward required by WithReward or nil.
+	wantReward *string
+	// The NextPieceModel the MDP was trained with. nil is SevenBag.
+	model NextPieceModel
+
+	// defaultPol is used if the policy does not contain the game state. If
+	// nil, it is created by newDefault the first time it is needed.
+	defaultPol  Policy
+	newDefault  func() Policy
+	defaultOnce sync.Once
 }
 
-// NextState returns the next state. NextState panics if the preview is over
-// length 8.
+// MDPPolicyOption configures an MDPPolicy created by NewMDPPolicyFromGob.
+type MDPPolicyOption func(*MDPPolicy)
+
+// WithFallback makes the MDPPolicy use the fallback for the GameStates it
+// does not contain instead of the default for how it was created.
+func WithFallback(fallback Policy) MDPPolicyOption {
+	return func(m *MDPPolicy) {
+		m.SetFallback(fallback)
+	}
+}
+
+// WithReward makes NewMDPPolicyFromGob and LoadFile return an
+// ErrRewardMismatch if the MDPPolicy was trained with a different Reward.
+// Only the Name of the Reward is compared.
+func WithReward(reward Reward) MDPPolicyOption {
+	return func(m *MDPPolicy) {
+		m.wantReward = &reward.Name
+	}
+}
+
+// NewMDPPolicyFromGob decodes an MDPPolicy from a Gob encoding.
+func NewMDPPolicyFromGob(b []byte, opts ...MDPPolicyOption) (*MDPPolicy, error) {
+	m := new(MDPPolicy)
+	if err := m.GobDecode(b); err != nil {
+		return nil, err
+	}
+	if err := m.applyOptions(opts); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// applyOptions applies the options to a decoded MDPPolicy and checks the
+// Reward of WithReward.
+func (m *MDPPolicy) applyOptions(opts []MDPPolicyOption) error {
+	for _, opt := range opts {
+		opt(m)
+	}
+	if m.wantReward != nil && *m.wantReward != m.rewardName {
+		return fmt.Errorf("%w: the policy was trained with %q but loaded with %q", ErrRewardMismatch, m.rewardName, *m.wantReward)
+	}
+	return nil
+}
+
+// SetFallback replaces the Policy used for the GameStates that the MDPPolicy
+// does not contain. This can be a cheaper or deeper Scorer or an MDPPolicy
+// with a shorter preview. SetFallback is not safe to call concurrently with
+// NextState.
+//
+// By default, a compressed MDPPolicy falls back to the embedded NFAScorer
+// and an uncompressed one to a Scorer of the known pieces.
+func (m *MDPPolicy) SetFallback(fallback Policy) {
+	m.defaultPol = fallback
+	m.newDefault = nil
+}
+
+// fallback returns the Policy for the GameStates that the MDPPolicy does not
+// contain, creating it if needed.
+func (m *MDPPolicy) fallback() Policy {
+	m.defaultOnce.Do(func() {
+		if m.defaultPol == nil && m.newDefault != nil {
+			m.defaultPol = m.newDefault()
+		}
+	})
+	return m.defaultPol
+}
+
+// NextState returns the next state. NextState returns nil if the pieces do
+// not pass GameState.Validate e.g. the preview is over length 8 or has a
+// piece that is not in the bag.
+//
+// For a policy trained with a NextPieceModel other than SevenBag, endBagUsed
+// is the bag state of the model like in a game with the WithPieceModel
+// option and the pieces are not checked against it.
+//
+// If the preview is shorter than the preview of a policy trained with
+// SevenBag, NextState chooses the State chosen for the most completions of
+// the preview with the pieces that can come next. See partialChoice.
 func (m *MDPPolicy) NextState(initial combo4.State, current tetris.Piece, preview []tetris.Piece, endBagUsed tetris.PieceSet) *combo4.State {
-	if next, ok := m.policy[GameState{
-		State:   initial,
+	gState, err := m.newGameState(initial, current, preview, endBagUsed)
+	if err != nil {
+		atomic.AddInt64(&m.invalid, 1)
+		return nil
+	}
+	if next := m.known(gState, preview); next != nil {
+		return next
+	}
+	return m.fallback().NextState(initial, current, preview, endBagUsed)
+}
+
+// known returns the choice of the policy for the GameState or nil if the
+// policy does not contain it, counting why in the stats.
+func (m *MDPPolicy) known(gState GameState, preview []tetris.Piece) *combo4.State {
+	next, stat := m.knownChoice(gState, preview)
+	atomic.AddInt64(stat, 1)
+	return next
+}
+
+// knownChoice is known without counting. It returns the stat that counts
+// why.
+func (m *MDPPolicy) knownChoice(gState GameState, preview []tetris.Piece) (*combo4.State, *int64) {
+	if next, ok := m.lookup(gState); ok {
+		return &next, &m.hits
+	}
+	if len(preview) < m.previewLen && isSevenBag(m.model) {
+		if next, ok := m.partialChoice(gState, preview); ok {
+			return next, &m.partialPreview
+		}
+	}
+	if m.previewLen >= 0 && len(preview) > m.previewLen {
+		return nil, &m.previewTooLong
+	}
+	return nil, &m.unknownState
+}
+
+// newGameState is newValidGameState for the NextPieceModel of the policy.
+func (m *MDPPolicy) newGameState(state combo4.State, current tetris.Piece, preview []tetris.Piece, bagUsed tetris.PieceSet) (GameState, error) {
+	if isSevenBag(m.model) {
+		return newValidGameState(state, current, preview, bagUsed)
+	}
+	seq, err := tetris.NewSeq(preview)
+	if err != nil {
+		return GameState{}, fmt.Errorf("invalid preview %v: %v", preview, err)
+	}
+	gs := GameState{
+		State:   state,
 		Current: current,
-		Preview: tetris.MustSeq(preview),
-		BagUsed: endBagUsed,
-	}]; ok {
-		copy := next
-		return &copy
+		Preview: seq,
+		BagUsed: bagUsed,
+	}
+	return gs, validatePieces(gs, len(preview))
+}
+
+// partialChoice marginalizes over the pieces missing from a preview that is
+// shorter than the preview of the policy. Each completion of the preview with
+// pieces that can come next from the bag is equally likely so partialChoice
+// returns the State chosen for the most completions, breaking ties by
+// State.Less. The policy only keeps the choices and not their values so the
+// completions vote instead of averaging values.
+//
+// Compressed policies leave out the completions where the fallback chooses
+// the same so those vote for what the fallback chooses with the known
+// preview. It returns false if no completion is in the policy.
+func (m *MDPPolicy) partialChoice(gState GameState, preview []tetris.Piece) (*combo4.State, bool) {
+	votes := make(map[combo4.State]int)
+	var missing int
+	full := make([]tetris.Piece, m.previewLen)
+	copy(full, preview)
+	forEachSeq(gState.BagUsed, m.previewLen-len(preview), func(rest []tetris.Piece) {
+		copy(full[len(preview):], rest)
+		bagUsed := gState.BagUsed
+		for _, p := range rest {
+			if bagUsed.IsFullBag() {
+				bagUsed = 0
+			}
+			bagUsed = bagUsed.Add(p)
+		}
+		next, ok := m.lookup(GameState{
+			State:   gState.State,
+			Current: gState.Current,
+			Preview: tetris.MustSeq(full),
+			BagUsed: bagUsed,
+		})
+		if !ok {
+			missing++
+			return
+		}
+		votes[next]++
+	})
+	if len(votes) == 0 {
+		return nil, false
+	}
+	if m.compressed && missing > 0 {
+		if next := m.fallback().NextState(gState.State, gState.Current, preview, gState.BagUsed); next != nil {
+			votes[*next] += missing
+		}
+	}
+
+	var (
+		best      combo4.State
+		bestVotes int
+	)
+	for choice, n := range votes {
+		if n > bestVotes || n == bestVotes && choice.Less(best) {
+			best, bestVotes = choice, n
+		}
 	}
-	return m.defaultPol.NextState(initial, current, preview, endBagUsed)
+	return &best, true
+}
+
+// MDPPolicyStats counts the NextState calls of an MDPPolicy.
+type MDPPolicyStats struct {
+	// The number of calls with a GameState in the policy.
+	Hits int64
+	// The number of calls with a preview shorter than the preview of the
+	// policy that were decided by the completions of the preview in the
+	// policy.
+	PartialPreview int64
+	// The number of calls that used the fallback because the GameState is
+	// not in the policy. Compressed policies leave out the GameStates where
+	// the fallback makes the same choice so these are expected.
+	UnknownState int64
+	// The number of calls that used the fallback because the preview is
+	// longer than the preview of the GameStates in the policy.
+	PreviewTooLong int64
+	// The number of calls with pieces that do not pass GameState.Validate.
+	Invalid int64
+}
+
+// Misses returns the number of calls that used the fallback.
+func (s MDPPolicyStats) Misses() int64 {
+	return s.UnknownState + s.PreviewTooLong
+}
+
+// HitRate returns the fraction of the calls with valid pieces that were
+// decided by the policy, including PartialPreview, or 0 if there were none.
+func (s MDPPolicyStats) HitRate() float64 {
+	total := s.Hits + s.PartialPreview + s.Misses()
+	if total == 0 {
+		return 0
+	}
+	return float64(s.Hits+s.PartialPreview) / float64(total)
+}
+
+// Model returns the NextPieceModel the MDP was trained with.
+func (m *MDPPolicy) Model() NextPieceModel {
+	if m.model == nil {
+		return SevenBag{}
+	}
+	return m.model
+}
+
+// RewardName returns the Name of the Reward the MDP was trained with or an
+// empty string if it was trained to only consume pieces.
+func (m *MDPPolicy) RewardName() string {
+	return m.rewardName
+}
+
+// PreviewLen returns the length of the preview the MDP was trained with or
+// -1 if the policy is empty.
+func (m *MDPPolicy) PreviewLen() int {
+	return m.previewLen
+}
+
+// Stats returns the counts of NextState calls since the last Reset.
+func (m *MDPPolicy) Stats() MDPPolicyStats {
+	return MDPPolicyStats{
+		Hits:           atomic.LoadInt64(&m.hits),
+		PartialPreview: atomic.LoadInt64(&m.partialPreview),
+		UnknownState:   atomic.LoadInt64(&m.unknownState),
+		PreviewTooLong: atomic.LoadInt64(&m.previewTooLong),
+		Invalid:        atomic.LoadInt64(&m.invalid),
+	}
+}
+
+// Reset sets the counts of NextState calls to 0.
+func (m *MDPPolicy) Reset() {
+	atomic.StoreInt64(&m.hits, 0)
+	atomic.StoreInt64(&m.partialPreview, 0)
+	atomic.StoreInt64(&m.unknownState, 0)
+	atomic.StoreInt64(&m.previewTooLong, 0)
+	atomic.StoreInt64(&m.invalid, 0)
+}
+
+// policyPreviewLen returns the length of the preview in the GameStates of the
+// policy or -1 if the policy is empty. Every GameState in a policy has the
+// same preview length.
+func policyPreviewLen(policy map[GameState]combo4.State) int {
+	for gState := range policy {
+		return len(gState.Preview.Slice())
+	}
+	return -1
+}
+
+// Validate returns an error if any choice in the policy is not a possible
+// next state in the NFA. This can detect a corrupted encoding.
+func (m *MDPPolicy) Validate(nfa *combo4.NFA) error {
+	var err error
+	m.forEach(func(gState GameState, choice combo4.State) {
+		if err != nil {
+			return
+		}
+		for _, next := range nfa.NextStates(gState.State, gState.Current) {
+			if next == choice {
+				return
+			}
+		}
+		err = fmt.Errorf("choice %+v is not a next state of %v", choice, gState)
+	})
+	return err
 }
 
 // CompressedPolicy returns the MDP's policy in compressed form.
 func (m *MDP) CompressedPolicy() *MDPPolicy {
-	policy := make(map[GameState]combo4.State, len(m.policy))
-	defaultPol := FromScorer(m.nfa, NewNFAScorer(m.nfa, 7))
+	policy, _ := m.CompressedPolicyWithBudget(math.Inf(-1))
+	return policy
+}
+
+// CompressionReport describes what was dropped by CompressedPolicyWithBudget.
+type CompressionReport struct {
+	// The number of choices which differ from the default policy but were
+	// dropped because they were within the budget.
+	Dropped int
+	// The largest loss in expected value of a dropped choice.
+	MaxLoss float64
+}
 
+// CompressedPolicyWithBudget is like CompressedPolicy but also drops choices
+// where taking the default policy's choice instead loses at most
+// maxLossPerState of expected value. The loss is computed from the MDP's
+// current values.
+func (m *MDP) CompressedPolicyWithBudget(maxLossPerState float64) (*MDPPolicy, CompressionReport) {
+	var (
+		policy     = make(map[GameState]combo4.State, len(m.policy))
+		defaultPol = m.defaultPolicy(true)
+		report     CompressionReport
+		buf        possibilityBuf
+	)
 	for gState, choice := range m.policy {
 		// Only specify the choice if its not obvious.
 		if choices := m.nfa.NextStates(gState.State, gState.Current); len(choices) <= 1 {
 			continue
 		}
 		// Only specify the choice if it differs from the Scorer's policy.
-		if choice == *defaultPol.NextState(gState.State, gState.Current, gState.Preview.Slice(), gState.BagUsed) {
+		defaultChoice := *defaultPol.NextState(gState.State, gState.Current, gState.Preview.Slice(), gState.BagUsed)
+		if choice == defaultChoice {
+			continue
+		}
+		if loss := m.calcValue(gState, choice, &buf) - m.calcValue(gState, defaultChoice, &buf); loss <= maxLossPerState {
+			report.Dropped++
+			report.MaxLoss = math.Max(report.MaxLoss, loss)
 			continue
 		}
 		policy[gState] = choice
 	}
 
-	log.Printf("reduced states = %d\n", len(policy))
+	log.Printf("reduced states = %d (%d dropped within budget)\n", len(policy), report.Dropped)
 	return &MDPPolicy{
 		policy:     policy,
+		previewLen: m.previewLen,
 		defaultPol: defaultPol,
 		compressed: true,
-	}
+		noHold:     m.noHold,
+		fewerKeys:  true,
+		rewardName: m.rewardName,
+		model:      m.model,
+	}, report
 }
 
 type basicScorer struct {
@@ -486,49 +1852,131 @@ type basicScorer struct {
 }
 
 // Score checks how many of the known next pieces can be consumed.
-func (s *basicScorer) Score(state combo4.State, next []tetris.Piece, bagUsed tetris.PieceSet) int64 {
+func (s *basicScorer) Score(state combo4.State, next []tetris.Piece, bagUsed tetris.PieceSet) ScoreBreakdown {
 	_, consumed := s.NFA.EndStates(combo4.NewStateSet(state), next)
-	return int64(consumed)
+	return ScoreBreakdown{Consumed: consumed}
 }
 
 // Policy returns the MDP's policy without compressing first.
 func (m *MDP) Policy() Policy {
+	return m.policyFrom(m.policy)
+}
+
+// policyFrom returns an MDPPolicy like Policy with the choices of policy.
+func (m *MDP) policyFrom(policy map[GameState]combo4.State) *MDPPolicy {
 	return &MDPPolicy{
-		policy:     m.policy,
-		defaultPol: FromScorer(m.nfa, &basicScorer{m.nfa}),
+		policy:     policy,
+		previewLen: m.previewLen,
+		defaultPol: m.defaultPolicy(false),
+		noHold:     m.noHold,
+		fewerKeys:  true,
+		rewardName: m.rewardName,
+		model:      m.model,
+	}
+}
+
+// defaultPolicy returns the Policy used by an MDPPolicy for the GameStates
+// it does not contain.
+func (m *MDP) defaultPolicy(compressed bool) Policy {
+	return newDefaultPolicy(m.nfa, m.mActions, compressed, true)
+}
+
+func newDefaultPolicy(nfa *combo4.NFA, mActions map[combo4.Move][]tetris.Action, compressed, fewerKeys bool) Policy {
+	var opts []ScorePolicyOption
+	if fewerKeys {
+		opts = append(opts, PreferFewerKeys(mActions))
+	}
+	if compressed {
+		return FromScorer(nfa, LoadNFAScorer(nfa, 7), opts...)
 	}
+	return FromScorer(nfa, &basicScorer{nfa}, opts...)
 }
 
-// GobEncode returns a Gob encoding of a MDPPolicy.
+// GobEncode returns a Gob encoding of a MDPPolicy. It starts with a header
+// that GobDecode checks before decoding the rest.
 func (m *MDPPolicy) GobEncode() ([]byte, error) {
-	buf := new(bytes.Buffer)
-	encoder := gob.NewEncoder(buf)
-	if err := encoder.Encode(&m.policy); err != nil {
+	encoder := newGobWriter(kindMDPPolicy, m.previewLen)
+	policy := m.policy
+	if m.frozen != nil {
+		policy = make(map[GameState]combo4.State, m.Len())
+		m.forEach(func(gState GameState, choice combo4.State) { policy[gState] = choice })
+	}
+	if err := encoder.Encode(&policy); err != nil {
 		return nil, fmt.Errorf("encoder.Encode(compressed): %v", err)
 	}
 	if err := encoder.Encode(&m.compressed); err != nil {
 		return nil, fmt.Errorf("encoder.Encode(compressed): %v", err)
 	}
-	return buf.Bytes(), nil
+	if err := encoder.Encode(&m.noHold); err != nil {
+		return nil, fmt.Errorf("encoder.Encode(noHold): %v", err)
+	}
+	if err := encoder.Encode(&m.fewerKeys); err != nil {
+		return nil, fmt.Errorf("encoder.Encode(fewerKeys): %v", err)
+	}
+	if err := encoder.Encode(&m.rewardName); err != nil {
+		return nil, fmt.Errorf("encoder.Encode(rewardName): %v", err)
+	}
+	var modelName string
+	if m.model != nil {
+		modelName = m.model.Name()
+	}
+	if err := encoder.Encode(&modelName); err != nil {
+		return nil, fmt.Errorf("encoder.Encode(modelName): %v", err)
+	}
+	return encoder.Bytes()
 }
 
-// GobDecode decodes a Gob encoding into an MDPPolicy.
+// GobDecode decodes a Gob encoding into an MDPPolicy. It returns an
+// ErrGobHeader if the encoding is not of an MDPPolicy or cannot be used with
+// this version. Encodings from before the header was added are still
+// decoded.
 func (m *MDPPolicy) GobDecode(b []byte) error {
-	buf := new(bytes.Buffer)
-	buf.Write(b) // Always returns nil.
-	decoder := gob.NewDecoder(buf)
+	decoder, header, err := decodeGobHeader(b, kindMDPPolicy)
+	if err != nil {
+		return err
+	}
+	m.frozen = nil
 	if err := decoder.Decode(&m.policy); err != nil {
 		return fmt.Errorf("decoder.Decode(policy): %v", err)
 	}
+	m.previewLen = policyPreviewLen(m.policy)
+	if err := header.checkPreviewLen(m.previewLen); err != nil {
+		return err
+	}
 	if err := decoder.Decode(&m.compressed); err != nil {
 		return fmt.Errorf("decoder.Decode(compressed): %v", err)
 	}
-	continuousMoves, _ := combo4.AllContinuousMoves()
-	nfa := combo4.NewNFA(continuousMoves)
-	if m.compressed {
-		m.defaultPol = FromScorer(nfa, NewNFAScorer(nfa, 7))
-	} else {
-		m.defaultPol = FromScorer(nfa, &basicScorer{nfa})
+	// Encodings from before the header end after compressed and are of a
+	// policy with the hold for SevenBag.
+	var modelName string
+	m.noHold, m.fewerKeys, m.rewardName = false, false, ""
+	if header.version() >= 2 {
+		if err := decoder.Decode(&m.noHold); err != nil {
+			return fmt.Errorf("decoder.Decode(noHold): %v", err)
+		}
+		if err := decoder.Decode(&m.fewerKeys); err != nil {
+			return fmt.Errorf("decoder.Decode(fewerKeys): %v", err)
+		}
+		if err := decoder.Decode(&m.rewardName); err != nil {
+			return fmt.Errorf("decoder.Decode(rewardName): %v", err)
+		}
+		if err := decoder.Decode(&modelName); err != nil {
+			return fmt.Errorf("decoder.Decode(modelName): %v", err)
+		}
+	}
+	model, err := modelByName(modelName)
+	if err != nil {
+		return err
+	}
+	m.model = model
+	// Creating the default Policy is slow for compressed policies so it is
+	// deferred until it is needed in case SetFallback is called.
+	compressed, noHold, fewerKeys := m.compressed, m.noHold, m.fewerKeys
+	m.defaultPol = nil
+	m.newDefault = func() Policy {
+		nfa, mActions := newMDPNFA(noHold)
+		return newDefaultPolicy(nfa, mActions, compressed, fewerKeys)
 	}
+	m.defaultOnce = sync.Once{}
 	return nil
 }
@@ -1,8 +1,10 @@
 package policy
 
 import (
+	"errors"
 	"math"
 	"math/rand"
+	"path/filepath"
 	"testing"
 	"tetris"
 	"tetris/combo4"
@@ -34,6 +36,26 @@ func BenchmarkMDP3UpdateValues(b *testing.B) {
 	mdp.updateValues()
 }
 
+func BenchmarkMDP2UpdateValuesFullSweep(b *testing.B) {
+	benchmarkMDPUpdateValues(b, 2, MDPOptions{})
+}
+
+func BenchmarkMDP2UpdateValuesPrioritized(b *testing.B) {
+	benchmarkMDPUpdateValues(b, 2, MDPOptions{PrioritizedSweep: true})
+}
+
+func benchmarkMDPUpdateValues(b *testing.B, previewLen int, opts MDPOptions) {
+	for n := 0; n < b.N; n++ {
+		b.StopTimer()
+		mdp, err := NewMDPWithOptions(previewLen, opts)
+		if err != nil {
+			b.Fatalf("NewMDPWithOptions: %v", err)
+		}
+		b.StartTimer()
+		mdp.updateValues()
+	}
+}
+
 func benchmarkMDPUpdate(b *testing.B, previewLen int) {
 	for n := 0; n < b.N; n++ {
 		mdp, err := NewMDP(previewLen)
@@ -52,6 +74,183 @@ func benchmarkMDPUpdate(b *testing.B, previewLen int) {
 	}
 }
 
+func TestNewMDPStableStates(t *testing.T) {
+	const previewLen = 2
+	mdp, err := NewMDPWithOptions(previewLen, MDPOptions{Concurrency: 3})
+	if err != nil {
+		t.Fatalf("NewMDPWithOptions: %v", err)
+	}
+
+	// Check every GameState with isStable.
+	var want int
+	r := rand.New(rand.NewSource(1))
+	for _, bagUsed := range tetris.AllPieceSets() {
+		if bagUsed == 0 {
+			// The same as the full bag.
+			continue
+		}
+		reversed := make([]tetris.Piece, previewLen+1)
+		forEachSeq(bagUsed.Inverted(), previewLen+1, func(seq []tetris.Piece) {
+			for i, p := range seq {
+				reversed[len(reversed)-1-i] = p
+			}
+			for state := range mdp.nfa.States() {
+				if state.SwapRestricted || state.Hold == tetris.EmptyPiece {
+					continue
+				}
+				gState := GameState{
+					State:   state,
+					Current: reversed[0],
+					Preview: tetris.MustSeq(reversed[1:]),
+					BagUsed: bagUsed,
+				}
+				stable := mdp.isStable(gState)
+				if stable {
+					want++
+				}
+				if r.Intn(100) != 0 {
+					continue
+				}
+				if _, got := mdp.value[gState]; got != stable {
+					t.Errorf("NewMDP has %v=%t, want %t", gState, got, stable)
+				}
+			}
+		})
+	}
+	if got := len(mdp.value); got != want {
+		t.Errorf("NewMDP has %d GameStates, want %d", got, want)
+	}
+}
+
+func TestNewMDPReachable(t *testing.T) {
+	t.Parallel()
+	const previewLen = 1
+	mdp, err := NewMDPWithOptions(previewLen, MDPOptions{Openings: true})
+	if err != nil {
+		t.Fatalf("NewMDPWithOptions: %v", err)
+	}
+	for gState := range mdp.value {
+		if gState.BagUsed == 0 {
+			t.Fatalf("NewMDP has %v with an empty bag", gState)
+		}
+	}
+
+	// Every stable GameState a game decides from must be in the MDP.
+	check := func(gState GameState) {
+		t.Helper()
+		if _, ok := mdp.value[gState]; !ok && mdp.isStable(gState) {
+			t.Errorf("NewMDP does not have the reachable %v", gState)
+		}
+	}
+	pol := mdp.Policy()
+	r := rand.New(rand.NewSource(1))
+	for trial := 0; trial < 50; trial++ {
+		queue := tetris.RandPiecesFrom(r, 100)
+		game, err := NewGameFromField(pol, combo4.LeftI, queue[0], queue[1:previewLen+1])
+		if err != nil {
+			t.Fatalf("NewGameFromField: %v", err)
+		}
+		check(GameState{
+			State:   combo4.State{Field: combo4.LeftI},
+			Current: queue[0],
+			Preview: tetris.MustSeq(queue[1 : previewLen+1]),
+			BagUsed: game.BagUsed(),
+		})
+		for _, p := range queue[previewLen+1:] {
+			if game.State() == nil {
+				break
+			}
+			prev := *game.State()
+			if _, err := game.Step(p); err != nil {
+				t.Fatalf("Step: %v", err)
+			}
+			check(GameState{
+				State:   prev,
+				Current: game.Current(),
+				Preview: tetris.MustSeq(game.Preview()),
+				BagUsed: game.BagUsed(),
+			})
+		}
+	}
+}
+
+// BenchmarkFullSweep reports the sweeps to reach equilibrium from the
+// initial values with and without dependencyOrder.
+func BenchmarkFullSweep(b *testing.B) {
+	mdp, err := NewMDP(2)
+	if err != nil {
+		b.Fatalf("NewMDP: %v", err)
+	}
+	base := func(GameState, combo4.State, []GameState, []float64) float64 { return 1 }
+	for _, test := range []struct {
+		desc  string
+		order func([]*valueChange, []GameState, []float64) []*valueChange
+	}{
+		{desc: "unordered", order: func(vals []*valueChange, _ []GameState, _ []float64) []*valueChange { return vals }},
+		{desc: "ordered", order: func(vals []*valueChange, gStates []GameState, values []float64) []*valueChange {
+			vals = dependencyOrder(vals)
+			renumber(vals, gStates, values)
+			return vals
+		}},
+	} {
+		b.Run(test.desc, func(b *testing.B) {
+			var sweeps int
+			for n := 0; n < b.N; n++ {
+				b.StopTimer()
+				vals, gStates, values := mdp.valueChanges(mdp.value, mdp.policyChoice, base)
+				b.StartTimer()
+				sweeps += len(fullSweep(test.order(vals, gStates, values), values, 0))
+			}
+			b.ReportMetric(float64(sweeps)/float64(b.N), "sweeps/op")
+		})
+	}
+}
+
+func TestDependencyOrder(t *testing.T) {
+	t.Parallel()
+	mdp, err := NewMDP(1)
+	if err != nil {
+		t.Fatalf("NewMDP: %v", err)
+	}
+	base := func(GameState, combo4.State, []GameState, []float64) float64 { return 1 }
+
+	unordered, unorderedStates, unorderedValues := mdp.valueChanges(mdp.value, mdp.policyChoice, base)
+	ordered, orderedStates, orderedValues := mdp.valueChanges(mdp.value, mdp.policyChoice, base)
+	sweep := dependencyOrder(ordered)
+	seen := make(map[int32]bool)
+	for _, c := range sweep {
+		seen[c.idx] = true
+	}
+	if len(sweep) != len(ordered) || len(seen) != len(ordered) {
+		t.Fatalf("dependencyOrder got %d valueChanges with %d distinct, want %d", len(sweep), len(seen), len(ordered))
+	}
+	renumber(sweep, orderedStates, orderedValues)
+	for idx, c := range sweep {
+		if int(c.idx) != idx {
+			t.Fatalf("renumber set idx %d at index %d", c.idx, idx)
+		}
+	}
+
+	unorderedSweeps := len(fullSweep(unordered, unorderedValues, 0))
+	orderedSweeps := len(fullSweep(sweep, orderedValues, 0))
+	if orderedSweeps > unorderedSweeps {
+		t.Errorf("got %d sweeps in dependency order, want at most the %d sweeps without", orderedSweeps, unorderedSweeps)
+	}
+	want := make(map[GameState]float64, len(unordered))
+	for idx, gState := range unorderedStates {
+		want[gState] = unorderedValues[idx]
+	}
+	var maxDiff float64
+	for idx, gState := range orderedStates {
+		maxDiff = math.Max(maxDiff, math.Abs(orderedValues[idx]-want[gState]))
+	}
+	// Both sweeps stop once no value changes by epsilon so the values can
+	// be a few epsilon apart.
+	if maxDiff > 10*epsilon {
+		t.Errorf("got values that differ by up to %v in dependency order, want the same values", maxDiff)
+	}
+}
+
 func TestMDPUpdateValues(t *testing.T) {
 	t.Parallel()
 	mdp, err := NewMDP(0)
@@ -66,6 +265,54 @@ func TestMDPUpdateValues(t *testing.T) {
 	}
 }
 
+func TestMDPUpdateDeterministic(t *testing.T) {
+	t.Parallel()
+	if testing.Short() {
+		t.Skip("trains two MDPs")
+	}
+
+	var values [2]map[GameState]float64
+	for i := range values {
+		mdp, err := NewMDP(1)
+		if err != nil {
+			t.Fatalf("NewMDP: %v", err)
+		}
+		if err := mdp.Update(filepath.Join(t.TempDir(), "mdp.gob")); err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+		values[i] = mdp.value
+	}
+	if diff := cmp.Diff(values[0], values[1]); diff != "" {
+		t.Errorf("values differ between two trainings (-first +second):\n%s", diff)
+	}
+}
+
+func TestMDPPrioritizedSweep(t *testing.T) {
+	t.Parallel()
+
+	full, err := NewMDP(0)
+	if err != nil {
+		t.Fatalf("NewMDP: %v", err)
+	}
+	prioritized, err := NewMDPWithOptions(0, MDPOptions{PrioritizedSweep: true})
+	if err != nil {
+		t.Fatalf("NewMDPWithOptions: %v", err)
+	}
+	full.updateValues()
+	prioritized.updateValues()
+	if !prioritized.Converged() {
+		t.Errorf("Converged()=false after prioritized sweeping, want true: %+v", prioritized.ConvergenceInfo().SweepStats)
+	}
+
+	// Both stop once no value changes by epsilon, so the values can differ
+	// by a little more than epsilon.
+	for gState, want := range full.value {
+		if got := prioritized.value[gState]; math.Abs(got-want) > 0.01 {
+			t.Fatalf("got value %.4f with prioritized sweeping, want %.4f for %+v", got, want, gState)
+		}
+	}
+}
+
 func TestCompressedPolicy(t *testing.T) {
 	t.Parallel()
 
@@ -89,6 +336,55 @@ func TestCompressedPolicy(t *testing.T) {
 	}
 }
 
+func TestCompressedPolicyWithBudget(t *testing.T) {
+	if testing.Short() {
+		t.Skip("compressing a previewLen=1 policy twice is slow")
+	}
+	t.Parallel()
+
+	mdp, err := NewMDP(1)
+	if err != nil {
+		t.Fatalf("NewMDP: %v", err)
+	}
+	// The accounting does not need the values to have converged.
+	mdp.updateValues()
+	mdp.updatePolicy()
+
+	lossless := mdp.CompressedPolicy()
+	const budget = 0.5
+	lossy, report := mdp.CompressedPolicyWithBudget(budget)
+
+	var (
+		wantDropped int
+		wantMaxLoss float64
+	)
+	for gState, choice := range lossless.policy {
+		defaultChoice := *lossless.fallback().NextState(gState.State, gState.Current, gState.Preview.Slice(), gState.BagUsed)
+		loss := mdp.calcValue(gState, choice, nil) - mdp.calcValue(gState, defaultChoice, nil)
+		_, kept := lossy.policy[gState]
+		if kept == (loss <= budget) {
+			t.Fatalf("got kept=%t for a loss of %.3f with a budget of %.1f", kept, loss, budget)
+		}
+		if !kept {
+			wantDropped++
+			wantMaxLoss = math.Max(wantMaxLoss, loss)
+		}
+	}
+	if wantDropped == 0 {
+		t.Fatalf("no choices were within the budget")
+	}
+	if len(lossy.policy) != len(lossless.policy)-wantDropped {
+		t.Errorf("got %d choices, want %d", len(lossy.policy), len(lossless.policy)-wantDropped)
+	}
+	want := CompressionReport{Dropped: wantDropped, MaxLoss: wantMaxLoss}
+	if diff := cmp.Diff(want, report); diff != "" {
+		t.Errorf("CompressionReport mismatch (-want +got):\n%s", diff)
+	}
+	if report.MaxLoss > budget {
+		t.Errorf("got MaxLoss=%.3f, want at most %.1f", report.MaxLoss, budget)
+	}
+}
+
 // This test is technically flaky but has a low failure rate because it
 // takes a lot of samples.
 func TestMDPExpectedValue(t *testing.T) {
@@ -107,7 +403,7 @@ func TestMDPExpectedValue(t *testing.T) {
 		Preview: tetris.MustSeq([]tetris.Piece{tetris.O}),
 	}
 	if got := mdp.ExpectedValue(known); got != 1 {
-		t.Errorf("ExpectedValue got %.2f, want 1 for %+v", got, known)
+		t.Errorf("ExpectedValue got %.2f, want 1 for %v", got, known)
 	}
 
 	// Check that the expected value of a GameState is accurate by doing
@@ -144,7 +440,7 @@ func TestMDPExpectedValue(t *testing.T) {
 		for {
 			next := tetris.RandPieces(7)
 			for _, p := range next {
-				if <-outputCh == nil {
+				if (<-outputCh).State == nil {
 					break OuterLoop
 				}
 				count++
@@ -163,6 +459,112 @@ func TestMDPExpectedValue(t *testing.T) {
 	}
 }
 
+func TestMDPExpectedValueFallback(t *testing.T) {
+	t.Parallel()
+	const previewLen = 2
+	mdp, err := NewMDP(previewLen)
+	if err != nil {
+		t.Fatalf("NewMDP: %v", err)
+	}
+
+	preview := tetris.MustSeq([]tetris.Piece{tetris.I, tetris.S})
+	tests := []struct {
+		desc   string
+		gState GameState
+		inMDP  bool
+		want   float64
+	}{
+		{
+			desc: "in the MDP",
+			gState: GameState{
+				State:   combo4.State{Field: combo4.LeftI, Hold: tetris.I},
+				Current: tetris.T,
+				Preview: preview,
+				BagUsed: tetris.NewPieceSet(tetris.T, tetris.I, tetris.S),
+			},
+			inMDP: true,
+			want:  1 + previewLen,
+		},
+		{
+			// The States without a piece held are left out of the MDP
+			// without the Openings option.
+			desc: "stable but not in the MDP",
+			gState: GameState{
+				State:   combo4.State{Field: combo4.LeftI},
+				Current: tetris.T,
+				Preview: preview,
+				BagUsed: tetris.NewPieceSet(tetris.T, tetris.I, tetris.S),
+			},
+			want: 1 + previewLen,
+		},
+		{
+			desc: "current piece cannot be placed",
+			gState: GameState{
+				State:   combo4.State{Field: combo4.LeftI, Hold: tetris.I, SwapRestricted: true},
+				Current: tetris.O,
+				Preview: preview,
+				BagUsed: tetris.NewPieceSet(tetris.O, tetris.I, tetris.S),
+			},
+			want: 0,
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+			if _, got := mdp.value[test.gState]; got != test.inMDP {
+				t.Fatalf("got %v in the MDP=%t, want %t", test.gState, got, test.inMDP)
+			}
+			if got := mdp.ExpectedValue(test.gState); got != test.want {
+				t.Errorf("ExpectedValue(%v) got %v, want %v", test.gState, got, test.want)
+			}
+		})
+	}
+}
+
+func TestExactExpectedValue(t *testing.T) {
+	if testing.Short() {
+		t.Skip("ExactExpectedValue is slow for a large depth")
+	}
+	t.Parallel()
+
+	mdp, err := NewMDP(1)
+	if err != nil {
+		t.Fatalf("NewMDP: %v", err)
+	}
+	if err := mdp.Update(""); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	known := GameState{
+		State:   combo4.State{Field: combo4.LeftI, Hold: tetris.I},
+		Current: tetris.O,
+		Preview: tetris.MustSeq([]tetris.Piece{tetris.O}),
+	}
+	if got := ExactExpectedValue(mdp.nfa, known, 5); got != 1 {
+		t.Errorf("ExactExpectedValue got %.2f, want 1 for %v", got, known)
+	}
+
+	gState := GameState{
+		State: combo4.State{
+			Hold: tetris.J,
+			Field: combo4.NewField4x4([][4]bool{
+				{true, false, false, false},
+				{true, true, false, false},
+			}),
+		},
+		Current: tetris.S,
+		Preview: tetris.MustSeq([]tetris.Piece{tetris.O}),
+		BagUsed: tetris.NewPieceSet(tetris.O, tetris.S),
+	}
+	// The chance of surviving 400 more pieces is small enough that the
+	// truncated value is within 0.05 of the converged MDP.
+	want := mdp.ExpectedValue(gState)
+	if got := ExactExpectedValue(mdp.nfa, gState, 400); got > want+0.01 || got < want-0.05 {
+		t.Errorf("got ExactExpectedValue=%.3f, want %.3f", got, want)
+	}
+}
+
 func TestMDPUpdatePolicy(t *testing.T) {
 	t.Parallel()
 	mdp, err := NewMDP(0)
@@ -177,6 +579,137 @@ func TestMDPUpdatePolicy(t *testing.T) {
 	}
 }
 
+func TestMDPInitPolicyFrom(t *testing.T) {
+	t.Parallel()
+	seeded, err := NewMDP(0)
+	if err != nil {
+		t.Fatalf("NewMDP: %v", err)
+	}
+	initial := make(map[GameState]combo4.State, len(seeded.policy))
+	for gState, choice := range seeded.policy {
+		initial[gState] = choice
+	}
+	// The first next state instead of the one chosen by the NFAScorer. A
+	// State that is not a next state is replaced by the NFAScorer choice.
+	first := PolicyFunc(func(state combo4.State, current tetris.Piece, _ []tetris.Piece, _ tetris.PieceSet) *combo4.State {
+		if current == tetris.O {
+			return &combo4.State{}
+		}
+		return &seeded.nfa.NextStates(state, current)[0]
+	})
+	seeded.InitPolicyFrom(first)
+
+	var changed int
+	for gState, choice := range seeded.policy {
+		if gState.Current == tetris.O {
+			if choice != initial[gState] {
+				t.Fatalf("InitPolicyFrom with an invalid choice for %v got %v, want the NFAScorer choice %v", gState, choice, initial[gState])
+			}
+			continue
+		}
+		if want := seeded.nfa.NextStates(gState.State, gState.Current)[0]; choice != want {
+			t.Fatalf("InitPolicyFrom for %v got %v, want %v", gState, choice, want)
+		}
+		if choice != initial[gState] {
+			changed++
+		}
+	}
+	if changed == 0 {
+		t.Fatalf("InitPolicyFrom did not change the initial policy")
+	}
+
+	if err := seeded.Update(filepath.Join(t.TempDir(), "mdp.gob")); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if n := seeded.updatePolicy(); n != 0 {
+		t.Errorf("updatePolicy after Update changed %d choices, want it converged", n)
+	}
+	want, err := NewMDP(0)
+	if err != nil {
+		t.Fatalf("NewMDP: %v", err)
+	}
+	if err := want.Update(filepath.Join(t.TempDir(), "mdp.gob")); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	for gState, v := range want.value {
+		if got := seeded.value[gState]; math.Abs(got-v) > 0.01 {
+			t.Fatalf("got value %.4f when seeded, want %.4f for %+v", got, v, gState)
+		}
+	}
+}
+
+func TestMDPNoPreview(t *testing.T) {
+	t.Parallel()
+	mdp, err := NewMDP(0)
+	if err != nil {
+		t.Fatalf("NewMDP: %v", err)
+	}
+	for gState := range mdp.value {
+		if gState.Preview != 0 {
+			t.Fatalf("NewMDP(0) has %v with a preview", gState)
+		}
+		if len(mdp.nfa.NextStates(gState.State, gState.Current)) == 0 {
+			t.Fatalf("NewMDP(0) has %v where the current piece cannot be placed", gState)
+		}
+	}
+
+	gState := GameState{
+		State:   combo4.State{Field: combo4.LeftI, Hold: tetris.I},
+		Current: tetris.T,
+		BagUsed: tetris.NewPieceSet(tetris.I, tetris.T),
+	}
+	possibilities, _ := mdp.possibilities(gState, combo4.State{Field: combo4.LeftI, Hold: tetris.I}, nil)
+	for _, next := range possibilities {
+		if next.Current == tetris.EmptyPiece || next.Current == tetris.T || next.Current == tetris.I {
+			t.Errorf("got possibility %v, want the current piece drawn from bag %v", next, gState.BagUsed)
+		}
+	}
+
+	if err := mdp.Update(""); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	// After one update, a stable GameState is worth more than its own piece
+	// since the pieces after it can be consumed too.
+	if got := mdp.ExpectedValue(gState); got <= 1 {
+		t.Errorf("got ExpectedValue(%v)=%v, want more than 1", gState, got)
+	}
+
+	pol := mdp.Policy()
+	if got := pol.NextState(gState.State, gState.Current, []tetris.Piece{}, gState.BagUsed); got == nil {
+		t.Errorf("NextState with an empty preview got nil")
+	} else if want := mdp.policy[gState]; *got != want {
+		t.Errorf("NextState with an empty preview got %v, want %v", *got, want)
+	}
+	if stats := pol.(*MDPPolicy).Stats(); stats.Hits != 1 {
+		t.Errorf("got %d hits, want the NextState to be looked up", stats.Hits)
+	}
+}
+
+func TestMDPUpdatePolicyDeterministic(t *testing.T) {
+	t.Parallel()
+
+	first, err := NewMDP(1)
+	if err != nil {
+		t.Fatalf("NewMDP: %v", err)
+	}
+	second, err := NewMDP(1)
+	if err != nil {
+		t.Fatalf("NewMDP: %v", err)
+	}
+	// The concurrent sweeps in updateValues can give slightly different
+	// values so the second MDP starts from the first's values.
+	first.updateValues()
+	for gState, v := range first.value {
+		second.value[gState] = v
+	}
+	first.updatePolicy()
+	second.updatePolicy()
+
+	if diff := cmp.Diff(first.policy, second.policy); diff != "" {
+		t.Errorf("policy differs between two updates with the same values (-first +second):\n%s", diff)
+	}
+}
+
 func TestMDPGob(t *testing.T) {
 	t.Parallel()
 
@@ -208,6 +741,130 @@ func testMdpGobHelper(t *testing.T, mdp *MDP) {
 	if decoding.previewLen != mdp.previewLen {
 		t.Errorf("got previewLen=%d after decoding, want %d", decoding.previewLen, mdp.previewLen)
 	}
+	if decoding.riskAversion != mdp.riskAversion {
+		t.Errorf("got riskAversion=%v after decoding, want %v", decoding.riskAversion, mdp.riskAversion)
+	}
+	if diff := cmp.Diff(decoding.secondMoment, mdp.secondMoment); diff != "" {
+		t.Errorf("secondMoment map differs after decoding: (-want +got)\n:%v", diff)
+	}
+}
+
+func TestMDPGobOrphans(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		desc   string
+		update bool
+	}{
+		{desc: "initial values"},
+		{desc: "updated values", update: true},
+	} {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+			mdp, err := NewMDP(0)
+			if err != nil {
+				t.Fatalf("NewMDP: %v", err)
+			}
+			if test.update {
+				mdp.updateValues()
+			}
+			// No piece can be placed in a full field.
+			orphan := GameState{
+				State:   combo4.State{Field: combo4.Field4x4(0xFFFF), Hold: tetris.I},
+				Current: tetris.T,
+				BagUsed: tetris.NewPieceSet(tetris.T),
+			}
+			if next := mdp.nfa.NextStates(orphan.State, orphan.Current); len(next) != 0 {
+				t.Fatalf("the orphan GameState has next states %v", next)
+			}
+			want := make(map[GameState]float64, len(mdp.value))
+			for gState, v := range mdp.value {
+				want[gState] = v
+			}
+			mdp.value[orphan] = 1
+
+			encoding, err := mdp.GobEncode()
+			if err != nil {
+				t.Fatalf("GobEncode: %v", err)
+			}
+			decoded, err := NewMDPFromGob(encoding, MDPDecodeOptions{Verify: true})
+			if err != nil {
+				t.Fatalf("NewMDPFromGob: %v", err)
+			}
+			if diff := cmp.Diff(want, decoded.value); diff != "" {
+				t.Errorf("values after decoding mismatch (-want +got):\n%s", diff)
+			}
+			if _, ok := decoded.policy[orphan]; ok {
+				t.Errorf("the orphan GameState has a policy choice after decoding")
+			}
+		})
+	}
+}
+
+func TestMDPRiskAversion(t *testing.T) {
+	if testing.Short() {
+		t.Skip("training two previewLen=1 MDPs is slow")
+	}
+	t.Parallel()
+
+	// One round of training is enough for the policies to differ.
+	train := func(riskAversion float64) *MDP {
+		mdp, err := NewMDPWithOptions(1, MDPOptions{RiskAversion: riskAversion})
+		if err != nil {
+			t.Fatalf("NewMDPWithOptions: %v", err)
+		}
+		mdp.updateValues()
+		mdp.updatePolicy()
+		return mdp
+	}
+	neutral, averse := train(0), train(1)
+
+	var changed int
+	for gState, choice := range averse.policy {
+		if neutral.policy[gState] != choice {
+			changed++
+		}
+	}
+	if changed == 0 {
+		t.Fatalf("got the same policy with RiskAversion=1 as without")
+	}
+
+	// The mean drops too so the stddev is compared relative to the mean.
+	// With this many trials, the ratio varies by about 0.01 between seeds
+	// while RiskAversion=1 lowers it by about 0.04.
+	relStddev := func(mdp *MDP) float64 {
+		result := Evaluate(mdp.Policy(), EvalOptions{
+			Trials:         20000,
+			PiecesPerTrial: 1000,
+			PreviewSize:    1,
+			Rand:           rand.New(rand.NewSource(1)),
+		})
+		var sumSq float64
+		for _, consumed := range result.Consumed {
+			sumSq += math.Pow(float64(consumed)-result.Mean, 2)
+		}
+		return math.Sqrt(sumSq/float64(len(result.Consumed))) / result.Mean
+	}
+	if got, neutralRel := relStddev(averse), relStddev(neutral); got >= neutralRel {
+		t.Errorf("got a stddev of %.3f times the mean with RiskAversion=1, want less than %.3f without", got, neutralRel)
+	}
+
+	t.Run("gob", func(t *testing.T) { testMdpGobHelper(t, averse) })
+	t.Run("gob without RiskAversion", func(t *testing.T) {
+		// Decoding into an MDP with second moments drops them.
+		b, err := neutral.GobEncode()
+		if err != nil {
+			t.Fatalf("GobEncode: %v", err)
+		}
+		decoded := &MDP{riskAversion: averse.riskAversion, secondMoment: averse.secondMoment}
+		if err := decoded.GobDecode(b); err != nil {
+			t.Fatalf("GobDecode: %v", err)
+		}
+		if decoded.riskAversion != 0 || decoded.secondMoment != nil {
+			t.Errorf("got riskAversion=%v and %d second moments after decoding an MDP without them", decoded.riskAversion, len(decoded.secondMoment))
+		}
+	})
 }
 
 func TestMDPPolicyGob(t *testing.T) {
@@ -234,3 +891,571 @@ func TestMDPPolicyGob(t *testing.T) {
 		t.Errorf("value map differs after decoding: (-want +got)\n:%v", diff)
 	}
 }
+
+func TestMDPPolicyFallback(t *testing.T) {
+	t.Parallel()
+
+	mdp, err := NewMDP(0)
+	if err != nil {
+		t.Fatalf("NewMDP: %v", err)
+	}
+	encoding, err := mdp.Policy().(*MDPPolicy).GobEncode()
+	if err != nil {
+		t.Fatalf("GobEncode: %v", err)
+	}
+
+	// The MDP only contains GameStates without a preview so these use the
+	// fallback.
+	initial := combo4.State{Field: combo4.LeftI}
+	preview := []tetris.Piece{tetris.O}
+	bag := tetris.NewPieceSet(tetris.S, tetris.O)
+
+	decoded, err := NewMDPPolicyFromGob(encoding)
+	if err != nil {
+		t.Fatalf("NewMDPPolicyFromGob: %v", err)
+	}
+	if decoded.defaultPol != nil {
+		t.Errorf("got a default Policy after decoding, want it created on first use")
+	}
+	if got := decoded.NextState(initial, tetris.S, preview, bag); got == nil {
+		t.Errorf("NextState got nil, want a State from the default Policy")
+	}
+	if decoded.defaultPol == nil {
+		t.Errorf("got no default Policy after NextState")
+	}
+
+	moves, _ := combo4.AllContinuousMoves()
+	nfa := combo4.NewNFA(moves)
+	rec := &recordingPolicy{pol: FromScorer(nfa, NewNFAScorer(nfa, 3))}
+	overridden, err := NewMDPPolicyFromGob(encoding, WithFallback(rec))
+	if err != nil {
+		t.Fatalf("NewMDPPolicyFromGob: %v", err)
+	}
+	if overridden.NextState(initial, tetris.S, preview, bag) == nil {
+		t.Errorf("NextState got nil, want a State from the fallback")
+	}
+	if len(rec.calls) != 1 {
+		t.Errorf("got %d calls to the fallback, want 1", len(rec.calls))
+	}
+	if overridden.newDefault != nil {
+		t.Errorf("got a default Policy constructor after SetFallback, want nil")
+	}
+}
+
+func TestMDPPolicyStats(t *testing.T) {
+	t.Parallel()
+
+	mdp, err := NewMDP(0)
+	if err != nil {
+		t.Fatalf("NewMDP: %v", err)
+	}
+	encoding, err := mdp.Policy().(*MDPPolicy).GobEncode()
+	if err != nil {
+		t.Fatalf("GobEncode: %v", err)
+	}
+	pol, err := NewMDPPolicyFromGob(encoding)
+	if err != nil {
+		t.Fatalf("NewMDPPolicyFromGob: %v", err)
+	}
+
+	var known GameState
+	for gState := range mdp.policy {
+		known = gState
+		break
+	}
+	bag := tetris.NewPieceSet(tetris.S, tetris.O)
+	// Hit.
+	pol.NextState(known.State, known.Current, nil, known.BagUsed)
+	// Unknown state since the MDP only has states with a piece held.
+	pol.NextState(combo4.State{Field: combo4.LeftI}, tetris.S, nil, tetris.S.PieceSet())
+	// Preview too long.
+	pol.NextState(known.State, tetris.S, []tetris.Piece{tetris.O}, bag)
+	pol.NextState(known.State, tetris.S, []tetris.Piece{tetris.O}, bag)
+	// Invalid since O is not in the bag.
+	pol.NextState(known.State, tetris.S, []tetris.Piece{tetris.O}, tetris.S.PieceSet())
+
+	want := MDPPolicyStats{Hits: 1, UnknownState: 1, PreviewTooLong: 2, Invalid: 1}
+	if diff := cmp.Diff(want, pol.Stats()); diff != "" {
+		t.Errorf("Stats mismatch (-want +got):\n%s", diff)
+	}
+	if got := pol.Stats().HitRate(); got != 0.25 {
+		t.Errorf("HitRate got %v, want 0.25", got)
+	}
+
+	pol.Reset()
+	if diff := cmp.Diff(MDPPolicyStats{}, pol.Stats()); diff != "" {
+		t.Errorf("Stats after Reset mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestMDPOpenings(t *testing.T) {
+	t.Parallel()
+
+	mdp, err := NewMDPWithOptions(0, MDPOptions{Openings: true})
+	if err != nil {
+		t.Fatalf("NewMDPWithOptions: %v", err)
+	}
+	if err := mdp.Update(""); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if errs := mdp.Verify(); len(errs) > 0 {
+		t.Fatalf("Verify got %v", errs)
+	}
+
+	// The first piece of a game.
+	pol := mdp.Policy().(*MDPPolicy)
+	if got := pol.NextState(combo4.State{Field: combo4.LeftI}, tetris.S, nil, tetris.S.PieceSet()); got == nil {
+		t.Fatalf("NextState got nil for the start of a game")
+	}
+	if got := pol.Stats(); got.Hits != 1 {
+		t.Errorf("got Stats %+v for the start of a game, want a hit", got)
+	}
+
+	// The piece after holding the first piece.
+	restricted := combo4.State{Field: combo4.LeftI, Hold: tetris.S, SwapRestricted: true}
+	if _, ok := mdp.value[GameState{State: restricted, Current: tetris.T, BagUsed: tetris.NewPieceSet(tetris.S, tetris.T)}]; !ok {
+		t.Errorf("got no value for the swap restricted State %v", restricted)
+	}
+}
+
+func TestMDPNoHold(t *testing.T) {
+	t.Parallel()
+
+	mdp, err := NewMDPWithOptions(0, MDPOptions{NoHold: true})
+	if err != nil {
+		t.Fatalf("NewMDPWithOptions: %v", err)
+	}
+	if err := mdp.Update(""); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	for gState := range mdp.value {
+		if gState.State.Hold != tetris.EmptyPiece {
+			t.Fatalf("got GameState %v in a no hold MDP, want no Hold", gState)
+		}
+	}
+
+	encoding, err := mdp.CompressedPolicy().GobEncode()
+	if err != nil {
+		t.Fatalf("GobEncode: %v", err)
+	}
+	decoded := new(MDPPolicy)
+	if err := decoded.GobDecode(encoding); err != nil {
+		t.Fatalf("GobDecode: %v", err)
+	}
+	if !decoded.noHold {
+		t.Errorf("got noHold=false after decoding, want true")
+	}
+
+	moves, _ := combo4.AllContinuousMoves()
+	nfa := combo4.NewNFANoHold(moves)
+	policies := map[string]Policy{
+		"MDP":       decoded,
+		"NFAScorer": FromScorer(nfa, NewNFAScorer(nfa, 3)),
+	}
+	for name, pol := range policies {
+		queue := tetris.RandPieces(100)
+		game, err := NewGameFromField(pol, combo4.LeftI, queue[0], nil, NoHold())
+		if err != nil {
+			t.Fatalf("NewGameFromField: %v", err)
+		}
+		for _, p := range queue[1:] {
+			state, err := game.Step(p)
+			if err != nil {
+				t.Fatalf("%s: Step(%v): %v", name, p, err)
+			}
+			if state == nil {
+				break
+			}
+			if state.Hold != tetris.EmptyPiece {
+				t.Fatalf("%s: got State %v, want no Hold", name, state)
+			}
+		}
+	}
+}
+
+func TestMDPVerify(t *testing.T) {
+	t.Parallel()
+
+	mdp, err := NewMDP(0)
+	if err != nil {
+		t.Fatalf("NewMDP: %v", err)
+	}
+	if errs := mdp.Verify(); len(errs) > 0 {
+		t.Fatalf("Verify got %v for a valid MDP", errs)
+	}
+
+	b, err := mdp.GobEncode()
+	if err != nil {
+		t.Fatalf("GobEncode: %v", err)
+	}
+	if _, err := NewMDPFromGob(b, MDPDecodeOptions{Verify: true}); err != nil {
+		t.Fatalf("NewMDPFromGob: %v", err)
+	}
+
+	var valid GameState
+	for gState := range mdp.value {
+		valid = gState
+		break
+	}
+	corruptions := []struct {
+		desc    string
+		corrupt func(m *MDP)
+	}{
+		{
+			desc: "Wrong preview length",
+			corrupt: func(m *MDP) {
+				gState := valid
+				gState.Preview = tetris.MustSeq([]tetris.Piece{tetris.I})
+				m.value[gState] = 1
+				m.policy[gState] = m.policy[valid]
+			},
+		},
+		{
+			desc: "Current not in the bag",
+			corrupt: func(m *MDP) {
+				gState := valid
+				gState.BagUsed = gState.Current.PieceSet().Inverted()
+				m.value[gState] = 1
+				m.policy[gState] = m.policy[valid]
+			},
+		},
+		{
+			desc:    "Invalid value",
+			corrupt: func(m *MDP) { m.value[valid] = math.NaN() },
+		},
+		{
+			desc: "Impossible choice",
+			corrupt: func(m *MDP) {
+				m.policy[valid] = combo4.State{Field: combo4.NewField4x4([][4]bool{
+					{true, true, true, true},
+					{true, true, true, true},
+				})}
+			},
+		},
+		{
+			desc:    "Missing choice",
+			corrupt: func(m *MDP) { delete(m.policy, valid) },
+		},
+	}
+	for _, c := range corruptions {
+		corrupted := &MDP{
+			nfa:        mdp.nfa,
+			previewLen: mdp.previewLen,
+			policy:     make(map[GameState]combo4.State, len(mdp.policy)),
+			value:      make(map[GameState]float64, len(mdp.value)),
+		}
+		for gState, choice := range mdp.policy {
+			corrupted.policy[gState] = choice
+		}
+		for gState, v := range mdp.value {
+			corrupted.value[gState] = v
+		}
+		c.corrupt(corrupted)
+		if errs := corrupted.Verify(); len(errs) != 1 {
+			t.Errorf("%s: Verify got %d errors, want 1: %v", c.desc, len(errs), errs)
+		}
+	}
+}
+
+func TestMDPPolicyValidate(t *testing.T) {
+	t.Parallel()
+
+	mdp, err := NewMDP(0)
+	if err != nil {
+		t.Fatalf("NewMDP: %v", err)
+	}
+	policy := (mdp.Policy()).(*MDPPolicy)
+	if err := policy.Validate(mdp.nfa); err != nil {
+		t.Fatalf("Validate got %v for a valid policy", err)
+	}
+
+	// Corrupt an entry with a State that cannot be reached.
+	corrupted := &MDPPolicy{policy: make(map[GameState]combo4.State, len(policy.policy))}
+	for gState, choice := range policy.policy {
+		corrupted.policy[gState] = choice
+	}
+	for gState := range corrupted.policy {
+		corrupted.policy[gState] = combo4.State{Field: combo4.NewField4x4([][4]bool{
+			{true, true, true, true},
+			{true, true, true, true},
+		})}
+		break
+	}
+	if err := corrupted.Validate(mdp.nfa); err == nil {
+		t.Errorf("Validate got no error for a corrupted policy")
+	}
+}
+
+func TestGameStateString(t *testing.T) {
+	gState := GameState{
+		State: combo4.State{
+			Hold: tetris.J,
+			Field: combo4.NewField4x4([][4]bool{
+				{true, false, false, false},
+				{true, true, false, false},
+			}),
+		},
+		Current: tetris.S,
+		Preview: tetris.MustSeq(tetris.SeqFromStr("OIT")),
+		BagUsed: tetris.NewPieceSet(tetris.S, tetris.O, tetris.I, tetris.T),
+	}
+
+	if got, want := gState.String(), "field=□___/□□__ hold=J cur=S preview=OIT bag=[T S O I]"; got != want {
+		t.Errorf("String() got %q, want %q", got, want)
+	}
+
+	want := "Hold: J\nSwapRestricted: false\nCurrent: S\nPreview: OIT\nBag: [T S O I]\nField:\n□___\n□□__\n"
+	if diff := cmp.Diff(want, gState.Verbose()); diff != "" {
+		t.Errorf("Verbose() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestGameStateBagPhase(t *testing.T) {
+	t.Parallel()
+
+	for phase := 0; phase <= 7; phase++ {
+		gState := GameState{BagUsed: tetris.NewPieceSet(tetris.NonemptyPieces[:phase]...)}
+		wantPhase := phase % 7
+		if got := gState.BagPhase(); got != wantPhase {
+			t.Errorf("BagPhase() with %v got %d, want %d", gState.BagUsed, got, wantPhase)
+		}
+		want := tetris.NonemptyPieces[wantPhase:]
+		if diff := cmp.Diff(tetris.NewPieceSet(want...), tetris.NewPieceSet(gState.NextBagPieces()...)); diff != "" {
+			t.Errorf("NextBagPieces() with %v mismatch (-want +got):\n%s", gState.BagUsed, diff)
+		}
+	}
+}
+
+func TestGameStateValidate(t *testing.T) {
+	tests := []struct {
+		desc       string
+		current    tetris.Piece
+		preview    []tetris.Piece
+		bagUsed    tetris.PieceSet
+		previewLen int
+		wantErr    bool
+	}{
+		{
+			desc:       "valid",
+			current:    tetris.S,
+			preview:    tetris.SeqFromStr("OI"),
+			bagUsed:    tetris.NewPieceSet(tetris.T, tetris.S, tetris.O, tetris.I),
+			previewLen: 2,
+		},
+		{
+			desc:       "valid across bags",
+			current:    tetris.S,
+			preview:    tetris.SeqFromStr("OS"),
+			bagUsed:    tetris.S.PieceSet(),
+			previewLen: 2,
+		},
+		{
+			desc:       "empty bag is full",
+			current:    tetris.S,
+			preview:    tetris.SeqFromStr("OI"),
+			previewLen: 2,
+		},
+		{
+			desc:       "no current piece",
+			current:    tetris.EmptyPiece,
+			preview:    tetris.SeqFromStr("OI"),
+			bagUsed:    tetris.NewPieceSet(tetris.O, tetris.I),
+			previewLen: 2,
+			wantErr:    true,
+		},
+		{
+			desc:       "wrong preview length",
+			current:    tetris.S,
+			preview:    tetris.SeqFromStr("OI"),
+			bagUsed:    tetris.NewPieceSet(tetris.S, tetris.O, tetris.I),
+			previewLen: 3,
+			wantErr:    true,
+		},
+		{
+			desc:       "preview piece not in bag",
+			current:    tetris.S,
+			preview:    tetris.SeqFromStr("OI"),
+			bagUsed:    tetris.NewPieceSet(tetris.S, tetris.O),
+			previewLen: 2,
+			wantErr:    true,
+		},
+		{
+			desc:       "repeated piece without a new bag",
+			current:    tetris.S,
+			preview:    tetris.SeqFromStr("OS"),
+			bagUsed:    tetris.NewPieceSet(tetris.T, tetris.S, tetris.O),
+			previewLen: 2,
+			wantErr:    true,
+		},
+		{
+			desc:       "8 pieces without a new bag",
+			current:    tetris.I,
+			preview:    tetris.SeqFromStr("TOSZJLI"),
+			bagUsed:    tetris.NewPieceSet(tetris.T, tetris.O, tetris.S, tetris.Z, tetris.J, tetris.L),
+			previewLen: 7,
+			wantErr:    true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			gState := GameState{
+				Current: test.current,
+				Preview: tetris.MustSeq(test.preview),
+				BagUsed: test.bagUsed,
+			}
+			if err := gState.Validate(test.previewLen); (err != nil) != test.wantErr {
+				t.Errorf("Validate(%d) got err=%v, want error %t", test.previewLen, err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestMDPPolicyNextStateInvalid(t *testing.T) {
+	moves, _ := combo4.AllContinuousMoves()
+	nfa := combo4.NewNFA(moves)
+	pol := &MDPPolicy{
+		policy:     map[GameState]combo4.State{},
+		defaultPol: FromScorer(nfa, NewNFAScorer(nfa, 3)),
+	}
+	initial := combo4.State{Field: combo4.LeftI}
+
+	if got := pol.NextState(initial, tetris.S, tetris.SeqFromStr("OI"), tetris.NewPieceSet(tetris.S, tetris.O, tetris.I)); got == nil {
+		t.Errorf("NextState got nil for a valid GameState")
+	}
+	for _, preview := range [][]tetris.Piece{
+		{tetris.O, tetris.EmptyPiece},
+		tetris.SeqFromStr("TOSZJLIS"),
+		tetris.SeqFromStr("TOSZJLISO"),
+	} {
+		if got := pol.NextState(initial, tetris.S, preview, tetris.NewPieceSet(tetris.NonemptyPieces[:]...)); got != nil {
+			t.Errorf("NextState with preview %v got %v, want nil", preview, got)
+		}
+	}
+}
+
+func TestMDPPolicyPartialPreview(t *testing.T) {
+	t.Parallel()
+
+	mdp, err := NewMDP(1)
+	if err != nil {
+		t.Fatalf("NewMDP: %v", err)
+	}
+	mdp.updateValues()
+	mdp.updatePolicy()
+	pol := mdp.Policy().(*MDPPolicy)
+
+	r := rand.New(rand.NewSource(1))
+	var checked int
+	for gState := range mdp.policy {
+		if r.Intn(200) != 0 {
+			continue
+		}
+		if _, err := newValidGameState(gState.State, gState.Current, nil, gState.BagUsed); err != nil {
+			continue
+		}
+		// Count the trained choices for every piece that can come next.
+		votes := make(map[combo4.State]int)
+		bag := gState.BagUsed
+		if bag.IsFullBag() {
+			bag = 0
+		}
+		for _, p := range bag.Inverted().Slice() {
+			full := GameState{
+				State:   gState.State,
+				Current: gState.Current,
+				Preview: tetris.MustSeq([]tetris.Piece{p}),
+				BagUsed: bag.Add(p),
+			}
+			if next, ok := mdp.policy[full]; ok {
+				votes[next]++
+			}
+		}
+		if len(votes) == 0 {
+			continue
+		}
+		var bestVotes int
+		for _, n := range votes {
+			if n > bestVotes {
+				bestVotes = n
+			}
+		}
+
+		got := pol.NextState(gState.State, gState.Current, nil, gState.BagUsed)
+		if got == nil {
+			t.Fatalf("NextState(%v) with no preview got nil", gState)
+		}
+		if votes[*got] != bestVotes {
+			t.Errorf("NextState(%v) with no preview got %v chosen for %d pieces, want a choice for %d", gState, got, votes[*got], bestVotes)
+		}
+		checked++
+	}
+	if checked == 0 {
+		t.Fatal("no GameStates were checked")
+	}
+	if got := pol.Stats().PartialPreview; got != int64(checked) {
+		t.Errorf("Stats().PartialPreview got %d, want %d", got, checked)
+	}
+}
+
+func TestMDPConvergence(t *testing.T) {
+	t.Parallel()
+	mdp, err := NewMDPWithOptions(0, MDPOptions{MaxSweeps: 2})
+	if err != nil {
+		t.Fatalf("NewMDPWithOptions: %v", err)
+	}
+	if mdp.Converged() || !math.IsInf(mdp.ConvergenceInfo().Residual, 1) {
+		t.Errorf("got %+v before training, want an infinite residual", mdp.ConvergenceInfo())
+	}
+
+	path := filepath.Join(t.TempDir(), "mdp.gob")
+	if err := mdp.Update(path); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	info := mdp.ConvergenceInfo()
+	if mdp.Converged() || info.Unconverged == 0 || len(info.History) != 2 {
+		t.Fatalf("got %+v after 2 sweeps, want 2 sweeps that have not converged", info)
+	}
+	if info.History[1].Residual >= info.History[0].Residual {
+		t.Errorf("got residuals %+v, want them to shrink", info.History)
+	}
+	var gState GameState
+	for gState = range mdp.value {
+		break
+	}
+	if _, residual := mdp.ExpectedValueWithResidual(gState); residual != info.Residual {
+		t.Errorf("ExpectedValueWithResidual(%v) got residual %v, want %v", gState, residual, info.Residual)
+	}
+	// The values of GameStates not in the MDP are only lower bounds.
+	missing := GameState{State: combo4.State{Field: combo4.LeftI}, Current: tetris.T, BagUsed: tetris.T.PieceSet()}
+	if _, residual := mdp.ExpectedValueWithResidual(missing); !math.IsInf(residual, 1) {
+		t.Errorf("ExpectedValueWithResidual(%v) got residual %v, want +Inf", missing, residual)
+	}
+	if err := mdp.CheckConverged(); !errors.Is(err, ErrNotConverged) {
+		t.Errorf("CheckConverged got err=%v, want %v", err, ErrNotConverged)
+	}
+
+	// The residual of the last sweep is kept in the encoding.
+	bytes, err := ReadGobFile(path)
+	if err != nil {
+		t.Fatalf("ReadGobFile: %v", err)
+	}
+	decoded, err := NewMDPFromGob(bytes, MDPDecodeOptions{})
+	if err != nil {
+		t.Fatalf("NewMDPFromGob: %v", err)
+	}
+	if diff := cmp.Diff(info.SweepStats, decoded.ConvergenceInfo().SweepStats); diff != "" {
+		t.Errorf("decoded ConvergenceInfo mismatch (-want +got):\n%s", diff)
+	}
+
+	decoded.SetMaxSweeps(0)
+	if err := decoded.Update(""); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	info = decoded.ConvergenceInfo()
+	if !decoded.Converged() || info.Unconverged != 0 || decoded.CheckConverged() != nil {
+		t.Errorf("got %+v after training without MaxSweeps, want it converged", info)
+	}
+	if first := info.History[0]; first.Residual <= info.Residual {
+		t.Errorf("got a first residual of %v, want more than the last %v", first.Residual, info.Residual)
+	}
+}
@@ -0,0 +1,64 @@
+package policy
+
+import (
+	"tetris"
+	"tetris/combo4"
+)
+
+// BestNextPiece returns the piece after the preview that the Policy plays the
+// best with. bag is the bag state after the last piece in the preview and
+// only the pieces that can be drawn from it are considered.
+//
+// Each candidate is added to the end of the preview and the Policy plays
+// every known piece like in PlanMoves. The candidate where the most pieces
+// are consumed is the best. Ties are broken by the number of pieces that can
+// be drawn after the candidate that the Policy can still play from the last
+// State and then by the order of the pieces.
+//
+// BestNextPiece returns EmptyPiece if the pieces do not pass
+// GameState.Validate like in ResumeGame or the preview already has 8 pieces.
+func BestNextPiece(pol Policy, state combo4.State, current tetris.Piece, preview []tetris.Piece, bag tetris.PieceSet) tetris.Piece {
+	if len(preview) >= 8 {
+		return tetris.EmptyPiece
+	}
+	if _, err := newValidGameState(state, current, preview, bag); err != nil {
+		return tetris.EmptyPiece
+	}
+	if bag.IsFullBag() {
+		bag = 0
+	}
+
+	var (
+		best                         = tetris.EmptyPiece
+		bestConsumed, bestFollowedBy = -1, -1
+	)
+	for _, p := range bag.Inverted().Slice() {
+		withNext := append(append(make([]tetris.Piece, 0, len(preview)+1), preview...), p)
+		nextBag := bag.Add(p)
+		plan, _ := PlanMoves(pol, state, current, withNext, nextBag, len(withNext)+1)
+
+		followedBy := -1
+		if len(plan) == len(withNext)+1 {
+			followedBy = numPlayable(pol, plan[len(plan)-1], nextBag)
+		}
+		if len(plan) > bestConsumed || len(plan) == bestConsumed && followedBy > bestFollowedBy {
+			best, bestConsumed, bestFollowedBy = p, len(plan), followedBy
+		}
+	}
+	return best
+}
+
+// numPlayable returns the number of pieces that can be drawn after the bag
+// which the Policy can play from the State.
+func numPlayable(pol Policy, state combo4.State, bag tetris.PieceSet) int {
+	if bag.IsFullBag() {
+		bag = 0
+	}
+	var n int
+	for _, p := range bag.Inverted().Slice() {
+		if pol.NextState(state, p, nil, bag.Add(p)) != nil {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,79 @@
+package policy
+
+import (
+	"math/rand"
+	"testing"
+	"tetris"
+	"tetris/combo4"
+)
+
+func TestBestNextPiece(t *testing.T) {
+	t.Parallel()
+
+	moves, _ := combo4.AllContinuousMoves()
+	nfa := combo4.NewNFA(moves)
+	pol := FromScorer(nfa, NewNFAScorer(nfa, 3))
+	r := rand.New(rand.NewSource(1))
+
+	consumed := func(state combo4.State, current tetris.Piece, preview []tetris.Piece, bag tetris.PieceSet, next tetris.Piece) int {
+		if bag.IsFullBag() {
+			bag = 0
+		}
+		withNext := append(append([]tetris.Piece(nil), preview...), next)
+		plan, _ := PlanMoves(pol, state, current, withNext, bag.Add(next), len(withNext)+1)
+		return len(plan)
+	}
+
+	const previewLen = 2
+	var checked int
+	for trial := 0; trial < 200; trial++ {
+		queue := tetris.RandPiecesFrom(r, 20)
+		// Play some of the queue to get to a random State and bag.
+		state := combo4.State{Field: combo4.LeftI}
+		var bag tetris.PieceSet
+		for _, p := range queue[:previewLen+1] {
+			if bag.IsFullBag() {
+				bag = 0
+			}
+			bag = bag.Add(p)
+		}
+		played := r.Intn(10)
+		for i := 0; i < played; i++ {
+			next := pol.NextState(state, queue[i], queue[i+1:i+1+previewLen], bag)
+			if next == nil {
+				break
+			}
+			state = *next
+			if bag.IsFullBag() {
+				bag = 0
+			}
+			bag = bag.Add(queue[i+1+previewLen])
+		}
+		current, preview := queue[played], queue[played+1:played+1+previewLen]
+		if _, err := newValidGameState(state, current, preview, bag); err != nil {
+			continue
+		}
+
+		got := BestNextPiece(pol, state, current, preview, bag)
+		legal := bag
+		if legal.IsFullBag() {
+			legal = 0
+		}
+		candidates := legal.Inverted().Slice()
+		if legal.Contains(got) || got == tetris.EmptyPiece {
+			t.Fatalf("BestNextPiece(%v, %v, %v, %v) got %v which is not in the bag", state, current, preview, bag, got)
+		}
+		checked++
+		random := candidates[r.Intn(len(candidates))]
+		if gotN, randomN := consumed(state, current, preview, bag, got), consumed(state, current, preview, bag, random); gotN < randomN {
+			t.Errorf("BestNextPiece(%v, %v, %v, %v) got %v consuming %d pieces, want at least the %d of %v", state, current, preview, bag, got, gotN, randomN, random)
+		}
+	}
+	if checked < 100 {
+		t.Errorf("checked %d States, want at least 100", checked)
+	}
+
+	if got := BestNextPiece(pol, combo4.State{Field: combo4.LeftI}, tetris.S, []tetris.Piece{tetris.O}, tetris.S.PieceSet()); got != tetris.EmptyPiece {
+		t.Errorf("BestNextPiece with O not in the bag got %v, want EmptyPiece", got)
+	}
+}
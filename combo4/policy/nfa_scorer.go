@@ -1,7 +1,11 @@
 package policy
 
 import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
 	"sort"
+	"sync"
 	"tetris"
 	"tetris/combo4"
 )
@@ -18,79 +22,238 @@ type NFAScorer struct {
 	inviable map[combo4.State]*tetris.SeqSet
 	// Precompute the size of each inviable SeqSet for each state.
 	inviableSizes map[combo4.State]int
-}
 
-// Score looks at the next pieces and all permutations of length permLen after
-// the next pieces and sees which ones an NFA could solve.
-func (s *NFAScorer) Score(state combo4.State, next []tetris.Piece, bagUsed tetris.PieceSet) int64 {
-	tuple := s.scoreTuple(state, next, bagUsed)
+	// If shareMirrors is true, inviable and inviableSizes only have one
+	// State of each pair of mirrored States. The inviable SeqSets of the
+	// other States are added to mirrored the first time one is needed.
+	shareMirrors bool
+	mirrorOnce   sync.Once
+	mirrored     map[combo4.State]*tetris.SeqSet
 
-	// Score by (in order of importance)
-	// 1) The number of elements consumed. (must be less than 2^13=8192)
-	// 2) The viable/inviable permutations (must be less than 2^40)
-	// 3) The number of states.            (must be less than 2^10=1024)
-	return int64(tuple.consumed<<50) - int64(tuple.invalidPermutations<<10) + int64(tuple.numStates)
+	// The inviable permutations of each length from 1 to permLen at the
+	// index of the length minus 1 or nil without KeepLevels.
+	levels []map[combo4.State]*tetris.SeqSet
 }
 
-type scoreTuple struct {
-	consumed            int
-	invalidPermutations int
-	numStates           int
+// NFAScorerOptions configures NewNFAScorerWithOptions.
+type NFAScorerOptions struct {
+	// ShareMirrors only generates the inviable permutations of one State of
+	// each pair of mirrored States. The inviable permutations of the other
+	// State are the mirror of them and are only created if they are needed.
+	// The NFA must give mirrored next States for mirrored States and pieces,
+	// which is true for the NFAs from combo4.AllContinuousMoves.
+	ShareMirrors bool
+	// KeepLevels keeps the inviable permutations of every length up to
+	// permLen so AtPermLen can return the NFAScorers of shorter lengths and
+	// Extend keeps them too. This takes more memory since only two lengths
+	// are kept otherwise.
+	KeepLevels bool
 }
 
-func (s *NFAScorer) scoreTuple(state combo4.State, next []tetris.Piece, bagUsed tetris.PieceSet) scoreTuple {
-	endStates, consumed := s.nfa.EndStates(combo4.NewStateSet(state), next)
+// Score looks at the next pieces and all permutations of length permLen after
+// the next pieces and sees which ones an NFA could solve.
+func (s *NFAScorer) Score(state combo4.State, next []tetris.Piece, bagUsed tetris.PieceSet) ScoreBreakdown {
+	scratch := scoreScratchPool.Get().(*scoreScratch)
+	defer scoreScratchPool.Put(scratch)
+	for key := range scratch.initial {
+		delete(scratch.initial, key)
+	}
+	scratch.initial[state] = true
+	endStates, consumed := s.nfa.EndStatesInto(&scratch.endStates, scratch.initial, next)
 
-	score := scoreTuple{
-		consumed:  consumed,
-		numStates: len(endStates),
+	score := ScoreBreakdown{
+		Consumed:  consumed,
+		NumStates: len(endStates),
 	}
 	if consumed == len(next) {
-		score.invalidPermutations = s.inviableSeqs(endStates, bagUsed)
+		score.Inviable = s.inviableSeqs(endStates, bagUsed)
 	}
 	return score
 }
 
+// NormalizedScore maps the Score to [0, 1] for a CompositeScorer. The
+// situations where the next pieces cannot all be consumed are below 0.5 by
+// the fraction consumed and the others are above it by the fraction of the
+// permutations after the next pieces that are viable. NumStates is not
+// used so some scores that differ are equal.
+func (s *NFAScorer) NormalizedScore(state combo4.State, next []tetris.Piece, bagUsed tetris.PieceSet) float64 {
+	score := s.Score(state, next, bagUsed)
+	if score.Consumed < len(next) {
+		return 0.5 * float64(score.Consumed) / float64(len(next))
+	}
+	total := tetris.Permutations(bagUsed).Size(s.permLen)
+	if total == 0 {
+		return 1
+	}
+	return 0.5 + 0.5*float64(total-score.Inviable)/float64(total)
+}
+
+// maxInviable is the bound on the number of inviable permutations for
+// ScoreBreakdown.Value.
+const maxInviable = 1 << 40
+
+// maxPermLen returns the largest permLen where the number of inviable
+// permutations always fits in Score. The inviable permutations are bounded
+// by the permutations of a 7 bag randomizer which is about 1.3*10^11 for a
+// permLen of 20.
+func maxPermLen() int {
+	for permLen := 1; ; permLen++ {
+		for _, bag := range tetris.AllPieceSets() {
+			if tetris.Permutations(bag).Size(permLen) >= maxInviable {
+				return permLen - 1
+			}
+		}
+	}
+}
+
+// scoreScratch holds the StateSets reused by Score.
+type scoreScratch struct {
+	initial   combo4.StateSet
+	endStates combo4.EndStatesScratch
+}
+
+// scoreScratchPool gives each goroutine calling Score its own scoreScratch.
+var scoreScratchPool = sync.Pool{
+	New: func() interface{} {
+		return &scoreScratch{initial: make(combo4.StateSet, 1)}
+	},
+}
+
 func (s *NFAScorer) inviableSeqs(endStates combo4.StateSet, bagUsed tetris.PieceSet) int {
 	// Try the states with the least failures first to reduce the set.
 	states := endStates.Slice()
-	sort.Slice(states, func(i, j int) bool { return s.inviableSizes[states[i]] < s.inviableSizes[states[j]] })
+	sort.Slice(states, func(i, j int) bool { return s.inviableSize(states[i]) < s.inviableSize(states[j]) })
 
 	inviableForAll := tetris.Permutations(bagUsed)
 	for _, state := range states {
-		inviableForState, ok := s.inviable[state]
+		inviableForState, ok := s.inviableFor(state)
 		if !ok {
 			// The State is not one of the expected states. Assume everything
 			// will fail.
 			continue
 		}
 		inviableForAll = inviableForAll.Intersection(inviableForState)
+		if inviableForAll.IsEmpty() {
+			// No other State can make a sequence inviable again.
+			return 0
+		}
 	}
 	// Score by the number of inviable sequences.
 	return inviableForAll.Size(s.permLen)
 }
 
+// inviableFor returns the inviable permutations of the State or false if the
+// State is not one of the expected states.
+func (s *NFAScorer) inviableFor(state combo4.State) (*tetris.SeqSet, bool) {
+	if inviable, ok := s.inviable[state]; ok || !s.shareMirrors {
+		return inviable, ok
+	}
+	s.mirrorOnce.Do(s.addMirrors)
+	inviable, ok := s.mirrored[state]
+	return inviable, ok
+}
+
+// inviableSize returns the number of inviable permutations of the State.
+// Mirrored States have the same size.
+func (s *NFAScorer) inviableSize(state combo4.State) int {
+	if size, ok := s.inviableSizes[state]; ok || !s.shareMirrors {
+		return size
+	}
+	return s.inviableSizes[state.Mirror()]
+}
+
+// addMirrors sets mirrored to the inviable permutations of the States that
+// are not in inviable.
+func (s *NFAScorer) addMirrors() {
+	s.mirrored = mirrorInviable(s.nfa, s.inviable)
+}
+
+// mirrorInviable returns the inviable permutations of the mirror of each
+// State that is in the NFA but not already in inviable.
+func mirrorInviable(nfa *combo4.NFA, inviable map[combo4.State]*tetris.SeqSet) map[combo4.State]*tetris.SeqSet {
+	var (
+		states []combo4.State
+		sets   []*tetris.SeqSet
+	)
+	for state, set := range inviable {
+		if _, ok := inviable[state.Mirror()]; !ok && nfa.HasState(state.Mirror()) {
+			states = append(states, state.Mirror())
+			sets = append(sets, set)
+		}
+	}
+	mirrored := make(map[combo4.State]*tetris.SeqSet, len(states))
+	for idx, set := range tetris.MirrorSeqSets(sets) {
+		mirrored[states[idx]] = set
+	}
+	return mirrored
+}
+
+// isMirrorCanonical returns whether the inviable permutations of the State
+// are generated instead of mirrored with ShareMirrors.
+func isMirrorCanonical(nfa *combo4.NFA, state combo4.State) bool {
+	mirror := state.Mirror()
+	return !mirror.Less(state) || !nfa.HasState(mirror)
+}
+
 type stateInviable struct {
 	state    combo4.State
 	inviable *tetris.SeqSet
 }
 
 // NewNFAScorer creates a new Scorer based on permutations of the specified length.
+//
+// Only two lengths of inviable permutations are kept in memory at a time
+// unless the KeepLevels option is used.
+// Since SeqSets share their sub SeqSets, the memory grows with the number of
+// distinct inviable prefixes rather than the number of permutations.
 func NewNFAScorer(nfa *combo4.NFA, permLen int) *NFAScorer {
+	return NewNFAScorerWithOptions(nfa, permLen, NFAScorerOptions{})
+}
+
+// NewNFAScorerWithOptions is like NewNFAScorer but configured by
+// NFAScorerOptions.
+func NewNFAScorerWithOptions(nfa *combo4.NFA, permLen int, opts NFAScorerOptions) *NFAScorer {
 	states := nfa.States().Slice()
 	if len(states) > 2<<10 {
 		panic("Too many possible states to generate a score")
 	}
+	if opts.ShareMirrors {
+		var canonical []combo4.State
+		for _, state := range states {
+			if isMirrorCanonical(nfa, state) {
+				canonical = append(canonical, state)
+			}
+		}
+		states = canonical
+	}
+	if max := maxPermLen(); permLen > max {
+		panic(fmt.Sprintf("permLen=%d is over the maximum of %d", permLen, max))
+	}
 
-	ch := make(chan stateInviable, len(states))
-
-	// Base case on prevInviable is all sequences of length 0 that are inviable
-	// (everything is viable).
-	prevInviable := make(map[combo4.State]*tetris.SeqSet, len(states))
-	inviable := make(map[combo4.State]*tetris.SeqSet, len(states))
+	// Base case on the inviable sequences of length 0 is that everything
+	// is viable.
+	inviable, levels := buildInviable(nfa, states, make(map[combo4.State]*tetris.SeqSet), 0, permLen, opts)
+	return &NFAScorer{
+		nfa:           nfa,
+		permLen:       permLen,
+		inviable:      inviable,
+		inviableSizes: genSizes(inviable, permLen),
+		shareMirrors:  opts.ShareMirrors,
+		levels:        levels,
+	}
+}
 
-	for n := 1; n <= permLen; n++ {
-		prevInviable, inviable = inviable, prevInviable
+// buildInviable generates the inviable sequences of the States from length
+// fromLen+1 to toLen from the inviable sequences of every State of length
+// fromLen. With ShareMirrors, states only has the canonical States and the
+// result only has those. With KeepLevels, it also returns the inviable
+// sequences of each length after fromLen.
+func buildInviable(nfa *combo4.NFA, states []combo4.State, prevInviable map[combo4.State]*tetris.SeqSet, fromLen, toLen int, opts NFAScorerOptions) (map[combo4.State]*tetris.SeqSet, []map[combo4.State]*tetris.SeqSet) {
+	ch := make(chan stateInviable, len(states))
+	inviable := prevInviable
+	var levels []map[combo4.State]*tetris.SeqSet
+	for n := fromLen + 1; n <= toLen; n++ {
+		prevInviable, inviable = inviable, make(map[combo4.State]*tetris.SeqSet, len(prevInviable))
 
 		// Generate the inviable sequences of length n based on the inviable
 		// sequences of length n-1.
@@ -112,13 +275,91 @@ func NewNFAScorer(nfa *combo4.NFA, permLen int) *NFAScorer {
 			si := <-ch
 			inviable[si.state] = si.inviable
 		}
+		if opts.KeepLevels {
+			levels = append(levels, inviable)
+		}
+		if opts.ShareMirrors && n < toLen {
+			// The next length needs the inviable sequences of every State.
+			// A kept level then also has the mirrors.
+			for state, set := range mirrorInviable(nfa, inviable) {
+				inviable[state] = set
+			}
+		}
+	}
+	return inviable, levels
+}
+
+// Extend returns an NFAScorer for the longer toPermLen that starts from the
+// inviable permutations of the NFAScorer instead of length 0 so only the
+// additional lengths are generated. The NFAScorer is not changed and the
+// result has the same options. It returns an error if toPermLen is shorter
+// than the permLen of the NFAScorer or over the maximum.
+func (s *NFAScorer) Extend(toPermLen int) (*NFAScorer, error) {
+	if toPermLen < s.permLen {
+		return nil, fmt.Errorf("cannot extend an NFAScorer of permLen=%d to the shorter %d", s.permLen, toPermLen)
+	}
+	if max := maxPermLen(); toPermLen > max {
+		return nil, fmt.Errorf("permLen=%d is over the maximum of %d", toPermLen, max)
+	}
+	if s.shareMirrors {
+		s.mirrorOnce.Do(s.addMirrors)
+	}
+	states := make([]combo4.State, 0, len(s.inviable))
+	prevInviable := make(map[combo4.State]*tetris.SeqSet, len(s.inviable)+len(s.mirrored))
+	for state, set := range s.inviable {
+		states = append(states, state)
+		prevInviable[state] = set
+	}
+	for state, set := range s.mirrored {
+		prevInviable[state] = set
+	}
+
+	opts := NFAScorerOptions{ShareMirrors: s.shareMirrors, KeepLevels: s.levels != nil}
+	inviable, levels := buildInviable(s.nfa, states, prevInviable, s.permLen, toPermLen, opts)
+	if toPermLen == s.permLen {
+		inviable = s.inviable
+	}
+	extended := &NFAScorer{
+		nfa:           s.nfa,
+		permLen:       toPermLen,
+		inviable:      inviable,
+		inviableSizes: genSizes(inviable, toPermLen),
+		shareMirrors:  s.shareMirrors,
+	}
+	if s.levels != nil {
+		extended.levels = append(append([]map[combo4.State]*tetris.SeqSet(nil), s.levels...), levels...)
+	}
+	return extended, nil
+}
+
+// AtPermLen returns an NFAScorer for the permLen from the levels kept by the
+// KeepLevels option without generating them again. It returns an error if
+// the levels were not kept or permLen is not from 1 to the permLen of the
+// NFAScorer.
+func (s *NFAScorer) AtPermLen(permLen int) (*NFAScorer, error) {
+	if permLen == s.permLen {
+		return s, nil
+	}
+	if s.levels == nil {
+		return nil, fmt.Errorf("the NFAScorer of permLen=%d did not keep its levels", s.permLen)
+	}
+	if permLen < 1 || permLen > s.permLen {
+		return nil, fmt.Errorf("permLen=%d is not from 1 to %d", permLen, s.permLen)
+	}
+	inviable := make(map[combo4.State]*tetris.SeqSet, len(s.levels[permLen-1]))
+	for state, set := range s.levels[permLen-1] {
+		if !s.shareMirrors || isMirrorCanonical(s.nfa, state) {
+			inviable[state] = set
+		}
 	}
 	return &NFAScorer{
-		nfa:           nfa,
+		nfa:           s.nfa,
 		permLen:       permLen,
 		inviable:      inviable,
 		inviableSizes: genSizes(inviable, permLen),
-	}
+		shareMirrors:  s.shareMirrors,
+		levels:        s.levels[:permLen:permLen],
+	}, nil
 }
 
 func genSizes(inviable map[combo4.State]*tetris.SeqSet, permLen int) map[combo4.State]int {
@@ -128,3 +369,102 @@ func genSizes(inviable map[combo4.State]*tetris.SeqSet, permLen int) map[combo4.
 	}
 	return sizes
 }
+
+// GobEncode returns a Gob encoding of an NFAScorer. The NFA is not encoded.
+// With ShareMirrors, the mirrored States are also encoded so the encoding is
+// the same as without it.
+func (s *NFAScorer) GobEncode() ([]byte, error) {
+	if s.shareMirrors {
+		s.mirrorOnce.Do(s.addMirrors)
+	}
+	states := make([]combo4.State, 0, len(s.inviable)+len(s.mirrored))
+	for state := range s.inviable {
+		states = append(states, state)
+	}
+	for state := range s.mirrored {
+		states = append(states, state)
+	}
+	// Sort the states so the encoding is deterministic.
+	sort.Slice(states, func(i, j int) bool { return states[i].Less(states[j]) })
+	sets := make([]*tetris.SeqSet, 0, len(states))
+	for _, state := range states {
+		set, _ := s.inviableFor(state)
+		sets = append(sets, set)
+	}
+
+	buf := new(bytes.Buffer)
+	encoder := gob.NewEncoder(buf)
+	if err := encoder.Encode(&s.permLen); err != nil {
+		return nil, fmt.Errorf("encoder.Encode(permLen): %v", err)
+	}
+	if err := encoder.Encode(&states); err != nil {
+		return nil, fmt.Errorf("encoder.Encode(states): %v", err)
+	}
+	if err := encoder.Encode(tetris.MarshalSeqSets(sets)); err != nil {
+		return nil, fmt.Errorf("encoder.Encode(inviable): %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// GobDecode decodes a Gob encoding into an NFAScorer. If the NFAScorer does
+// not have an NFA, the NFA from combo4.NewNFA is used. Use
+// NewNFAScorerFromGob for other NFAs.
+func (s *NFAScorer) GobDecode(b []byte) error {
+	decoder := gob.NewDecoder(bytes.NewReader(b))
+	if err := decoder.Decode(&s.permLen); err != nil {
+		return fmt.Errorf("decoder.Decode(permLen): %v", err)
+	}
+	var states []combo4.State
+	if err := decoder.Decode(&states); err != nil {
+		return fmt.Errorf("decoder.Decode(states): %v", err)
+	}
+	var encodedSets []byte
+	if err := decoder.Decode(&encodedSets); err != nil {
+		return fmt.Errorf("decoder.Decode(inviable): %v", err)
+	}
+	sets, err := tetris.UnmarshalSeqSets(encodedSets)
+	if err != nil {
+		return fmt.Errorf("UnmarshalSeqSets: %v", err)
+	}
+	if len(sets) != len(states) {
+		return fmt.Errorf("got %d inviable SeqSets for %d states", len(sets), len(states))
+	}
+
+	s.inviable = make(map[combo4.State]*tetris.SeqSet, len(states))
+	for idx, state := range states {
+		s.inviable[state] = sets[idx]
+	}
+	s.inviableSizes = genSizes(s.inviable, s.permLen)
+	if s.nfa == nil {
+		continuousMoves, _ := combo4.AllContinuousMoves()
+		s.nfa = combo4.NewNFA(continuousMoves)
+	}
+	return nil
+}
+
+// NewNFAScorerFromGob decodes an NFAScorer for the NFA. The encoding must
+// have been created from an NFAScorer with the same NFA.
+func NewNFAScorerFromGob(nfa *combo4.NFA, b []byte) (*NFAScorer, error) {
+	s := &NFAScorer{nfa: nfa}
+	if err := s.GobDecode(b); err != nil {
+		return nil, err
+	}
+	if !s.matches(nfa) {
+		return nil, fmt.Errorf("the encoded NFAScorer is for a different NFA")
+	}
+	return s, nil
+}
+
+// matches returns whether the NFAScorer has the same States as the NFA.
+func (s *NFAScorer) matches(nfa *combo4.NFA) bool {
+	states := nfa.States()
+	if len(states) != len(s.inviable) {
+		return false
+	}
+	for state := range states {
+		if _, ok := s.inviable[state]; !ok {
+			return false
+		}
+	}
+	return true
+}
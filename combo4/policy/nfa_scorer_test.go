@@ -1,9 +1,14 @@
 package policy
 
 import (
+	"errors"
+	"math/rand"
+	"strings"
 	"testing"
 	"tetris"
 	"tetris/combo4"
+
+	"github.com/google/go-cmp/cmp"
 )
 
 func BenchmarkNewNFAScorer7(b *testing.B) {
@@ -22,6 +27,40 @@ func BenchmarkNewNFAScorer8(b *testing.B) {
 	}
 }
 
+func BenchmarkNewNFAScorer8Extend(b *testing.B) {
+	moves, _ := combo4.AllContinuousMoves()
+	nfa := combo4.NewNFA(moves)
+	scorer7 := NewNFAScorer(nfa, 7)
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if _, err := scorer7.Extend(8); err != nil {
+			b.Fatalf("Extend: %v", err)
+		}
+	}
+}
+
+func BenchmarkNewNFAScorer9(b *testing.B) {
+	moves, _ := combo4.AllContinuousMoves()
+	nfa := combo4.NewNFA(moves)
+	for n := 0; n < b.N; n++ {
+		_ = NewNFAScorer(nfa, 9)
+	}
+}
+
+func TestMaxPermLen(t *testing.T) {
+	max := maxPermLen()
+	if max < 10 {
+		t.Errorf("got maxPermLen()=%d, want at least 10", max)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Errorf("NewNFAScorer(nfa, %d) did not panic", max+1)
+		}
+	}()
+	moves, _ := combo4.AllContinuousMoves()
+	NewNFAScorer(combo4.NewNFA(moves), max+1)
+}
+
 func TestInviableSeqs(t *testing.T) {
 	tests := []struct {
 		desc   string
@@ -60,3 +99,321 @@ func TestInviableSeqs(t *testing.T) {
 		})
 	}
 }
+
+// testSameScores checks that two Scorers give the same scores for random
+// situations.
+func testSameScores(t *testing.T, nfa *combo4.NFA, want, got Scorer) {
+	t.Helper()
+	r := rand.New(rand.NewSource(1))
+	states := nfa.States().Slice()
+	for i := 0; i < 500; i++ {
+		state := states[r.Intn(len(states))]
+		next := tetris.RandPiecesFrom(r, r.Intn(4))
+		bag, _ := BagUsedAfter(next)
+		if w, g := want.Score(state, next, bag), got.Score(state, next, bag); w != g {
+			t.Fatalf("Score(%v, %v, %v) got %+v, want %+v", state, next, bag, g, w)
+		}
+	}
+}
+
+func TestNFAScorerGob(t *testing.T) {
+	moves, _ := combo4.AllContinuousMoves()
+	nfa := combo4.NewNFA(moves)
+	scorer := NewNFAScorer(nfa, 4)
+
+	b, err := scorer.GobEncode()
+	if err != nil {
+		t.Fatalf("GobEncode: %v", err)
+	}
+	decoded, err := NewNFAScorerFromGob(nfa, b)
+	if err != nil {
+		t.Fatalf("NewNFAScorerFromGob: %v", err)
+	}
+	if decoded.permLen != 4 {
+		t.Errorf("got permLen=%d after decoding, want 4", decoded.permLen)
+	}
+	testSameScores(t, nfa, scorer, decoded)
+
+	if _, err := NewNFAScorerFromGob(combo4.NewNFANoHold(moves), b); err == nil {
+		t.Errorf("NewNFAScorerFromGob got no error for a different NFA")
+	}
+}
+
+func TestLoadNFAScorer(t *testing.T) {
+	if testing.Short() {
+		t.Skip("compares the scores of NFAScorers with a permLen of 7")
+	}
+	moves, _ := combo4.AllContinuousMoves()
+	nfa := combo4.NewNFA(moves)
+
+	if embeddedScorer == nil {
+		if _, err := EmbeddedNFAScorer(nfa); !errors.Is(err, ErrNoEmbeddedScorer) {
+			t.Errorf("EmbeddedNFAScorer without the embedscorer build tag got %v, want ErrNoEmbeddedScorer", err)
+		}
+	} else {
+		if _, err := EmbeddedNFAScorer(nfa); err != nil {
+			t.Fatalf("decoding the embedded NFAScorer failed: %v", err)
+		}
+		corrupted := append([]byte(nil), embeddedScorer...)
+		corrupted[len(corrupted)/2]++
+		if _, err := decodeEmbeddedScorer(nfa, corrupted, embeddedScorerSum); err == nil || !strings.Contains(err.Error(), "SHA-256") {
+			t.Errorf("decoding a corrupted NFAScorer got error %v, want a checksum mismatch", err)
+		}
+	}
+	testSameScores(t, nfa, NewNFAScorer(nfa, 7), LoadNFAScorer(nfa, 7))
+
+	noHold := combo4.NewNFANoHold(moves)
+	testSameScores(t, noHold, NewNFAScorer(noHold, 7), LoadNFAScorer(noHold, 7))
+}
+
+func TestScoreBreakdownOrdering(t *testing.T) {
+	moves, _ := combo4.AllContinuousMoves()
+	nfa := combo4.NewNFA(moves)
+	scorer := NewNFAScorer(nfa, 3)
+	// The int64 score from before ScoreBreakdown.
+	packed := func(s ScoreBreakdown) int64 {
+		return int64(s.Consumed<<50) - int64(s.Inviable<<10) + int64(s.NumStates)
+	}
+
+	r := rand.New(rand.NewSource(1))
+	states := nfa.States().Slice()
+	for i := 0; i < 300; i++ {
+		state := states[r.Intn(len(states))]
+		queue := tetris.RandPiecesFrom(r, 1+r.Intn(4))
+		bag, _ := BagUsedAfter(queue)
+		choices := nfa.NextStates(state, queue[0])
+		scores := make([]ScoreBreakdown, len(choices))
+		for idx, choice := range choices {
+			scores[idx] = scorer.Score(choice, queue[1:], bag)
+		}
+		for _, a := range scores {
+			for _, b := range scores {
+				if got, want := a.Compare(b), compareInts(packed(a), packed(b)); got != want {
+					t.Fatalf("%+v.Compare(%+v) got %d, want %d like the packed scores", a, b, got, want)
+				}
+				if got, want := a.Value() < b.Value(), a.Compare(b) < 0; got != want {
+					t.Fatalf("%+v.Value() < %+v.Value() got %t, want %t", a, b, got, want)
+				}
+			}
+		}
+	}
+}
+
+// packedScorer is a LegacyScorer that packs the score of an NFAScorer.
+type packedScorer struct {
+	*NFAScorer
+}
+
+func (s packedScorer) Score(state combo4.State, next []tetris.Piece, bagUsed tetris.PieceSet) int64 {
+	return s.NFAScorer.Score(state, next, bagUsed).Int64()
+}
+
+func TestFromLegacyScorer(t *testing.T) {
+	moves, _ := combo4.AllContinuousMoves()
+	nfa := combo4.NewNFA(moves)
+	scorer := NewNFAScorer(nfa, 3)
+	legacy := FromLegacyScorer(packedScorer{scorer})
+
+	want, got := FromScorer(nfa, scorer), FromScorer(nfa, legacy)
+	queue := tetris.RandPiecesFrom(rand.New(rand.NewSource(1)), 40)
+	state := &combo4.State{Field: combo4.LeftI}
+	for idx := 0; idx+3 < len(queue) && state != nil; idx++ {
+		preview := queue[idx+1 : idx+3]
+		bag, _ := BagUsedAfter(queue[:idx+3])
+		wantNext := want.NextState(*state, queue[idx], preview, bag)
+		gotNext := got.NextState(*state, queue[idx], preview, bag)
+		if !cmp.Equal(wantNext, gotNext) {
+			t.Fatalf("NextState #%d with the adapted Scorer got %v, want %v", idx, gotNext, wantNext)
+		}
+		state = wantNext
+	}
+}
+
+func TestScoreBreakdownLegacy(t *testing.T) {
+	for _, test := range []struct{ a, b int64 }{
+		{-5, 3},
+		{0, 1 << 52},
+		{-1 << 52, 0},
+	} {
+		a, b := ScoreBreakdown{Legacy: test.a}, ScoreBreakdown{Legacy: test.b}
+		if got := a.Compare(b); got != -1 {
+			t.Errorf("%+v.Compare(%+v) got %d, want -1", a, b, got)
+		}
+		if got := b.Compare(a); got != 1 {
+			t.Errorf("%+v.Compare(%+v) got %d, want 1", b, a, got)
+		}
+		if a.Int64() != test.a || b.Int64() != test.b {
+			t.Errorf("Int64 of %+v and %+v got %d and %d, want the Legacy scores", a, b, a.Int64(), b.Int64())
+		}
+	}
+}
+
+func TestScoreBreakdownValue(t *testing.T) {
+	tests := []struct {
+		desc          string
+		worse, better ScoreBreakdown
+	}{
+		{
+			desc:   "normalized",
+			worse:  ScoreBreakdown{Normalized: 0.2},
+			better: ScoreBreakdown{Normalized: 0.3},
+		},
+		{
+			desc:   "continuation before normalized",
+			worse:  ScoreBreakdown{Continuation: 0.1, Normalized: 1},
+			better: ScoreBreakdown{Continuation: 0.2},
+		},
+		{
+			desc:   "states before continuation",
+			worse:  ScoreBreakdown{NumStates: 1, Continuation: 1, Normalized: 1},
+			better: ScoreBreakdown{NumStates: 2},
+		},
+		{
+			desc:   "fewer inviable before continuation",
+			worse:  ScoreBreakdown{Inviable: 1, Continuation: 1},
+			better: ScoreBreakdown{NumStates: 0},
+		},
+	}
+	for _, test := range tests {
+		if got := test.worse.Compare(test.better); got != -1 {
+			t.Errorf("%s: %+v.Compare(%+v) got %d, want -1", test.desc, test.worse, test.better, got)
+		}
+		if test.worse.Value() >= test.better.Value() {
+			t.Errorf("%s: %+v.Value() = %v, want less than %+v.Value() = %v", test.desc, test.worse, test.worse.Value(), test.better, test.better.Value())
+		}
+	}
+}
+
+func TestNFAScorerShareMirrors(t *testing.T) {
+	moves, _ := combo4.AllContinuousMoves()
+	nfa := combo4.NewNFA(moves)
+	full := NewNFAScorer(nfa, 4)
+	shared := NewNFAScorerWithOptions(nfa, 4, NFAScorerOptions{ShareMirrors: true})
+	if len(shared.inviable) >= len(full.inviable) {
+		t.Errorf("got %d inviable SeqSets with ShareMirrors, want fewer than %d", len(shared.inviable), len(full.inviable))
+	}
+
+	r := rand.New(rand.NewSource(1))
+	states := nfa.States().Slice()
+	for i := 0; i < 300; i++ {
+		state := states[r.Intn(len(states))]
+		next := tetris.RandPiecesFrom(r, r.Intn(4))
+		bag, _ := BagUsedAfter(next)
+		mirroredNext := make([]tetris.Piece, len(next))
+		for idx, p := range next {
+			mirroredNext[idx] = p.Mirror()
+		}
+
+		want := full.Score(state, next, bag)
+		if got := shared.Score(state, next, bag); got != want {
+			t.Fatalf("Score(%v, %v, %v) with ShareMirrors got %+v, want %+v", state, next, bag, got, want)
+		}
+		if got := shared.Score(state.Mirror(), mirroredNext, bag.Mirror()); got != want {
+			t.Fatalf("Score(%v, %v, %v) of the mirror got %+v, want %+v", state.Mirror(), mirroredNext, bag.Mirror(), got, want)
+		}
+	}
+
+	// The encoding includes the mirrored States.
+	b, err := shared.GobEncode()
+	if err != nil {
+		t.Fatalf("GobEncode: %v", err)
+	}
+	if _, err := NewNFAScorerFromGob(nfa, b); err != nil {
+		t.Errorf("NewNFAScorerFromGob: %v", err)
+	}
+}
+
+// testSameInviable checks that two NFAScorers have equal inviable SeqSets.
+func testSameInviable(t *testing.T, want, got *NFAScorer) {
+	t.Helper()
+	if got.permLen != want.permLen {
+		t.Errorf("got permLen=%d, want %d", got.permLen, want.permLen)
+	}
+	if len(got.inviable) != len(want.inviable) {
+		t.Fatalf("got %d inviable SeqSets, want %d", len(got.inviable), len(want.inviable))
+	}
+	for state, set := range want.inviable {
+		if !got.inviable[state].Equals(set) {
+			t.Fatalf("the inviable SeqSet of %v differs", state)
+		}
+	}
+	if diff := cmp.Diff(want.inviableSizes, got.inviableSizes); diff != "" {
+		t.Errorf("inviableSizes mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestNFAScorerExtend(t *testing.T) {
+	moves, _ := combo4.AllContinuousMoves()
+	nfa := combo4.NewNFA(moves)
+	for _, opts := range []NFAScorerOptions{{}, {ShareMirrors: true}} {
+		scorer2 := NewNFAScorerWithOptions(nfa, 2, opts)
+		extended, err := scorer2.Extend(4)
+		if err != nil {
+			t.Fatalf("Extend(4) with %+v: %v", opts, err)
+		}
+		testSameInviable(t, NewNFAScorerWithOptions(nfa, 4, opts), extended)
+
+		same, err := scorer2.Extend(2)
+		if err != nil {
+			t.Fatalf("Extend(2) with %+v: %v", opts, err)
+		}
+		testSameInviable(t, scorer2, same)
+	}
+
+	// A decoded NFAScorer can also be extended.
+	b, err := NewNFAScorer(nfa, 2).GobEncode()
+	if err != nil {
+		t.Fatalf("GobEncode: %v", err)
+	}
+	decoded, err := NewNFAScorerFromGob(nfa, b)
+	if err != nil {
+		t.Fatalf("NewNFAScorerFromGob: %v", err)
+	}
+	extended, err := decoded.Extend(3)
+	if err != nil {
+		t.Fatalf("Extend(3) of a decoded NFAScorer: %v", err)
+	}
+	testSameInviable(t, NewNFAScorer(nfa, 3), extended)
+
+	scorer3 := NewNFAScorer(nfa, 3)
+	if _, err := scorer3.Extend(2); err == nil {
+		t.Errorf("Extend to a shorter permLen got no error")
+	}
+	if _, err := scorer3.Extend(maxPermLen() + 1); err == nil {
+		t.Errorf("Extend over the maximum permLen got no error")
+	}
+}
+
+func TestNFAScorerKeepLevels(t *testing.T) {
+	moves, _ := combo4.AllContinuousMoves()
+	nfa := combo4.NewNFA(moves)
+	for _, opts := range []NFAScorerOptions{{KeepLevels: true}, {KeepLevels: true, ShareMirrors: true}} {
+		scorer := NewNFAScorerWithOptions(nfa, 3, opts)
+		for permLen := 1; permLen <= 3; permLen++ {
+			got, err := scorer.AtPermLen(permLen)
+			if err != nil {
+				t.Fatalf("AtPermLen(%d) with %+v: %v", permLen, opts, err)
+			}
+			testSameInviable(t, NewNFAScorerWithOptions(nfa, permLen, NFAScorerOptions{ShareMirrors: opts.ShareMirrors}), got)
+		}
+
+		// The extended NFAScorer keeps the levels of both.
+		extended, err := scorer.Extend(4)
+		if err != nil {
+			t.Fatalf("Extend(4) with %+v: %v", opts, err)
+		}
+		for _, permLen := range []int{2, 4} {
+			got, err := extended.AtPermLen(permLen)
+			if err != nil {
+				t.Fatalf("AtPermLen(%d) of the extended NFAScorer with %+v: %v", permLen, opts, err)
+			}
+			testSameInviable(t, NewNFAScorerWithOptions(nfa, permLen, NFAScorerOptions{ShareMirrors: opts.ShareMirrors}), got)
+		}
+		if _, err := scorer.AtPermLen(4); err == nil {
+			t.Errorf("AtPermLen over the permLen got no error")
+		}
+	}
+	if _, err := NewNFAScorer(nfa, 3).AtPermLen(2); err == nil {
+		t.Errorf("AtPermLen without KeepLevels got no error")
+	}
+}
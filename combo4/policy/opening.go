@@ -0,0 +1,241 @@
+package policy
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"sync"
+	"tetris"
+	"tetris/combo4"
+)
+
+// OpeningBook contains the best choices for every first bag of a game
+// starting with no piece held. The first bag is fully known after 6 pieces
+// are seen, which is at the first move with a preview of 5 or more.
+//
+// OpeningBook is safe for concurrent use.
+type OpeningBook struct {
+	lines map[openingKey][]combo4.State
+}
+
+type openingKey struct {
+	Start combo4.Field4x4
+	Bag   tetris.Seq
+}
+
+// NewOpeningBook finds the best choices for each of the 5040 first bags
+// starting from each field. Every sequence of choices is searched so the
+// most pieces of the bag are consumed. Ties between sequences that consume
+// the whole bag are broken by the Scorer's score of the final State with a
+// new bag.
+func NewOpeningBook(nfa *combo4.NFA, scorer Scorer, starts ...combo4.Field4x4) *OpeningBook {
+	type job struct {
+		start combo4.Field4x4
+		bag   []tetris.Piece
+	}
+	type result struct {
+		key  openingKey
+		line []combo4.State
+	}
+	jobs := make(chan job)
+	results := make(chan result)
+	var wg sync.WaitGroup
+	wg.Add(concurrency)
+	for i := 0; i < concurrency; i++ {
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				results <- result{
+					key:  openingKey{Start: j.start, Bag: tetris.MustSeq(j.bag)},
+					line: bestLine(nfa, scorer, combo4.State{Field: j.start}, j.bag),
+				}
+			}
+		}()
+	}
+	go func() {
+		for _, start := range starts {
+			tetris.ForEachBagQueue(7, func(bag []tetris.Piece) bool {
+				jobs <- job{start: start, bag: append([]tetris.Piece(nil), bag...)}
+				return true
+			})
+		}
+		close(jobs)
+		wg.Wait()
+		close(results)
+	}()
+
+	b := &OpeningBook{lines: make(map[openingKey][]combo4.State, len(starts)*5040)}
+	for r := range results {
+		b.lines[r.key] = r.line
+	}
+	return b
+}
+
+// bestLine returns the choices for the bag that consume the most pieces from
+// the initial State.
+func bestLine(nfa *combo4.NFA, scorer Scorer, initial combo4.State, bag []tetris.Piece) []combo4.State {
+	type key struct {
+		state combo4.State
+		idx   int
+	}
+	type outcome struct {
+		consumed int
+		score    ScoreBreakdown
+		choice   combo4.State
+	}
+	newBag := tetris.NewPieceSet(bag...)
+	memo := make(map[key]outcome)
+	var best func(state combo4.State, idx int) outcome
+	best = func(state combo4.State, idx int) outcome {
+		if idx == len(bag) {
+			return outcome{consumed: idx, score: scorer.Score(state, nil, newBag)}
+		}
+		k := key{state, idx}
+		if o, ok := memo[k]; ok {
+			return o
+		}
+		// Any next State consumes more than idx so it replaces o.
+		o := outcome{consumed: idx}
+		for _, next := range nfa.NextStates(state, bag[idx]) {
+			nextOutcome := best(next, idx+1)
+			if nextOutcome.consumed > o.consumed || (nextOutcome.consumed == o.consumed && nextOutcome.score.Compare(o.score) > 0) {
+				o = outcome{consumed: nextOutcome.consumed, score: nextOutcome.score, choice: next}
+			}
+		}
+		memo[k] = o
+		return o
+	}
+
+	var line []combo4.State
+	state := initial
+	for idx := range bag {
+		o := best(state, idx)
+		if o.consumed == idx {
+			break
+		}
+		state = o.choice
+		line = append(line, state)
+	}
+	return line
+}
+
+// Line returns the choices for the first bag of a game starting from the
+// field with no piece held. seenPieces are the first pieces of the game and
+// must contain at least 6 pieces of the first bag. The choices end early if
+// the bag cannot be fully consumed.
+func (b *OpeningBook) Line(field combo4.Field4x4, seenPieces []tetris.Piece) ([]combo4.State, bool) {
+	bag, ok := firstBag(seenPieces)
+	if !ok {
+		return nil, false
+	}
+	line, ok := b.lines[openingKey{Start: field, Bag: tetris.MustSeq(bag)}]
+	return line, ok
+}
+
+// Lookup returns the first choice of Line.
+func (b *OpeningBook) Lookup(field combo4.Field4x4, seenPieces []tetris.Piece) (*combo4.State, bool) {
+	line, ok := b.Line(field, seenPieces)
+	if !ok || len(line) == 0 {
+		return nil, false
+	}
+	first := line[0]
+	return &first, true
+}
+
+// firstBag returns the 7 pieces of the first bag from at least its first 6
+// pieces.
+func firstBag(seenPieces []tetris.Piece) ([]tetris.Piece, bool) {
+	if len(seenPieces) < 6 {
+		return nil, false
+	}
+	if len(seenPieces) > 7 {
+		seenPieces = seenPieces[:7]
+	}
+	bag := append([]tetris.Piece(nil), seenPieces...)
+	used := tetris.NewPieceSet(bag...)
+	if used.Len() != len(bag) || used.Contains(tetris.EmptyPiece) {
+		return nil, false
+	}
+	if len(bag) == 6 {
+		bag = append(bag, used.Inverted().Slice()[0])
+	}
+	return bag, true
+}
+
+// GobEncode returns a Gob encoding of an OpeningBook.
+func (b *OpeningBook) GobEncode() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(&b.lines); err != nil {
+		return nil, fmt.Errorf("encoder.Encode(lines): %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// GobDecode decodes a Gob encoding into an OpeningBook.
+func (b *OpeningBook) GobDecode(data []byte) error {
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&b.lines); err != nil {
+		return fmt.Errorf("decoder.Decode(lines): %v", err)
+	}
+	return nil
+}
+
+// WithOpeningBook returns a Policy that plays the OpeningBook's choices for
+// the first bag of a game and then the fallback's choices. The book is only
+// used if the first NextState is for a game started by StartGame with at
+// least 5 pieces in the preview. After any unexpected State or piece, only
+// the fallback is used.
+//
+// The returned Policy is for a single game and is not safe for concurrent
+// use.
+func WithOpeningBook(book *OpeningBook, fallback Policy) Policy {
+	return &openingPolicy{book: book, fallback: fallback}
+}
+
+type openingPolicy struct {
+	book     *OpeningBook
+	fallback Policy
+
+	started bool
+	// The first bag and the choices for it or nil if the book is not used.
+	bag  []tetris.Piece
+	line []combo4.State
+	// The State before the next choice in line.
+	prev   combo4.State
+	played int
+}
+
+func (p *openingPolicy) NextState(initial combo4.State, current tetris.Piece, preview []tetris.Piece, endBagUsed tetris.PieceSet) *combo4.State {
+	if !p.started {
+		p.started = true
+		p.start(initial, current, preview, endBagUsed)
+	}
+	if p.played < len(p.line) && initial == p.prev && current == p.bag[p.played] {
+		next := p.line[p.played]
+		p.prev = next
+		p.played++
+		return &next
+	}
+	p.line = nil
+	return p.fallback.NextState(initial, current, preview, endBagUsed)
+}
+
+// start finds the line in the book if the game is starting.
+func (p *openingPolicy) start(initial combo4.State, current tetris.Piece, preview []tetris.Piece, endBagUsed tetris.PieceSet) {
+	if initial.Hold != tetris.EmptyPiece || initial.SwapRestricted {
+		return
+	}
+	pieces := append([]tetris.Piece{current}, preview...)
+	if bag, err := startingBag(nil, current, preview); err != nil || bag != endBagUsed {
+		// Not the start of a game.
+		return
+	}
+	bag, ok := firstBag(pieces)
+	if !ok {
+		return
+	}
+	line, ok := p.book.Line(initial.Field, bag)
+	if !ok {
+		return
+	}
+	p.bag, p.line, p.prev = bag, line, initial
+}
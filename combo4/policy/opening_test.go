@@ -0,0 +1,112 @@
+package policy
+
+import (
+	"testing"
+	"tetris"
+	"tetris/combo4"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestOpeningBook(t *testing.T) {
+	moves, _ := combo4.AllContinuousMoves()
+	nfa := combo4.NewNFA(moves)
+	book := NewOpeningBook(nfa, NewNFAScorer(nfa, 1), combo4.LeftI)
+	start := combo4.State{Field: combo4.LeftI}
+
+	var survivable int
+	tetris.ForEachBagQueue(7, func(bag []tetris.Piece) bool {
+		line, ok := book.Line(combo4.LeftI, bag)
+		if !ok {
+			t.Fatalf("Line(%v) not found", bag)
+		}
+		_, wantLen := nfa.EndStates(combo4.NewStateSet(start), bag)
+		if wantLen == len(bag) {
+			survivable++
+		}
+		if len(line) != wantLen {
+			t.Fatalf("Line(%v) has %d choices, want %d", bag, len(line), wantLen)
+		}
+		prev := start
+		for idx, choice := range line {
+			if !containsState(nfa.NextStates(prev, bag[idx]), choice) {
+				t.Fatalf("Line(%v) choice #%d %v is not a next state", bag, idx, choice)
+			}
+			prev = choice
+		}
+
+		// The last piece of the bag is known from the first 6.
+		got, ok := book.Lookup(combo4.LeftI, bag[:6])
+		if wantLen > 0 && (!ok || *got != line[0]) {
+			t.Fatalf("Lookup(%v) got %v, %t, want %v", bag[:6], got, ok, line[0])
+		}
+		return true
+	})
+	if survivable == 0 {
+		t.Errorf("got no survivable bags")
+	}
+
+	for _, seen := range [][]tetris.Piece{
+		tetris.SeqFromStr("TOSZJ"),
+		tetris.SeqFromStr("TOSZJT"),
+	} {
+		if _, ok := book.Lookup(combo4.LeftI, seen); ok {
+			t.Errorf("Lookup(%v) got ok, want not found", seen)
+		}
+	}
+	if _, ok := book.Lookup(combo4.RightI, tetris.SeqFromStr("TOSZJLI")); ok {
+		t.Errorf("Lookup for a field without lines got ok, want not found")
+	}
+
+	b, err := book.GobEncode()
+	if err != nil {
+		t.Fatalf("GobEncode: %v", err)
+	}
+	decoded := new(OpeningBook)
+	if err := decoded.GobDecode(b); err != nil {
+		t.Fatalf("GobDecode: %v", err)
+	}
+	if diff := cmp.Diff(book.lines, decoded.lines); diff != "" {
+		t.Errorf("lines differ after decoding (-want +got):\n%s", diff)
+	}
+}
+
+func TestWithOpeningBook(t *testing.T) {
+	moves, _ := combo4.AllContinuousMoves()
+	nfa := combo4.NewNFA(moves)
+	book := NewOpeningBook(nfa, NewNFAScorer(nfa, 1), combo4.LeftI)
+	fallback := &recordingPolicy{pol: FromScorer(nfa, NewNFAScorer(nfa, 3))}
+
+	queue := tetris.SeqFromStr("TOSZJLIOTISZJL")
+	line, _ := book.Line(combo4.LeftI, queue)
+	if len(line) != 7 {
+		t.Fatalf("got %d choices for %v, want 7", len(line), queue[:7])
+	}
+
+	input := make(chan tetris.Piece, len(queue))
+	output := StartGame(WithOpeningBook(book, fallback), combo4.LeftI, queue[0], queue[1:7], input)
+	for idx := range line {
+		decision := <-output
+		if decision.State == nil || *decision.State != line[idx] {
+			t.Fatalf("decision #%d got %v, want %v", idx, decision.State, line[idx])
+		}
+		input <- queue[idx+7]
+	}
+	if len(fallback.calls) != 0 {
+		t.Errorf("got %d calls to the fallback during the first bag, want 0", len(fallback.calls))
+	}
+	<-output
+	if len(fallback.calls) != 1 {
+		t.Errorf("got %d calls to the fallback after the first bag, want 1", len(fallback.calls))
+	}
+	close(input)
+}
+
+func containsState(states []combo4.State, s combo4.State) bool {
+	for _, state := range states {
+		if state == s {
+			return true
+		}
+	}
+	return false
+}
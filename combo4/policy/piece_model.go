@@ -0,0 +1,139 @@
+package policy
+
+import (
+	"fmt"
+	"tetris"
+)
+
+// NextPieceModel is how a randomizer draws the next piece. The bag state
+// after drawing each piece is a PieceSet whose meaning is defined by the
+// model. It is the BagUsed of the GameStates in an MDP trained with the
+// model.
+type NextPieceModel interface {
+	// Possible returns the pieces that can be drawn after the bag state.
+	Possible(bagUsed tetris.PieceSet) []tetris.Piece
+	// Prob returns the probability that the piece is drawn after the bag
+	// state.
+	Prob(bagUsed tetris.PieceSet, p tetris.Piece) float64
+	// Draw returns the bag state after drawing the piece.
+	Draw(bagUsed tetris.PieceSet, p tetris.Piece) tetris.PieceSet
+	// Name identifies the model in the encodings of MDPs and MDPPolicies.
+	Name() string
+}
+
+// SevenBag is the NextPieceModel of the 7 bag randomizer which draws each of
+// the 7 pieces once in a random order before starting a new bag. The bag
+// state is the pieces drawn from the current bag. It is the default for an
+// MDP.
+type SevenBag struct{}
+
+// Possible returns the pieces not drawn from the bag yet or every piece if
+// the bag is full.
+func (SevenBag) Possible(bagUsed tetris.PieceSet) []tetris.Piece {
+	if bagUsed.IsFullBag() {
+		bagUsed = 0
+	}
+	return bagUsed.Inverted().Slice()
+}
+
+// Prob returns the same probability for each possible piece.
+func (s SevenBag) Prob(bagUsed tetris.PieceSet, p tetris.Piece) float64 {
+	if bagUsed.IsFullBag() {
+		bagUsed = 0
+	}
+	if p == tetris.EmptyPiece || bagUsed.Contains(p) {
+		return 0
+	}
+	return 1 / float64(7-bagUsed.Len())
+}
+
+// Draw adds the piece to the bag or starts a new bag if it is full.
+func (SevenBag) Draw(bagUsed tetris.PieceSet, p tetris.Piece) tetris.PieceSet {
+	if bagUsed.IsFullBag() {
+		return p.PieceSet()
+	}
+	return bagUsed.Add(p)
+}
+
+// Name returns "7bag".
+func (SevenBag) Name() string {
+	return "7bag"
+}
+
+// Memoryless is the NextPieceModel of a randomizer that draws each piece
+// independently with the same probability. The bag state is always 0.
+type Memoryless struct{}
+
+// Possible returns every piece.
+func (Memoryless) Possible(tetris.PieceSet) []tetris.Piece {
+	return append([]tetris.Piece(nil), tetris.NonemptyPieces[:]...)
+}
+
+// Prob returns 1/7 for every piece.
+func (Memoryless) Prob(_ tetris.PieceSet, p tetris.Piece) float64 {
+	if p == tetris.EmptyPiece {
+		return 0
+	}
+	return 1.0 / 7
+}
+
+// Draw returns 0.
+func (Memoryless) Draw(tetris.PieceSet, tetris.Piece) tetris.PieceSet {
+	return 0
+}
+
+// Name returns "memoryless".
+func (Memoryless) Name() string {
+	return "memoryless"
+}
+
+// modelByName returns the built-in NextPieceModel with the name. Encodings
+// from before models were added have an empty name and use SevenBag.
+func modelByName(name string) (NextPieceModel, error) {
+	switch name {
+	case "", SevenBag{}.Name():
+		return SevenBag{}, nil
+	case Memoryless{}.Name():
+		return Memoryless{}, nil
+	}
+	return nil, fmt.Errorf("unknown NextPieceModel %q", name)
+}
+
+// isSevenBag returns whether the model is the default SevenBag.
+func isSevenBag(model NextPieceModel) bool {
+	_, ok := model.(SevenBag)
+	return model == nil || ok
+}
+
+// canDraw returns the bag state after drawing the piece from the model or
+// false if the model cannot draw it. A nil model is the 7 bag randomizer.
+func canDraw(model NextPieceModel, bagUsed tetris.PieceSet, p tetris.Piece) (tetris.PieceSet, bool) {
+	if model == nil {
+		return draw(bagUsed, p)
+	}
+	if model.Prob(bagUsed, p) == 0 {
+		return bagUsed, false
+	}
+	return model.Draw(bagUsed, p), true
+}
+
+// randPiecesFrom returns n pieces drawn from the model starting with an
+// empty bag using float64 to sample from [0, 1) e.g. rand.Float64.
+func randPiecesFrom(model NextPieceModel, float64 func() float64, n int) []tetris.Piece {
+	pieces := make([]tetris.Piece, n)
+	var bagUsed tetris.PieceSet
+	for i := range pieces {
+		possible := model.Possible(bagUsed)
+		sample := float64()
+		p := possible[len(possible)-1]
+		for _, candidate := range possible {
+			if sample -= model.Prob(bagUsed, candidate); sample < 0 {
+				p = candidate
+				break
+			}
+		}
+		pieces[i] = p
+		bagUsed = model.Draw(bagUsed, p)
+	}
+	return pieces
+}
@@ -0,0 +1,113 @@
+package policy
+
+import (
+	"math/rand"
+	"testing"
+	"tetris"
+	"tetris/combo4"
+)
+
+func TestNextPieceModelProbs(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		desc    string
+		model   NextPieceModel
+		bagUsed tetris.PieceSet
+	}{
+		{desc: "7 bag empty", model: SevenBag{}},
+		{desc: "7 bag partial", model: SevenBag{}, bagUsed: tetris.NewPieceSet(tetris.I, tetris.T, tetris.O)},
+		{desc: "7 bag full", model: SevenBag{}, bagUsed: tetris.NewPieceSet(tetris.NonemptyPieces[:]...)},
+		{desc: "memoryless", model: Memoryless{}},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+			var sum float64
+			possible := tetris.NewPieceSet(test.model.Possible(test.bagUsed)...)
+			for _, p := range tetris.NonemptyPieces {
+				prob := test.model.Prob(test.bagUsed, p)
+				if got, want := prob > 0, possible.Contains(p); got != want {
+					t.Errorf("Prob(%v, %v)=%v but Possible has it=%t", test.bagUsed, p, prob, want)
+				}
+				sum += prob
+			}
+			if sum < 1-1e-9 || sum > 1+1e-9 {
+				t.Errorf("probabilities after %v sum to %v, want 1", test.bagUsed, sum)
+			}
+		})
+	}
+
+	if got, want := (SevenBag{}).Draw(tetris.NewPieceSet(tetris.NonemptyPieces[:]...), tetris.T), tetris.T.PieceSet(); got != want {
+		t.Errorf("SevenBag.Draw on a full bag got %v, want %v", got, want)
+	}
+	if got := (Memoryless{}).Draw(0, tetris.T); got != 0 {
+		t.Errorf("Memoryless.Draw got %v, want 0", got)
+	}
+}
+
+func TestMemorylessMDP(t *testing.T) {
+	t.Parallel()
+
+	mdp, err := NewMDPWithOptions(1, MDPOptions{Model: Memoryless{}})
+	if err != nil {
+		t.Fatalf("NewMDPWithOptions: %v", err)
+	}
+	mdp.updatePolicy()
+	mdp.updateValues()
+	if errs := mdp.Verify(); len(errs) > 0 {
+		t.Fatalf("Verify got %d errors e.g. %v", len(errs), errs[0])
+	}
+
+	// A 7 bag randomizer cannot repeat a piece right after itself.
+	repeated := GameState{
+		State:   combo4.State{Field: combo4.LeftI, Hold: tetris.I},
+		Current: tetris.S,
+		Preview: tetris.MustSeq([]tetris.Piece{tetris.S}),
+	}
+	if _, ok := mdp.value[repeated]; !ok {
+		t.Errorf("MDP does not have %v", repeated)
+	}
+
+	pol := mdp.Policy().(*MDPPolicy)
+	result := Evaluate(pol, EvalOptions{
+		Trials:         100,
+		PiecesPerTrial: 100,
+		PreviewSize:    1,
+		Rand:           rand.New(rand.NewSource(1)),
+		Model:          Memoryless{},
+	})
+	if result.Mean == 0 {
+		t.Errorf("Evaluate with memoryless queues consumed no pieces")
+	}
+	if stats := pol.Stats(); stats.Invalid > 0 {
+		t.Errorf("got %d invalid NextState calls, want 0", stats.Invalid)
+	}
+
+	t.Run("gob", func(t *testing.T) {
+		b, err := mdp.GobEncode()
+		if err != nil {
+			t.Fatalf("GobEncode: %v", err)
+		}
+		decoded := new(MDP)
+		if err := decoded.GobDecode(b); err != nil {
+			t.Fatalf("GobDecode: %v", err)
+		}
+		if _, ok := decoded.model.(Memoryless); !ok {
+			t.Errorf("got model %v after decoding, want Memoryless", decoded.model)
+		}
+
+		b, err = pol.GobEncode()
+		if err != nil {
+			t.Fatalf("MDPPolicy.GobEncode: %v", err)
+		}
+		decodedPol := new(MDPPolicy)
+		if err := decodedPol.GobDecode(b); err != nil {
+			t.Fatalf("MDPPolicy.GobDecode: %v", err)
+		}
+		if got := decodedPol.Model().Name(); got != "memoryless" {
+			t.Errorf("got MDPPolicy model %q after decoding, want memoryless", got)
+		}
+	})
+}
@@ -0,0 +1,142 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"tetris"
+	"tetris/combo4"
+)
+
+// ErrPlanEnded is returned by PlanMoves when the Policy has no possible move
+// before the end of the plan.
+var ErrPlanEnded = errors.New("no possible move before the end of the plan")
+
+// PlanMoves returns the next k States the Policy chooses if no more pieces
+// are added to the preview. The first State is for the current piece and
+// each following State is for the next piece of the preview with the rest
+// of the preview shifted like in StartGame. endBagUsed is the bag state
+// after the last piece in preview and stays the same since no pieces are
+// added.
+//
+// k must be between 1 and len(preview)+1 and the pieces must pass
+// GameState.Validate like in ResumeGame. If the Policy has no possible move
+// before k States, PlanMoves returns the States so far and an ErrPlanEnded.
+//
+// The plan is what the Policy chooses with the pieces known now. Once more
+// pieces are seen the Policy can choose differently so a plan should be
+// recomputed when a new piece is added to the preview.
+//
+// Planning does not count in the Stats of an MDPPolicy, write records of a
+// Recorder or record latencies of an InstrumentedPolicy. Other Policies are
+// asked with NextState.
+func PlanMoves(pol Policy, initial combo4.State, current tetris.Piece, preview []tetris.Piece, endBagUsed tetris.PieceSet, k int) ([]combo4.State, error) {
+	if k < 1 || k > len(preview)+1 {
+		return nil, fmt.Errorf("k=%d must be between 1 and %d", k, len(preview)+1)
+	}
+	if _, err := newValidGameState(initial, current, preview, endBagUsed); err != nil {
+		return nil, err
+	}
+
+	plan := make([]combo4.State, 0, k)
+	state := initial
+	for i := 0; i < k; i++ {
+		next := planNextState(pol, state, current, preview, endBagUsed)
+		if next == nil {
+			return plan, fmt.Errorf("%w: piece %d of %d", ErrPlanEnded, i+1, k)
+		}
+		plan = append(plan, *next)
+		state = *next
+		if len(preview) > 0 {
+			current, preview = preview[0], preview[1:]
+		}
+	}
+	return plan, nil
+}
+
+// planner is implemented by the Policies that have side effects in
+// NextState e.g. counting Stats so they can be asked for a plan without
+// them.
+type planner interface {
+	planNextState(initial combo4.State, current tetris.Piece, preview []tetris.Piece, endBagUsed tetris.PieceSet) *combo4.State
+}
+
+// planNextState returns the choice of the Policy without its side effects if
+// it is a planner.
+func planNextState(pol Policy, initial combo4.State, current tetris.Piece, preview []tetris.Piece, endBagUsed tetris.PieceSet) *combo4.State {
+	if p, ok := pol.(planner); ok {
+		return p.planNextState(initial, current, preview, endBagUsed)
+	}
+	return pol.NextState(initial, current, preview, endBagUsed)
+}
+
+func (m *MDPPolicy) planNextState(initial combo4.State, current tetris.Piece, preview []tetris.Piece, endBagUsed tetris.PieceSet) *combo4.State {
+	gState, err := m.newGameState(initial, current, preview, endBagUsed)
+	if err != nil {
+		return nil
+	}
+	if next, _ := m.knownChoice(gState, preview); next != nil {
+		return next
+	}
+	return planNextState(m.fallback(), initial, current, preview, endBagUsed)
+}
+
+func (r *Recorder) planNextState(initial combo4.State, current tetris.Piece, preview []tetris.Piece, endBagUsed tetris.PieceSet) *combo4.State {
+	return planNextState(r.pol, initial, current, preview, endBagUsed)
+}
+
+func (p *InstrumentedPolicy) planNextState(initial combo4.State, current tetris.Piece, preview []tetris.Piece, endBagUsed tetris.PieceSet) *combo4.State {
+	return planNextState(p.pol, initial, current, preview, endBagUsed)
+}
+
+// plannedMove is a State of the plan of a Game and the piece it is for.
+type plannedMove struct {
+	piece tetris.Piece
+	state combo4.State
+}
+
+// Plan returns the next k States the Policy chooses after State with the
+// pieces in the preview. See PlanMoves.
+//
+// The Game keeps the plan and follows it while the Policy agrees. Each Step
+// or Report still decides with the Policy and the decision consumes the
+// next State of the plan if it is the planned one for the same State and
+// piece. Otherwise, e.g. the new piece changed the choice of the Policy, the
+// plan is dropped. See Planned. A new plan replaces the previous one.
+func (g *Game) Plan(k int) ([]combo4.State, error) {
+	if g.state == nil {
+		return nil, fmt.Errorf("%w: the game has no more possible moves", ErrPlanEnded)
+	}
+	if len(g.preview) == 0 {
+		return nil, errors.New("a plan needs at least one piece in the preview")
+	}
+	plan, err := PlanMoves(g.pol, *g.state, g.preview[0], g.preview[1:], g.bagUsed, k)
+	g.plan, g.planFrom = nil, *g.state
+	for i, state := range plan {
+		g.plan = append(g.plan, plannedMove{piece: g.preview[i], state: state})
+	}
+	return plan, err
+}
+
+// Planned returns the States of the plan from Plan that the Game has not
+// followed yet or nil if there is no plan.
+func (g *Game) Planned() []combo4.State {
+	var states []combo4.State
+	for _, move := range g.plan {
+		states = append(states, move.state)
+	}
+	return states
+}
+
+// followPlan consumes the next State of the plan if the Game decided it from
+// the planned State and piece and otherwise drops the plan.
+func (g *Game) followPlan(from combo4.State) {
+	if len(g.plan) == 0 {
+		return
+	}
+	next := g.plan[0]
+	if g.state == nil || from != g.planFrom || g.current != next.piece || *g.state != next.state {
+		g.plan = nil
+		return
+	}
+	g.planFrom, g.plan = next.state, g.plan[1:]
+}
@@ -0,0 +1,174 @@
+package policy
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"testing"
+	"tetris"
+	"tetris/combo4"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPlanMoves(t *testing.T) {
+	t.Parallel()
+
+	mdp, err := NewMDP(1)
+	if err != nil {
+		t.Fatalf("NewMDP: %v", err)
+	}
+	mdp.updateValues()
+	mdp.updatePolicy()
+	pol := mdp.Policy()
+
+	r := rand.New(rand.NewSource(1))
+	var planned int
+	for gState := range mdp.policy {
+		if r.Intn(1000) != 0 {
+			continue
+		}
+		preview := gState.Preview.Slice()
+		plan, err := PlanMoves(pol, gState.State, gState.Current, preview, gState.BagUsed, len(preview)+1)
+		if err != nil && !errors.Is(err, ErrPlanEnded) {
+			t.Fatalf("PlanMoves(%v) got err=%v", gState, err)
+		}
+
+		// Call NextState one piece at a time.
+		var want []combo4.State
+		state, current := gState.State, gState.Current
+		for i := 0; i < len(preview)+1; i++ {
+			next := pol.NextState(state, current, preview[i:], gState.BagUsed)
+			if next == nil {
+				break
+			}
+			want = append(want, *next)
+			state = *next
+			if i < len(preview) {
+				current = preview[i]
+			}
+		}
+		if diff := cmp.Diff(want, plan); diff != "" {
+			t.Errorf("PlanMoves(%v) mismatch (-want +got):\n%s", gState, diff)
+		}
+		if (err == nil) != (len(want) == len(preview)+1) {
+			t.Errorf("PlanMoves(%v) got err=%v with %d of %d States", gState, err, len(want), len(preview)+1)
+		}
+		planned++
+	}
+	if planned == 0 {
+		t.Fatal("no GameStates were planned")
+	}
+
+	if _, err := PlanMoves(pol, combo4.State{Field: combo4.LeftI}, tetris.T, []tetris.Piece{tetris.O}, tetris.NewPieceSet(tetris.T, tetris.O), 3); err == nil {
+		t.Error("PlanMoves with k over len(preview)+1 got no error")
+	}
+}
+
+func TestGamePlan(t *testing.T) {
+	moves, _ := combo4.AllContinuousMoves()
+	nfa := combo4.NewNFA(moves)
+	pol := FromScorer(nfa, NewNFAScorer(nfa, 3))
+
+	queue := []tetris.Piece{tetris.I, tetris.T, tetris.O, tetris.L}
+	game, err := NewGameFromField(pol, combo4.LeftI, queue[0], queue[1:])
+	if err != nil {
+		t.Fatalf("NewGameFromField: %v", err)
+	}
+	plan, err := game.Plan(3)
+	if err != nil {
+		t.Fatalf("Plan: %v", err)
+	}
+	want, err := PlanMoves(pol, *game.State(), queue[1], queue[2:], game.BagUsed(), 3)
+	if err != nil {
+		t.Fatalf("PlanMoves: %v", err)
+	}
+	if diff := cmp.Diff(want, plan); diff != "" {
+		t.Errorf("Plan mismatch (-want +got):\n%s", diff)
+	}
+	if got, want := game.Consumed(), 1; got != want {
+		t.Errorf("got Consumed()=%d after Plan, want %d", got, want)
+	}
+}
+
+func TestPlanMovesNoSideEffects(t *testing.T) {
+	mdp, err := NewMDP(1)
+	if err != nil {
+		t.Fatalf("NewMDP: %v", err)
+	}
+	pol := mdp.Policy().(*MDPPolicy)
+	instrumented := Instrumented(pol)
+	var buf bytes.Buffer
+	rec := NewRecorder(instrumented, &buf)
+
+	queue := RandQueue(nil, rand.New(rand.NewSource(1)), 30)
+	bag, _ := BagUsedAfter(queue[:2])
+	want, wantErr := PlanMoves(pol, combo4.State{Field: combo4.LeftI}, queue[0], queue[1:2], bag, 2)
+	pol.Reset()
+	got, err := PlanMoves(rec, combo4.State{Field: combo4.LeftI}, queue[0], queue[1:2], bag, 2)
+	if diff := cmp.Diff(want, got); diff != "" || (err == nil) != (wantErr == nil) {
+		t.Errorf("PlanMoves through a Recorder got %v, %v, want %v, %v", got, err, want, wantErr)
+	}
+	if stats := pol.Stats(); stats != (MDPPolicyStats{}) {
+		t.Errorf("got Stats()=%+v after PlanMoves, want none counted", stats)
+	}
+	if got := instrumented.Stats().Count; got != 0 {
+		t.Errorf("got %d latencies after PlanMoves, want 0", got)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("PlanMoves wrote records %q, want none", buf.String())
+	}
+}
+
+func TestGameFollowsPlan(t *testing.T) {
+	moves, _ := combo4.AllContinuousMoves()
+	nfa := combo4.NewNFA(moves)
+	// Chooses the first next State unless contradict is set, then the last.
+	var contradict bool
+	pol := PolicyFunc(func(initial combo4.State, current tetris.Piece, _ []tetris.Piece, _ tetris.PieceSet) *combo4.State {
+		choices := nfa.NextStates(initial, current)
+		switch {
+		case len(choices) == 0:
+			return nil
+		case contradict:
+			return &choices[len(choices)-1]
+		}
+		return &choices[0]
+	})
+
+	queue := []tetris.Piece{tetris.I, tetris.T, tetris.O, tetris.L, tetris.J, tetris.S, tetris.Z}
+	game, err := NewGameFromField(pol, combo4.LeftI, queue[0], queue[1:4])
+	if err != nil {
+		t.Fatalf("NewGameFromField: %v", err)
+	}
+	plan, err := game.Plan(3)
+	if err != nil {
+		t.Fatalf("Plan: %v", err)
+	}
+	if diff := cmp.Diff(plan, game.Planned()); diff != "" {
+		t.Errorf("Planned() after Plan mismatch (-want +got):\n%s", diff)
+	}
+
+	// The Policy agrees with the plan so the Game follows it.
+	if _, err := game.Step(queue[4]); err != nil {
+		t.Fatalf("Step: %v", err)
+	}
+	if diff := cmp.Diff(plan[0], *game.State()); diff != "" {
+		t.Errorf("State() after Step mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(plan[1:], game.Planned()); diff != "" {
+		t.Errorf("Planned() after Step mismatch (-want +got):\n%s", diff)
+	}
+
+	// The new piece changes the choice so the plan is dropped.
+	if len(nfa.NextStates(*game.State(), game.Preview()[0])) < 2 {
+		t.Fatalf("want a choice between next States from %v with %v", *game.State(), game.Preview()[0])
+	}
+	contradict = true
+	if _, err := game.Step(queue[5]); err != nil {
+		t.Fatalf("Step: %v", err)
+	}
+	if got := game.Planned(); got != nil {
+		t.Errorf("got Planned()=%v after the Policy chose %v instead of %v, want nil", got, game.State(), plan[1])
+	}
+}
@@ -0,0 +1,138 @@
+// Package main plays 4 wide combos with pieces typed into a prompt or piped
+// to stdin.
+//
+// Usage:
+//
+//	play <current piece> [preview]
+//
+// For example the following plays I with SZLOI in the preview and then
+// reads the next pieces from stdin.
+//
+//	echo "SZLOIJT" | play I SZLOI
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"tetris"
+	"tetris/combo4"
+	"tetris/combo4/policy"
+)
+
+var permLen = flag.Int("perm_len", 6, "the length of permutations considered by the NFAScorer")
+
+func main() {
+	flag.Parse()
+
+	moves, _ := combo4.AllContinuousMoves()
+	nfa := combo4.NewNFA(moves)
+	pol := policy.FromScorer(nfa, policy.NewNFAScorer(nfa, *permLen))
+
+	if err := run(pol, os.Stdin, os.Stdout, flag.Args(), isTerminal(os.Stdin)); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+// isTerminal returns whether the file is a terminal as opposed to a pipe or
+// regular file.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// run plays a game starting with the pieces in args and then the pieces read
+// from r. If interactive is set, run prompts for each piece and skips
+// unrecognized input. Otherwise unrecognized input is an error. run returns
+// when r has no more input or there are no more possible moves.
+func run(pol policy.Policy, r io.Reader, w io.Writer, args []string, interactive bool) error {
+	if len(args) == 0 || len(args) > 2 {
+		return errors.New("usage: play <current piece> [preview]")
+	}
+	current := tetris.SeqFromStr(args[0])
+	if len(current) != 1 || current[0] == tetris.EmptyPiece {
+		return fmt.Errorf("invalid current piece %q", args[0])
+	}
+	var preview []tetris.Piece
+	if len(args) == 2 {
+		preview = tetris.SeqFromStr(args[1])
+		if err := checkPieces(preview, args[1]); err != nil {
+			return err
+		}
+	}
+
+	// The pieces may be read in the middle of a game so where the bags start
+	// is unknown.
+	game := policy.NewGame(pol, combo4.State{Field: combo4.LeftI}, current[0], preview, 0, policy.UnknownBagPhase())
+	if !printState(w, game) {
+		return nil
+	}
+
+	scanner := bufio.NewScanner(r)
+	for {
+		if interactive {
+			fmt.Fprint(w, "Next piece: ")
+		}
+		if !scanner.Scan() {
+			break
+		}
+		line := strings.TrimSpace(scanner.Text())
+		pieces := tetris.SeqFromStr(line)
+		if err := checkPieces(pieces, line); err != nil {
+			if interactive {
+				fmt.Fprintln(w, err)
+				continue
+			}
+			return err
+		}
+
+		for _, p := range pieces {
+			if _, err := game.Step(p); err != nil {
+				if interactive {
+					fmt.Fprintln(w, err)
+					continue
+				}
+				return err
+			}
+			if !printState(w, game) {
+				return nil
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Consumed %d pieces.\n", game.Consumed())
+	return nil
+}
+
+// checkPieces returns an error if any of the pieces parsed from s are
+// unrecognized.
+func checkPieces(pieces []tetris.Piece, s string) error {
+	for _, p := range pieces {
+		if p == tetris.EmptyPiece {
+			return fmt.Errorf("invalid pieces %q", s)
+		}
+	}
+	return nil
+}
+
+// printState prints the Game's State and returns false if there are no more
+// possible moves.
+func printState(w io.Writer, game *policy.Game) bool {
+	state := game.State()
+	if state == nil {
+		fmt.Fprintf(w, "No more combos after %d pieces.\n", game.Consumed())
+		return false
+	}
+	fmt.Fprintf(w, "Played %s\n%s\n", game.Current(), state)
+	return true
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"tetris"
+	"tetris/combo4"
+	"tetris/combo4/policy"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRunPiped(t *testing.T) {
+	moves, _ := combo4.AllContinuousMoves()
+	nfa := combo4.NewNFA(moves)
+	pol := policy.FromScorer(nfa, policy.NewNFAScorer(nfa, 3))
+
+	tests := []struct {
+		desc    string
+		current tetris.Piece
+		preview string
+		input   string
+	}{
+		{
+			desc:    "Input ends",
+			current: tetris.Z,
+			preview: "JTSOL",
+			input:   "IL\nTOSIZJ\n",
+		},
+		{
+			desc:    "Game ends",
+			current: tetris.I,
+			preview: "SZLOI",
+			input:   "JTLSZ\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			// Build the expected output by playing the same pieces.
+			preview := tetris.SeqFromStr(test.preview)
+			game := policy.NewGame(pol, combo4.State{Field: combo4.LeftI}, test.current, preview, 0, policy.UnknownBagPhase())
+
+			var want strings.Builder
+			fmt.Fprintf(&want, "Played %s\n%s\n", test.current, game.State())
+			for _, p := range tetris.SeqFromStr(strings.ReplaceAll(test.input, "\n", "")) {
+				state, err := game.Step(p)
+				if err != nil {
+					t.Fatalf("Step(%v): %v", p, err)
+				}
+				if state == nil {
+					break
+				}
+				fmt.Fprintf(&want, "Played %s\n%s\n", game.Current(), state)
+			}
+			if game.State() == nil {
+				fmt.Fprintf(&want, "No more combos after %d pieces.\n", game.Consumed())
+			} else {
+				fmt.Fprintf(&want, "Consumed %d pieces.\n", game.Consumed())
+			}
+
+			var got bytes.Buffer
+			if err := run(pol, strings.NewReader(test.input), &got, []string{test.current.String(), test.preview}, false); err != nil {
+				t.Fatalf("run: %v", err)
+			}
+			if diff := cmp.Diff(want.String(), got.String()); diff != "" {
+				t.Errorf("run output mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	moves, _ := combo4.AllContinuousMoves()
+	nfa := combo4.NewNFA(moves)
+	pol := policy.FromScorer(nfa, policy.NewNFAScorer(nfa, 3))
+
+	tests := []struct {
+		desc  string
+		args  []string
+		input string
+	}{
+		{
+			desc: "No arguments",
+		},
+		{
+			desc: "Invalid current piece",
+			args: []string{"X"},
+		},
+		{
+			desc:  "Invalid input piece",
+			args:  []string{"I", "SZLOI"},
+			input: "JX\n",
+		},
+		{
+			desc:  "Impossible input piece",
+			args:  []string{"I", "SZLOI"},
+			input: "I\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := run(pol, strings.NewReader(test.input), &out, test.args, false); err == nil {
+				t.Errorf("run got no error, want an error")
+			}
+		})
+	}
+}
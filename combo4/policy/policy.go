@@ -13,128 +13,266 @@ type Policy interface {
 	NextState(initial combo4.State, current tetris.Piece, preview []tetris.Piece, endBagUsed tetris.PieceSet) *combo4.State
 }
 
+// PolicyFunc adapts a function to a Policy.
+type PolicyFunc func(initial combo4.State, current tetris.Piece, preview []tetris.Piece, endBagUsed tetris.PieceSet) *combo4.State
+
+// NextState returns f(initial, current, preview, endBagUsed).
+func (f PolicyFunc) NextState(initial combo4.State, current tetris.Piece, preview []tetris.Piece, endBagUsed tetris.PieceSet) *combo4.State {
+	return f(initial, current, preview, endBagUsed)
+}
+
+// WrapWithFallback returns a Policy that uses the fallback when the primary
+// Policy returns nil e.g. for a situation it does not know.
+func WrapWithFallback(primary, fallback Policy) Policy {
+	return PolicyFunc(func(initial combo4.State, current tetris.Piece, preview []tetris.Piece, endBagUsed tetris.PieceSet) *combo4.State {
+		if next := primary.NextState(initial, current, preview, endBagUsed); next != nil {
+			return next
+		}
+		return fallback.NextState(initial, current, preview, endBagUsed)
+	})
+}
+
 // Scorer scores a sitaution on how good it is.
 type Scorer interface {
-	// A higher score means the situation is better than others.
+	// ScoreBreakdown.Compare orders the scores from worst to best.
+	Score(state combo4.State, next []tetris.Piece, bagUsed tetris.PieceSet) ScoreBreakdown
+}
+
+// ScoreBreakdown is the score of a situation. The components are compared in
+// order of importance with Compare so none of them can overflow into the
+// others. A score from a LegacyScorer only has Legacy set and Legacy is the
+// whole score.
+type ScoreBreakdown struct {
+	// The number of the next pieces that can be consumed. More is better.
+	Consumed int
+	// The number of permutations after the next pieces that cannot be
+	// consumed. Fewer is better.
+	Inviable int
+	// The number of States that can be reached after the next pieces. More
+	// is better.
+	NumStates int
+	// The estimated probability that the combo continues past the next
+	// pieces e.g. from an ExtendedScorer. More is better.
+	Continuation float64
+	// The blended score in [0, 1] from a CompositeScorer or the score of a
+	// FieldScorer. More is better.
+	Normalized float64
+	// The score from a LegacyScorer. More is better. It is 0 for every
+	// other Scorer.
+	Legacy int64
+}
+
+// Compare returns -1 if the score is worse than the other, 1 if it is better
+// and 0 if they are equal. Scores from a LegacyScorer are compared by Legacy.
+// Other scores are compared by Consumed, Inviable, NumStates, Continuation
+// and then Normalized.
+func (s ScoreBreakdown) Compare(other ScoreBreakdown) int {
+	switch {
+	case s.Legacy != other.Legacy:
+		return compareInts(s.Legacy, other.Legacy)
+	case s.Consumed != other.Consumed:
+		return compareInts(int64(s.Consumed), int64(other.Consumed))
+	case s.Inviable != other.Inviable:
+		return compareInts(int64(other.Inviable), int64(s.Inviable))
+	case s.NumStates != other.NumStates:
+		return compareInts(int64(s.NumStates), int64(other.NumStates))
+	case s.Continuation < other.Continuation:
+		return -1
+	case s.Continuation > other.Continuation:
+		return 1
+	case s.Normalized < other.Normalized:
+		return -1
+	case s.Normalized > other.Normalized:
+		return 1
+	}
+	return 0
+}
+
+func compareInts(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// Value returns the score as a float64 that is ordered the same way as
+// Compare if Consumed is less than 2^13, Inviable is less than 2^40 and
+// NumStates is less than 2^10. It is used where scores are averaged. The
+// Continuation and Normalized are added in that order as a fraction below the
+// Int64 so they break its ties. A float64 only keeps the fraction while the
+// Int64 is small, e.g. for the scores of a CompositeScorer, and the
+// Continuation must differ by more than 2^-32 to take precedence over the
+// Normalized.
+func (s ScoreBreakdown) Value() float64 {
+	return float64(s.Int64()) + s.Continuation/2 + s.Normalized/(1<<33)
+}
+
+// Int64 returns the score packed into an int64 like the scores from before
+// ScoreBreakdown with the same bounds as Value. It is Legacy for a score
+// from a LegacyScorer.
+func (s ScoreBreakdown) Int64() int64 {
+	if s.Legacy != 0 {
+		return s.Legacy
+	}
+	return int64(s.Consumed<<50) - int64(s.Inviable<<10) + int64(s.NumStates)
+}
+
+// LegacyScorer is the Scorer interface from before ScoreBreakdown.
+//
+// Deprecated: Implement Scorer instead. LegacyScorer will be removed in the
+// next release.
+type LegacyScorer interface {
 	Score(state combo4.State, next []tetris.Piece, bagUsed tetris.PieceSet) int64
 }
 
+// FromLegacyScorer adapts a LegacyScorer to a Scorer. The scores are set as
+// the Legacy component and are the whole score.
+//
+// Deprecated: Implement Scorer instead. FromLegacyScorer will be removed in
+// the next release.
+func FromLegacyScorer(s LegacyScorer) Scorer {
+	return legacyScorer{s}
+}
+
+type legacyScorer struct {
+	LegacyScorer
+}
+
+func (s legacyScorer) Score(state combo4.State, next []tetris.Piece, bagUsed tetris.PieceSet) ScoreBreakdown {
+	return ScoreBreakdown{Legacy: s.LegacyScorer.Score(state, next, bagUsed)}
+}
+
 // scorePolicy picks the next best state based on a Scorer.
 type scorePolicy struct {
 	nfa    *combo4.NFA
 	scorer Scorer
+
+	// The actions of each Move used to break ties. nil if ties are not
+	// broken by the actions.
+	mActions map[combo4.Move][]tetris.Action
+	// The cache of scores or nil if scores are not cached.
+	cache *ScoreCache
+	// The Scorer used to break ties between states with the same score or
+	// nil if ties are not broken by a Scorer.
+	tieBreak Scorer
+}
+
+// ScorePolicyOption configures a Policy created by FromScorer.
+type ScorePolicyOption func(*scorePolicy)
+
+// PreferFewerKeys breaks ties between states with the same score by picking
+// the state that takes the fewest key presses to reach. The actions are
+// usually from combo4.AllContinuousMoves.
+func PreferFewerKeys(mActions map[combo4.Move][]tetris.Action) ScorePolicyOption {
+	return func(p *scorePolicy) {
+		p.mActions = mActions
+	}
+}
+
+// WithTieBreak breaks ties between states with the same score by picking the
+// state with the best score from the tieBreak Scorer e.g. one that prefers
+// flatter fields. The tieBreak Scorer is only used for ties so the choices
+// with a different score are unchanged. Ties that remain are broken by
+// PreferFewerKeys if it is also used.
+func WithTieBreak(tieBreak Scorer) ScorePolicyOption {
+	return func(p *scorePolicy) {
+		p.tieBreak = tieBreak
+	}
 }
 
 // FromScorer creates a new Policy based on a Scorer.
-func FromScorer(nfa *combo4.NFA, scorer Scorer) Policy {
-	return &scorePolicy{
+func FromScorer(nfa *combo4.NFA, scorer Scorer, opts ...ScorePolicyOption) Policy {
+	p := &scorePolicy{
 		nfa:    nfa,
 		scorer: scorer,
 	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
 }
 
 // NextState returns the best possible next state or nil if there are no
 // possible moves.
 func (p *scorePolicy) NextState(initial combo4.State, current tetris.Piece, preview []tetris.Piece, endBagUsed tetris.PieceSet) *combo4.State {
+	next, _, _ := p.choose(initial, current, preview, endBagUsed)
+	return next
+}
+
+// choose returns the best possible next state like NextState along with the
+// choices and their scores. The scores are nil if there are fewer than two
+// choices since they are not scored.
+func (p *scorePolicy) choose(initial combo4.State, current tetris.Piece, preview []tetris.Piece, endBagUsed tetris.PieceSet) (*combo4.State, []combo4.State, []ScoreBreakdown) {
 	choices := p.nfa.NextStates(initial, current)
 	switch len(choices) {
 	case 0:
-		return nil
+		return nil, nil, nil
 	case 1:
-		return &choices[0]
+		return &choices[0], choices, nil
 	}
 
-	scores := make([]int64, len(choices))
+	scores := make([]ScoreBreakdown, len(choices))
 	var wg sync.WaitGroup
 	wg.Add(len(choices))
 	for idx, choice := range choices {
 		idx, choice := idx, choice // Capture range variables.
 		go func() {
-			scores[idx] = p.scorer.Score(choice, preview, endBagUsed)
+			scores[idx] = p.cache.score(p.scorer, choice, preview, endBagUsed)
 			wg.Done()
 		}()
 	}
 	wg.Wait()
 
-	var (
-		bestState combo4.State
-		bestScore int64 = math.MinInt64
-	)
-	for idx, score := range scores {
-		if score > bestScore {
-			bestScore = score
-			bestState = choices[idx]
+	best := bestIndices(scores)
+	if len(best) > 1 && p.tieBreak != nil {
+		tieScores := make([]ScoreBreakdown, len(best))
+		for i, idx := range best {
+			tieScores[i] = p.tieBreak.Score(choices[idx], preview, endBagUsed)
 		}
+		tied := bestIndices(tieScores)
+		for i, tieIdx := range tied {
+			tied[i] = best[tieIdx]
+		}
+		best = tied
 	}
 
-	return &bestState
-}
-
-// StartGame returns a channel that outputs the next state after the beginning
-// and then an additional state for each input. The channel returns nil if
-// there are no more possible moves.
-//
-// StartGame assumes there is no piece held and the game is starting with no
-// pieces played yet (starting with an empty bag).
-//
-// StartGame panics if a piece that does not follow the 7 bag randomizer is
-// added to the input channel.
-func StartGame(pol Policy, initial combo4.Field4x4, current tetris.Piece, next []tetris.Piece, input chan tetris.Piece) chan *combo4.State {
-	bag := current.PieceSet()
-	for _, n := range next {
-		bag = bag.Add(n)
-		if bag.Len() == 7 {
-			bag = 0
+	bestState := choices[best[0]]
+	if len(best) > 1 && p.mActions != nil {
+		bestCost := actionsCost(p.mActions, initial, bestState, current)
+		for _, idx := range best[1:] {
+			if cost := actionsCost(p.mActions, initial, choices[idx], current); cost < bestCost {
+				bestState = choices[idx]
+				bestCost = cost
+			}
 		}
 	}
-	return ResumeGame(pol, combo4.State{Field: initial}, current, next, bag, input)
+	return &bestState, choices, scores
 }
 
-// ResumeGame is like StartGame but does not assume the game is played from
-// the beginning.
-func ResumeGame(pol Policy, initialState combo4.State, current tetris.Piece, next []tetris.Piece, endBagUsed tetris.PieceSet, input chan tetris.Piece) chan *combo4.State {
-	// Make a copy of next because we will be modifying it.
-	cpy := make([]tetris.Piece, len(next))
-	copy(cpy, next)
-	next = cpy
-
-	output := make(chan *combo4.State, len(input))
-	go func() {
-		defer close(output)
-
-		// Output the first move.
-		state := pol.NextState(initialState, current, next, endBagUsed)
-		output <- state
-
-		for p := range input {
-			if state == nil {
-				output <- nil
-				continue
-			}
-
-			// Shift next and the current piece.
-			if len(next) == 0 {
-				current = p
-			} else {
-				current = next[0]
-
-				copy(next, next[1:])
-				next[len(next)-1] = p
-			}
-
-			// Update the bag.
-			if endBagUsed.Len() == 7 {
-				endBagUsed = 0
-			}
-			if endBagUsed.Contains(p) {
-				panic(`impossible piece "` + p.String() + `" for bag state ` + endBagUsed.String())
-			}
-			endBagUsed = endBagUsed.Add(p)
-
-			state = pol.NextState(*state, current, next, endBagUsed)
-			output <- state
+// bestIndices returns the indices of the best scores in order.
+func bestIndices(scores []ScoreBreakdown) []int {
+	best := []int{0}
+	for idx, score := range scores[1:] {
+		idx++
+		switch cmp := score.Compare(scores[best[0]]); {
+		case cmp > 0:
+			best = append(best[:0], idx)
+		case cmp == 0:
+			best = append(best, idx)
 		}
-	}()
+	}
+	return best
+}
 
-	return output
+// actionsCost returns the number of key presses to go from prev to next.
+// Transitions with unknown actions cost the most.
+func actionsCost(mActions map[combo4.Move][]tetris.Action, prev, next combo4.State, current tetris.Piece) int {
+	actions, ok := combo4.TransitionActions(mActions, prev, next, current)
+	if !ok {
+		return math.MaxInt32
+	}
+	return tetris.ActionsCost(actions)
 }
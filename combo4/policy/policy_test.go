@@ -1,18 +1,30 @@
 package policy
 
 import (
+	"errors"
+	"math/bits"
 	"math/rand"
 	"testing"
 	"tetris"
 	"tetris/combo4"
+
+	"github.com/google/go-cmp/cmp"
 )
 
 func BenchmarkNextState(b *testing.B) {
+	benchmarkNextState(b, 7)
+}
+
+func BenchmarkNextState9(b *testing.B) {
+	benchmarkNextState(b, 9)
+}
+
+func benchmarkNextState(b *testing.B, permLen int) {
 	moves, _ := combo4.AllContinuousMoves()
 	nfa := combo4.NewNFA(moves)
 	states := nfa.States().Slice()
 
-	p := FromScorer(nfa, NewNFAScorer(nfa, 7))
+	p := FromScorer(nfa, NewNFAScorer(nfa, permLen))
 
 	b.ResetTimer()
 	for n := 0; n < b.N; n++ {
@@ -25,32 +37,472 @@ func BenchmarkNextState(b *testing.B) {
 }
 
 func testPolicySucessRate(t *testing.T, p Policy, want float64) {
-	const (
-		trials         = 100
-		piecesPerTrial = 100
-	)
-	rand.Seed(110)
-
-	var incomplete int
-	for t := 0; t < trials; t++ {
-		queue := tetris.RandPieces(piecesPerTrial)
+	res := Evaluate(p, EvalOptions{
+		Trials:         100,
+		PiecesPerTrial: 93,
+		PreviewSize:    6,
+		Rand:           rand.New(rand.NewSource(110)),
+	})
+	if res.WinRate < want {
+		t.Errorf("Policy has win rate=%.2f, want at least %.2f", res.WinRate, want)
+	}
+}
+
+func TestNFASucessRate(t *testing.T) {
+	moves, _ := combo4.AllContinuousMoves()
+	nfa := combo4.NewNFA(moves)
+	testPolicySucessRate(t, FromScorer(nfa, NewNFAScorer(nfa, 7)), 0.7)
+}
+
+// constScorer gives every situation the same score.
+type constScorer struct{}
+
+func (constScorer) Score(combo4.State, []tetris.Piece, tetris.PieceSet) ScoreBreakdown {
+	return ScoreBreakdown{}
+}
+
+func TestPreferFewerKeys(t *testing.T) {
+	moves, mActions := combo4.AllContinuousMoves()
+	nfa := combo4.NewNFA(moves)
+	// Every choice ties so the number of key presses decides.
+	p := FromScorer(nfa, constScorer{}, PreferFewerKeys(mActions))
+	firstPolicy := FromScorer(nfa, constScorer{})
+
+	var cheaper int
+	for state := range nfa.States() {
+		for _, piece := range tetris.NonemptyPieces {
+			choices := nfa.NextStates(state, piece)
+			if len(choices) == 0 {
+				continue
+			}
+			got := p.NextState(state, piece, nil, 0)
+			gotCost := actionsCost(mActions, state, *got, piece)
+			for _, choice := range choices {
+				if cost := actionsCost(mActions, state, choice, piece); cost < gotCost {
+					t.Fatalf("NextState(%v, %v) got %v with cost %d, want %v with cost %d", state, piece, *got, gotCost, choice, cost)
+				}
+			}
+			first := firstPolicy.NextState(state, piece, nil, 0)
+			if actionsCost(mActions, state, *first, piece) > gotCost {
+				cheaper++
+			}
+		}
+	}
+	if cheaper == 0 {
+		t.Errorf("PreferFewerKeys never picked a cheaper move than the first tie")
+	}
+}
+
+// emptierScorer prefers States with fewer cells filled.
+type emptierScorer struct{}
+
+func (emptierScorer) Score(state combo4.State, _ []tetris.Piece, _ tetris.PieceSet) ScoreBreakdown {
+	return ScoreBreakdown{Legacy: -int64(bits.OnesCount16(uint16(state.Field)))}
+}
+
+func TestWithTieBreak(t *testing.T) {
+	moves, mActions := combo4.AllContinuousMoves()
+	nfa := combo4.NewNFA(moves)
+	// Every choice ties so the tie-break Scorer decides.
+	p := FromScorer(nfa, constScorer{}, WithTieBreak(emptierScorer{}), PreferFewerKeys(mActions))
+
+	var changed int
+	for state := range nfa.States() {
+		for _, piece := range tetris.NonemptyPieces {
+			choices := nfa.NextStates(state, piece)
+			if len(choices) == 0 {
+				continue
+			}
+			got := p.NextState(state, piece, nil, 0)
+			gotScore := emptierScorer{}.Score(*got, nil, 0)
+			for _, choice := range choices {
+				if score := (emptierScorer{}).Score(choice, nil, 0); score.Compare(gotScore) > 0 {
+					t.Fatalf("NextState(%v, %v) got %v, want %v with fewer cells filled", state, piece, *got, choice)
+				}
+			}
+			if again := p.NextState(state, piece, nil, 0); *again != *got {
+				t.Fatalf("NextState(%v, %v) got %v then %v", state, piece, *got, *again)
+			}
+			if *got != choices[0] {
+				changed++
+			}
+		}
+	}
+	if changed == 0 {
+		t.Errorf("WithTieBreak never picked a different move than the first tie")
+	}
+
+	// The tie-break Scorer is not used when the scores differ.
+	primary := FromScorer(nfa, emptierScorer{})
+	reversed := FromScorer(nfa, emptierScorer{}, WithTieBreak(negatedScorer{emptierScorer{}}))
+	for state := range nfa.States() {
+		if got, want := reversed.NextState(state, tetris.T, nil, 0), primary.NextState(state, tetris.T, nil, 0); got != nil && *got != *want {
+			t.Fatalf("NextState(%v, T) with a tie-break got %v, want %v", state, *got, *want)
+		}
+	}
+}
+
+// negatedScorer reverses the order of a Scorer that only sets Legacy.
+type negatedScorer struct {
+	s Scorer
+}
+
+func (n negatedScorer) Score(state combo4.State, next []tetris.Piece, bagUsed tetris.PieceSet) ScoreBreakdown {
+	return ScoreBreakdown{Legacy: -n.s.Score(state, next, bagUsed).Legacy}
+}
+
+func TestWrapWithFallback(t *testing.T) {
+	moves, _ := combo4.AllContinuousMoves()
+	nfa := combo4.NewNFA(moves)
+	// The primary only knows the States with an empty hold.
+	primary := PolicyFunc(func(initial combo4.State, current tetris.Piece, preview []tetris.Piece, endBagUsed tetris.PieceSet) *combo4.State {
+		if initial.Hold != tetris.EmptyPiece {
+			return nil
+		}
+		choices := nfa.NextStates(initial, current)
+		if len(choices) == 0 {
+			return nil
+		}
+		return &choices[0]
+	})
+	fallback := &recordingPolicy{pol: FromScorer(nfa, NewNFAScorer(nfa, 3))}
+	p := WrapWithFallback(primary, fallback)
+
+	empty := combo4.State{Field: combo4.LeftI}
+	if got, want := p.NextState(empty, tetris.T, nil, 0), primary(empty, tetris.T, nil, 0); got == nil || *got != *want {
+		t.Errorf("NextState with an empty hold got %v, want the primary choice %v", got, *want)
+	}
+	if len(fallback.calls) != 0 {
+		t.Errorf("the fallback was called %d times when the primary chose", len(fallback.calls))
+	}
+
+	held := combo4.State{Field: combo4.LeftI, Hold: tetris.I}
+	preview := []tetris.Piece{tetris.O, tetris.S}
+	if got, want := p.NextState(held, tetris.T, preview, 0), fallback.pol.NextState(held, tetris.T, preview, 0); got == nil || *got != *want {
+		t.Errorf("NextState with a held piece got %v, want the fallback choice %v", got, *want)
+	}
+	if diff := cmp.Diff([]policyCall{{held, tetris.T, preview, 0}}, fallback.calls, cmp.AllowUnexported(policyCall{})); diff != "" {
+		t.Errorf("fallback calls mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// policyCall is the arguments to a call to Policy.NextState.
+type policyCall struct {
+	initial combo4.State
+	current tetris.Piece
+	preview []tetris.Piece
+	bag     tetris.PieceSet
+}
+
+// recordingPolicy records all calls before delegating to another Policy.
+type recordingPolicy struct {
+	pol   Policy
+	calls []policyCall
+}
+
+func (r *recordingPolicy) NextState(initial combo4.State, current tetris.Piece, preview []tetris.Piece, endBagUsed tetris.PieceSet) *combo4.State {
+	r.calls = append(r.calls, policyCall{
+		initial: initial,
+		current: current,
+		preview: append([]tetris.Piece(nil), preview...),
+		bag:     endBagUsed,
+	})
+	return r.pol.NextState(initial, current, preview, endBagUsed)
+}
+
+func TestResumeGame(t *testing.T) {
+	moves, _ := combo4.AllContinuousMoves()
+	nfa := combo4.NewNFA(moves)
+	pol := FromScorer(nfa, NewNFAScorer(nfa, 3))
+
+	// Resume with a piece held in the middle of a bag.
+	initial := combo4.State{
+		Hold: tetris.J,
+		Field: combo4.NewField4x4([][4]bool{
+			{true, false, false, false},
+			{true, true, false, false},
+		}),
+	}
+	current := tetris.S
+	preview := []tetris.Piece{tetris.O}
+	bag := tetris.NewPieceSet(tetris.O, tetris.S)
+
+	r := rand.New(rand.NewSource(1))
+	var maxCalls int
+	for trial := 0; trial < 20; trial++ {
+		// Like TestMDPExpectedValue, the rest of the bag comes in a random
+		// order followed by whole bags.
+		rest := bag.Inverted().Slice()
+		r.Shuffle(len(rest), func(i, j int) { rest[i], rest[j] = rest[j], rest[i] })
+		bags := [][]tetris.Piece{append([]tetris.Piece{current, preview[0]}, rest...)}
+		for i := 0; i < 3; i++ {
+			bags = append(bags, tetris.RandPiecesFrom(r, 7))
+		}
+		// The pieces in order and the bag state after each of them.
+		var (
+			pieces  []tetris.Piece
+			bagUsed []tetris.PieceSet
+		)
+		for _, b := range bags {
+			for k, p := range b {
+				pieces = append(pieces, p)
+				bagUsed = append(bagUsed, tetris.NewPieceSet(b[:k+1]...))
+			}
+		}
+
+		rec := &recordingPolicy{pol: pol}
 		input := make(chan tetris.Piece, 1)
-		output := StartGame(p, combo4.LeftI, queue[0], queue[1:7], input)
-		for _, p := range queue[7:] {
-			input <- p
-			if <-output == nil {
-				incomplete++
+		output := ResumeGame(rec, initial, current, preview, bag, input)
+		states := []*combo4.State{(<-output).State}
+		for _, p := range pieces[2:] {
+			if states[len(states)-1] == nil {
 				break
 			}
+			input <- p
+			states = append(states, (<-output).State)
+		}
+		close(input)
+
+		if len(rec.calls) > maxCalls {
+			maxCalls = len(rec.calls)
+		}
+		for idx, call := range rec.calls {
+			wantInitial := initial
+			if idx > 0 {
+				wantInitial = *states[idx-1]
+			}
+			if call.initial != wantInitial {
+				t.Errorf("trial #%d call #%d got initial %v, want %v", trial, idx, call.initial, wantInitial)
+			}
+			if call.current != pieces[idx] {
+				t.Errorf("trial #%d call #%d got current %v, want %v", trial, idx, call.current, pieces[idx])
+			}
+			if want := pieces[idx+1 : idx+2]; !cmp.Equal(call.preview, want) {
+				t.Errorf("trial #%d call #%d got preview %v, want %v", trial, idx, call.preview, want)
+			}
+			if want := bagUsed[idx+1]; call.bag != want {
+				t.Errorf("trial #%d call #%d got bag %v, want %v", trial, idx, call.bag, want)
+			}
 		}
 	}
-	if ratio := 1 - float64(incomplete)/trials; ratio < want {
-		t.Errorf("Decider has win rate=%.2f, want at least %.2f", ratio, want)
+	// The bag state must be followed into the next bags.
+	if maxCalls <= 7 {
+		t.Errorf("got at most %d calls, want a game past the first bag", maxCalls)
 	}
 }
 
-func TestNFASucessRate(t *testing.T) {
+func TestResumeGameWithReports(t *testing.T) {
 	moves, _ := combo4.AllContinuousMoves()
 	nfa := combo4.NewNFA(moves)
-	testPolicySucessRate(t, FromScorer(nfa, NewNFAScorer(nfa, 7)), 0.7)
+	rec := &recordingPolicy{pol: FromScorer(nfa, NewNFAScorer(nfa, 3))}
+
+	queue := tetris.SeqFromStr("TLJSZOIOJTSZLI")
+	input := make(chan tetris.Piece, 1)
+	reports := make(chan StateReport, 1)
+	output := ResumeGameWithReports(rec, nfa, combo4.State{Field: combo4.LeftI}, queue[0], queue[1:3], tetris.NewPieceSet(queue[:3]...), input, reports)
+	if (<-output).State == nil {
+		t.Fatalf("got no first state")
+	}
+
+	// The game diverged from the chosen state. The L piece was played from
+	// a different field instead.
+	actual := StateReport{
+		State:   combo4.State{Field: combo4.RightI, Hold: tetris.T},
+		Current: tetris.L,
+	}
+	reports <- actual
+	corrected := (<-output).State
+	if corrected == nil {
+		t.Fatalf("got no state after the report")
+	}
+	if got := rec.calls[len(rec.calls)-1]; got.initial != actual.State || got.current != actual.Current {
+		t.Errorf("after the report NextState got (%v, %v), want (%v, %v)", got.initial, got.current, actual.State, actual.Current)
+	}
+
+	// Play continues from the corrected state.
+	input <- queue[3]
+	if (<-output).State == nil {
+		t.Fatalf("got no state after the next input")
+	}
+	if got := rec.calls[len(rec.calls)-1]; got.initial != *corrected || got.current != queue[1] {
+		t.Errorf("after the next input NextState got (%v, %v), want (%v, %v)", got.initial, got.current, *corrected, queue[1])
+	}
+}
+
+func TestGameImpossiblePiece(t *testing.T) {
+	moves, _ := combo4.AllContinuousMoves()
+	nfa := combo4.NewNFA(moves)
+	pol := FromScorer(nfa, NewNFAScorer(nfa, 3))
+
+	t.Run("Input piece", func(t *testing.T) {
+		input := make(chan tetris.Piece, 1)
+		output := StartGame(pol, combo4.LeftI, tetris.T, []tetris.Piece{tetris.L, tetris.J}, input)
+		if (<-output).State == nil {
+			t.Fatalf("got no first state")
+		}
+
+		input <- tetris.L
+		decision := <-output
+		var impossible *ErrImpossiblePiece
+		if !errors.As(decision.Err, &impossible) {
+			t.Fatalf("got Err=%v, want an ErrImpossiblePiece", decision.Err)
+		}
+		if want := tetris.NewPieceSet(tetris.T, tetris.L, tetris.J); impossible.Piece != tetris.L || impossible.BagUsed != want {
+			t.Errorf("got ErrImpossiblePiece{%v, %v}, want {L, %v}", impossible.Piece, impossible.BagUsed, want)
+		}
+
+		// The game continues after a valid piece.
+		input <- tetris.S
+		if decision := <-output; decision.Err != nil || decision.State == nil {
+			t.Errorf("got %+v after a valid piece, want a State", decision)
+		}
+	})
+
+	t.Run("Starting piece", func(t *testing.T) {
+		output := StartGame(pol, combo4.LeftI, tetris.T, []tetris.Piece{tetris.T}, make(chan tetris.Piece))
+		var impossible *ErrImpossiblePiece
+		if decision := <-output; !errors.As(decision.Err, &impossible) {
+			t.Errorf("got Err=%v, want an ErrImpossiblePiece", decision.Err)
+		}
+		if _, ok := <-output; ok {
+			t.Errorf("got more than one output")
+		}
+	})
+
+	t.Run("Invalid report", func(t *testing.T) {
+		reports := make(chan StateReport, 1)
+		output := ResumeGameWithReports(pol, nfa, combo4.State{Field: combo4.LeftI}, tetris.T, nil, tetris.NewPieceSet(tetris.T), make(chan tetris.Piece), reports)
+		<-output
+
+		reports <- StateReport{State: combo4.State{Field: combo4.LeftI, SwapRestricted: true}, Current: tetris.L}
+		if decision := <-output; !errors.Is(decision.Err, ErrInvalidReport) {
+			t.Errorf("got Err=%v, want ErrInvalidReport", decision.Err)
+		}
+	})
+}
+
+func TestResumeGameInvalid(t *testing.T) {
+	moves, _ := combo4.AllContinuousMoves()
+	nfa := combo4.NewNFA(moves)
+	rec := &recordingPolicy{pol: FromScorer(nfa, NewNFAScorer(nfa, 3))}
+
+	// O is in the preview but not in the bag.
+	output := ResumeGame(rec, combo4.State{Field: combo4.LeftI}, tetris.S, []tetris.Piece{tetris.O}, tetris.S.PieceSet(), make(chan tetris.Piece))
+	decision, ok := <-output
+	if !ok || decision.Err == nil || decision.State != nil {
+		t.Errorf("got Decision %+v, want only an Err", decision)
+	}
+	if _, ok := <-output; ok {
+		t.Errorf("got more than one Decision")
+	}
+	if len(rec.calls) != 0 {
+		t.Errorf("got %d calls to the Policy, want 0", len(rec.calls))
+	}
+}
+
+func TestResumeGameBagViolation(t *testing.T) {
+	moves, _ := combo4.AllContinuousMoves()
+	nfa := combo4.NewNFA(moves)
+	pol := FromScorer(nfa, NewNFAScorer(nfa, 3))
+	initial := combo4.State{Field: combo4.LeftI}
+
+	// O is in the preview but not in the bag.
+	resumes := map[string]func(opts ...GameOption) chan Decision{
+		"ResumeGame": func(opts ...GameOption) chan Decision {
+			return ResumeGame(pol, initial, tetris.S, []tetris.Piece{tetris.O}, tetris.S.PieceSet(), make(chan tetris.Piece), opts...)
+		},
+		"ResumeGameWithReports": func(opts ...GameOption) chan Decision {
+			return ResumeGameWithReports(pol, nfa, initial, tetris.S, []tetris.Piece{tetris.O}, tetris.S.PieceSet(), make(chan tetris.Piece), nil, opts...)
+		},
+	}
+	for name, resume := range resumes {
+		if d := <-resume(); d.Err == nil || d.State != nil {
+			t.Errorf("%s got Decision %+v, want only an Err", name, d)
+		}
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s with PanicOnBagViolation did not panic", name)
+				}
+			}()
+			resume(PanicOnBagViolation())
+		}()
+	}
+}
+
+func TestResumeGameMalformedState(t *testing.T) {
+	moves, _ := combo4.AllContinuousMoves()
+	nfa := combo4.NewNFA(moves)
+	rec := &recordingPolicy{pol: FromScorer(nfa, NewNFAScorer(nfa, 3))}
+
+	// A swap restricted State always has a piece held.
+	malformed := combo4.State{Field: combo4.LeftI, SwapRestricted: true}
+	output := ResumeGame(rec, malformed, tetris.S, []tetris.Piece{tetris.O}, tetris.NewPieceSet(tetris.S, tetris.O), make(chan tetris.Piece), CheckState(nfa))
+	decision, ok := <-output
+	if !ok || !errors.Is(decision.Err, ErrMalformedState) || decision.State != nil {
+		t.Errorf("got Decision %+v, want only an ErrMalformedState", decision)
+	}
+	if _, ok := <-output; ok {
+		t.Errorf("got more than one Decision")
+	}
+	if len(rec.calls) != 0 {
+		t.Errorf("got %d calls to the Policy, want 0", len(rec.calls))
+	}
+
+	// Without CheckState the game looks like it has no possible moves.
+	output = ResumeGame(rec, malformed, tetris.S, []tetris.Piece{tetris.O}, tetris.NewPieceSet(tetris.S, tetris.O), make(chan tetris.Piece))
+	if decision := <-output; decision.Err != nil || decision.State != nil {
+		t.Errorf("got Decision %+v without CheckState, want no State or Err", decision)
+	}
+}
+
+func TestCheckResumeState(t *testing.T) {
+	moves, _ := combo4.AllContinuousMoves()
+	nfa := combo4.NewNFA(moves)
+
+	// Find a State that cannot continue with some piece.
+	var deadEnd combo4.State
+	var deadPiece tetris.Piece
+	for state := range nfa.States() {
+		for _, p := range tetris.NonemptyPieces {
+			if nfa.HasState(state) && len(nfa.NextStates(state, p)) == 0 {
+				deadEnd, deadPiece = state, p
+			}
+		}
+	}
+	if deadPiece == tetris.EmptyPiece {
+		t.Fatal("no State without transitions for a piece")
+	}
+
+	tests := []struct {
+		desc    string
+		state   combo4.State
+		current tetris.Piece
+		want    error
+	}{
+		{
+			desc:    "Valid",
+			state:   combo4.State{Field: combo4.LeftI},
+			current: tetris.I,
+		},
+		{
+			desc:    "Swap restricted without a held piece",
+			state:   combo4.State{Field: combo4.LeftI, SwapRestricted: true},
+			current: tetris.I,
+			want:    ErrMalformedState,
+		},
+		{
+			desc:    "Dead end",
+			state:   deadEnd,
+			current: deadPiece,
+			want:    ErrDeadEnd,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			if err := CheckResumeState(nfa, test.state, test.current); !errors.Is(err, test.want) {
+				t.Errorf("CheckResumeState(%v, %v) got err=%v, want %v", test.state, test.current, err, test.want)
+			}
+		})
+	}
 }
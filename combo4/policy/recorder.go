@@ -0,0 +1,300 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"tetris"
+	"tetris/combo4"
+	"time"
+)
+
+// DecisionRecord is a NextState call logged by a Recorder.
+type DecisionRecord struct {
+	Initial combo4.State
+	Current tetris.Piece
+	Preview []tetris.Piece
+	BagUsed tetris.PieceSet
+	// The States that could be chosen with their scores. It is empty if the
+	// Policy does not score its choices or there was only one choice. See
+	// NewRecorder.
+	Candidates []CandidateScore
+	// The State chosen or nil if the Policy returned nil.
+	Chosen *combo4.State
+	// How long the NextState call of the Policy took.
+	Latency time.Duration
+}
+
+// CandidateScore is a State that could be chosen and its score.
+type CandidateScore struct {
+	State combo4.State
+	Score ScoreBreakdown
+}
+
+// candidateScorer is implemented by the Policies that can return the scores
+// of the States they chose from along with their choice.
+type candidateScorer interface {
+	nextStateWithCandidates(initial combo4.State, current tetris.Piece, preview []tetris.Piece, endBagUsed tetris.PieceSet) (*combo4.State, []CandidateScore)
+}
+
+func (p *scorePolicy) nextStateWithCandidates(initial combo4.State, current tetris.Piece, preview []tetris.Piece, endBagUsed tetris.PieceSet) (*combo4.State, []CandidateScore) {
+	next, choices, scores := p.choose(initial, current, preview, endBagUsed)
+	if scores == nil {
+		return next, nil
+	}
+	candidates := make([]CandidateScore, len(choices))
+	for i, choice := range choices {
+		candidates[i] = CandidateScore{State: choice, Score: scores[i]}
+	}
+	return next, candidates
+}
+
+// nextStateWithCandidates chooses like NextState. The MDPPolicy only keeps
+// its choices and not their values so the candidates are scored by the
+// fallback if it scores its choices. The choice of the policy can differ from
+// the candidate with the best score.
+func (m *MDPPolicy) nextStateWithCandidates(initial combo4.State, current tetris.Piece, preview []tetris.Piece, endBagUsed tetris.PieceSet) (*combo4.State, []CandidateScore) {
+	gState, err := m.newGameState(initial, current, preview, endBagUsed)
+	if err != nil {
+		atomic.AddInt64(&m.invalid, 1)
+		return nil, nil
+	}
+	next := m.known(gState, preview)
+	scorer, ok := m.fallback().(candidateScorer)
+	if !ok {
+		if next == nil {
+			next = m.fallback().NextState(initial, current, preview, endBagUsed)
+		}
+		return next, nil
+	}
+	fallbackNext, candidates := scorer.nextStateWithCandidates(initial, current, preview, endBagUsed)
+	if next == nil {
+		next = fallbackNext
+	}
+	return next, candidates
+}
+
+// Recorder logs every NextState call of a Policy as a line of JSON.
+//
+// Recorder is safe for concurrent use if the wrapped Policy is but the
+// lines of concurrent games are interleaved.
+type Recorder struct {
+	pol Policy
+
+	mu  sync.Mutex
+	enc *json.Encoder
+	err error
+}
+
+// NewRecorder wraps a Policy to write a DecisionRecord for each NextState
+// call to w. The candidates are only recorded for a Policy from FromScorer
+// and come from the scores it chose with, and for an MDPPolicy whose fallback
+// is one. Either may be wrapped by Instrumented. Use a RecordReader to read
+// the records.
+func NewRecorder(pol Policy, w io.Writer) *Recorder {
+	return &Recorder{pol: pol, enc: json.NewEncoder(w)}
+}
+
+// NextState calls NextState of the wrapped Policy and records the decision.
+// The decision is returned even if it cannot be written. See Err.
+func (r *Recorder) NextState(initial combo4.State, current tetris.Piece, preview []tetris.Piece, endBagUsed tetris.PieceSet) *combo4.State {
+	start := time.Now()
+	var (
+		next       *combo4.State
+		candidates []CandidateScore
+	)
+	if scorer, ok := r.pol.(candidateScorer); ok {
+		next, candidates = scorer.nextStateWithCandidates(initial, current, preview, endBagUsed)
+	} else {
+		next = r.pol.NextState(initial, current, preview, endBagUsed)
+	}
+	rec := DecisionRecord{
+		Initial:    initial,
+		Current:    current,
+		Preview:    preview,
+		BagUsed:    endBagUsed,
+		Candidates: candidates,
+		Chosen:     next,
+		Latency:    time.Since(start),
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err == nil {
+		if err := r.enc.Encode(newJSONDecision(rec)); err != nil {
+			r.err = fmt.Errorf("failed to write a decision: %v", err)
+		}
+	}
+	return next
+}
+
+// Err returns the first error writing a record or nil. No records are
+// written after an error.
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// RecordReader reads the DecisionRecords written by a Recorder.
+type RecordReader struct {
+	dec *json.Decoder
+}
+
+// NewRecordReader returns a RecordReader of the records in r.
+func NewRecordReader(r io.Reader) *RecordReader {
+	return &RecordReader{dec: json.NewDecoder(r)}
+}
+
+// Next returns the next DecisionRecord or io.EOF after the last one.
+func (r *RecordReader) Next() (DecisionRecord, error) {
+	var d jsonDecision
+	if err := r.dec.Decode(&d); err != nil {
+		if err == io.EOF {
+			return DecisionRecord{}, err
+		}
+		return DecisionRecord{}, fmt.Errorf("invalid record: %v", err)
+	}
+	return d.record()
+}
+
+// ReadRecords returns all the DecisionRecords in r.
+func ReadRecords(r io.Reader) ([]DecisionRecord, error) {
+	reader := NewRecordReader(r)
+	var records []DecisionRecord
+	for {
+		rec, err := reader.Next()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return records, fmt.Errorf("record #%d: %v", len(records), err)
+		}
+		records = append(records, rec)
+	}
+}
+
+// jsonDecision is the JSON encoding of a DecisionRecord. The pieces are
+// letters so the records can be read without decoding them.
+type jsonDecision struct {
+	Initial    jsonState       `json:"initial"`
+	Current    string          `json:"current"`
+	Preview    string          `json:"preview"`
+	BagUsed    string          `json:"bag_used"`
+	Candidates []jsonCandidate `json:"candidates,omitempty"`
+	Chosen     *jsonState      `json:"chosen"`
+	LatencyNS  int64           `json:"latency_ns"`
+}
+
+type jsonCandidate struct {
+	State jsonState      `json:"state"`
+	Score ScoreBreakdown `json:"score"`
+}
+
+type jsonState struct {
+	Field          uint16 `json:"field"`
+	Hold           string `json:"hold"`
+	SwapRestricted bool   `json:"swap_restricted,omitempty"`
+}
+
+func newJSONDecision(rec DecisionRecord) jsonDecision {
+	d := jsonDecision{
+		Initial:   newJSONState(rec.Initial),
+		Current:   piecesString([]tetris.Piece{rec.Current}),
+		Preview:   piecesString(rec.Preview),
+		BagUsed:   piecesString(rec.BagUsed.Slice()),
+		LatencyNS: int64(rec.Latency),
+	}
+	for _, c := range rec.Candidates {
+		d.Candidates = append(d.Candidates, jsonCandidate{newJSONState(c.State), c.Score})
+	}
+	if rec.Chosen != nil {
+		chosen := newJSONState(*rec.Chosen)
+		d.Chosen = &chosen
+	}
+	return d
+}
+
+func (d jsonDecision) record() (DecisionRecord, error) {
+	initial, err := d.Initial.state()
+	if err != nil {
+		return DecisionRecord{}, fmt.Errorf("invalid initial state: %v", err)
+	}
+	current, err := parsePieces(d.Current)
+	if err != nil || len(current) != 1 {
+		return DecisionRecord{}, fmt.Errorf("invalid current piece %q", d.Current)
+	}
+	preview, err := parsePieces(d.Preview)
+	if err != nil {
+		return DecisionRecord{}, fmt.Errorf("invalid preview: %v", err)
+	}
+	bag, err := parsePieces(d.BagUsed)
+	if err != nil {
+		return DecisionRecord{}, fmt.Errorf("invalid bag: %v", err)
+	}
+	rec := DecisionRecord{
+		Initial: initial,
+		Current: current[0],
+		Preview: preview,
+		BagUsed: tetris.NewPieceSet(bag...),
+		Latency: time.Duration(d.LatencyNS),
+	}
+	for _, c := range d.Candidates {
+		state, err := c.State.state()
+		if err != nil {
+			return DecisionRecord{}, fmt.Errorf("invalid candidate: %v", err)
+		}
+		rec.Candidates = append(rec.Candidates, CandidateScore{state, c.Score})
+	}
+	if d.Chosen != nil {
+		chosen, err := d.Chosen.state()
+		if err != nil {
+			return DecisionRecord{}, fmt.Errorf("invalid chosen state: %v", err)
+		}
+		rec.Chosen = &chosen
+	}
+	return rec, nil
+}
+
+func newJSONState(s combo4.State) jsonState {
+	js := jsonState{Field: uint16(s.Field), SwapRestricted: s.SwapRestricted}
+	if s.Hold != tetris.EmptyPiece {
+		js.Hold = s.Hold.String()
+	}
+	return js
+}
+
+func (js jsonState) state() (combo4.State, error) {
+	hold, err := parsePieces(js.Hold)
+	if err != nil || len(hold) > 1 {
+		return combo4.State{}, fmt.Errorf("invalid hold piece %q", js.Hold)
+	}
+	s := combo4.State{Field: combo4.Field4x4(js.Field), SwapRestricted: js.SwapRestricted}
+	if len(hold) == 1 {
+		s.Hold = hold[0]
+	}
+	return s, nil
+}
+
+// piecesString returns the letters of the pieces.
+func piecesString(pieces []tetris.Piece) string {
+	var b strings.Builder
+	for _, p := range pieces {
+		b.WriteString(p.String())
+	}
+	return b.String()
+}
+
+// parsePieces is the inverse of piecesString for nonempty pieces.
+func parsePieces(s string) ([]tetris.Piece, error) {
+	pieces := tetris.SeqFromStr(s)
+	for _, p := range pieces {
+		if p == tetris.EmptyPiece {
+			return nil, fmt.Errorf("%q has a letter that is not a piece", s)
+		}
+	}
+	return pieces, nil
+}
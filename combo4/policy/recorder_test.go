@@ -0,0 +1,188 @@
+package policy
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"strings"
+	"testing"
+	"tetris"
+	"tetris/combo4"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestRecorderRoundTrip(t *testing.T) {
+	moves, _ := combo4.AllContinuousMoves()
+	nfa := combo4.NewNFA(moves)
+	calls := &recordingPolicy{pol: FromScorer(nfa, NewNFAScorer(nfa, 3))}
+	var buf bytes.Buffer
+	rec := NewRecorder(calls, &buf)
+
+	// The game with this queue consumes every piece.
+	queue := RandQueue(nil, rand.New(rand.NewSource(2)), 30)
+	Simulate(rec, combo4.LeftI, queue, 3)
+	if err := rec.Err(); err != nil {
+		t.Fatalf("Err: %v", err)
+	}
+
+	records, err := ReadRecords(&buf)
+	if err != nil {
+		t.Fatalf("ReadRecords: %v", err)
+	}
+	if len(records) != len(calls.calls) {
+		t.Fatalf("got %d records, want one for each of the %d calls", len(records), len(calls.calls))
+	}
+	for i, r := range records {
+		call := calls.calls[i]
+		got := policyCall{r.Initial, r.Current, r.Preview, r.BagUsed}
+		if diff := cmp.Diff(call, got, cmp.AllowUnexported(policyCall{}), cmpopts.EquateEmpty()); diff != "" {
+			t.Fatalf("record #%d mismatch (-want +got):\n%s", i, diff)
+		}
+		if want := calls.pol.NextState(call.initial, call.current, call.preview, call.bag); !cmp.Equal(r.Chosen, want) {
+			t.Errorf("record #%d chose %v, want %v", i, r.Chosen, want)
+		}
+		if r.Latency < 0 {
+			t.Errorf("record #%d has a negative latency %v", i, r.Latency)
+		}
+	}
+
+	// Only a Policy from FromScorer has its candidates scored.
+	if len(records[0].Candidates) != 0 {
+		t.Errorf("got candidates for a Policy that does not score them: %v", records[0].Candidates)
+	}
+	// Choices are only scored if there is more than one.
+	var choice DecisionRecord
+	for _, r := range records {
+		if len(nfa.NextStates(r.Initial, r.Current)) > 1 {
+			choice = r
+			break
+		}
+	}
+	if choice.Chosen == nil {
+		t.Fatalf("got no record with a choice between next states")
+	}
+	var scoredBuf bytes.Buffer
+	// The candidates come through an InstrumentedPolicy which only times
+	// the one NextState call.
+	instrumented := Instrumented(FromScorer(nfa, NewNFAScorer(nfa, 3)))
+	scored := NewRecorder(instrumented, &scoredBuf)
+	scored.NextState(choice.Initial, choice.Current, choice.Preview, choice.BagUsed)
+	if got := instrumented.Stats().Count; got != 1 {
+		t.Errorf("got %d latencies, want 1", got)
+	}
+	scoredRecords, err := ReadRecords(&scoredBuf)
+	if err != nil || len(scoredRecords) != 1 {
+		t.Fatalf("ReadRecords got %d records, %v, want 1", len(scoredRecords), err)
+	}
+	var chosen bool
+	for _, c := range scoredRecords[0].Candidates {
+		if c.State == *choice.Chosen {
+			chosen = true
+			for _, other := range scoredRecords[0].Candidates {
+				if other.Score.Compare(c.Score) > 0 {
+					t.Errorf("candidate %v has a better score than the chosen %v", other, c)
+				}
+			}
+		}
+	}
+	if len(scoredRecords[0].Candidates) != len(nfa.NextStates(choice.Initial, choice.Current)) || !chosen {
+		t.Errorf("got candidates %v, want every next state including the chosen %v", scoredRecords[0].Candidates, *choice.Chosen)
+	}
+}
+
+func TestRecorderMDPPolicy(t *testing.T) {
+	mdp, err := NewMDP(1)
+	if err != nil {
+		t.Fatalf("NewMDP: %v", err)
+	}
+	pol := mdp.Policy().(*MDPPolicy)
+	var buf bytes.Buffer
+	rec := NewRecorder(pol, &buf)
+	queue := RandQueue(nil, rand.New(rand.NewSource(2)), 30)
+	Simulate(rec, combo4.LeftI, queue, 1)
+	if err := rec.Err(); err != nil {
+		t.Fatalf("Err: %v", err)
+	}
+	records, err := ReadRecords(&buf)
+	if err != nil {
+		t.Fatalf("ReadRecords: %v", err)
+	}
+	// Each record is counted once in the stats.
+	stats := pol.Stats()
+	if got := stats.Hits + stats.PartialPreview + stats.Misses() + stats.Invalid; got != int64(len(records)) {
+		t.Errorf("got %d NextState calls in the stats, want %d", got, len(records))
+	}
+
+	var scored int
+	for i, r := range records {
+		if want := pol.NextState(r.Initial, r.Current, r.Preview, r.BagUsed); !cmp.Equal(r.Chosen, want) {
+			t.Errorf("record #%d chose %v, want %v", i, r.Chosen, want)
+		}
+		choices := mdp.nfa.NextStates(r.Initial, r.Current)
+		if len(choices) <= 1 {
+			continue
+		}
+		scored++
+		var chosen bool
+		for _, c := range r.Candidates {
+			chosen = chosen || c.State == *r.Chosen
+		}
+		if len(r.Candidates) != len(choices) || !chosen {
+			t.Errorf("record #%d got candidates %v, want every next state including the chosen %v", i, r.Candidates, *r.Chosen)
+		}
+	}
+	if scored == 0 {
+		t.Errorf("got no record with a choice between next states")
+	}
+}
+
+func TestRecorderNoChoice(t *testing.T) {
+	var buf bytes.Buffer
+	rec := NewRecorder(PolicyFunc(func(combo4.State, tetris.Piece, []tetris.Piece, tetris.PieceSet) *combo4.State {
+		return nil
+	}), &buf)
+	initial := combo4.State{Field: combo4.LeftI, Hold: tetris.I, SwapRestricted: true}
+	if got := rec.NextState(initial, tetris.T, nil, 0); got != nil {
+		t.Fatalf("NextState got %v, want nil", got)
+	}
+	records, err := ReadRecords(&buf)
+	if err != nil {
+		t.Fatalf("ReadRecords: %v", err)
+	}
+	want := []DecisionRecord{{Initial: initial, Current: tetris.T}}
+	if diff := cmp.Diff(want, records, cmpopts.IgnoreFields(DecisionRecord{}, "Latency"), cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("ReadRecords mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestRecorderWriteError(t *testing.T) {
+	rec := NewRecorder(PolicyFunc(func(combo4.State, tetris.Piece, []tetris.Piece, tetris.PieceSet) *combo4.State {
+		return &combo4.State{}
+	}), failingWriter{})
+	if got := rec.NextState(combo4.State{}, tetris.T, nil, 0); got == nil {
+		t.Errorf("NextState got nil, want the choice even if it is not written")
+	}
+	if err := rec.Err(); err == nil {
+		t.Errorf("Err got nil, want the write error")
+	}
+}
+
+func TestReadRecordsInvalid(t *testing.T) {
+	for _, log := range []string{
+		`{"initial":{"field":0,"hold":"X"},"current":"T","preview":"","bag_used":"","chosen":null,"latency_ns":0}`,
+		`{"initial":{"field":0,"hold":""},"current":"TT","preview":"","bag_used":"","chosen":null,"latency_ns":0}`,
+		`{"initial":`,
+	} {
+		if _, err := ReadRecords(strings.NewReader(log)); err == nil {
+			t.Errorf("ReadRecords(%s) got no error", log)
+		}
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("disk full")
+}
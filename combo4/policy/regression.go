@@ -0,0 +1,121 @@
+package policy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"tetris"
+	"tetris/combo4"
+)
+
+// Simulate plays through a queue starting at the initial field and returns
+// the number of pieces consumed. The first previewLen+1 pieces of the queue
+// are known at the start and each following piece is added to the preview
+// after a piece is consumed. Simulate stops at the first piece that does not
+// follow the 7 bag randomizer.
+func Simulate(pol Policy, initial combo4.Field4x4, queue []tetris.Piece, previewLen int, opts ...GameOption) int {
+	if len(queue) <= previewLen {
+		return 0
+	}
+	game, err := NewGameFromField(pol, initial, queue[0], queue[1:previewLen+1], opts...)
+	if err != nil {
+		return 0
+	}
+	for _, p := range queue[previewLen+1:] {
+		if game.State() == nil {
+			break
+		}
+		if _, err := game.Step(p); err != nil {
+			break
+		}
+	}
+	return game.Consumed()
+}
+
+// RegressionCase is a queue with a known number of pieces that a Policy
+// should be able to consume.
+type RegressionCase struct {
+	Name       string
+	Initial    combo4.Field4x4
+	PreviewLen int
+	Queue      []tetris.Piece
+	// The minimum number of pieces that should be consumed.
+	MinConsumed int
+}
+
+// RegressionFailure is a RegressionCase that consumed fewer pieces than
+// expected.
+type RegressionFailure struct {
+	Case     RegressionCase
+	Consumed int
+}
+
+func (f RegressionFailure) String() string {
+	return fmt.Sprintf("%s: consumed %d pieces, want at least %d", f.Case.Name, f.Consumed, f.Case.MinConsumed)
+}
+
+// RunRegressionSuite simulates each case and returns the cases that did not
+// consume enough pieces.
+func RunRegressionSuite(pol Policy, cases []RegressionCase) []RegressionFailure {
+	var failures []RegressionFailure
+	for _, c := range cases {
+		if consumed := Simulate(pol, c.Initial, c.Queue, c.PreviewLen); consumed < c.MinConsumed {
+			failures = append(failures, RegressionFailure{Case: c, Consumed: consumed})
+		}
+	}
+	return failures
+}
+
+// ReadRegressionCases parses RegressionCases. Each case is a line of
+// space separated fields:
+//
+//	name initialField previewLen minConsumed queue
+//
+// where initialField is the numeric value of a Field4x4 and queue is a
+// string of piece letters like "TLJSZOI". Empty lines and lines starting
+// with "#" are ignored.
+func ReadRegressionCases(r io.Reader) ([]RegressionCase, error) {
+	var cases []RegressionCase
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 5 {
+			return nil, fmt.Errorf("line %d: got %d fields, want 5", lineNum, len(fields))
+		}
+		field, err := strconv.ParseUint(fields[1], 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid field: %v", lineNum, err)
+		}
+		previewLen, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid preview length: %v", lineNum, err)
+		}
+		minConsumed, err := strconv.Atoi(fields[3])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid minimum consumed: %v", lineNum, err)
+		}
+		for _, r := range fields[4] {
+			if tetris.PieceFromRune(r) == tetris.EmptyPiece {
+				return nil, fmt.Errorf("line %d: invalid piece %q", lineNum, r)
+			}
+		}
+		cases = append(cases, RegressionCase{
+			Name:        fields[0],
+			Initial:     combo4.Field4x4(field),
+			PreviewLen:  previewLen,
+			Queue:       tetris.SeqFromStr(fields[4]),
+			MinConsumed: minConsumed,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return cases, nil
+}
@@ -0,0 +1,104 @@
+package policy
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"tetris"
+	"tetris/combo4"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRegressionSuite(t *testing.T) {
+	file, err := os.Open("testdata/regression.txt")
+	if err != nil {
+		t.Fatalf("os.Open: %v", err)
+	}
+	defer file.Close()
+
+	cases, err := ReadRegressionCases(file)
+	if err != nil {
+		t.Fatalf("ReadRegressionCases: %v", err)
+	}
+	if len(cases) == 0 {
+		t.Fatalf("got no regression cases")
+	}
+
+	moves, _ := combo4.AllContinuousMoves()
+	nfa := combo4.NewNFA(moves)
+	for _, failure := range RunRegressionSuite(FromScorer(nfa, NewNFAScorer(nfa, 6)), cases) {
+		t.Errorf("regression: %v", failure)
+	}
+}
+
+func TestRunRegressionSuiteFailures(t *testing.T) {
+	moves, _ := combo4.AllContinuousMoves()
+	nfa := combo4.NewNFA(moves)
+
+	// No policy can consume more pieces than there are in the queue.
+	queue := tetris.SeqFromStr("TLJSZOI")
+	cases := []RegressionCase{
+		{
+			Name:        "possible",
+			Initial:     combo4.LeftI,
+			Queue:       queue,
+			MinConsumed: 0,
+		},
+		{
+			Name:        "impossible",
+			Initial:     combo4.LeftI,
+			Queue:       queue,
+			MinConsumed: len(queue) + 1,
+		},
+	}
+	failures := RunRegressionSuite(FromScorer(nfa, &basicScorer{nfa}), cases)
+	if len(failures) != 1 {
+		t.Fatalf("got %d failures, want 1: %v", len(failures), failures)
+	}
+	if got := failures[0]; got.Case.Name != "impossible" {
+		t.Errorf("got failure %v, want the impossible case", got)
+	}
+}
+
+func TestReadRegressionCases(t *testing.T) {
+	tests := []struct {
+		desc    string
+		input   string
+		want    []RegressionCase
+		wantErr bool
+	}{
+		{
+			desc:  "Comments and empty lines",
+			input: "# comment\n\nname 28672 2 3 TLJ\n",
+			want: []RegressionCase{{
+				Name:        "name",
+				Initial:     combo4.LeftI,
+				PreviewLen:  2,
+				Queue:       []tetris.Piece{tetris.T, tetris.L, tetris.J},
+				MinConsumed: 3,
+			}},
+		},
+		{
+			desc:    "Missing field",
+			input:   "name 28672 2 TLJ\n",
+			wantErr: true,
+		},
+		{
+			desc:    "Invalid piece",
+			input:   "name 28672 2 3 TLX\n",
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			got, err := ReadRegressionCases(strings.NewReader(test.input))
+			if gotErr := err != nil; gotErr != test.wantErr {
+				t.Fatalf("ReadRegressionCases got err=%v, want error=%t", err, test.wantErr)
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("ReadRegressionCases mismatch(-want +got):\n%s", diff)
+			}
+		})
+	}
+}
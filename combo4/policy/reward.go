@@ -0,0 +1,89 @@
+package policy
+
+import (
+	"fmt"
+	"tetris"
+	"tetris/combo4"
+)
+
+// RewardFunc returns a bonus for choosing the next State from the GameState
+// which an MDP adds to the one piece consumed by the choice. move is the Move
+// that places a piece which has the EmptyPiece if the current piece is held
+// without placing one. actions are the actions of the choice including a
+// Hold or nil if they are not known.
+type RewardFunc func(gState GameState, choice combo4.State, move combo4.Move, actions []tetris.Action) float64
+
+// Reward shapes what an MDP optimizes in addition to the pieces consumed.
+// The zero Reward adds nothing.
+type Reward struct {
+	// Name identifies the Reward in the encodings of MDPs and MDPPolicies so
+	// ones trained with different rewards are not mixed up. It must be set
+	// if Func is.
+	Name string
+	Func RewardFunc
+}
+
+// TSpinReward is a Reward of bonus for each T-spin. A T-spin is a Move of the
+// T piece that is rotated after it is soft dropped and then not moved again.
+// With a small bonus, the MDP chooses T-spins when they consume as many
+// pieces as the other choices since T-spins send more garbage.
+func TSpinReward(bonus float64) Reward {
+	return Reward{
+		Name: fmt.Sprintf("tspin(%g)", bonus),
+		Func: func(_ GameState, _ combo4.State, move combo4.Move, actions []tetris.Action) float64 {
+			if isTSpin(move, actions) {
+				return bonus
+			}
+			return 0
+		},
+	}
+}
+
+// rewardByName returns the Reward with the Name e.g. a TSpinReward. For other
+// names it returns a Reward without a Func and false.
+func rewardByName(name string) (Reward, bool) {
+	if name == "" {
+		return Reward{}, true
+	}
+	var bonus float64
+	if _, err := fmt.Sscanf(name, "tspin(%g)", &bonus); err == nil {
+		if reward := TSpinReward(bonus); reward.Name == name {
+			return reward, true
+		}
+	}
+	return Reward{Name: name}, false
+}
+
+func isTSpin(move combo4.Move, actions []tetris.Action) bool {
+	if move.Piece != tetris.T {
+		return false
+	}
+	if n := len(actions); n > 0 && actions[n-1] == tetris.HardDrop {
+		actions = actions[:n-1]
+	}
+	var rotated bool
+	for i := len(actions) - 1; i >= 0; i-- {
+		switch actions[i] {
+		case tetris.RotateCW, tetris.RotateCCW:
+			rotated = true
+		case tetris.SoftDrop:
+			return rotated
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+// reward returns the bonus of the Reward of the MDP for the choice.
+func (m *MDP) reward(gState GameState, choice combo4.State) float64 {
+	if m.rewardFunc == nil {
+		return 0
+	}
+	move := combo4.Move{Start: gState.State.Field, End: choice.Field, Piece: gState.Current}
+	if gState.State.Hold != choice.Hold {
+		move.Piece = gState.State.Hold
+	}
+	actions, _ := combo4.TransitionActions(m.mActions, gState.State, choice, gState.Current)
+	return m.rewardFunc(gState, choice, move, actions)
+}
@@ -0,0 +1,210 @@
+package policy
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+	"tetris"
+	"tetris/combo4"
+)
+
+func TestIsTSpin(t *testing.T) {
+	t.Parallel()
+
+	moves, mActions := combo4.AllContinuousMoves()
+	var tSpins int
+	for _, move := range moves {
+		if isTSpin(move, mActions[move]) {
+			tSpins++
+		}
+	}
+	// Two T-spins and their mirrors.
+	if tSpins != 4 {
+		t.Errorf("got %d T-spin Moves, want 4", tSpins)
+	}
+
+	tests := []struct {
+		desc    string
+		piece   tetris.Piece
+		actions []tetris.Action
+		want    bool
+	}{
+		{
+			desc:    "Rotated after a soft drop",
+			piece:   tetris.T,
+			actions: []tetris.Action{tetris.Right, tetris.SoftDrop, tetris.RotateCCW, tetris.HardDrop},
+			want:    true,
+		},
+		{
+			desc:    "Rotated before dropping",
+			piece:   tetris.T,
+			actions: []tetris.Action{tetris.Right, tetris.RotateCW, tetris.HardDrop},
+		},
+		{
+			desc:    "Moved after the rotation",
+			piece:   tetris.T,
+			actions: []tetris.Action{tetris.SoftDrop, tetris.RotateCW, tetris.Left, tetris.HardDrop},
+		},
+		{
+			desc:    "Not a T",
+			piece:   tetris.L,
+			actions: []tetris.Action{tetris.Right, tetris.SoftDrop, tetris.RotateCCW, tetris.HardDrop},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			if got := isTSpin(combo4.Move{Piece: test.piece}, test.actions); got != test.want {
+				t.Errorf("isTSpin got %t, want %t", got, test.want)
+			}
+		})
+	}
+}
+
+func TestMDPReward(t *testing.T) {
+	t.Parallel()
+
+	plain, err := NewMDP(1)
+	if err != nil {
+		t.Fatalf("NewMDP: %v", err)
+	}
+	reward := TSpinReward(0.01)
+	shaped, err := NewMDPWithOptions(1, MDPOptions{Reward: reward})
+	if err != nil {
+		t.Fatalf("NewMDPWithOptions: %v", err)
+	}
+
+	// Placing the T is a T-spin which consumes as many pieces as holding
+	// the T and placing the I. Before training, the values after both
+	// choices are the same so only the Reward breaks the tie.
+	tie := GameState{
+		State: combo4.State{
+			Field: combo4.NewField4x4([][4]bool{{true, true, true, false}}),
+			Hold:  tetris.I,
+		},
+		Current: tetris.T,
+		Preview: tetris.MustSeq([]tetris.Piece{tetris.Z}),
+		BagUsed: tetris.NewPieceSet(tetris.T, tetris.Z, tetris.I),
+	}
+	tSpin := combo4.State{
+		Field: combo4.NewField4x4([][4]bool{
+			{false, false, false, true},
+			{false, false, true, true},
+		}),
+		Hold: tetris.I,
+	}
+	held := combo4.State{Field: tie.State.Field, Hold: tetris.T}
+	plain.updatePolicy()
+	shaped.updatePolicy()
+	if got, want := plain.calcValue(tie, tSpin, nil), plain.calcValue(tie, held, nil); got != want {
+		t.Fatalf("got value %v for the T-spin, want a tie with %v", got, want)
+	}
+	if got := plain.policy[tie]; got != held {
+		t.Errorf("the MDP without a Reward chose %v, want %v with fewer key presses", got, held)
+	}
+	if got := shaped.policy[tie]; got != tSpin {
+		t.Errorf("the MDP with a T-spin Reward chose %v, want the T-spin %v", got, tSpin)
+	}
+
+	for _, m := range []*MDP{plain, shaped} {
+		m.updateValues()
+		m.updatePolicy()
+	}
+	countTSpins := func(m *MDP) int {
+		var count int
+		for gState, choice := range m.policy {
+			actions, _ := combo4.TransitionActions(m.mActions, gState.State, choice, gState.Current)
+			move := combo4.Move{Start: gState.State.Field, End: choice.Field, Piece: gState.Current}
+			if gState.State.Hold != choice.Hold {
+				move.Piece = gState.State.Hold
+			}
+			if isTSpin(move, actions) {
+				count++
+			}
+		}
+		return count
+	}
+	if plainTSpins, shapedTSpins := countTSpins(plain), countTSpins(shaped); shapedTSpins <= plainTSpins {
+		t.Errorf("the MDP with a T-spin Reward chose %d T-spins, want more than the %d without", shapedTSpins, plainTSpins)
+	}
+
+	// The bonus only breaks ties so the number of pieces consumed should be
+	// about the same.
+	const tolerance = 0.02
+	opts := EvalOptions{Trials: 1000, PiecesPerTrial: 100, PreviewSize: 1}
+	opts.Rand = rand.New(rand.NewSource(1))
+	plainResult := Evaluate(plain.Policy(), opts)
+	opts.Rand = rand.New(rand.NewSource(1))
+	shapedResult := Evaluate(shaped.Policy(), opts)
+	if shapedResult.Mean < plainResult.Mean*(1-tolerance) {
+		t.Errorf("Evaluate got a mean of %.2f with the Reward, want at least %.2f of %.2f", shapedResult.Mean, 1-tolerance, plainResult.Mean)
+	}
+
+	// The Reward is kept in the encodings.
+	encoding, err := shaped.GobEncode()
+	if err != nil {
+		t.Fatalf("GobEncode: %v", err)
+	}
+	if _, err := NewMDPFromGob(encoding, MDPDecodeOptions{Reward: TSpinReward(1)}); !errors.Is(err, ErrRewardMismatch) {
+		t.Errorf("NewMDPFromGob with another Reward got err=%v, want %v", err, ErrRewardMismatch)
+	}
+	if _, err := NewMDPFromGob(encoding, MDPDecodeOptions{Reward: reward}); err != nil {
+		t.Errorf("NewMDPFromGob with the Reward got err=%v", err)
+	}
+	// Without a Reward, the one the MDP was trained with is used.
+	decoded, err := NewMDPFromGob(encoding, MDPDecodeOptions{})
+	if err != nil {
+		t.Fatalf("NewMDPFromGob without the Reward got err=%v", err)
+	}
+	if decoded.rewardName != reward.Name || decoded.rewardFunc == nil {
+		t.Errorf("NewMDPFromGob without the Reward got the Reward %q, want %q with its Func", decoded.rewardName, reward.Name)
+	}
+	polEncoding, err := shaped.Policy().(*MDPPolicy).GobEncode()
+	if err != nil {
+		t.Fatalf("MDPPolicy.GobEncode: %v", err)
+	}
+	pol, err := NewMDPPolicyFromGob(polEncoding)
+	if err != nil {
+		t.Fatalf("NewMDPPolicyFromGob: %v", err)
+	}
+	if got := pol.RewardName(); got != reward.Name {
+		t.Errorf("RewardName got %q, want %q", got, reward.Name)
+	}
+	if _, err := NewMDPPolicyFromGob(polEncoding, WithReward(reward)); err != nil {
+		t.Errorf("NewMDPPolicyFromGob WithReward of the Reward got err=%v", err)
+	}
+	if _, err := NewMDPPolicyFromGob(polEncoding, WithReward(Reward{})); !errors.Is(err, ErrRewardMismatch) {
+		t.Errorf("NewMDPPolicyFromGob WithReward of no Reward got err=%v, want %v", err, ErrRewardMismatch)
+	}
+}
+
+func TestRewardByName(t *testing.T) {
+	t.Parallel()
+	for _, reward := range []Reward{{}, TSpinReward(0.01), TSpinReward(2)} {
+		got, ok := rewardByName(reward.Name)
+		if !ok || got.Name != reward.Name || (got.Func == nil) != (reward.Func == nil) {
+			t.Errorf("rewardByName(%q) got %q, %t, want the Reward", reward.Name, got.Name, ok)
+		}
+	}
+	if got, ok := rewardByName("custom"); ok || got.Name != "custom" || got.Func != nil {
+		t.Errorf("rewardByName(custom) got %q, %t, want only the name and false", got.Name, ok)
+	}
+}
+
+func TestMDPUpdateUnknownReward(t *testing.T) {
+	t.Parallel()
+	mdp, err := NewMDPWithOptions(0, MDPOptions{Reward: Reward{Name: "custom", Func: func(GameState, combo4.State, combo4.Move, []tetris.Action) float64 { return 0 }}})
+	if err != nil {
+		t.Fatalf("NewMDPWithOptions: %v", err)
+	}
+	encoding, err := mdp.GobEncode()
+	if err != nil {
+		t.Fatalf("GobEncode: %v", err)
+	}
+	decoded, err := NewMDPFromGob(encoding, MDPDecodeOptions{})
+	if err != nil {
+		t.Fatalf("NewMDPFromGob: %v", err)
+	}
+	if err := decoded.Update(""); !errors.Is(err, ErrRewardMismatch) {
+		t.Errorf("Update without the Func of the Reward got err=%v, want %v", err, ErrRewardMismatch)
+	}
+}
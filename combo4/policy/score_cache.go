@@ -0,0 +1,136 @@
+package policy
+
+import (
+	"container/list"
+	"sync"
+	"tetris"
+	"tetris/combo4"
+)
+
+// ScoreCache is a bounded cache of the scores computed by a Policy created by
+// FromScorer. Consecutive decisions in a game score many of the same choices
+// with the same preview so they can be reused. The least recently used score
+// is dropped when the cache is full.
+//
+// A ScoreCache must only be used with one Scorer. ScoreCache is safe for
+// concurrent use. The nil *ScoreCache is usable and never caches.
+type ScoreCache struct {
+	mu      sync.Mutex
+	size    int
+	entries map[scoreCacheKey]*list.Element
+	// The most recently used entries are at the front.
+	order *list.List
+
+	hits, misses int64
+}
+
+// scoreCacheKey is the input to Scorer.Score.
+type scoreCacheKey struct {
+	state   uint32
+	next    tetris.Seq
+	bagUsed tetris.PieceSet
+}
+
+type scoreCacheEntry struct {
+	key   scoreCacheKey
+	score ScoreBreakdown
+}
+
+// ScoreCacheStats are the number of lookups in a ScoreCache.
+type ScoreCacheStats struct {
+	Hits   int64
+	Misses int64
+}
+
+// NewScoreCache creates a ScoreCache that holds up to size scores. It returns
+// nil if size is not positive.
+func NewScoreCache(size int) *ScoreCache {
+	if size <= 0 {
+		return nil
+	}
+	return &ScoreCache{
+		size:    size,
+		entries: make(map[scoreCacheKey]*list.Element, size),
+		order:   list.New(),
+	}
+}
+
+// WithScoreCache makes the Policy look up its scores in the ScoreCache before
+// calling the Scorer. A nil ScoreCache disables the cache.
+func WithScoreCache(cache *ScoreCache) ScorePolicyOption {
+	return func(p *scorePolicy) {
+		p.cache = cache
+	}
+}
+
+// score returns the cached score or calls the Scorer and caches the result.
+// Scores with more than 8 next pieces are never cached.
+func (c *ScoreCache) score(scorer Scorer, state combo4.State, next []tetris.Piece, bagUsed tetris.PieceSet) ScoreBreakdown {
+	if c == nil {
+		return scorer.Score(state, next, bagUsed)
+	}
+	seq, err := tetris.NewSeq(next)
+	if err != nil {
+		return scorer.Score(state, next, bagUsed)
+	}
+	key := scoreCacheKey{state: state.Pack(), next: seq, bagUsed: bagUsed}
+
+	c.mu.Lock()
+	if elem, ok := c.entries[key]; ok {
+		c.hits++
+		c.order.MoveToFront(elem)
+		score := elem.Value.(*scoreCacheEntry).score
+		c.mu.Unlock()
+		return score
+	}
+	c.misses++
+	c.mu.Unlock()
+
+	// Score without the lock so choices are still scored concurrently.
+	score := scorer.Score(state, next, bagUsed)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; ok {
+		// Another goroutine added it first.
+		return score
+	}
+	c.entries[key] = c.order.PushFront(&scoreCacheEntry{key: key, score: score})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*scoreCacheEntry).key)
+	}
+	return score
+}
+
+// Stats returns the number of hits and misses since the ScoreCache was
+// created or last Reset.
+func (c *ScoreCache) Stats() ScoreCacheStats {
+	if c == nil {
+		return ScoreCacheStats{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return ScoreCacheStats{Hits: c.hits, Misses: c.misses}
+}
+
+// Reset sets the counts in Stats to 0. The cached scores are kept.
+func (c *ScoreCache) Reset() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.hits, c.misses = 0, 0
+}
+
+// Len returns the number of cached scores.
+func (c *ScoreCache) Len() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
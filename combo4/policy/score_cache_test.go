@@ -0,0 +1,145 @@
+package policy
+
+import (
+	"math/rand"
+	"sync/atomic"
+	"testing"
+	"tetris"
+	"tetris/combo4"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func BenchmarkSequentialPlay(b *testing.B) {
+	benchmarkSequentialPlay(b, 0)
+}
+
+func BenchmarkSequentialPlayScoreCache(b *testing.B) {
+	benchmarkSequentialPlay(b, 10000)
+}
+
+// benchmarkSequentialPlay plays games over long queues with StartGame where
+// consecutive decisions share most of their preview.
+func benchmarkSequentialPlay(b *testing.B, cacheSize int) {
+	moves, _ := combo4.AllContinuousMoves()
+	nfa := combo4.NewNFA(moves)
+	scorer := NewNFAScorer(nfa, 5)
+	queue := tetris.RandPiecesFrom(rand.New(rand.NewSource(6)), 1000)
+	const previewLen = 5
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		pol := FromScorer(nfa, scorer, WithScoreCache(NewScoreCache(cacheSize)))
+		input := make(chan tetris.Piece, len(queue))
+		for _, p := range queue[previewLen+1:] {
+			input <- p
+		}
+		close(input)
+		for range StartGame(pol, combo4.LeftI, queue[0], queue[1:previewLen+1], input) {
+		}
+	}
+}
+
+// countingScorer counts the calls to a Scorer.
+type countingScorer struct {
+	scorer Scorer
+	calls  int64
+}
+
+func (s *countingScorer) Score(state combo4.State, next []tetris.Piece, bagUsed tetris.PieceSet) ScoreBreakdown {
+	atomic.AddInt64(&s.calls, 1)
+	return s.scorer.Score(state, next, bagUsed)
+}
+
+func TestScoreCache(t *testing.T) {
+	moves, _ := combo4.AllContinuousMoves()
+	nfa := combo4.NewNFA(moves)
+	scorer := &countingScorer{scorer: NewNFAScorer(nfa, 3)}
+	cache := NewScoreCache(2)
+
+	state := combo4.State{Field: combo4.LeftI, Hold: tetris.T}
+	next := []tetris.Piece{tetris.S, tetris.O}
+	bag := tetris.NewPieceSet(tetris.S, tetris.O)
+	want := scorer.scorer.Score(state, next, bag)
+	other := combo4.State{Field: combo4.RightI, Hold: tetris.T}
+
+	steps := []struct {
+		state     combo4.State
+		wantCalls int64
+	}{
+		{state: state, wantCalls: 1},
+		{state: state, wantCalls: 1},
+		{state: other, wantCalls: 2},
+		{state: state, wantCalls: 2},
+		// Drops other since it was used least recently.
+		{state: combo4.State{Field: combo4.LeftZ, Hold: tetris.T}, wantCalls: 3},
+		{state: state, wantCalls: 3},
+		{state: other, wantCalls: 4},
+	}
+	for idx, step := range steps {
+		got := cache.score(scorer, step.state, next, bag)
+		if step.state == state && got != want {
+			t.Errorf("step %d: score got %+v, want %+v", idx, got, want)
+		}
+		if scorer.calls != step.wantCalls {
+			t.Errorf("step %d: got %d calls to the Scorer, want %d", idx, scorer.calls, step.wantCalls)
+		}
+	}
+	if got, want := cache.Stats(), (ScoreCacheStats{Hits: 3, Misses: 4}); got != want {
+		t.Errorf("Stats() got %+v, want %+v", got, want)
+	}
+	if got := cache.Len(); got != 2 {
+		t.Errorf("Len() got %d, want 2", got)
+	}
+	cache.Reset()
+	if got := cache.Stats(); got != (ScoreCacheStats{}) {
+		t.Errorf("Stats() after Reset got %+v, want zero", got)
+	}
+
+	if cache := NewScoreCache(0); cache != nil {
+		t.Errorf("NewScoreCache(0) got %v, want nil", cache)
+	}
+}
+
+func TestWithScoreCache(t *testing.T) {
+	moves, _ := combo4.AllContinuousMoves()
+	nfa := combo4.NewNFA(moves)
+	scorer := NewNFAScorer(nfa, 3)
+	cache := NewScoreCache(1000)
+	cached := FromScorer(nfa, scorer, WithScoreCache(cache))
+	uncached := FromScorer(nfa, scorer)
+
+	// A queue where the game lasts for more than 100 pieces.
+	queue := tetris.RandPiecesFrom(rand.New(rand.NewSource(6)), 200)
+	play := func(pol Policy) []combo4.State {
+		input := make(chan tetris.Piece, len(queue))
+		for _, p := range queue[4:] {
+			input <- p
+		}
+		close(input)
+		var states []combo4.State
+		for decision := range StartGame(pol, combo4.LeftI, queue[0], queue[1:4], input) {
+			if decision.State == nil {
+				break
+			}
+			states = append(states, *decision.State)
+		}
+		return states
+	}
+	want := play(uncached)
+	if len(want) < 100 {
+		t.Fatalf("got a game of %d pieces, want at least 100", len(want))
+	}
+	if diff := cmp.Diff(want, play(cached)); diff != "" {
+		t.Errorf("game with a ScoreCache mismatch (-want +got):\n%s", diff)
+	}
+
+	// Replaying the game only uses the cached scores.
+	misses := cache.Stats().Misses
+	if diff := cmp.Diff(want, play(cached)); diff != "" {
+		t.Errorf("replayed game with a ScoreCache mismatch (-want +got):\n%s", diff)
+	}
+	if got := cache.Stats().Misses; got != misses {
+		t.Errorf("got %d misses replaying the game, want none", got-misses)
+	}
+}
@@ -0,0 +1,33 @@
+package policy
+
+import (
+	"tetris"
+)
+
+// SensitivityToLastPreview returns whether the Policy chooses a different
+// next state for the GameState when the last piece of the preview is not
+// known. The fraction of sensitive decisions in a game estimates how much of
+// the preview the Policy makes use of.
+func SensitivityToLastPreview(pol Policy, gs GameState) bool {
+	preview := gs.Preview.Slice()
+	if len(preview) == 0 {
+		return false
+	}
+	last := preview[len(preview)-1]
+
+	full := pol.NextState(gs.State, gs.Current, preview, gs.BagUsed)
+	shorter := pol.NextState(gs.State, gs.Current, preview[:len(preview)-1], undraw(gs.BagUsed, last))
+	if full == nil || shorter == nil {
+		return full != shorter
+	}
+	return *full != *shorter
+}
+
+// undraw returns the bag state before the piece was drawn.
+func undraw(bagUsed tetris.PieceSet, p tetris.Piece) tetris.PieceSet {
+	if bagUsed == p.PieceSet() {
+		// The piece started a new bag so the previous bag was full.
+		return tetris.NewPieceSet(tetris.NonemptyPieces[:]...)
+	}
+	return bagUsed &^ p.PieceSet()
+}
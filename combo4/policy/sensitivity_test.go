@@ -0,0 +1,70 @@
+package policy
+
+import (
+	"testing"
+	"tetris"
+	"tetris/combo4"
+)
+
+func TestSensitivityToLastPreview(t *testing.T) {
+	moves, _ := combo4.AllContinuousMoves()
+	nfa := combo4.NewNFA(moves)
+	pol := FromScorer(nfa, NewNFAScorer(nfa, 3))
+
+	tests := []struct {
+		desc string
+		gs   GameState
+		want bool
+	}{
+		{
+			desc: "Last piece flips the decision",
+			gs:   NewGameState(combo4.State{Field: combo4.LeftI, Hold: tetris.J}, tetris.S, []tetris.Piece{tetris.I, tetris.O}),
+			want: true,
+		},
+		{
+			desc: "No preview",
+			gs:   NewGameState(combo4.State{Field: combo4.LeftI, Hold: tetris.J}, tetris.S, nil),
+			want: false,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			if got := SensitivityToLastPreview(pol, test.gs); got != test.want {
+				t.Errorf("SensitivityToLastPreview(%v)=%t, want %t", test.gs, got, test.want)
+			}
+		})
+	}
+}
+
+func TestUndraw(t *testing.T) {
+	tests := []struct {
+		desc    string
+		bagUsed tetris.PieceSet
+		p       tetris.Piece
+		want    tetris.PieceSet
+	}{
+		{
+			desc:    "Middle of a bag",
+			bagUsed: tetris.NewPieceSet(tetris.T, tetris.L),
+			p:       tetris.L,
+			want:    tetris.NewPieceSet(tetris.T),
+		},
+		{
+			desc:    "Start of a bag",
+			bagUsed: tetris.NewPieceSet(tetris.L),
+			p:       tetris.L,
+			want:    tetris.NewPieceSet(tetris.NonemptyPieces[:]...),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			got := undraw(test.bagUsed, test.p)
+			if got != test.want {
+				t.Errorf("undraw(%v, %v)=%v, want %v", test.bagUsed, test.p, got, test.want)
+			}
+			if redrawn, _ := draw(got, test.p); redrawn != test.bagUsed {
+				t.Errorf("draw(undraw(%v, %v))=%v, want the original bag", test.bagUsed, test.p, redrawn)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package policy
+
+import "tetris/combo4"
+
+// mdpSnapshot is a copy of the values and policy of an MDP that other
+// goroutines can read while the MDP is updated.
+type mdpSnapshot struct {
+	value  map[GameState]float64
+	policy map[GameState]combo4.State
+}
+
+// SetPublishSnapshots changes whether Update publishes snapshots for
+// SnapshotPolicy and SnapshotValues. Each snapshot copies the values and
+// policy so it is off by default. It must not be called during Update.
+func (m *MDP) SetPublishSnapshots(publish bool) {
+	m.publishSnapshots = publish
+}
+
+// publishSnapshot copies the values and policy of the MDP for
+// SnapshotPolicy and SnapshotValues if snapshots are published.
+func (m *MDP) publishSnapshot() {
+	if !m.publishSnapshots {
+		return
+	}
+	snap := &mdpSnapshot{
+		value:  make(map[GameState]float64, len(m.value)),
+		policy: make(map[GameState]combo4.State, len(m.policy)),
+	}
+	for gState, v := range m.value {
+		snap.value[gState] = v
+	}
+	for gState, choice := range m.policy {
+		snap.policy[gState] = choice
+	}
+	m.snapshot.Store(snap)
+}
+
+// loadSnapshot returns the last snapshot published or nil.
+func (m *MDP) loadSnapshot() *mdpSnapshot {
+	snap, _ := m.snapshot.Load().(*mdpSnapshot)
+	return snap
+}
+
+// SnapshotPolicy returns the policy of the last snapshot published by Update
+// like Policy or nil if Update has not published one. It is safe to call while
+// Update is running e.g. to evaluate the policy being trained.
+func (m *MDP) SnapshotPolicy() *MDPPolicy {
+	snap := m.loadSnapshot()
+	if snap == nil {
+		return nil
+	}
+	return m.policyFrom(snap.policy)
+}
+
+// SnapshotValues returns the values of the last snapshot published by Update
+// or nil if Update has not published one. The values are in the same units as
+// ExpectedValue without the pieces of the preview. The map is shared by the
+// callers and must not be modified. It is safe to call while Update is
+// running.
+func (m *MDP) SnapshotValues() map[GameState]float64 {
+	snap := m.loadSnapshot()
+	if snap == nil {
+		return nil
+	}
+	return snap.value
+}
@@ -0,0 +1,72 @@
+package policy
+
+import (
+	"math/rand"
+	"path/filepath"
+	"testing"
+	"tetris/combo4"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMDPSnapshotDuringUpdate(t *testing.T) {
+	t.Parallel()
+	if testing.Short() {
+		t.Skip("trains an MDP")
+	}
+
+	mdp, err := NewMDP(1)
+	if err != nil {
+		t.Fatalf("NewMDP: %v", err)
+	}
+	if mdp.SnapshotPolicy() != nil || mdp.SnapshotValues() != nil {
+		t.Fatalf("got a snapshot before Update")
+	}
+	mdp.publishSnapshot()
+	if mdp.SnapshotPolicy() != nil {
+		t.Fatalf("got a snapshot without SetPublishSnapshots")
+	}
+	mdp.SetPublishSnapshots(true)
+
+	done := make(chan error)
+	go func() {
+		done <- mdp.Update(filepath.Join(t.TempDir(), "mdp.gob"))
+	}()
+
+	var snapshots int
+	r := rand.New(rand.NewSource(1))
+	for updating := true; updating; {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("Update: %v", err)
+			}
+			updating = false
+		case <-time.After(10 * time.Millisecond):
+		}
+		pol := mdp.SnapshotPolicy()
+		if pol == nil {
+			continue
+		}
+		snapshots++
+		queue := RandQueue(nil, r, 10)
+		Simulate(pol, combo4.LeftI, queue, 1)
+		for _, v := range mdp.SnapshotValues() {
+			if v < 0 {
+				t.Fatalf("got a negative snapshot value %v", v)
+			}
+			break
+		}
+	}
+	if snapshots == 0 {
+		t.Fatalf("no snapshot was published during Update")
+	}
+
+	if diff := cmp.Diff(mdp.value, mdp.SnapshotValues()); diff != "" {
+		t.Errorf("the last snapshot values differ from the MDP (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(mdp.policy, mdp.SnapshotPolicy().policy); diff != "" {
+		t.Errorf("the last snapshot policy differs from the MDP (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,107 @@
+package policy
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"tetris/combo4"
+)
+
+// ValueSummary aggregates the expected values of the GameStates of an MDP
+// that share a combo4.State.
+type ValueSummary struct {
+	// The number of GameStates with the State.
+	Count int
+	Mean  float64
+	Min   float64
+	Max   float64
+}
+
+// StateSummary returns a ValueSummary of the ExpectedValue of every
+// GameState in the MDP for each State over the current pieces, previews and
+// bags. It shows which fields are strong regardless of the pieces. The
+// values are visited once without copying the MDP.
+func (m *MDP) StateSummary() map[combo4.State]ValueSummary {
+	summary := make(map[combo4.State]ValueSummary)
+	for gState := range m.value {
+		value := m.ExpectedValue(gState)
+		s, ok := summary[gState.State]
+		if !ok {
+			s = ValueSummary{Min: math.Inf(1), Max: math.Inf(-1)}
+		}
+		s.Count++
+		// Mean holds the sum until every value is visited.
+		s.Mean += value
+		s.Min = math.Min(s.Min, value)
+		s.Max = math.Max(s.Max, value)
+		summary[gState.State] = s
+	}
+	for state, s := range summary {
+		s.Mean /= float64(s.Count)
+		summary[state] = s
+	}
+	return summary
+}
+
+// statesByMean returns the States of the summary from the highest to the
+// lowest mean. Ties are ordered by State.Less.
+func statesByMean(summary map[combo4.State]ValueSummary) []combo4.State {
+	states := make([]combo4.State, 0, len(summary))
+	for state := range summary {
+		states = append(states, state)
+	}
+	sort.Slice(states, func(i, j int) bool {
+		if mi, mj := summary[states[i]].Mean, summary[states[j]].Mean; mi != mj {
+			return mi > mj
+		}
+		return states[i].Less(states[j])
+	})
+	return states
+}
+
+// stateSummaryHeader is the first row written by WriteStateSummaryCSV.
+var stateSummaryHeader = []string{"state", "field", "hold", "swap_restricted", "count", "mean", "min", "max"}
+
+// WriteStateSummaryCSV writes one row for each State of the summary from the
+// highest to the lowest mean. The fields and pieces are written like
+// MDP.WriteCSV.
+func WriteStateSummaryCSV(w io.Writer, summary map[combo4.State]ValueSummary) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(stateSummaryHeader); err != nil {
+		return fmt.Errorf("writing the header: %v", err)
+	}
+	for _, state := range statesByMean(summary) {
+		s := summary[state]
+		row := []string{
+			strconv.FormatUint(uint64(state.Pack()), 10),
+			fieldCSVString(state.Field),
+			piecesCSVString(state.Hold),
+			strconv.FormatBool(state.SwapRestricted),
+			strconv.Itoa(s.Count),
+			strconv.FormatFloat(s.Mean, 'f', -1, 64),
+			strconv.FormatFloat(s.Min, 'f', -1, 64),
+			strconv.FormatFloat(s.Max, 'f', -1, 64),
+		}
+		if err := cw.Write(row); err != nil {
+			return fmt.Errorf("writing the row for %v: %v", state, err)
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteStateSummary writes the States of the summary from the highest to the
+// lowest mean with their fields drawn for reading in a terminal.
+func WriteStateSummary(w io.Writer, summary map[combo4.State]ValueSummary) error {
+	for rank, state := range statesByMean(summary) {
+		s := summary[state]
+		if _, err := fmt.Fprintf(w, "#%d hold=%v swap_restricted=%t mean=%.2f min=%.2f max=%.2f count=%d\n%s\n",
+			rank+1, state.Hold, state.SwapRestricted, s.Mean, s.Min, s.Max, s.Count, state.Field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
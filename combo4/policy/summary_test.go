@@ -0,0 +1,66 @@
+package policy
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMDPStateSummary(t *testing.T) {
+	t.Parallel()
+
+	mdp, err := NewMDP(1)
+	if err != nil {
+		t.Fatalf("NewMDP: %v", err)
+	}
+	mdp.updateValues()
+
+	summary := mdp.StateSummary()
+	var total int
+	for state, s := range summary {
+		total += s.Count
+		if s.Min > s.Mean || s.Mean > s.Max {
+			t.Errorf("%v has mean %v outside of [%v, %v]", state, s.Mean, s.Min, s.Max)
+		}
+	}
+	if total != len(mdp.value) {
+		t.Errorf("got a total count of %d, want %d GameStates", total, len(mdp.value))
+	}
+
+	var buf bytes.Buffer
+	if err := WriteStateSummaryCSV(&buf, summary); err != nil {
+		t.Fatalf("WriteStateSummaryCSV: %v", err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("reading the CSV: %v", err)
+	}
+	if diff := cmp.Diff(stateSummaryHeader, rows[0]); diff != "" {
+		t.Errorf("header mismatch (-want +got):\n%s", diff)
+	}
+	if got, want := len(rows)-1, len(summary); got != want {
+		t.Errorf("got %d rows, want %d", got, want)
+	}
+	prevMean := rows[1][5]
+	for _, row := range rows[2:] {
+		prev, _ := strconv.ParseFloat(prevMean, 64)
+		mean, _ := strconv.ParseFloat(row[5], 64)
+		if mean > prev {
+			t.Fatalf("row with mean %v is after a mean of %v, want the highest first", mean, prev)
+		}
+		prevMean = row[5]
+	}
+
+	buf.Reset()
+	if err := WriteStateSummary(&buf, summary); err != nil {
+		t.Fatalf("WriteStateSummary: %v", err)
+	}
+	best := statesByMean(summary)[0]
+	if !strings.HasPrefix(buf.String(), "#1 hold="+best.Hold.String()) || !strings.Contains(buf.String(), best.Field.String()) {
+		t.Errorf("WriteStateSummary does not start with %v:\n%s", best, buf.String()[:200])
+	}
+}
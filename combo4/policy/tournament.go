@@ -0,0 +1,172 @@
+package policy
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+)
+
+// TournamentResult is the result of playing several policies on the same
+// queues.
+type TournamentResult struct {
+	// The names of the policies in the order of the rows of the tables.
+	// NewTournamentResult sorts them.
+	Names []string
+	// The result of each policy by name.
+	Results map[string]EvalResult
+	// The checkpoints of the Reach of the results.
+	Checkpoints []int
+	// ExtraColumns are written by WriteTable after the reach of each
+	// checkpoint.
+	ExtraColumns []TableColumn
+	// ExtraRows are written by WriteTable after the policies.
+	ExtraRows []TableRow
+}
+
+// TableColumn is a column of the table of WriteTable e.g. the latency of
+// each policy.
+type TableColumn struct {
+	Title string
+	// The cell of each policy by name.
+	Cells map[string]string
+}
+
+// TableRow is a row of the table of WriteTable that is not a policy e.g. an
+// upper-bound. It has no cells in the ExtraColumns.
+type TableRow struct {
+	Name   string
+	Result EvalResult
+}
+
+// PairResult compares two policies A and B on the same queues.
+type PairResult struct {
+	// The number of queues where A consumed more, fewer or the same number
+	// of pieces as B.
+	Wins, Losses, Ties int
+	// The mean over the queues of the pieces A consumed minus the pieces B
+	// consumed.
+	MeanDiff float64
+}
+
+// Tournament evaluates every policy like Evaluate on the same queues so the
+// policies can be compared on each queue. This pairing removes the
+// variance of the queues from the differences between the policies, which
+// is usually most of the variance of the results.
+func Tournament(policies map[string]Policy, opts EvalOptions) TournamentResult {
+	queues := evalQueues(opts)
+	results := make(map[string]EvalResult, len(policies))
+	for name, pol := range policies {
+		results[name] = evaluateQueues(pol, queues, opts)
+	}
+	return NewTournamentResult(results, opts.Checkpoints)
+}
+
+// NewTournamentResult returns the TournamentResult of results that were
+// evaluated on the same queues e.g. by Evaluate with the same options and a
+// Rand with the same seed.
+func NewTournamentResult(results map[string]EvalResult, checkpoints []int) TournamentResult {
+	names := make([]string, 0, len(results))
+	for name := range results {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return TournamentResult{Names: names, Results: results, Checkpoints: checkpoints}
+}
+
+// Pair compares the policy named a against the one named b on each queue.
+// It returns an error if they did not play the same number of queues.
+func (r TournamentResult) Pair(a, b string) (PairResult, error) {
+	consumedA, consumedB := r.Results[a].Consumed, r.Results[b].Consumed
+	if len(consumedA) != len(consumedB) {
+		return PairResult{}, fmt.Errorf("%s played %d queues but %s played %d", a, len(consumedA), b, len(consumedB))
+	}
+	var (
+		pair    PairResult
+		sumDiff int
+	)
+	for t := range consumedA {
+		switch diff := consumedA[t] - consumedB[t]; {
+		case diff > 0:
+			pair.Wins++
+		case diff < 0:
+			pair.Losses++
+		default:
+			pair.Ties++
+		}
+		sumDiff += consumedA[t] - consumedB[t]
+	}
+	if len(consumedA) > 0 {
+		pair.MeanDiff = float64(sumDiff) / float64(len(consumedA))
+	}
+	return pair, nil
+}
+
+// tablePadding is the padding between the columns of the tables.
+const tablePadding = 3
+
+// WriteTable writes the mean and the reach of each checkpoint of each policy
+// followed by the ExtraColumns and then the ExtraRows like the compare
+// command.
+func (r TournamentResult) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, tablePadding, ' ', 0)
+	title := "\tAvg"
+	for _, c := range r.Checkpoints {
+		title += fmt.Sprintf("\tReach %d", c)
+	}
+	for _, col := range r.ExtraColumns {
+		title += "\t" + col.Title
+	}
+	fmt.Fprintln(tw, title)
+	for _, name := range r.Names {
+		row := resultRow(name, r.Results[name])
+		for _, col := range r.ExtraColumns {
+			row += "\t" + col.Cells[name]
+		}
+		fmt.Fprintln(tw, row)
+	}
+	for _, extra := range r.ExtraRows {
+		fmt.Fprintln(tw, resultRow(extra.Name, extra.Result))
+	}
+	return tw.Flush()
+}
+
+// resultRow returns the row of WriteTable with the mean and the reach of
+// each checkpoint of res.
+func resultRow(name string, res EvalResult) string {
+	row := name
+	row += fmt.Sprintf("\t%.1f", res.Mean)
+	for _, reach := range res.Reach {
+		row += fmt.Sprintf("\t%.1f%%", reach*100)
+	}
+	return row
+}
+
+// WritePairs writes the wins, losses and ties of the policy of each row
+// against the policy of each column on the same queues followed by the mean
+// difference in pieces consumed e.g. "12-3-5 (+40.2)". It returns the error
+// of Pair if two policies did not play the same number of queues.
+func (r TournamentResult) WritePairs(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, tablePadding, ' ', 0)
+	title := "W-L-T"
+	for _, name := range r.Names {
+		title += "\t" + name
+	}
+	fmt.Fprintln(tw, title)
+	for _, a := range r.Names {
+		row := a
+		for _, b := range r.Names {
+			if a == b {
+				row += "\t-"
+				continue
+			}
+			pair, err := r.Pair(a, b)
+			if err != nil {
+				return err
+			}
+			row += fmt.Sprintf("\t%d-%d-%d (%+.1f)", pair.Wins, pair.Losses, pair.Ties, pair.MeanDiff)
+		}
+		fmt.Fprintln(tw, row)
+	}
+	return tw.Flush()
+}
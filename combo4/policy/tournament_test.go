@@ -0,0 +1,107 @@
+package policy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+	"tetris"
+	"tetris/combo4"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestTournament(t *testing.T) {
+	moves, _ := combo4.AllContinuousMoves()
+	nfa := combo4.NewNFA(moves)
+	seq3 := FromScorer(nfa, NewNFAScorer(nfa, 3))
+	// Plays like seq3 until the first O piece so it never consumes more.
+	noO := PolicyFunc(func(initial combo4.State, current tetris.Piece, preview []tetris.Piece, endBagUsed tetris.PieceSet) *combo4.State {
+		if current == tetris.O {
+			return nil
+		}
+		return seq3.NextState(initial, current, preview, endBagUsed)
+	})
+	opts := EvalOptions{
+		Trials:         20,
+		PiecesPerTrial: 50,
+		PreviewSize:    3,
+		Checkpoints:    []int{10, 50},
+		Rand:           rand.New(rand.NewSource(1)),
+	}
+	res := Tournament(map[string]Policy{"seq3": seq3, "no O": noO}, opts)
+
+	if diff := cmp.Diff([]string{"no O", "seq3"}, res.Names); diff != "" {
+		t.Errorf("Names mismatch (-want +got):\n%s", diff)
+	}
+	opts.Rand = rand.New(rand.NewSource(1))
+	if diff := cmp.Diff(Evaluate(seq3, opts), res.Results["seq3"]); diff != "" {
+		t.Errorf("the result of seq3 differs from Evaluate (-want +got):\n%s", diff)
+	}
+
+	pair, err := res.Pair("seq3", "no O")
+	if err != nil {
+		t.Fatalf("Pair(seq3, no O): %v", err)
+	}
+	if pair.Wins == 0 || pair.Losses != 0 || pair.Wins+pair.Ties != opts.Trials {
+		t.Errorf("Pair(seq3, no O) got %+v, want only wins and ties over %d queues", pair, opts.Trials)
+	}
+	if want := res.Results["seq3"].Mean - res.Results["no O"].Mean; pair.MeanDiff <= 0 || math.Abs(pair.MeanDiff-want) > 1e-9 {
+		t.Errorf("Pair(seq3, no O).MeanDiff got %v, want %v", pair.MeanDiff, want)
+	}
+	reversed, err := res.Pair("no O", "seq3")
+	if err != nil {
+		t.Fatalf("Pair(no O, seq3): %v", err)
+	}
+	if reversed.Wins != pair.Losses || reversed.Losses != pair.Wins || reversed.Ties != pair.Ties || reversed.MeanDiff != -pair.MeanDiff {
+		t.Errorf("Pair(no O, seq3) got %+v, want the reverse of %+v", reversed, pair)
+	}
+
+	var table, pairs strings.Builder
+	if err := res.WriteTable(&table); err != nil {
+		t.Fatalf("WriteTable: %v", err)
+	}
+	wantTable := "       Avg    Reach 10   Reach 50\n" +
+		"no O   2.0    0.0%       0.0%\n" +
+		"seq3   31.4   75.0%      50.0%\n"
+	if diff := cmp.Diff(wantTable, table.String()); diff != "" {
+		t.Errorf("WriteTable mismatch (-want +got):\n%s", diff)
+	}
+	if err := res.WritePairs(&pairs); err != nil {
+		t.Fatalf("WritePairs: %v", err)
+	}
+	if want := fmt.Sprintf("%d-%d-%d", pair.Wins, pair.Losses, pair.Ties); !strings.Contains(pairs.String(), want) {
+		t.Errorf("WritePairs got\n%s\nwant it to contain %s", pairs.String(), want)
+	}
+
+	// The rows of compare.
+	res.Names = []string{"seq3", "no O"}
+	res.ExtraColumns = []TableColumn{{Title: "Hit rate", Cells: map[string]string{"seq3": "-", "no O": "-"}}}
+	res.ExtraRows = []TableRow{{Name: "Upper-bound", Result: EvalResult{Mean: 50, Reach: []float64{1, 1}}}}
+	table.Reset()
+	if err := res.WriteTable(&table); err != nil {
+		t.Fatalf("WriteTable: %v", err)
+	}
+	wantTable = "              Avg    Reach 10   Reach 50   Hit rate\n" +
+		"seq3          31.4   75.0%      50.0%      -\n" +
+		"no O          2.0    0.0%       0.0%       -\n" +
+		"Upper-bound   50.0   100.0%     100.0%\n"
+	if diff := cmp.Diff(wantTable, table.String()); diff != "" {
+		t.Errorf("WriteTable with extra columns and rows mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestTournamentPairDifferentQueues(t *testing.T) {
+	res := NewTournamentResult(map[string]EvalResult{
+		"a": {Consumed: []int{1, 2, 3}},
+		"b": {Consumed: []int{1, 2}},
+	}, nil)
+	if _, err := res.Pair("a", "b"); err == nil {
+		t.Errorf("Pair(a, b) got no error for 3 and 2 queues")
+	}
+	if err := res.WritePairs(ioutil.Discard); err == nil {
+		t.Errorf("WritePairs got no error for 3 and 2 queues")
+	}
+}
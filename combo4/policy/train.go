@@ -0,0 +1,155 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"tetris"
+	"tetris/combo4"
+	"time"
+)
+
+// TrainRange trains an MDP for each preview length from minPreview to
+// maxPreview and saves each one to dir as mdpN.gob where N is the preview
+// length. Each MDP after the first starts from the policy of the one before
+// it, which ignores the last piece of the preview. Progress is saved while
+// training so an interrupted MDP can be resumed with the gen/mdp command.
+func TrainRange(minPreview, maxPreview int, dir string) error {
+	return trainRange(minPreview, maxPreview, dir, (*MDP).Update)
+}
+
+// trainRange implements TrainRange with a custom function to update and save
+// each MDP.
+func trainRange(minPreview, maxPreview int, dir string, update func(m *MDP, filePath string) error) error {
+	if minPreview > maxPreview {
+		return fmt.Errorf("minPreview=%d is greater than maxPreview=%d", minPreview, maxPreview)
+	}
+	var prev *MDP
+	for previewLen := minPreview; previewLen <= maxPreview; previewLen++ {
+		mdp, err := NewMDP(previewLen)
+		if err != nil {
+			return fmt.Errorf("NewMDP(%d): %v", previewLen, err)
+		}
+		if prev != nil {
+			mdp.InitPolicyFrom(shorterPreview(prev.Policy(), prev.previewLen))
+		}
+		path := MDPPath(dir, previewLen)
+		log.Printf("Training the MDP for previewLen=%d to %s", previewLen, path)
+		if err := update(mdp, path); err != nil {
+			return fmt.Errorf("training previewLen=%d failed: %v", previewLen, err)
+		}
+		prev = mdp
+	}
+	return nil
+}
+
+// shorterPreview returns a Policy that calls pol with only the first
+// previewLen pieces of the preview and the 7 bag state after them.
+func shorterPreview(pol Policy, previewLen int) Policy {
+	return PolicyFunc(func(initial combo4.State, current tetris.Piece, preview []tetris.Piece, endBagUsed tetris.PieceSet) *combo4.State {
+		for len(preview) > previewLen {
+			last := preview[len(preview)-1]
+			preview = preview[:len(preview)-1]
+			// The bag before the last piece was full if the piece started
+			// a bag.
+			endBagUsed = fullIfEmpty(endBagUsed &^ last.PieceSet())
+		}
+		return pol.NextState(initial, current, preview, endBagUsed)
+	})
+}
+
+// MDPPath returns the path that TrainRange saves the MDP for the preview
+// length to.
+func MDPPath(dir string, previewLen int) string {
+	return filepath.Join(dir, fmt.Sprintf("mdp%d.gob", previewLen))
+}
+
+// TrainingMode is how MDP.Update finds the optimal policy.
+type TrainingMode int
+
+const (
+	// PolicyIteration alternates between updating the values of the policy
+	// until they converge and updating the policy from the values. It is
+	// the default.
+	PolicyIteration TrainingMode = iota
+	// ValueIteration updates each value to the highest value of its choices
+	// until the values converge and then chooses the policy once. Each
+	// sweep looks at every choice and keeps the dependencies of all of them
+	// so it uses more memory than PolicyIteration. It cannot be used with a
+	// RiskAversion.
+	ValueIteration
+)
+
+// String returns the name used by ParseTrainingMode.
+func (t TrainingMode) String() string {
+	switch t {
+	case PolicyIteration:
+		return "policy"
+	case ValueIteration:
+		return "value"
+	}
+	return fmt.Sprintf("TrainingMode(%d)", int(t))
+}
+
+// ParseTrainingMode returns the TrainingMode with the name from String.
+func ParseTrainingMode(name string) (TrainingMode, error) {
+	for _, t := range []TrainingMode{PolicyIteration, ValueIteration} {
+		if t.String() == name {
+			return t, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown TrainingMode %q", name)
+}
+
+// SetTrainingMode changes how Update trains the MDP. It returns an error for
+// ValueIteration if the MDP has a RiskAversion.
+func (m *MDP) SetTrainingMode(mode TrainingMode) error {
+	if err := validateTrainingMode(mode, m.riskAversion); err != nil {
+		return err
+	}
+	m.trainingMode = mode
+	return nil
+}
+
+func validateTrainingMode(mode TrainingMode, riskAversion float64) error {
+	switch {
+	case mode != PolicyIteration && mode != ValueIteration:
+		return fmt.Errorf("unknown %v", mode)
+	case mode == ValueIteration && riskAversion != 0:
+		return errors.New("ValueIteration cannot be used with a RiskAversion")
+	}
+	return nil
+}
+
+// valueIterate trains the MDP with ValueIteration and saves it to the
+// filePath.
+func (m *MDP) valueIterate(filePath string) error {
+	m.publishSnapshot()
+	m.policy = nil
+	start := time.Now()
+	changes, stats := m.sweepValues(m.value, m.allChoices, m.choiceBase)
+	m.convergence.add(stats)
+	log.Printf("value iteration with %d total changes in %v", changes, time.Since(start))
+
+	start = time.Now()
+	// The values of the choices are only accurate to epsilon since they
+	// are not computed from converged values of a single policy.
+	m.policy = make(map[GameState]combo4.State, len(m.value))
+	var buf possibilityBuf
+	for gState := range m.value {
+		m.policy[gState] = m.bestChoice(gState, epsilon, &buf)
+	}
+	log.Printf("Chose the policy in %v", time.Since(start))
+	m.publishSnapshot()
+
+	if err := m.Save(filePath); err != nil {
+		return fmt.Errorf("Save() failed: %v", err)
+	}
+	return nil
+}
+
+// allChoices returns every choice of the GameState.
+func (m *MDP) allChoices(gState GameState) []combo4.State {
+	return m.nfa.NextStates(gState.State, gState.Current)
+}
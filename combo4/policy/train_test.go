@@ -0,0 +1,191 @@
+package policy
+
+import (
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"path/filepath"
+	"testing"
+	"tetris/combo4"
+)
+
+func TestTrainRange(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping building MDPs in short mode")
+	}
+	dir := t.TempDir()
+
+	// Skip training to keep the test fast. The untrained MDPs are saved
+	// instead.
+	var trained []int
+	var prev *MDP
+	noUpdate := func(m *MDP, filePath string) error {
+		trained = append(trained, m.previewLen)
+		if prev != nil {
+			// The MDP starts from the choices of the previous one wherever
+			// they are valid.
+			seed := shorterPreview(prev.Policy(), prev.previewLen)
+			var seeded int
+			for gState, choice := range m.policy {
+				want := seed.NextState(gState.State, gState.Current, gState.Preview.Slice(), gState.BagUsed)
+				if want == nil || !isNextState(m.nfa, gState, *want) {
+					continue
+				}
+				seeded++
+				if choice != *want {
+					t.Fatalf("got choice %v for %v, want %v from the previous MDP", choice, gState, *want)
+				}
+			}
+			if seeded == 0 {
+				t.Errorf("no choices of previewLen=%d were seeded from the previous MDP", m.previewLen)
+			}
+		}
+		prev = m
+		return m.Save(filePath)
+	}
+	if err := trainRange(1, 2, dir, noUpdate); err != nil {
+		t.Fatalf("trainRange: %v", err)
+	}
+	if len(trained) != 2 || trained[0] != 1 || trained[1] != 2 {
+		t.Errorf("trained previewLens %v, want [1 2]", trained)
+	}
+
+	for previewLen := 1; previewLen <= 2; previewLen++ {
+		b, err := ioutil.ReadFile(MDPPath(dir, previewLen))
+		if err != nil {
+			t.Fatalf("ReadFile: %v", err)
+		}
+		mdp := new(MDP)
+		if err := mdp.GobDecode(b); err != nil {
+			t.Fatalf("GobDecode(mdp%d.gob): %v", previewLen, err)
+		}
+		if mdp.previewLen != previewLen {
+			t.Errorf("got previewLen=%d in mdp%d.gob", mdp.previewLen, previewLen)
+		}
+	}
+}
+
+func isNextState(nfa *combo4.NFA, gState GameState, next combo4.State) bool {
+	for _, s := range nfa.NextStates(gState.State, gState.Current) {
+		if s == next {
+			return true
+		}
+	}
+	return false
+}
+
+func TestTrainRangeInvalid(t *testing.T) {
+	if err := TrainRange(3, 2, t.TempDir()); err == nil {
+		t.Errorf("TrainRange(3, 2) got no error")
+	}
+	if err := TrainRange(8, 8, t.TempDir()); err == nil {
+		t.Errorf("TrainRange(8, 8) got no error")
+	}
+}
+
+func TestValueIteration(t *testing.T) {
+	t.Parallel()
+	if testing.Short() {
+		t.Skip("skipping training MDPs in short mode")
+	}
+
+	mdps := make(map[TrainingMode]*MDP)
+	for _, mode := range []TrainingMode{PolicyIteration, ValueIteration} {
+		mdp, err := NewMDPWithOptions(1, MDPOptions{TrainingMode: mode})
+		if err != nil {
+			t.Fatalf("NewMDPWithOptions(%v): %v", mode, err)
+		}
+		path := filepath.Join(t.TempDir(), "mdp.gob")
+		if err := mdp.Update(path); err != nil {
+			t.Fatalf("Update with %v: %v", mode, err)
+		}
+		mdps[mode] = mdp
+
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("ReadFile: %v", err)
+		}
+		decoded := new(MDP)
+		if err := decoded.GobDecode(b); err != nil {
+			t.Fatalf("GobDecode: %v", err)
+		}
+		if decoded.trainingMode != mode {
+			t.Errorf("got %v after decoding, want %v", decoded.trainingMode, mode)
+		}
+	}
+	// The modes may break near ties differently but the choices must be
+	// equally good.
+	policyIter := mdps[PolicyIteration]
+	var differ int
+	for gState, want := range policyIter.policy {
+		got := mdps[ValueIteration].policy[gState]
+		if got == want {
+			continue
+		}
+		differ++
+		if wantVal, gotVal := policyIter.choiceValue(gState, want, nil), policyIter.choiceValue(gState, got, nil); math.Abs(wantVal-gotVal) > 2*epsilon {
+			t.Errorf("%v: value iteration chose %v with a value of %v, want %v with %v", gState, got, gotVal, want, wantVal)
+		}
+	}
+	if differ > len(policyIter.policy)/100 {
+		t.Errorf("value iteration chose differently for %d of %d GameStates", differ, len(policyIter.policy))
+	}
+}
+
+func TestTrainingMode(t *testing.T) {
+	for _, mode := range []TrainingMode{PolicyIteration, ValueIteration} {
+		if got, err := ParseTrainingMode(mode.String()); err != nil || got != mode {
+			t.Errorf("ParseTrainingMode(%q) got (%v, %v), want %v", mode, got, err, mode)
+		}
+	}
+	if _, err := ParseTrainingMode("unknown"); err == nil {
+		t.Errorf("ParseTrainingMode(unknown) got no error")
+	}
+	if _, err := NewMDPWithOptions(0, MDPOptions{TrainingMode: ValueIteration, RiskAversion: 0.5}); err == nil {
+		t.Errorf("NewMDPWithOptions with ValueIteration and a RiskAversion got no error")
+	}
+}
+
+func TestBestChoiceTolerance(t *testing.T) {
+	mdp, err := NewMDP(1)
+	if err != nil {
+		t.Fatalf("NewMDP: %v", err)
+	}
+	mdp.updateValues()
+
+	// A large tolerance makes many choices ties which must all be within it
+	// of the highest value and not of each other.
+	const tolerance = 0.5
+	var (
+		buf     possibilityBuf
+		checked int
+	)
+	r := rand.New(rand.NewSource(1))
+	for gState := range mdp.policy {
+		if r.Intn(100) != 0 {
+			continue
+		}
+		checked++
+		choices := mdp.nfa.NextStates(gState.State, gState.Current)
+		maxVal := math.Inf(-1)
+		for _, choice := range choices {
+			maxVal = math.Max(maxVal, mdp.choiceValue(gState, choice, nil))
+		}
+		got := mdp.bestChoice(gState, tolerance, &buf)
+		if v := mdp.choiceValue(gState, got, nil); v < maxVal-tolerance {
+			t.Fatalf("%v: bestChoice got %v with a value of %v, want within %v of %v", gState, got, v, tolerance, maxVal)
+		}
+		gotCost := actionsCost(mdp.mActions, gState.State, got, gState.Current)
+		for _, choice := range choices {
+			if mdp.choiceValue(gState, choice, nil) < maxVal-tolerance {
+				continue
+			}
+			if cost := actionsCost(mdp.mActions, gState.State, choice, gState.Current); cost < gotCost || cost == gotCost && choice.Less(got) {
+				t.Fatalf("%v: bestChoice got %v with a cost of %d, want the tie %v with %d", gState, got, gotCost, choice, cost)
+			}
+		}
+	}
+	if checked == 0 {
+		t.Fatal("no GameStates were checked")
+	}
+}
@@ -2,6 +2,7 @@ package combo4
 
 import (
 	"fmt"
+	"math/bits"
 	"tetris"
 )
 
@@ -18,6 +19,28 @@ func (s State) String() string {
 	return fmt.Sprintf("Hold: %s\nField:\n%s", s.Hold, s.Field)
 }
 
+// Pack returns the State as an integer. Different States have different
+// packed values.
+func (s State) Pack() uint32 {
+	packed := uint32(s.Field)<<16 | uint32(s.Hold)<<1
+	if s.SwapRestricted {
+		packed |= 1
+	}
+	return packed
+}
+
+// Less returns whether the State is ordered before the other State. States
+// are ordered by their packed values.
+func (s State) Less(other State) bool {
+	return s.Pack() < other.Pack()
+}
+
+// Mirror returns the State with the field and hold piece reflected across
+// the y axis.
+func (s State) Mirror() State {
+	return State{Field: s.Field.Mirror(), Hold: s.Hold.Mirror(), SwapRestricted: s.SwapRestricted}
+}
+
 // StateSet represents a set of States.
 type StateSet map[State]bool
 
@@ -59,6 +82,18 @@ type NFA struct {
 	// trans contains possible transitions in the NFA.
 	// Usage: trans[piece][state] where piece is the next piece from the queue.
 	trans [8]map[State][]State
+
+	// ids assigns each State in the NFA a dense id for stateBits. states is
+	// the inverse of ids.
+	ids    map[State]int32
+	states []State
+	// transIDs contains the transitions of trans using ids.
+	// Usage: transIDs[piece][id].
+	transIDs [8][][]int32
+
+	// actions contains the actions of each Move or is nil if the NFA was not
+	// created with NewNFAWithActions.
+	actions map[Move][]tetris.Action
 }
 
 // NextStates returns the possible next states.
@@ -69,6 +104,24 @@ func (nfa *NFA) NextStates(initial State, piece tetris.Piece) []State {
 	return cpy
 }
 
+// IsForced returns true if there is exactly one possible next state.
+func (nfa *NFA) IsForced(s State, p tetris.Piece) bool {
+	return len(nfa.trans[p][s]) == 1
+}
+
+// ForcedChain returns how many of the pieces are forced to be played one way
+// starting from the State.
+func (nfa *NFA) ForcedChain(s State, pieces []tetris.Piece) int {
+	for idx, p := range pieces {
+		next := nfa.trans[p][s]
+		if len(next) != 1 {
+			return idx
+		}
+		s = next[0]
+	}
+	return len(pieces)
+}
+
 // States returns the set of States represented in the NFA.
 func (nfa *NFA) States() StateSet {
 	states := make(map[State]bool)
@@ -83,37 +136,142 @@ func (nfa *NFA) States() StateSet {
 	return states
 }
 
+// HasState returns whether the State has any transitions in the NFA. States
+// without transitions cannot continue and are usually invalid.
+func (nfa *NFA) HasState(state State) bool {
+	for _, m := range nfa.trans {
+		if _, ok := m[state]; ok {
+			return true
+		}
+	}
+	return false
+}
+
 // EndStates returns a set of end states given a set of initial/current
 // states and pieces to consume. EndStates also returns the number of consumed
 // pieces. The final state is returned if not all pieces were consumed.
 func (nfa *NFA) EndStates(initial StateSet, pieces []tetris.Piece) (StateSet, int) {
-	cur := make(map[State]bool)
-	for state, ok := range initial {
-		cur[state] = ok
+	return nfa.EndStatesInto(new(EndStatesScratch), initial, pieces)
+}
+
+// EndStatesScratch holds the sets used by EndStatesInto so they can be
+// reused between calls. The zero value is ready to use. An EndStatesScratch
+// must not be used by multiple goroutines at the same time.
+type EndStatesScratch struct {
+	cur, next stateBits
+	result    StateSet
+}
+
+// EndStatesInto is like EndStates but reuses the sets in scratch instead of
+// allocating new ones. The returned StateSet belongs to scratch and is only
+// valid until the next call with the same scratch.
+func (nfa *NFA) EndStatesInto(scratch *EndStatesScratch, initial StateSet, pieces []tetris.Piece) (StateSet, int) {
+	if scratch.result == nil {
+		scratch.result = make(StateSet)
 	}
+	end, consumed := nfa.endBits(scratch, initial, pieces)
+	if consumed == 0 {
+		return copyStateSet(initial, scratch.result), 0
+	}
+	nfa.fromBits(end, scratch.result)
+	return scratch.result, consumed
+}
+
+// NumConsumedInto is like EndStatesInto but only returns the number of
+// consumed pieces. It is faster since the end States are never converted to
+// a StateSet.
+func (nfa *NFA) NumConsumedInto(scratch *EndStatesScratch, initial StateSet, pieces []tetris.Piece) int {
+	_, consumed := nfa.endBits(scratch, initial, pieces)
+	return consumed
+}
+
+// endBits implements EndStatesInto using the stateBits in scratch. The end
+// States are only valid if at least one piece is consumed.
+func (nfa *NFA) endBits(scratch *EndStatesScratch, initial StateSet, pieces []tetris.Piece) (stateBits, int) {
+	if len(scratch.cur) != nfa.stateBitsLen() {
+		// The scratch is new or was used with an NFA with a different
+		// number of States.
+		scratch.cur = nfa.newStateBits()
+		scratch.next = nfa.newStateBits()
+	}
+	cur, next := scratch.cur, scratch.next
+	nfa.toBits(initial, cur)
 
-	next := make(map[State]bool)
 	for idx, piece := range pieces {
-		trans := nfa.trans[piece]
-		for curState := range cur {
-			for _, nextState := range trans[curState] {
-				next[nextState] = true
+		trans := nfa.transIDs[piece]
+		next.clear()
+		var hasNext bool
+		for w, word := range cur {
+			for word != 0 {
+				id := w<<6 + bits.TrailingZeros64(word)
+				word &= word - 1
+				for _, nextID := range trans[id] {
+					next.add(nextID)
+					hasNext = true
+				}
 			}
 		}
-		if len(next) == 0 {
+		if !hasNext {
 			return cur, idx
 		}
 		cur, next = next, cur
-		for key := range next {
-			delete(next, key)
-		}
 	}
 	return cur, len(pieces)
 }
 
+// copyStateSet sets dst to the States of src and returns dst. It is used
+// instead of stateBits when src may have States that are not in the NFA.
+func copyStateSet(src, dst StateSet) StateSet {
+	for key := range dst {
+		delete(dst, key)
+	}
+	for state, ok := range src {
+		dst[state] = ok
+	}
+	return dst
+}
+
 // NewNFA creates a new NFA. In general callers should reuse the same NFA
 // because the NFA is safe for concurrent use.
 func NewNFA(movesList []Move) *NFA {
+	return newNFA(movesList, true)
+}
+
+// NewNFAWithActions is like NewNFA but the NFA keeps the actions of each
+// Move e.g. the ones returned by AllContinuousMoves. See Actions.
+func NewNFAWithActions(movesList []Move, actions map[Move][]tetris.Action) *NFA {
+	nfa := newNFA(movesList, true)
+	nfa.actions = actions
+	return nfa
+}
+
+// Actions returns the actions of a Move or nil if they are not known. The
+// actions are only known by an NFA created with NewNFAWithActions.
+func (nfa *NFA) Actions(m Move) []tetris.Action {
+	actions, ok := nfa.actions[m]
+	if !ok {
+		return nil
+	}
+	return append([]tetris.Action(nil), actions...)
+}
+
+// TransitionActions is like the TransitionActions function with the actions
+// of the NFA. It returns false for an NFA not created with
+// NewNFAWithActions.
+func (nfa *NFA) TransitionActions(prev, next State, current tetris.Piece) ([]tetris.Action, bool) {
+	if nfa.actions == nil {
+		return nil, false
+	}
+	return TransitionActions(nfa.actions, prev, next, current)
+}
+
+// NewNFANoHold creates a new NFA that never uses the hold. Every State in the
+// NFA has an EmptyPiece Hold.
+func NewNFANoHold(movesList []Move) *NFA {
+	return newNFA(movesList, false)
+}
+
+func newNFA(movesList []Move, withHold bool) *NFA {
 	// Get a set of all Field4x4s which have possible moves.
 	startFields := make(map[Field4x4]bool)
 	for _, move := range movesList {
@@ -138,8 +296,10 @@ func NewNFA(movesList []Move) *NFA {
 	for field := range startFields {
 		for _, piece := range tetris.NonemptyPieces {
 			endStates := make([]State, 0, len(moves[field][piece])+1)
-			// Add transition from holding the piece.
-			endStates = append(endStates, State{Field: field, Hold: piece, SwapRestricted: true})
+			if withHold {
+				// Add transition from holding the piece.
+				endStates = append(endStates, State{Field: field, Hold: piece, SwapRestricted: true})
+			}
 			// Add transitions from playing the piece.
 			for _, endField := range moves[field][piece] {
 				endStates = append(endStates, State{Field: endField})
@@ -150,6 +310,10 @@ func NewNFA(movesList []Move) *NFA {
 		}
 	}
 
+	if !withHold {
+		return newNFAFromTrans(trans)
+	}
+
 	// Add all transitions from a SwapRestricted state.
 	for field := range startFields {
 		for _, hold := range tetris.NonemptyPieces {
@@ -186,5 +350,14 @@ func NewNFA(movesList []Move) *NFA {
 		}
 	}
 
-	return &NFA{trans: trans}
+	return newNFAFromTrans(trans)
+}
+
+// newNFAFromTrans returns the NFA of the transitions. The next states are in
+// the order of the moves they were built from. The policies break ties
+// between equal choices by this order so it is not changed.
+func newNFAFromTrans(trans [8]map[State][]State) *NFA {
+	nfa := &NFA{trans: trans}
+	nfa.assignStateIDs()
+	return nfa
 }
@@ -0,0 +1,81 @@
+package combo4
+
+import (
+	"math/bits"
+	"sort"
+)
+
+// stateBits is a set of the States in an NFA where each bit is the id of a
+// State. It is faster than a StateSet for the set operations in EndStates.
+type stateBits []uint64
+
+func (b stateBits) add(id int32) {
+	b[id>>6] |= 1 << (uint(id) & 63)
+}
+
+func (b stateBits) clear() {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// assignStateIDs gives each State in the NFA a dense id in the order of
+// State.Less and converts the transitions to ids.
+func (nfa *NFA) assignStateIDs() {
+	nfa.states = nfa.States().Slice()
+	sort.Slice(nfa.states, func(i, j int) bool { return nfa.states[i].Less(nfa.states[j]) })
+	nfa.ids = make(map[State]int32, len(nfa.states))
+	for id, state := range nfa.states {
+		nfa.ids[state] = int32(id)
+	}
+
+	for piece, m := range nfa.trans {
+		if m == nil {
+			continue
+		}
+		nfa.transIDs[piece] = make([][]int32, len(nfa.states))
+		for state, next := range m {
+			nextIDs := make([]int32, len(next))
+			for idx, nextState := range next {
+				nextIDs[idx] = nfa.ids[nextState]
+			}
+			nfa.transIDs[piece][nfa.ids[state]] = nextIDs
+		}
+	}
+}
+
+// newStateBits returns an empty stateBits that can hold every State in the
+// NFA.
+func (nfa *NFA) newStateBits() stateBits {
+	return make(stateBits, nfa.stateBitsLen())
+}
+
+// stateBitsLen returns the number of words in the stateBits of the NFA.
+func (nfa *NFA) stateBitsLen() int {
+	return (len(nfa.states) + 63) / 64
+}
+
+// toBits sets dst to the States of the StateSet. States that are not in the
+// NFA are left out since they have no transitions.
+func (nfa *NFA) toBits(set StateSet, dst stateBits) {
+	dst.clear()
+	for state := range set {
+		if id, ok := nfa.ids[state]; ok {
+			dst.add(id)
+		}
+	}
+}
+
+// fromBits sets dst to the States of the stateBits.
+func (nfa *NFA) fromBits(b stateBits, dst StateSet) {
+	for key := range dst {
+		delete(dst, key)
+	}
+	for w, word := range b {
+		for word != 0 {
+			id := w<<6 + bits.TrailingZeros64(word)
+			word &= word - 1
+			dst[nfa.states[id]] = true
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package combo4
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestStateBits(t *testing.T) {
+	moves, _ := AllContinuousMoves()
+	for name, nfa := range map[string]*NFA{
+		"Hold":    NewNFA(moves),
+		"No hold": NewNFANoHold(moves),
+	} {
+		t.Run(name, func(t *testing.T) {
+			states := nfa.States().Slice()
+			if len(nfa.ids) != len(states) {
+				t.Fatalf("got %d ids, want one for each of the %d States", len(nfa.ids), len(states))
+			}
+
+			r := rand.New(rand.NewSource(1))
+			randSet := func() StateSet {
+				set := make(StateSet)
+				for i := r.Intn(20); i >= 0; i-- {
+					set[states[r.Intn(len(states))]] = true
+				}
+				return set
+			}
+			toBits := func(set StateSet) stateBits {
+				b := nfa.newStateBits()
+				nfa.toBits(set, b)
+				return b
+			}
+
+			for i := 0; i < 100; i++ {
+				set, other := randSet(), randSet()
+				if i%2 == 0 {
+					other = copyStateSet(set, make(StateSet))
+				}
+
+				got := make(StateSet)
+				nfa.fromBits(toBits(set), got)
+				wantSlice, gotSlice := set.Slice(), got.Slice()
+				sort.Slice(wantSlice, func(i, j int) bool { return wantSlice[i].Less(wantSlice[j]) })
+				sort.Slice(gotSlice, func(i, j int) bool { return gotSlice[i].Less(gotSlice[j]) })
+				if diff := cmp.Diff(wantSlice, gotSlice); diff != "" {
+					t.Errorf("Slice after converting to bits mismatch (-want +got):\n%s", diff)
+				}
+
+				if want, got := set.Equals(other), cmp.Equal(toBits(set), toBits(other)); got != want {
+					t.Errorf("bits Equals got %t, want %t for %v and %v", got, want, set, other)
+				}
+			}
+		})
+	}
+}
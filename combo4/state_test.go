@@ -1,6 +1,7 @@
 package combo4
 
 import (
+	"math/rand"
 	"testing"
 	"tetris"
 
@@ -42,6 +43,63 @@ func benchmarkNFA(b *testing.B, sequenceLen int) {
 	b.Logf("Number of end states with possibilities %.3f%% of %d tries", float64(completed)/float64(b.N), b.N)
 }
 
+func BenchmarkEndStates(b *testing.B) {
+	benchmarkEndStates(b, func(nfa *NFA, initial StateSet, pieces []tetris.Piece) int {
+		_, consumed := nfa.EndStates(initial, pieces)
+		return consumed
+	})
+}
+
+func BenchmarkEndStatesInto(b *testing.B) {
+	scratch := new(EndStatesScratch)
+	benchmarkEndStates(b, func(nfa *NFA, initial StateSet, pieces []tetris.Piece) int {
+		_, consumed := nfa.EndStatesInto(scratch, initial, pieces)
+		return consumed
+	})
+}
+
+func benchmarkEndStates(b *testing.B, endStates func(*NFA, StateSet, []tetris.Piece) int) {
+	moves, _ := AllContinuousMoves()
+	nfa := NewNFA(moves)
+	initial := NewStateSet(State{Field: LeftI})
+	inputs := make([][]tetris.Piece, 100)
+	r := rand.New(rand.NewSource(1))
+	for idx := range inputs {
+		inputs[idx] = tetris.RandPiecesFrom(r, 7)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		endStates(nfa, initial, inputs[n%len(inputs)])
+	}
+}
+
+func TestEndStatesInto(t *testing.T) {
+	moves, _ := AllContinuousMoves()
+	nfa := NewNFA(moves)
+	states := nfa.States().Slice()
+	r := rand.New(rand.NewSource(1))
+
+	scratch := new(EndStatesScratch)
+	for i := 0; i < 200; i++ {
+		initial := NewStateSet(states[r.Intn(len(states))], states[r.Intn(len(states))])
+		pieces := tetris.RandPiecesFrom(r, r.Intn(10))
+
+		wantEnd, wantConsumed := nfa.EndStates(initial, pieces)
+		gotEnd, gotConsumed := nfa.EndStatesInto(scratch, initial, pieces)
+		if gotConsumed != wantConsumed {
+			t.Errorf("EndStatesInto(%v, %v) consumed %d, want %d", initial, pieces, gotConsumed, wantConsumed)
+		}
+		if diff := cmp.Diff(map[State]bool(wantEnd), map[State]bool(gotEnd)); diff != "" {
+			t.Errorf("EndStatesInto(%v, %v) mismatch (-want +got):\n%s", initial, pieces, diff)
+		}
+		if got := nfa.NumConsumedInto(scratch, initial, pieces); got != wantConsumed {
+			t.Errorf("NumConsumedInto(%v, %v) got %d, want %d", initial, pieces, got, wantConsumed)
+		}
+	}
+}
+
 func TestEndStates(t *testing.T) {
 	moves, _ := AllContinuousMoves()
 	nfa := NewNFA(moves)
@@ -174,3 +232,203 @@ func TestNextStates(t *testing.T) {
 		t.Errorf("NextStates() got %v, want %v", got, want)
 	}
 }
+
+func TestHasState(t *testing.T) {
+	moves, _ := AllContinuousMoves()
+	nfa := NewNFA(moves)
+
+	const X, o = true, false
+	tests := []struct {
+		desc  string
+		state State
+		want  bool
+	}{
+		{
+			desc:  "Start of a combo",
+			state: State{Field: LeftI},
+			want:  true,
+		},
+		{
+			desc:  "Swap restricted",
+			state: State{Field: LeftI, Hold: tetris.T, SwapRestricted: true},
+			want:  true,
+		},
+		{
+			desc:  "Field with too many squares",
+			state: State{Field: NewField4x4([][4]bool{{X, X, o, o}, {X, X, o, o}})},
+			want:  false,
+		},
+		{
+			desc:  "Swap restricted without a held piece",
+			state: State{Field: LeftI, SwapRestricted: true},
+			want:  false,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			if got := nfa.HasState(test.state); got != test.want {
+				t.Errorf("HasState(%v)=%t, want %t", test.state, got, test.want)
+			}
+		})
+	}
+}
+
+func TestNFAActions(t *testing.T) {
+	moves, mActions := AllContinuousMoves()
+	nfa := NewNFAWithActions(moves, mActions)
+	for move, want := range mActions {
+		if diff := cmp.Diff(want, nfa.Actions(move)); diff != "" {
+			t.Errorf("Actions(%v) differ: (-want +got)\n%s", move, diff)
+		}
+	}
+	if got := nfa.Actions(Move{Start: LeftI, End: LeftI}); got != nil {
+		t.Errorf("Actions of a Move without a piece got %v, want nil", got)
+	}
+
+	prev := State{Field: moves[0].Start, Hold: moves[0].Piece}
+	next := State{Field: moves[0].End, Hold: tetris.EmptyPiece}
+	for _, p := range tetris.NonemptyPieces {
+		if p != moves[0].Piece {
+			next.Hold = p
+			break
+		}
+	}
+	want, _ := TransitionActions(mActions, prev, next, next.Hold)
+	got, ok := nfa.TransitionActions(prev, next, next.Hold)
+	if !ok {
+		t.Fatalf("TransitionActions(%v, %v) got no actions", prev, next)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("TransitionActions differ: (-want +got)\n%s", diff)
+	}
+
+	if _, ok := NewNFA(moves).TransitionActions(prev, next, next.Hold); ok {
+		t.Errorf("TransitionActions of an NFA without actions got actions")
+	}
+}
+
+func TestForced(t *testing.T) {
+	moves, _ := AllContinuousMoves()
+	nfa := NewNFA(moves)
+
+	const X, o = true, false
+	restricted := State{
+		Field: NewField4x4([][4]bool{
+			{X, X, o, o},
+			{o, X, o, o},
+		}),
+		Hold:           tetris.L,
+		SwapRestricted: true,
+	}
+	tests := []struct {
+		desc          string
+		state         State
+		pieces        []tetris.Piece
+		wantForced    bool
+		wantChainSize int
+	}{
+		{
+			desc:          "Forced until a piece with no moves",
+			state:         restricted,
+			pieces:        []tetris.Piece{tetris.I, tetris.I, tetris.L},
+			wantForced:    true,
+			wantChainSize: 2,
+		},
+		{
+			desc:          "Can hold or play",
+			state:         State{Field: LeftI},
+			pieces:        []tetris.Piece{tetris.T},
+			wantForced:    false,
+			wantChainSize: 0,
+		},
+		{
+			desc:          "No pieces",
+			state:         State{Field: LeftI},
+			wantForced:    false,
+			wantChainSize: 0,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			if len(test.pieces) > 0 {
+				if got := nfa.IsForced(test.state, test.pieces[0]); got != test.wantForced {
+					t.Errorf("IsForced()=%t, want %t", got, test.wantForced)
+				}
+			}
+			if got := nfa.ForcedChain(test.state, test.pieces); got != test.wantChainSize {
+				t.Errorf("ForcedChain()=%d, want %d", got, test.wantChainSize)
+			}
+		})
+	}
+}
+
+func TestNFANoHold(t *testing.T) {
+	moves, _ := AllContinuousMoves()
+	nfa := NewNFA(moves)
+	noHold := NewNFANoHold(moves)
+
+	for state := range noHold.States() {
+		if state.Hold != tetris.EmptyPiece || state.SwapRestricted {
+			t.Errorf("got State %v in the no hold NFA, want no Hold", state)
+		}
+	}
+
+	// Compare how many sequences can be completed with perfect information.
+	const trials, seqLen = 200, 14
+	rng := rand.New(rand.NewSource(1))
+	var completed, completedNoHold int
+	for i := 0; i < trials; i++ {
+		var pieces []tetris.Piece
+		for len(pieces) < seqLen {
+			for _, idx := range rng.Perm(7) {
+				pieces = append(pieces, tetris.Piece(idx+1))
+			}
+		}
+		pieces = pieces[:seqLen]
+
+		initial := NewStateSet(State{Field: LeftI})
+		if _, consumed := nfa.EndStates(initial, pieces); consumed == seqLen {
+			completed++
+		}
+		if _, consumed := noHold.EndStates(initial, pieces); consumed == seqLen {
+			completedNoHold++
+		}
+	}
+	if completedNoHold >= completed {
+		t.Errorf("completed %d/%d sequences without hold, want fewer than the %d/%d with hold", completedNoHold, trials, completed, trials)
+	}
+}
+
+func TestStateLess(t *testing.T) {
+	states := []State{
+		{Field: LeftI},
+		{Field: LeftI, Hold: tetris.T},
+		{Field: LeftI, Hold: tetris.T, SwapRestricted: true},
+		{Field: LeftI, Hold: tetris.I},
+		{Field: RightI},
+	}
+	for i, a := range states {
+		for j, b := range states {
+			if got, want := a.Less(b), i < j; got != want {
+				t.Errorf("%+v.Less(%+v) got %t, want %t", a, b, got, want)
+			}
+		}
+	}
+}
+
+// The order of NextStates breaks ties between equal choices of the policies
+// so it must only depend on the order of the moves. The MDPPolicies that were
+// compressed with this order rely on it.
+func TestNFADeterministicOrder(t *testing.T) {
+	moves, _ := AllContinuousMoves()
+	want := NewNFA(moves)
+	got := NewNFA(moves)
+
+	for state := range want.States() {
+		for _, piece := range tetris.NonemptyPieces {
+			if diff := cmp.Diff(want.NextStates(state, piece), got.NextStates(state, piece)); diff != "" {
+				t.Fatalf("NextStates(%+v, %v) order differs (-want +got):\n%s", state, piece, diff)
+			}
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package combo4
+
+import (
+	"strings"
+	"tetris"
+)
+
+// RenderTrace returns the fields of a game side by side with the piece played
+// between each pair of fields and the hold piece under each field. pieces[i]
+// is played from states[i] to reach states[i+1]. A nil State, which means
+// there were no more possible moves, is drawn with dashes.
+//
+// Empty rows are only drawn above the rows of Field4x4.String, which is
+// always the case for the fields of a 4 wide combo.
+func RenderTrace(states []*State, pieces []tetris.Piece) string {
+	// Each field is 4 rows followed by the hold row.
+	var rows [5][]string
+	for idx, state := range states {
+		if idx > 0 {
+			piece := "?"
+			if idx-1 < len(pieces) {
+				piece = pieces[idx-1].String()
+			}
+			for r := range rows {
+				sep := "   "
+				if r == 3 {
+					sep = " " + piece + " "
+				}
+				rows[r] = append(rows[r], sep)
+			}
+		}
+		if state == nil {
+			for r := 0; r < 4; r++ {
+				rows[r] = append(rows[r], "----")
+			}
+			rows[4] = append(rows[4], "    ")
+			continue
+		}
+		fieldRows := strings.Split(strings.TrimSuffix(state.Field.String(), "\n"), "\n")
+		if fieldRows[0] == "" {
+			fieldRows = nil
+		}
+		for r := 0; r < 4; r++ {
+			if pad := 4 - len(fieldRows); r < pad {
+				rows[r] = append(rows[r], "____")
+			} else {
+				rows[r] = append(rows[r], fieldRows[r-pad])
+			}
+		}
+		hold := "h=" + state.Hold.String()
+		if state.SwapRestricted {
+			hold += "*"
+		} else {
+			hold += " "
+		}
+		rows[4] = append(rows[4], hold)
+	}
+
+	var sb strings.Builder
+	for _, row := range rows {
+		sb.WriteString(strings.TrimRight(strings.Join(row, ""), " "))
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
@@ -0,0 +1,28 @@
+package combo4
+
+import (
+	"testing"
+	"tetris"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRenderTrace(t *testing.T) {
+	states := []*State{
+		{Field: LeftI},
+		{Field: LeftI, Hold: tetris.T, SwapRestricted: true},
+		{Field: LeftZ, Hold: tetris.T},
+		nil,
+	}
+	pieces := []tetris.Piece{tetris.T, tetris.I, tetris.O}
+
+	want := "" +
+		"____   ____   ____   ----\n" +
+		"____   ____   ____   ----\n" +
+		"____   ____   □___   ----\n" +
+		"□□□_ T □□□_ I □□__ O ----\n" +
+		"h=Ɛ    h=T*   h=T\n"
+	if diff := cmp.Diff(want, RenderTrace(states, pieces)); diff != "" {
+		t.Errorf("RenderTrace mismatch (-want +got):\n%s", diff)
+	}
+}
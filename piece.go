@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"math/bits"
 	"math/rand"
+	"strings"
 )
 
 // Piece represents a tetrimino or empty piece.
@@ -55,6 +56,54 @@ func SeqFromStr(s string) []Piece {
 	return pieces
 }
 
+// compressAlphabet are the characters of CompressSeq. They are the URL safe
+// base64 alphabet so the strings can be used in file names and URLs.
+const compressAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_"
+
+// The number of nonempty pieces.
+const numPieces = len(NonemptyPieces)
+
+// CompressSeq returns a compact string of the nonempty pieces for logs. Each
+// character is a pair of pieces or the last piece of an odd number of
+// pieces so it is half the length of the piece letters. It panics on
+// an EmptyPiece. DecompressSeq returns the pieces.
+func CompressSeq(pieces []Piece) string {
+	index := func(p Piece) int {
+		if p == EmptyPiece || p > I {
+			panic(fmt.Sprintf("CompressSeq of %v", pieces))
+		}
+		return int(p - 1)
+	}
+	b := make([]byte, 0, (len(pieces)+1)/2)
+	for i := 0; i+1 < len(pieces); i += 2 {
+		b = append(b, compressAlphabet[index(pieces[i])*numPieces+index(pieces[i+1])])
+	}
+	if len(pieces)%2 == 1 {
+		// The pairs use the first 49 characters.
+		b = append(b, compressAlphabet[numPieces*numPieces+index(pieces[len(pieces)-1])])
+	}
+	return string(b)
+}
+
+// DecompressSeq returns the pieces of a string from CompressSeq.
+func DecompressSeq(s string) ([]Piece, error) {
+	pieces := make([]Piece, 0, 2*len(s))
+	for i := 0; i < len(s); i++ {
+		v := strings.IndexByte(compressAlphabet, s[i])
+		switch {
+		case v < 0 || v >= numPieces*numPieces+numPieces:
+			return nil, fmt.Errorf("invalid character %q at %d", s[i], i)
+		case v < numPieces*numPieces:
+			pieces = append(pieces, Piece(v/numPieces+1), Piece(v%numPieces+1))
+		case i != len(s)-1:
+			return nil, fmt.Errorf("a single piece %q at %d is not the last character", s[i], i)
+		default:
+			pieces = append(pieces, Piece(v-numPieces*numPieces+1))
+		}
+	}
+	return pieces, nil
+}
+
 func (p Piece) String() string {
 	switch p {
 	case EmptyPiece:
@@ -122,15 +171,50 @@ func (p Piece) Mirror() Piece {
 
 // RandPieces turns a slice of random pieces using a 7 bag randomizer.
 func RandPieces(length int) []Piece {
+	return randPieces(length, rand.Perm)
+}
+
+// RandPiecesFrom is like RandPieces but uses r as the source of randomness.
+func RandPiecesFrom(r *rand.Rand, length int) []Piece {
+	return randPieces(length, r.Perm)
+}
+
+func randPieces(length int, perm func(int) []int) []Piece {
 	pieces := make([]Piece, 0, length+6)
 	for len(pieces) < length {
-		for _, i := range rand.Perm(7) {
+		for _, i := range perm(7) {
 			pieces = append(pieces, Piece(i+1))
 		}
 	}
 	return pieces[:length]
 }
 
+// ForEachBagQueue calls fn with every sequence of the length that a 7 bag
+// randomizer can produce starting from a new bag. fn must not keep the slice
+// since it is reused. ForEachBagQueue stops early if fn returns false.
+func ForEachBagQueue(length int, fn func([]Piece) bool) {
+	queue := make([]Piece, length)
+	forEachBagQueue(queue, 0, 0, fn)
+}
+
+// forEachBagQueue fills in the queue from idx onwards and returns false if
+// the enumeration was stopped.
+func forEachBagQueue(queue []Piece, idx int, bagUsed PieceSet, fn func([]Piece) bool) bool {
+	if idx == len(queue) {
+		return fn(queue)
+	}
+	if bagUsed.IsFullBag() {
+		bagUsed = 0
+	}
+	for _, p := range bagUsed.Inverted().Slice() {
+		queue[idx] = p
+		if !forEachBagQueue(queue, idx+1, bagUsed.Add(p), fn) {
+			return false
+		}
+	}
+	return true
+}
+
 // PieceSet represents a set of pieces. Duplicates and EmptyPieces are not recorded.
 // The empty value is usable.
 type PieceSet uint8
@@ -161,9 +245,27 @@ func (ps PieceSet) Contains(p Piece) bool {
 	return ps&p.PieceSet() != 0
 }
 
+// popcount is the number of set bits of each uint8.
+var popcount = func() [256]uint8 {
+	var table [256]uint8
+	for i := range table {
+		table[i] = uint8(bits.OnesCount8(uint8(i)))
+	}
+	return table
+}()
+
 // Len returns the number of items in the PieceSet.
 func (ps PieceSet) Len() int {
-	return bits.OnesCount8(uint8(ps))
+	return int(popcount[ps])
+}
+
+// fullBag contains every non-empty Piece.
+const fullBag PieceSet = 0xFF &^ 1
+
+// IsFullBag returns whether the PieceSet contains all 7 non-empty Pieces.
+// A bag state with every Piece drawn starts a new bag.
+func (ps PieceSet) IsFullBag() bool {
+	return ps&fullBag == fullBag
 }
 
 // Slice returns a slice of all Pieces represented by this set.
@@ -191,6 +293,15 @@ func (ps PieceSet) Inverted() PieceSet {
 	return (ps ^ 255) &^ (1 << EmptyPiece)
 }
 
+// Mirror returns a PieceSet with the mirrored version of each Piece.
+func (ps PieceSet) Mirror() PieceSet {
+	var mirrored PieceSet
+	for _, p := range ps.Slice() {
+		mirrored = mirrored.Add(p.Mirror())
+	}
+	return mirrored
+}
+
 // AllPieceSets returns a list of all possible piece sets.
 func AllPieceSets() []PieceSet {
 	sets := make([]PieceSet, 128)
@@ -1,10 +1,14 @@
 package tetris
 
 import (
+	"fmt"
+	"math/bits"
+	"math/rand"
 	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 )
 
 func TestPieceFromRune(t *testing.T) {
@@ -135,6 +139,74 @@ func TestRandPieces(t *testing.T) {
 	}
 }
 
+func TestRandPiecesFrom(t *testing.T) {
+	got := RandPiecesFrom(rand.New(rand.NewSource(1)), 20)
+	want := RandPiecesFrom(rand.New(rand.NewSource(1)), 20)
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("RandPiecesFrom with the same seed differs (-want +got):\n%s", diff)
+	}
+	if set := NewPieceSet(got[:7]...); set != NewPieceSet(NonemptyPieces[:]...) {
+		t.Errorf("RandPiecesFrom(r, 20)[:7] does not contain all pieces, got %v", got[:7])
+	}
+}
+
+func TestCompressSeq(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, length := range []int{0, 1, 2, 7, 13, 100, 30000} {
+		pieces := RandPiecesFrom(r, length)
+		compressed := CompressSeq(pieces)
+		if want := (length + 1) / 2; len(compressed) != want {
+			t.Errorf("CompressSeq of %d pieces got %d characters, want %d", length, len(compressed), want)
+		}
+		got, err := DecompressSeq(compressed)
+		if err != nil {
+			t.Fatalf("DecompressSeq(CompressSeq(%d pieces)): %v", length, err)
+		}
+		if diff := cmp.Diff(pieces, got, cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("DecompressSeq(CompressSeq(%d pieces)) mismatch (-want +got):\n%s", length, diff)
+		}
+	}
+	if got := CompressSeq([]Piece{T, L, I}); got != "B3" {
+		t.Errorf("CompressSeq(TLI) got %q, want %q", got, "B3")
+	}
+}
+
+func TestDecompressSeqInvalid(t *testing.T) {
+	for _, s := range []string{"!", "3B", "B_"} {
+		if got, err := DecompressSeq(s); err == nil {
+			t.Errorf("DecompressSeq(%q) got %v, want an error", s, got)
+		}
+	}
+}
+
+func TestForEachBagQueue(t *testing.T) {
+	var count int
+	seen := make(map[string]bool)
+	ForEachBagQueue(8, func(queue []Piece) bool {
+		count++
+		seen[fmt.Sprint(queue)] = true
+		if !Permutations(0).Contains(queue) {
+			t.Fatalf("ForEachBagQueue gave %v which is not from a 7 bag", queue)
+		}
+		return true
+	})
+	if want := Permutations(0).Size(8); count != want {
+		t.Errorf("ForEachBagQueue(8) gave %d queues, want %d", count, want)
+	}
+	if len(seen) != count {
+		t.Errorf("ForEachBagQueue(8) gave %d queues but only %d are distinct", count, len(seen))
+	}
+
+	var calls int
+	ForEachBagQueue(8, func([]Piece) bool {
+		calls++
+		return calls < 3
+	})
+	if calls != 3 {
+		t.Errorf("ForEachBagQueue made %d calls after stopping at 3", calls)
+	}
+}
+
 func TestAddPiece(t *testing.T) {
 	var empty PieceSet
 
@@ -178,6 +250,27 @@ func TestMirror(t *testing.T) {
 	}
 }
 
+func TestMirrorPairs(t *testing.T) {
+	want := map[Piece]Piece{
+		EmptyPiece: EmptyPiece,
+		T:          T,
+		L:          J,
+		J:          L,
+		S:          Z,
+		Z:          S,
+		O:          O,
+		I:          I,
+	}
+	for p, w := range want {
+		if got := p.Mirror(); got != w {
+			t.Errorf("%v.Mirror() got %v, want %v", p, got, w)
+		}
+		if got := p.Mirror().Mirror(); got != p {
+			t.Errorf("%v.Mirror().Mirror() got %v, want %v", p, got, p)
+		}
+	}
+}
+
 func TestAllPieceSets(t *testing.T) {
 	sets := AllPieceSets()
 	seen := make(map[PieceSet]bool)
@@ -192,3 +285,34 @@ func TestAllPieceSets(t *testing.T) {
 		t.Errorf("got %d bags, want 128", len(seen))
 	}
 }
+
+func TestLenMatchesOnesCount(t *testing.T) {
+	for i := 0; i < 256; i++ {
+		if got, want := PieceSet(i).Len(), bits.OnesCount8(uint8(i)); got != want {
+			t.Errorf("PieceSet(%08b).Len() got %d, want %d", i, got, want)
+		}
+	}
+}
+
+func TestIsFullBag(t *testing.T) {
+	for _, ps := range AllPieceSets() {
+		if got, want := ps.IsFullBag(), ps.Len() == 7; got != want {
+			t.Errorf("%v.IsFullBag() got %t, want %t", ps, got, want)
+		}
+	}
+	// EmptyPiece is not one of the 7 pieces of a bag.
+	if NewPieceSet(T, L, J, S, Z, O).Add(EmptyPiece).IsFullBag() {
+		t.Error("IsFullBag() of 6 pieces and EmptyPiece got true, want false")
+	}
+}
+
+func BenchmarkPieceSetLen(b *testing.B) {
+	var total int
+	for n := 0; n < b.N; n++ {
+		total += PieceSet(n).Len()
+	}
+	benchLen = total
+}
+
+// benchLen keeps BenchmarkPieceSetLen from being optimized away.
+var benchLen int
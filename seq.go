@@ -51,6 +51,15 @@ func (seq Seq) Slice() []Piece {
 	return slice
 }
 
+// ForEach calls fn with the index and piece of each piece in the Seq in
+// order. Unlike Slice it does not allocate.
+func (seq Seq) ForEach(fn func(i int, p Piece)) {
+	for idx := 0; seq&15 != 0; idx++ {
+		fn(idx, Piece(seq&15))
+		seq >>= 4
+	}
+}
+
 // AtIndex returns what piece is at the index of the Sequence or EmptyPiece.
 func (seq Seq) AtIndex(idx int) Piece {
 	shift := uint(idx) << 2
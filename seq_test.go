@@ -111,3 +111,71 @@ func TestRemoveFirst(t *testing.T) {
 		})
 	}
 }
+
+func TestSeqMapKey(t *testing.T) {
+	seqs := map[Seq]bool{}
+	var count int
+	// Every sequence of up to 3 pieces must be a distinct key.
+	var add func(prefix []Piece)
+	add = func(prefix []Piece) {
+		seqs[MustSeq(prefix)] = true
+		count++
+		if len(prefix) == 3 {
+			return
+		}
+		for _, p := range NonemptyPieces {
+			add(append(prefix, p))
+		}
+	}
+	add(nil)
+	if len(seqs) != count {
+		t.Errorf("got %d distinct keys for %d sequences, want the same", len(seqs), count)
+	}
+
+	if !seqs[MustSeq([]Piece{T, L})] {
+		t.Errorf("lookup of an equal Seq failed")
+	}
+}
+
+func TestSeqForEach(t *testing.T) {
+	for _, pieces := range [][]Piece{
+		nil,
+		{T},
+		{I, L, O},
+		{I, L, O, S, J, S, I, I},
+	} {
+		seq := MustSeq(pieces)
+		var got []Piece
+		seq.ForEach(func(i int, p Piece) {
+			if i != len(got) {
+				t.Errorf("%v: ForEach got index %d, want %d", pieces, i, len(got))
+			}
+			got = append(got, p)
+		})
+		if diff := cmp.Diff(seq.Slice(), got); diff != "" {
+			t.Errorf("ForEach differs from Slice (-want +got):\n%s", diff)
+		}
+	}
+}
+
+func BenchmarkSeqSlice(b *testing.B) {
+	seq := MustSeq([]Piece{I, L, O, S, J, T, Z})
+	b.ReportAllocs()
+	var sum Piece
+	for n := 0; n < b.N; n++ {
+		for _, p := range seq.Slice() {
+			sum += p
+		}
+	}
+}
+
+func BenchmarkSeqForEach(b *testing.B) {
+	seq := MustSeq([]Piece{I, L, O, S, J, T, Z})
+	b.ReportAllocs()
+	var sum Piece
+	for n := 0; n < b.N; n++ {
+		seq.ForEach(func(_ int, p Piece) {
+			sum += p
+		})
+	}
+}
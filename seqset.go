@@ -10,9 +10,10 @@ func init() {
 	for _, bag := range AllPieceSets() {
 		bagIdx := bag
 		permutations[bagIdx].isPermutation = true
+		permutations[bagIdx].permutationBag = bagIdx
 
 		// Full bag is equivalent to empty bag.
-		if bag.Len() == 7 {
+		if bag.IsFullBag() {
 			bag = 0
 		}
 		for _, p := range NonemptyPieces {
@@ -40,6 +41,8 @@ type SeqSet struct {
 	subSeqSets [7]*SeqSet
 	// Whether the SeqSet is from the global permutations var.
 	isPermutation bool
+	// The bag state of the permutations if isPermutation is true.
+	permutationBag PieceSet
 }
 
 // ContainsAllSeqSet is a special SeqSet that contains all sequences.
@@ -164,6 +167,41 @@ func (s *SeqSet) String() string {
 	return fmt.Sprintf("{prefixes=%v}", s.Prefixes())
 }
 
+// IsEmpty returns true if the SeqSet contains no sequences. This is true for
+// nil and for SeqSets without any prefixes. ContainsAllSeqSet and the
+// Permutations are never empty.
+func (s *SeqSet) IsEmpty() bool {
+	if s == nil {
+		return true
+	}
+	if s == ContainsAllSeqSet || s.isPermutation {
+		return false
+	}
+	for _, sub := range s.subSeqSets {
+		if !sub.IsEmpty() {
+			return false
+		}
+	}
+	return true
+}
+
+// NumPrefixes returns the number of prefixes in the SeqSet without creating
+// them. This is the same as len(s.Prefixes()) so ContainsAllSeqSet has the
+// one empty prefix and the Permutations have none.
+func (s *SeqSet) NumPrefixes() int {
+	if s == nil || s.isPermutation {
+		return 0
+	}
+	if s == ContainsAllSeqSet {
+		return 1
+	}
+	var num int
+	for _, sub := range s.subSeqSets {
+		num += sub.NumPrefixes()
+	}
+	return num
+}
+
 // Union returns the union of this SeqSet and another.
 func (s *SeqSet) Union(other *SeqSet) *SeqSet {
 	if s == nil {
@@ -276,3 +314,39 @@ func (s *SeqSet) Equals(other *SeqSet) bool {
 	}
 	return true
 }
+
+// Mirror returns a SeqSet with the mirrored version of each sequence. See
+// MirrorSeqSets.
+func (s *SeqSet) Mirror() *SeqSet {
+	return MirrorSeqSets([]*SeqSet{s})[0]
+}
+
+// MirrorSeqSets returns the Mirror of each SeqSet. Sub SeqSets that are
+// shared by the SeqSets are also shared by their mirrors so the result uses
+// about as much memory as the SeqSets.
+func MirrorSeqSets(sets []*SeqSet) []*SeqSet {
+	memo := make(map[*SeqSet]*SeqSet)
+	mirrored := make([]*SeqSet, len(sets))
+	for idx, s := range sets {
+		mirrored[idx] = s.mirror(memo)
+	}
+	return mirrored
+}
+
+func (s *SeqSet) mirror(memo map[*SeqSet]*SeqSet) *SeqSet {
+	if s == nil || s == ContainsAllSeqSet {
+		return s
+	}
+	if s.isPermutation {
+		return Permutations(s.permutationBag.Mirror())
+	}
+	if m, ok := memo[s]; ok {
+		return m
+	}
+	m := new(SeqSet)
+	for idx, sub := range s.subSeqSets {
+		m.subSeqSets[Piece(idx+1).Mirror()-1] = sub.mirror(memo)
+	}
+	memo[s] = m
+	return m
+}
@@ -0,0 +1,145 @@
+package tetris
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// References to SeqSets in the encoding. Special SeqSets have fixed
+// references and all others are encoded once and referenced by index.
+const (
+	nilRef         = 0
+	containsAllRef = 1
+	// The references of the permutations start at this value.
+	permutationsRef = 2
+	// The references of the encoded SeqSets start at this value.
+	encodedRef = uint64(permutationsRef + len(permutations))
+)
+
+// MarshalSeqSets encodes SeqSets into bytes. SeqSets shared between the
+// SeqSets are only encoded once.
+func MarshalSeqSets(sets []*SeqSet) []byte {
+	e := &seqSetEncoder{refs: make(map[*SeqSet]uint64)}
+	setRefs := make([]uint64, len(sets))
+	for idx, s := range sets {
+		setRefs[idx] = e.ref(s)
+	}
+
+	var buf bytes.Buffer
+	varint := make([]byte, binary.MaxVarintLen64)
+	writeUvarint := func(v uint64) {
+		n := binary.PutUvarint(varint, v)
+		buf.Write(varint[:n])
+	}
+	writeUvarint(uint64(len(e.nodes)))
+	for _, node := range e.nodes {
+		for _, ref := range node {
+			writeUvarint(ref)
+		}
+	}
+	writeUvarint(uint64(len(setRefs)))
+	for _, ref := range setRefs {
+		writeUvarint(ref)
+	}
+	return buf.Bytes()
+}
+
+type seqSetEncoder struct {
+	refs map[*SeqSet]uint64
+	// The references of the subSeqSets of each encoded SeqSet. Children are
+	// always before their parents.
+	nodes [][7]uint64
+}
+
+// ref returns the reference of the SeqSet, encoding it if necessary.
+func (e *seqSetEncoder) ref(s *SeqSet) uint64 {
+	switch {
+	case s == nil:
+		return nilRef
+	case s == ContainsAllSeqSet:
+		return containsAllRef
+	case s.isPermutation:
+		for idx := range permutations {
+			if s == &permutations[idx] {
+				return uint64(permutationsRef + idx)
+			}
+		}
+		panic("SeqSet is marked as a permutation but is not one")
+	}
+	if ref, ok := e.refs[s]; ok {
+		return ref
+	}
+	var node [7]uint64
+	for idx, sub := range s.subSeqSets {
+		node[idx] = e.ref(sub)
+	}
+	ref := encodedRef + uint64(len(e.nodes))
+	e.nodes = append(e.nodes, node)
+	e.refs[s] = ref
+	return ref
+}
+
+// UnmarshalSeqSets decodes SeqSets encoded by MarshalSeqSets.
+func UnmarshalSeqSets(b []byte) ([]*SeqSet, error) {
+	r := bytes.NewReader(b)
+	numNodes, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, fmt.Errorf("reading the number of SeqSets: %v", err)
+	}
+	if numNodes > uint64(len(b)) {
+		return nil, errors.New("invalid number of SeqSets")
+	}
+
+	nodes := make([]*SeqSet, 0, numNodes)
+	lookup := func(ref uint64) (*SeqSet, error) {
+		switch {
+		case ref == nilRef:
+			return nil, nil
+		case ref == containsAllRef:
+			return ContainsAllSeqSet, nil
+		case ref < encodedRef:
+			return &permutations[ref-permutationsRef], nil
+		case ref-encodedRef < uint64(len(nodes)):
+			return nodes[ref-encodedRef], nil
+		}
+		return nil, fmt.Errorf("invalid reference %d", ref)
+	}
+
+	for i := uint64(0); i < numNodes; i++ {
+		s := new(SeqSet)
+		for idx := range s.subSeqSets {
+			ref, err := binary.ReadUvarint(r)
+			if err != nil {
+				return nil, fmt.Errorf("reading SeqSet #%d: %v", i, err)
+			}
+			if s.subSeqSets[idx], err = lookup(ref); err != nil {
+				return nil, fmt.Errorf("reading SeqSet #%d: %v", i, err)
+			}
+		}
+		nodes = append(nodes, s)
+	}
+
+	numSets, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, fmt.Errorf("reading the number of encoded SeqSets: %v", err)
+	}
+	if numSets > uint64(len(b)) {
+		return nil, errors.New("invalid number of encoded SeqSets")
+	}
+	sets := make([]*SeqSet, numSets)
+	for idx := range sets {
+		ref, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, fmt.Errorf("reading encoded SeqSet #%d: %v", idx, err)
+		}
+		if sets[idx], err = lookup(ref); err != nil {
+			return nil, fmt.Errorf("reading encoded SeqSet #%d: %v", idx, err)
+		}
+	}
+	if r.Len() != 0 {
+		return nil, fmt.Errorf("%d unexpected trailing bytes", r.Len())
+	}
+	return sets, nil
+}
@@ -0,0 +1,77 @@
+package tetris
+
+import (
+	"testing"
+)
+
+func TestMarshalSeqSets(t *testing.T) {
+	shared := NewSeqSet([]Piece{T, O}, []Piece{I})
+	sets := []*SeqSet{
+		nil,
+		ContainsAllSeqSet,
+		Permutations(NewPieceSet(T, L)),
+		shared,
+		PrependedSeqSets([8]*SeqSet{S: shared, Z: shared, J: ContainsAllSeqSet}),
+		NewSeqSet([]Piece{L, L, L}).Union(Permutations(0)),
+	}
+
+	got, err := UnmarshalSeqSets(MarshalSeqSets(sets))
+	if err != nil {
+		t.Fatalf("UnmarshalSeqSets: %v", err)
+	}
+	if len(got) != len(sets) {
+		t.Fatalf("got %d SeqSets, want %d", len(got), len(sets))
+	}
+	for idx, want := range sets {
+		if !got[idx].Equals(want) {
+			t.Errorf("SeqSet #%d got %v, want %v", idx, got[idx], want)
+		}
+		for _, seq := range [][]Piece{{T, O}, {I, J}, {S, T, O, Z}, {L, T, J}, {L, L, L, L}} {
+			if got[idx].Contains(seq) != want.Contains(seq) {
+				t.Errorf("SeqSet #%d got Contains(%v)=%t, want %t", idx, seq, got[idx].Contains(seq), want.Contains(seq))
+			}
+		}
+	}
+	if got[1] != ContainsAllSeqSet {
+		t.Errorf("ContainsAllSeqSet was not decoded to ContainsAllSeqSet")
+	}
+	if got[2] != Permutations(NewPieceSet(T, L)) {
+		t.Errorf("Permutations were not decoded to the same Permutations")
+	}
+	if got[4].subSeqSets[S-1] != got[3] || got[4].subSeqSets[Z-1] != got[3] {
+		t.Errorf("shared SeqSets were not decoded as shared")
+	}
+}
+
+func TestUnmarshalSeqSetsInvalid(t *testing.T) {
+	valid := MarshalSeqSets([]*SeqSet{NewSeqSet([]Piece{T, O})})
+	tests := []struct {
+		desc string
+		b    []byte
+	}{
+		{
+			desc: "Empty",
+			b:    nil,
+		},
+		{
+			desc: "Truncated",
+			b:    valid[:len(valid)-1],
+		},
+		{
+			desc: "Trailing bytes",
+			b:    append(append([]byte(nil), valid...), 0),
+		},
+		{
+			desc: "Forward reference",
+			// 1 node whose first child references itself (encodedRef=257).
+			b: []byte{1, 0x81, 0x02, 0, 0, 0, 0, 0, 0, 0, 0},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			if _, err := UnmarshalSeqSets(test.b); err == nil {
+				t.Errorf("UnmarshalSeqSets(%v) got no error", test.b)
+			}
+		})
+	}
+}
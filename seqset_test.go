@@ -1,6 +1,7 @@
 package tetris
 
 import (
+	"math/rand"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -134,6 +135,56 @@ func TestSeqSetSize(t *testing.T) {
 	}
 }
 
+func TestSeqSetIsEmptyAndNumPrefixes(t *testing.T) {
+	tests := []struct {
+		desc         string
+		set          *SeqSet
+		wantEmpty    bool
+		wantPrefixes int
+	}{
+		{
+			desc:      "nil",
+			set:       nil,
+			wantEmpty: true,
+		},
+		{
+			desc:      "No prefixes",
+			set:       PrependedSeqSets([8]*SeqSet{}),
+			wantEmpty: true,
+		},
+		{
+			desc:         "ContainsAllSeqSet",
+			set:          ContainsAllSeqSet,
+			wantPrefixes: 1,
+		},
+		{
+			desc: "Permutations",
+			set:  Permutations(NewPieceSet(I, T)),
+		},
+		{
+			desc: "Two sequences",
+			set: NewSeqSet(
+				[]Piece{I, J, O},
+				[]Piece{S, S, S, T, T},
+			),
+			wantPrefixes: 2,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			if got := test.set.IsEmpty(); got != test.wantEmpty {
+				t.Errorf("got IsEmpty = %t, want %t", got, test.wantEmpty)
+			}
+			if got := test.set.NumPrefixes(); got != test.wantPrefixes {
+				t.Errorf("got NumPrefixes = %d, want %d", got, test.wantPrefixes)
+			}
+			if got, want := test.set.NumPrefixes(), len(test.set.Prefixes()); got != want {
+				t.Errorf("got NumPrefixes = %d, want len(Prefixes) = %d", got, want)
+			}
+		})
+	}
+}
+
 func TestSeqSetEquals(t *testing.T) {
 	tests := []struct {
 		desc  string
@@ -320,3 +371,124 @@ func TestPrependedSeqSets(t *testing.T) {
 		t.Errorf("PrependedSeqSets got %v, want %v", got, want)
 	}
 }
+
+func TestSeqSetMirror(t *testing.T) {
+	mirrorSeq := func(seq []Piece) []Piece {
+		mirrored := make([]Piece, len(seq))
+		for i, p := range seq {
+			mirrored[i] = p.Mirror()
+		}
+		return mirrored
+	}
+
+	bag := NewPieceSet(T, L, S)
+	prefixes := NewSeqSet([]Piece{L, S}, []Piece{O, J, I}, []Piece{Z})
+	tests := []struct {
+		desc string
+		set  *SeqSet
+	}{
+		{desc: "nil"},
+		{desc: "Contains all", set: ContainsAllSeqSet},
+		{desc: "Prefixes", set: prefixes},
+		{desc: "Permutations", set: Permutations(bag)},
+		{desc: "Prefixes of permutations", set: Permutations(bag).Intersection(prefixes)},
+	}
+	r := rand.New(rand.NewSource(1))
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			mirrored := test.set.Mirror()
+			for i := 0; i < 200; i++ {
+				seq := RandPiecesFrom(r, 1+r.Intn(8))
+				if got, want := mirrored.Contains(mirrorSeq(seq)), test.set.Contains(seq); got != want {
+					t.Errorf("Mirror().Contains(%v) got %t, want %t", mirrorSeq(seq), got, want)
+				}
+			}
+			for length := 0; length < 6; length++ {
+				if got, want := mirrored.Size(length), test.set.Size(length); got != want {
+					t.Errorf("Mirror().Size(%d) got %d, want %d", length, got, want)
+				}
+			}
+		})
+	}
+
+	shared := NewSeqSet([]Piece{O, L})
+	sets := MirrorSeqSets([]*SeqSet{PrependedSeqSets([8]*SeqSet{T: shared}), PrependedSeqSets([8]*SeqSet{I: shared})})
+	if sets[0].subSeqSets[T-1] != sets[1].subSeqSets[I-1] {
+		t.Errorf("MirrorSeqSets did not share the mirror of a shared SeqSet")
+	}
+}
+
+// seqSetFromBytes returns a SeqSet with the prefixes in the bytes. Each byte
+// is a piece by its value mod 8 where EmptyPiece ends a prefix.
+func seqSetFromBytes(b []byte) *SeqSet {
+	var (
+		prefixes [][]Piece
+		prefix   []Piece
+	)
+	for _, v := range b {
+		if p := Piece(v % 8); p != EmptyPiece {
+			prefix = append(prefix, p)
+			continue
+		}
+		prefixes = append(prefixes, prefix)
+		prefix = nil
+	}
+	if len(prefix) > 0 {
+		prefixes = append(prefixes, prefix)
+	}
+	return NewSeqSet(prefixes...)
+}
+
+func FuzzSeqSet(f *testing.F) {
+	// The first byte splits the rest into the bytes of the two SeqSets.
+	f.Add([]byte{}, int64(1))
+	f.Add([]byte{1, 0}, int64(1))                            // Empty and ContainsAllSeqSet.
+	f.Add([]byte{3, 1, 2, 3, 1, 2, 3, 4}, int64(2))          // Subset.
+	f.Add([]byte{3, 1, 2, 3, 1, 5, 3}, int64(3))             // Partial overlap.
+	f.Add([]byte{5, 1, 2, 0, 4, 1, 2, 3, 0, 6, 7}, int64(4)) // Several prefixes.
+	f.Add([]byte{2, 7, 0, 0, 7}, int64(5))                   // ContainsAllSeqSet and a prefix.
+	f.Fuzz(func(t *testing.T, b []byte, seed int64) {
+		var aLen int
+		if len(b) > 0 {
+			aLen = int(b[0]) % len(b)
+			b = b[1:]
+		}
+		a, other := seqSetFromBytes(b[:aLen]), seqSetFromBytes(b[aLen:])
+		var (
+			inter     = a.Intersection(other)
+			union     = a.Union(other)
+			reversed  = other.Union(a)
+			selfInter = a.Intersection(a)
+		)
+		if !selfInter.Equals(a) {
+			t.Errorf("%v.Intersection(itself) got %v", a, selfInter)
+		}
+		if !union.Equals(reversed) {
+			t.Errorf("%v.Union(%v) got %v but the reverse got %v", a, other, union, reversed)
+		}
+
+		r := rand.New(rand.NewSource(seed))
+		for i := 0; i < 200; i++ {
+			seq := make([]Piece, r.Intn(8))
+			for j := range seq {
+				seq[j] = NonemptyPieces[r.Intn(len(NonemptyPieces))]
+			}
+			inA, inOther := a.Contains(seq), other.Contains(seq)
+			if inter.Contains(seq) && !inA {
+				t.Errorf("%v.Intersection(%v) contains %v which is not in the first", a, other, seq)
+			}
+			if inA && !union.Contains(seq) {
+				t.Errorf("%v.Union(%v) does not contain %v", a, other, seq)
+			}
+			if got := inter.Contains(seq); got != (inA && inOther) {
+				t.Errorf("%v.Intersection(%v).Contains(%v) got %t, want %t", a, other, seq, got, inA && inOther)
+			}
+			if got := union.Contains(seq); got != reversed.Contains(seq) {
+				t.Errorf("Union of %v and %v in different orders disagree on %v", a, other, seq)
+			}
+			if got := selfInter.Contains(seq); got != inA {
+				t.Errorf("%v.Intersection(itself).Contains(%v) got %t, want %t", a, seq, got, inA)
+			}
+		}
+	})
+}